@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 
+	"github.com/duscraft/tanzia/lib/domains"
 	"github.com/duscraft/tanzia/lib/helpers"
 
 	"github.com/go-session/redis/v3"
@@ -39,11 +40,16 @@ func main() {
 		}
 	}()
 
+	for _, route := range domains.APIRoutes() {
+		http.HandleFunc(route.Pattern, route.Handler)
+	}
+	http.HandleFunc("/", domains.APINotFoundHandler)
+
 	port := os.Getenv("PORT")
 	if len(port) == 0 {
 		port = "8080"
 	}
 
 	log.Printf("Listening on port %s...", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), nil))
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), domains.BearerAuth(http.DefaultServeMux)))
 }
@@ -0,0 +1,303 @@
+// Package client is a typed Go client for the Tanzia JSON API, described by
+// lib/domains/openapi.json. It only depends on the standard library, so that
+// tools can import it without the server dependencies.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Client calls the API on behalf of the owner of a personal access token.
+type Client struct {
+	baseURL string
+	token   string
+	// HTTPClient sends the requests, http.DefaultClient when nil.
+	HTTPClient *http.Client
+}
+
+// New returns a client for the API served at baseURL, such as
+// "https://tanzia.example.com/api/v1", authenticating with token.
+func New(baseURL, token string) *Client {
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), token: token}
+}
+
+// Error is an error answered by the API.
+type Error struct {
+	StatusCode int
+	// Code is the stable error code of the API, such as "not_found" or
+	// "free_tier_limit". It is empty when the body was not a JSON error.
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("tanzia: %d %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("tanzia: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// ListOptions selects a page of a list. Zero values use the defaults of the
+// API.
+type ListOptions struct {
+	Page    int
+	PerPage int
+}
+
+func (o ListOptions) encode(query url.Values) {
+	if o.Page > 0 {
+		query.Set("page", strconv.Itoa(o.Page))
+	}
+	if o.PerPage > 0 {
+		query.Set("per_page", strconv.Itoa(o.PerPage))
+	}
+}
+
+// PeriodOptions restricts entries to a period. Period is "all", "year",
+// "quarter" or "custom"; the zero value covers every entry. Dates are
+// formatted as 2006-01-02 and To is inclusive.
+type PeriodOptions struct {
+	Period  string
+	Year    int
+	Quarter int
+	From    string
+	To      string
+}
+
+func (o PeriodOptions) encode(query url.Values) {
+	if o.Period != "" {
+		query.Set("period", o.Period)
+	}
+	if o.Year > 0 {
+		query.Set("year", strconv.Itoa(o.Year))
+	}
+	if o.Quarter > 0 {
+		query.Set("quarter", strconv.Itoa(o.Quarter))
+	}
+	if o.From != "" {
+		query.Set("from", o.From)
+	}
+	if o.To != "" {
+		query.Set("to", o.To)
+	}
+}
+
+type BalanceListOptions struct {
+	ListOptions
+	PeriodOptions
+}
+
+type BillListOptions struct {
+	ListOptions
+	PeriodOptions
+	// SupplierID restricts the bills to a supplier when not zero.
+	SupplierID int
+}
+
+type ProvisionListOptions struct {
+	ListOptions
+	PeriodOptions
+}
+
+func (c *Client) GetOpenAPI(ctx context.Context) (json.RawMessage, error) {
+	var document json.RawMessage
+	err := c.do(ctx, http.MethodGet, "/openapi.json", nil, nil, &document)
+	return document, err
+}
+
+func (c *Client) ListCoproprietes(ctx context.Context, opts ListOptions) (*List[Copropriete], error) {
+	query := url.Values{}
+	opts.encode(query)
+	return list[Copropriete](ctx, c, "/coproprietes", query)
+}
+
+func (c *Client) GetDashboard(ctx context.Context, coproprieteID int, opts PeriodOptions) (*Dashboard, error) {
+	query := url.Values{}
+	opts.encode(query)
+	return item[Dashboard](ctx, c, http.MethodGet, coproprietePath(coproprieteID, "dashboard"), query, nil)
+}
+
+func (c *Client) ListBalances(ctx context.Context, coproprieteID int, opts BalanceListOptions) (*List[Balance], error) {
+	query := url.Values{}
+	opts.ListOptions.encode(query)
+	opts.PeriodOptions.encode(query)
+	return list[Balance](ctx, c, coproprietePath(coproprieteID, "balances"), query)
+}
+
+func (c *Client) ListPersons(ctx context.Context, coproprieteID int, opts ListOptions) (*List[Person], error) {
+	query := url.Values{}
+	opts.encode(query)
+	return list[Person](ctx, c, coproprietePath(coproprieteID, "persons"), query)
+}
+
+func (c *Client) GetPerson(ctx context.Context, coproprieteID, personID int) (*Person, error) {
+	return item[Person](ctx, c, http.MethodGet, coproprietePath(coproprieteID, "persons", personID), nil, nil)
+}
+
+func (c *Client) CreatePerson(ctx context.Context, coproprieteID int, input PersonInput) (*Person, error) {
+	return item[Person](ctx, c, http.MethodPost, coproprietePath(coproprieteID, "persons"), nil, input)
+}
+
+func (c *Client) UpdatePerson(ctx context.Context, coproprieteID, personID int, input PersonInput) (*Person, error) {
+	return item[Person](ctx, c, http.MethodPut, coproprietePath(coproprieteID, "persons", personID), nil, input)
+}
+
+func (c *Client) DeletePerson(ctx context.Context, coproprieteID, personID int) error {
+	return c.do(ctx, http.MethodDelete, coproprietePath(coproprieteID, "persons", personID), nil, nil, nil)
+}
+
+func (c *Client) ListBills(ctx context.Context, coproprieteID int, opts BillListOptions) (*List[Bill], error) {
+	query := url.Values{}
+	opts.ListOptions.encode(query)
+	opts.PeriodOptions.encode(query)
+	if opts.SupplierID > 0 {
+		query.Set("supplier", strconv.Itoa(opts.SupplierID))
+	}
+	return list[Bill](ctx, c, coproprietePath(coproprieteID, "bills"), query)
+}
+
+func (c *Client) GetBill(ctx context.Context, coproprieteID, billID int) (*Bill, error) {
+	return item[Bill](ctx, c, http.MethodGet, coproprietePath(coproprieteID, "bills", billID), nil, nil)
+}
+
+func (c *Client) CreateBill(ctx context.Context, coproprieteID int, input BillInput) (*Bill, error) {
+	return item[Bill](ctx, c, http.MethodPost, coproprietePath(coproprieteID, "bills"), nil, input)
+}
+
+func (c *Client) UpdateBill(ctx context.Context, coproprieteID, billID int, input BillInput) (*Bill, error) {
+	return item[Bill](ctx, c, http.MethodPut, coproprietePath(coproprieteID, "bills", billID), nil, input)
+}
+
+func (c *Client) DeleteBill(ctx context.Context, coproprieteID, billID int) error {
+	return c.do(ctx, http.MethodDelete, coproprietePath(coproprieteID, "bills", billID), nil, nil, nil)
+}
+
+func (c *Client) ListProvisions(ctx context.Context, coproprieteID int, opts ProvisionListOptions) (*List[Provision], error) {
+	query := url.Values{}
+	opts.ListOptions.encode(query)
+	opts.PeriodOptions.encode(query)
+	return list[Provision](ctx, c, coproprietePath(coproprieteID, "provisions"), query)
+}
+
+func (c *Client) GetProvision(ctx context.Context, coproprieteID, provisionID int) (*Provision, error) {
+	return item[Provision](ctx, c, http.MethodGet, coproprietePath(coproprieteID, "provisions", provisionID), nil, nil)
+}
+
+func (c *Client) CreateProvision(ctx context.Context, coproprieteID int, input ProvisionInput) (*Provision, error) {
+	return item[Provision](ctx, c, http.MethodPost, coproprietePath(coproprieteID, "provisions"), nil, input)
+}
+
+func (c *Client) UpdateProvision(ctx context.Context, coproprieteID, provisionID int, input ProvisionInput) (*Provision, error) {
+	return item[Provision](ctx, c, http.MethodPut, coproprietePath(coproprieteID, "provisions", provisionID), nil, input)
+}
+
+func (c *Client) DeleteProvision(ctx context.Context, coproprieteID, provisionID int) error {
+	return c.do(ctx, http.MethodDelete, coproprietePath(coproprieteID, "provisions", provisionID), nil, nil, nil)
+}
+
+// coproprietePath returns the path of a collection of a building, or of one
+// of its items when an id is given.
+func coproprietePath(coproprieteID int, collection string, id ...int) string {
+	path := "/coproprietes/" + strconv.Itoa(coproprieteID) + "/" + collection
+	for _, i := range id {
+		path += "/" + strconv.Itoa(i)
+	}
+	return path
+}
+
+func list[T any](ctx context.Context, c *Client, path string, query url.Values) (*List[T], error) {
+	var body List[T]
+	if err := c.do(ctx, http.MethodGet, path, query, nil, &body); err != nil {
+		return nil, err
+	}
+	return &body, nil
+}
+
+func item[T any](ctx context.Context, c *Client, method, path string, query url.Values, input any) (*T, error) {
+	var body struct {
+		Data T `json:"data"`
+	}
+	if err := c.do(ctx, method, path, query, input, &body); err != nil {
+		return nil, err
+	}
+	return &body.Data, nil
+}
+
+// do sends a request with input as JSON body, when not nil, and decodes the
+// response into output, when not nil. Error responses are returned as *Error.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, input, output any) error {
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if input != nil {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if input != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode >= 400 {
+		return newError(resp)
+	}
+	if output == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(output); err != nil {
+		return fmt.Errorf("tanzia: decoding %s %s response: %w", method, path, err)
+	}
+	return nil
+}
+
+func newError(resp *http.Response) *Error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &body); err == nil && body.Error.Code != "" {
+		return &Error{StatusCode: resp.StatusCode, Code: body.Error.Code, Message: body.Error.Message}
+	}
+
+	message := strings.TrimSpace(string(data))
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+	return &Error{StatusCode: resp.StatusCode, Message: message}
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestClientSendsAuthenticatedRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tzp_secret" {
+			t.Errorf("Unexpected Authorization header %q", r.Header.Get("Authorization"))
+		}
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/v1/coproprietes/3/bills":
+			expected := "page=2&per_page=10&period=year&supplier=7&year=2025"
+			if r.URL.RawQuery != expected {
+				t.Errorf("Unexpected query %q, expected %q", r.URL.RawQuery, expected)
+			}
+			_, _ = io.WriteString(w, `{"data":[{"id":1,"label":"Ménage","amount":"120.50","date":"2025-03-01","fiscal_year":2025,"charge_key_id":0,"works_fund":false,"supplier_id":7,"category":"614"}],"pagination":{"page":2,"per_page":10,"total":11,"total_pages":2}}`)
+		case "POST /api/v1/coproprietes/3/persons":
+			if r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("Unexpected Content-Type %q", r.Header.Get("Content-Type"))
+			}
+			var input PersonInput
+			if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Name != "Alice" {
+				t.Errorf("Unexpected body %+v (%v)", input, err)
+			}
+			w.WriteHeader(http.StatusCreated)
+			_, _ = io.WriteString(w, `{"data":{"id":9,"name":"Alice","tantieme":0}}`)
+		case "DELETE /api/v1/coproprietes/3/provisions/4":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusTeapot)
+		}
+	}))
+	defer server.Close()
+
+	c := New(server.URL+"/api/v1/", "tzp_secret")
+	ctx := context.Background()
+
+	bills, err := c.ListBills(ctx, 3, BillListOptions{
+		ListOptions:   ListOptions{Page: 2, PerPage: 10},
+		PeriodOptions: PeriodOptions{Period: "year", Year: 2025},
+		SupplierID:    7,
+	})
+	if err != nil {
+		t.Fatalf("ListBills returned error: %v", err)
+	}
+	if len(bills.Data) != 1 || bills.Data[0].Amount != "120.50" || bills.Data[0].Category != "614" || bills.Pagination.TotalPages != 2 {
+		t.Errorf("Unexpected bills %+v", bills)
+	}
+
+	person, err := c.CreatePerson(ctx, 3, PersonInput{Name: "Alice"})
+	if err != nil || person.ID != 9 {
+		t.Errorf("Unexpected person %+v (%v)", person, err)
+	}
+
+	if err := c.DeleteProvision(ctx, 3, 4); err != nil {
+		t.Errorf("DeleteProvision returned error: %v", err)
+	}
+}
+
+func TestClientReturnsAPIErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/coproprietes/3/persons/1" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"error":{"code":"not_found","message":"Person not found"}}`)
+			return
+		}
+		http.Error(w, "Bad gateway", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	c := New(server.URL+"/api/v1", "")
+
+	_, err := c.GetPerson(context.Background(), 3, 1)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Code != "not_found" || apiErr.Message != "Person not found" {
+		t.Errorf("Unexpected error %#v", err)
+	}
+
+	_, err = c.ListCoproprietes(context.Background(), ListOptions{})
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway || apiErr.Code != "" || apiErr.Message != "Bad gateway" {
+		t.Errorf("Unexpected error %#v", err)
+	}
+}
+
+// openAPIDocument is the part of lib/domains/openapi.json the client is
+// checked against.
+type openAPIDocument struct {
+	Paths      map[string]map[string]struct{ OperationID string }
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]any
+		}
+	}
+}
+
+func loadOpenAPIDocument(t *testing.T) openAPIDocument {
+	t.Helper()
+	data, err := os.ReadFile("../domains/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc openAPIDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestClientCoversOpenAPIOperations(t *testing.T) {
+	doc := loadOpenAPIDocument(t)
+	typ := reflect.TypeOf(&Client{})
+
+	for path, operations := range doc.Paths {
+		for method, operation := range operations {
+			name := strings.ToUpper(operation.OperationID[:1]) + operation.OperationID[1:]
+			if _, ok := typ.MethodByName(name); !ok {
+				t.Errorf("No client method %s for %s %s", name, strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestClientTypesMatchOpenAPISchemas(t *testing.T) {
+	doc := loadOpenAPIDocument(t)
+	types := map[string]any{
+		"Pagination":      Pagination{},
+		"Copropriete":     Copropriete{},
+		"Person":          Person{},
+		"PersonInput":     PersonInput{},
+		"Bill":            Bill{},
+		"BillInput":       BillInput{},
+		"Provision":       Provision{},
+		"ProvisionInput":  ProvisionInput{},
+		"Balance":         Balance{},
+		"Period":          Period{},
+		"WorksFund":       WorksFund{},
+		"CategoryTotal":   CategoryTotal{},
+		"SupplierTotal":   SupplierTotal{},
+		"DashboardCounts": DashboardCounts{},
+		"Dashboard":       Dashboard{},
+	}
+
+	for name, value := range types {
+		var properties, fields []string
+		for property := range doc.Components.Schemas[name].Properties {
+			properties = append(properties, property)
+		}
+		typ := reflect.TypeOf(value)
+		for i := 0; i < typ.NumField(); i++ {
+			field, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+			fields = append(fields, field)
+		}
+		sort.Strings(properties)
+		sort.Strings(fields)
+		if !slices.Equal(properties, fields) {
+			t.Errorf("Schema %s has properties %v, %s has %v", name, properties, typ.Name(), fields)
+		}
+	}
+}
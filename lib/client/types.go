@@ -0,0 +1,154 @@
+package client
+
+// Amounts are decimal strings in euros ("-1234.56") so that no precision is
+// lost, and dates are formatted as 2006-01-02.
+
+// Pagination describes the page of a list answered by the API.
+type Pagination struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Total      int `json:"total"`
+	TotalPages int `json:"total_pages"`
+}
+
+// List is a page of a list along with its pagination.
+type List[T any] struct {
+	Data       []T        `json:"data"`
+	Pagination Pagination `json:"pagination"`
+}
+
+type Copropriete struct {
+	ID              int    `json:"id"`
+	Name            string `json:"name"`
+	FiscalYearStart int    `json:"fiscal_year_start"` // month, 1 to 12
+	WorksFundRate   int    `json:"works_fund_rate"`
+}
+
+type Person struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Tantieme int    `json:"tantieme"`
+}
+
+type PersonInput struct {
+	Name string `json:"name"`
+}
+
+// Bill is an expense of a building. A zero ChargeKeyID stands for the
+// general tantièmes and a zero SupplierID for no supplier.
+type Bill struct {
+	ID          int    `json:"id"`
+	Label       string `json:"label"`
+	Amount      string `json:"amount"`
+	Date        string `json:"date"`
+	FiscalYear  int    `json:"fiscal_year"`
+	ChargeKeyID int    `json:"charge_key_id"`
+	WorksFund   bool   `json:"works_fund"`
+	SupplierID  int    `json:"supplier_id"`
+	Category    string `json:"category"`
+}
+
+// BillInput creates or replaces a bill. A zero FiscalYear stands for the
+// one containing the date.
+type BillInput struct {
+	Label       string `json:"label"`
+	Amount      string `json:"amount"`
+	Date        string `json:"date"`
+	FiscalYear  int    `json:"fiscal_year,omitempty"`
+	ChargeKeyID int    `json:"charge_key_id,omitempty"`
+	WorksFund   bool   `json:"works_fund"`
+	SupplierID  int    `json:"supplier_id,omitempty"`
+	Category    string `json:"category,omitempty"`
+}
+
+// Provision is a call for funds of a building.
+type Provision struct {
+	ID          int    `json:"id"`
+	Label       string `json:"label"`
+	Amount      string `json:"amount"`
+	Date        string `json:"date"`
+	FiscalYear  int    `json:"fiscal_year"`
+	ChargeKeyID int    `json:"charge_key_id"`
+	WorksFund   bool   `json:"works_fund"`
+}
+
+type ProvisionInput struct {
+	Label       string `json:"label"`
+	Amount      string `json:"amount"`
+	Date        string `json:"date"`
+	FiscalYear  int    `json:"fiscal_year,omitempty"`
+	ChargeKeyID int    `json:"charge_key_id,omitempty"`
+	WorksFund   bool   `json:"works_fund"`
+}
+
+// Balance is the account of a person over a period.
+type Balance struct {
+	PersonID    int    `json:"person_id"`
+	Name        string `json:"name"`
+	Tantieme    int    `json:"tantieme"`
+	Called      string `json:"called"`
+	Paid        string `json:"paid"`
+	CarriedOver string `json:"carried_over"`
+	Opening     string `json:"opening"`
+	Outstanding string `json:"outstanding"`
+	Charges     string `json:"charges"`
+	WorksFund   string `json:"works_fund"`
+}
+
+// Period is the period a dashboard covers. Kind is "all", "year", "quarter"
+// or "custom"; To is inclusive.
+type Period struct {
+	Kind       string `json:"kind"`
+	Label      string `json:"label"`
+	FiscalYear int    `json:"fiscal_year,omitempty"`
+	Quarter    int    `json:"quarter,omitempty"`
+	From       string `json:"from,omitempty"`
+	To         string `json:"to,omitempty"`
+}
+
+type WorksFund struct {
+	Contributions string `json:"contributions"`
+	Spent         string `json:"spent"`
+	Balance       string `json:"balance"`
+}
+
+type CategoryTotal struct {
+	Code   string  `json:"code"` // empty for the uncategorized bills
+	Label  string  `json:"label"`
+	Bills  int     `json:"bills"`
+	Amount string  `json:"amount"`
+	Share  float64 `json:"share"` // percent of the bills
+}
+
+type SupplierTotal struct {
+	SupplierID int    `json:"supplier_id"` // zero for the bills without supplier
+	Name       string `json:"name"`
+	Bills      int    `json:"bills"`
+	Amount     string `json:"amount"`
+}
+
+type DashboardCounts struct {
+	Persons    int `json:"persons"`
+	Bills      int `json:"bills"`
+	Provisions int `json:"provisions"`
+	Payments   int `json:"payments"`
+}
+
+type Dashboard struct {
+	Copropriete    Copropriete     `json:"copropriete"`
+	Period         Period          `json:"period"`
+	FiscalYears    []int           `json:"fiscal_years"`
+	IsPremium      bool            `json:"is_premium"`
+	TotalTantiemes int             `json:"total_tantiemes"`
+	Counts         DashboardCounts `json:"counts"`
+	Balance        string          `json:"balance"`
+	Unallocated    string          `json:"unallocated"`
+	Paid           string          `json:"paid"`
+	CarriedOver    string          `json:"carried_over"`
+	Opening        string          `json:"opening"`
+	Outstanding    string          `json:"outstanding"`
+	WorksFund      WorksFund       `json:"works_fund"`
+	Balances       []Balance       `json:"balances"`
+	CategoryTotals []CategoryTotal `json:"category_totals"`
+	SupplierTotals []SupplierTotal `json:"supplier_totals"`
+}
@@ -0,0 +1,365 @@
+package domains
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+const (
+	accessTokenScopeRead  = "read"
+	accessTokenScopeWrite = "write"
+
+	maxAccessTokenNameLength = 100
+
+	// accountEmailCount is how many of the last emails of the user the account
+	// page lists.
+	accountEmailCount = 20
+)
+
+var accessTokenScopes = []AccessTokenScope{
+	{Code: accessTokenScopeRead, Label: "Lecture seule"},
+	{Code: accessTokenScopeWrite, Label: "Lecture et écriture"},
+}
+
+// accessTokenExpiries are the lifetimes offered when creating a token, in
+// days. Zero never expires.
+var accessTokenExpiries = []AccessTokenExpiry{
+	{Days: 30, Label: "30 jours"},
+	{Days: 90, Label: "90 jours"},
+	{Days: 365, Label: "1 an"},
+	{Days: 0, Label: "Jamais"},
+}
+
+var ErrInvalidAccessToken = errors.New("invalid or expired access token")
+
+type AccessTokenScope struct {
+	Code  string
+	Label string
+}
+
+type AccessTokenExpiry struct {
+	Days  int
+	Label string
+}
+
+// AccessToken is a personal access token authenticating scripts and
+// integrations on the API. Only the hash of the token is stored; Hint keeps
+// its first characters to tell the tokens apart.
+type AccessToken struct {
+	ID         int
+	Name       string
+	Hint       string
+	Scope      string
+	ExpiresAt  time.Time // zero for a token that never expires
+	LastUsedAt time.Time // zero for a token never used
+	CreatedAt  time.Time
+}
+
+type accountData struct {
+	Name  string
+	Email string
+	// EmailVerified tells whether Email was verified, and PendingEmail is
+	// the address waiting for verification to replace it.
+	EmailVerified    bool
+	PendingEmail     string
+	TwoFactorEnabled bool
+	Passkeys         []Passkey
+	Tokens           []AccessToken
+	Scopes           []AccessTokenScope
+	Expiries         []AccessTokenExpiry
+	// NewToken is the token just created, shown once.
+	NewToken string
+	// Emails are the last emails sent to the user.
+	Emails []SentEmail
+	Now    time.Time
+}
+
+// accessTokenUserKey is the context key under which BearerAuth stores the
+// user authenticated by a token.
+type accessTokenUserKey struct{}
+
+// ScopeLabel returns the label of the scope of the token.
+func (token AccessToken) ScopeLabel() string {
+	for _, scope := range accessTokenScopes {
+		if scope.Code == token.Scope {
+			return scope.Label
+		}
+	}
+	return token.Scope
+}
+
+// IsExpired reports whether the token can no longer be used at now.
+func (token AccessToken) IsExpired(now time.Time) bool {
+	return !token.ExpiresAt.IsZero() && !now.Before(token.ExpiresAt)
+}
+
+// Allows reports whether the token grants a request with the given method:
+// read-only tokens are limited to the safe methods.
+func (token AccessToken) Allows(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return token.Scope == accessTokenScopeWrite
+}
+
+// BearerAuth authenticates the requests bearing a personal access token in
+// their Authorization header, so that GetAuthenticatedUserID returns the
+// owner of the token. Requests without one go through untouched and keep
+// relying on the session cookie.
+func BearerAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="tanzia"`)
+		value, ok := helpers.BearerToken(r)
+		if !ok {
+			writeAPIError(w, http.StatusUnauthorized, apiErrorUnauthorized, "Authorization header must use the Bearer scheme")
+			return
+		}
+
+		db, err := helpers.GetConnectionManager().GetConnection("postgres")
+		if err != nil {
+			writeAPIInternalError(w, err)
+			return
+		}
+
+		token, userID, err := authenticateAccessToken(db, value, time.Now())
+		if errors.Is(err, ErrInvalidAccessToken) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tanzia", error="invalid_token"`)
+			writeAPIError(w, http.StatusUnauthorized, apiErrorUnauthorized, "Invalid or expired access token")
+			return
+		}
+		if err != nil {
+			writeAPIInternalError(w, err)
+			return
+		}
+
+		if !token.Allows(r.Method) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tanzia", error="insufficient_scope", scope="write"`)
+			writeAPIError(w, http.StatusForbidden, apiErrorInsufficientScope, "This access token is read-only")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), accessTokenUserKey{}, userID)))
+	})
+}
+
+// authenticateAccessToken returns the token matching value along with its
+// owner, or ErrInvalidAccessToken when it is unknown or expired. The last use
+// of the token is recorded.
+func authenticateAccessToken(db *sql.DB, value string, now time.Time) (AccessToken, string, error) {
+	if !strings.HasPrefix(value, helpers.AccessTokenPrefix) {
+		return AccessToken{}, "", ErrInvalidAccessToken
+	}
+
+	var token AccessToken
+	var userID string
+	var expiresAt sql.NullTime
+	err := db.QueryRow("SELECT id, name, hint, scope, expiresAt, userId FROM access_tokens WHERE tokenHash = $1", helpers.HashAccessToken(value)).
+		Scan(&token.ID, &token.Name, &token.Hint, &token.Scope, &expiresAt, &userID)
+	if err == sql.ErrNoRows {
+		return AccessToken{}, "", ErrInvalidAccessToken
+	}
+	if err != nil {
+		return AccessToken{}, "", fmt.Errorf("error looking up access token: %w", err)
+	}
+	token.ExpiresAt = expiresAt.Time
+	if token.IsExpired(now) {
+		return AccessToken{}, "", ErrInvalidAccessToken
+	}
+
+	if _, err := db.Exec("UPDATE access_tokens SET lastUsedAt = $1 WHERE id = $2", now, token.ID); err != nil {
+		log.Printf("Error recording access token use: %v", err)
+	}
+	return token, userID, nil
+}
+
+func AccountHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderAccount(w, db, userID, "")
+}
+
+func AddAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	name, scope, expiresAt, err := parseAccessToken(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	token, hint, err := helpers.GenerateAccessToken()
+	if err != nil {
+		log.Printf("Error generating access token: %v", err)
+		http.Error(w, "Could not generate access token", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = db.Exec("INSERT INTO access_tokens (name, tokenHash, hint, scope, expiresAt, userId) VALUES ($1, $2, $3, $4, $5, $6)", name, helpers.HashAccessToken(token), hint, scope, expiresAt, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The token is only ever shown in this response: it is not stored in
+	// clear, so the page is rendered rather than redirected to.
+	renderAccount(w, db, userID, token)
+}
+
+func RevokeAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	tokenID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid token id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM access_tokens WHERE id = $1 AND userId = $2", tokenID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/account#token_revoked", http.StatusFound)
+}
+
+// parseAccessToken reads the name, the scope and the lifetime of a new token
+// from the form. A NULL expiry never expires.
+func parseAccessToken(r *http.Request, now time.Time) (string, string, sql.NullTime, error) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		return "", "", sql.NullTime{}, fmt.Errorf("name cannot be empty")
+	}
+	if len([]rune(name)) > maxAccessTokenNameLength {
+		return "", "", sql.NullTime{}, fmt.Errorf("name cannot exceed %d characters", maxAccessTokenNameLength)
+	}
+
+	scope := r.FormValue("scope")
+	if scope != accessTokenScopeRead && scope != accessTokenScopeWrite {
+		return "", "", sql.NullTime{}, fmt.Errorf("invalid scope value")
+	}
+
+	days, err := strconv.Atoi(r.FormValue("expires_in"))
+	if err != nil {
+		return "", "", sql.NullTime{}, fmt.Errorf("invalid expiry value")
+	}
+	for _, expiry := range accessTokenExpiries {
+		if expiry.Days == days {
+			if days == 0 {
+				return name, scope, sql.NullTime{}, nil
+			}
+			return name, scope, sql.NullTime{Time: now.AddDate(0, 0, days), Valid: true}, nil
+		}
+	}
+	return "", "", sql.NullTime{}, fmt.Errorf("invalid expiry value")
+}
+
+func getAccessTokens(db *sql.DB, userID string) ([]AccessToken, error) {
+	rows, err := db.Query("SELECT id, name, hint, scope, expiresAt, lastUsedAt, createdAt FROM access_tokens WHERE userId = $1 ORDER BY createdAt DESC, id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var tokens []AccessToken
+	for rows.Next() {
+		var token AccessToken
+		var expiresAt, lastUsedAt sql.NullTime
+		if err := rows.Scan(&token.ID, &token.Name, &token.Hint, &token.Scope, &expiresAt, &lastUsedAt, &token.CreatedAt); err != nil {
+			return nil, err
+		}
+		token.ExpiresAt = expiresAt.Time
+		token.LastUsedAt = lastUsedAt.Time
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func renderAccount(w http.ResponseWriter, db *sql.DB, userID string, newToken string) {
+	data := accountData{Scopes: accessTokenScopes, Expiries: accessTokenExpiries, NewToken: newToken, Now: time.Now()}
+	err := db.QueryRow("SELECT COALESCE(name, ''), COALESCE(email, ''), emailVerifiedAt IS NOT NULL, COALESCE(pendingEmail, ''), totpEnabledAt IS NOT NULL FROM users WHERE id = $1", userID).Scan(&data.Name, &data.Email, &data.EmailVerified, &data.PendingEmail, &data.TwoFactorEnabled)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data.Passkeys, err = getPasskeys(db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data.Tokens, err = getAccessTokens(db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data.Emails, err = getSentEmails(db, userID, accountEmailCount)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t, err := template.ParseFiles("lib/templates/account.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,130 @@
+package domains
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAccessToken(t *testing.T) {
+	now := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+	newRequest := func(values url.Values) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/account/tokens", strings.NewReader(values.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	name, scope, expiresAt, err := parseAccessToken(newRequest(url.Values{"name": {" Import "}, "scope": {"read"}, "expires_in": {"30"}}), now)
+	if err != nil {
+		t.Fatalf("parseAccessToken returned error: %v", err)
+	}
+	if name != "Import" || scope != accessTokenScopeRead || !expiresAt.Valid || !expiresAt.Time.Equal(now.AddDate(0, 0, 30)) {
+		t.Errorf("Unexpected token %q %q %v", name, scope, expiresAt)
+	}
+
+	_, _, expiresAt, err = parseAccessToken(newRequest(url.Values{"name": {"Sync"}, "scope": {"write"}, "expires_in": {"0"}}), now)
+	if err != nil || expiresAt.Valid {
+		t.Errorf("Expected a token without expiry, got %v (%v)", expiresAt, err)
+	}
+
+	invalid := []url.Values{
+		{"name": {""}, "scope": {"read"}, "expires_in": {"30"}},
+		{"name": {strings.Repeat("a", maxAccessTokenNameLength+1)}, "scope": {"read"}, "expires_in": {"30"}},
+		{"name": {"x"}, "scope": {"admin"}, "expires_in": {"30"}},
+		{"name": {"x"}, "scope": {"read"}, "expires_in": {"7"}},
+		{"name": {"x"}, "scope": {"read"}, "expires_in": {""}},
+	}
+	for _, values := range invalid {
+		if _, _, _, err := parseAccessToken(newRequest(values), now); err == nil {
+			t.Errorf("Expected %v to be rejected", values)
+		}
+	}
+}
+
+func TestAccessTokenIsExpired(t *testing.T) {
+	now := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+
+	if (AccessToken{}).IsExpired(now) {
+		t.Error("Expected a token without expiry never to expire")
+	}
+	if (AccessToken{ExpiresAt: now.Add(time.Minute)}).IsExpired(now) {
+		t.Error("Expected a token expiring later to be valid")
+	}
+	if !(AccessToken{ExpiresAt: now}).IsExpired(now) {
+		t.Error("Expected a token to be expired at its expiry")
+	}
+}
+
+func TestAccessTokenAllows(t *testing.T) {
+	read := AccessToken{Scope: accessTokenScopeRead}
+	write := AccessToken{Scope: accessTokenScopeWrite}
+
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodOptions} {
+		if !read.Allows(method) || !write.Allows(method) {
+			t.Errorf("Expected every token to allow %s", method)
+		}
+	}
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		if read.Allows(method) {
+			t.Errorf("Expected a read-only token to forbid %s", method)
+		}
+		if !write.Allows(method) {
+			t.Errorf("Expected a write token to allow %s", method)
+		}
+	}
+}
+
+func TestAuthenticateAccessTokenRejectsForeignTokens(t *testing.T) {
+	// Values without the token prefix are rejected before any lookup.
+	if _, _, err := authenticateAccessToken(nil, "a-session-id", time.Now()); !errors.Is(err, ErrInvalidAccessToken) {
+		t.Errorf("Expected ErrInvalidAccessToken, got %v", err)
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	var called bool
+	handler := BearerAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/coproprietes", nil))
+	if !called || w.Code != http.StatusNoContent {
+		t.Errorf("Expected requests without Authorization header to go through, got %d", w.Code)
+	}
+
+	called = false
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/coproprietes", nil)
+	r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	handler.ServeHTTP(w, r)
+	if called || w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a non-Bearer Authorization header to be rejected, got %d", w.Code)
+	}
+	if detail := decodeAPIErrorBody(t, w); detail.Code != apiErrorUnauthorized {
+		t.Errorf("Expected an unauthorized error, got %+v", detail)
+	}
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Error("Expected a WWW-Authenticate challenge")
+	}
+}
+
+func TestGetAuthenticatedUserIDFromAccessToken(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/coproprietes", nil)
+	r = r.WithContext(context.WithValue(r.Context(), accessTokenUserKey{}, "42"))
+
+	w := httptest.NewRecorder()
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok || userID != "42" {
+		t.Errorf("Expected the owner of the token, got %q, %v", userID, ok)
+	}
+	if len(w.Result().Cookies()) != 0 {
+		t.Error("Expected no session to be started for a token request")
+	}
+}
@@ -0,0 +1,238 @@
+package domains
+
+import (
+	"sort"
+	"time"
+)
+
+// Allocate splits amount proportionally to weights so that the shares always
+// add up to amount exactly. Every share is first rounded down to the cent, then
+// the leftover cents go one by one to the largest remainders. Equal remainders
+// are broken by position, so the same input always yields the same split.
+//
+// When the weights sum to zero nothing can be distributed and every share is
+// zero.
+func Allocate(amount Money, weights []int) []Money {
+	shares := make([]Money, len(weights))
+
+	var totalWeight int64
+	for _, weight := range weights {
+		if weight > 0 {
+			totalWeight += int64(weight)
+		}
+	}
+	if totalWeight == 0 {
+		return shares
+	}
+
+	if amount < 0 {
+		for i, share := range Allocate(-amount, weights) {
+			shares[i] = -share
+		}
+		return shares
+	}
+
+	remainders := make([]int64, len(weights))
+	var allocated Money
+	for i, weight := range weights {
+		if weight <= 0 {
+			continue
+		}
+		product := int64(amount) * int64(weight)
+		shares[i] = Money(product / totalWeight)
+		remainders[i] = product % totalWeight
+		allocated += shares[i]
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+
+	for _, i := range order[:amount-allocated] {
+		shares[i]++
+	}
+
+	return shares
+}
+
+// Allocator distributes the amounts of a building across its persons through
+// the lots they own, either by the general tantièmes of the lots or by the
+// tantièmes of a charge key. Persons and lots are expected in a stable order
+// (by id) since ties in the rounding are broken by position.
+type Allocator struct {
+	Persons         []Person
+	Lots            []Lot
+	ChargeKeys      []ChargeKey
+	FiscalYearStart time.Month
+}
+
+// NewAllocator returns an allocator where every person holds a single lot,
+// with the same id as the person, carrying their tantièmes.
+func NewAllocator(persons []Person, chargeKeys ...ChargeKey) Allocator {
+	lots := make([]Lot, len(persons))
+	for i, person := range persons {
+		lots[i] = Lot{ID: person.ID, Name: person.Name, Tantieme: person.Tantieme, Owners: []Ownership{{LotID: person.ID, PersonID: person.ID}}}
+	}
+	return NewLotAllocator(persons, lots, time.January, chargeKeys...)
+}
+
+// NewLotAllocator returns an allocator over the lots of a building, fiscal
+// years starting on fiscalYearStart.
+func NewLotAllocator(persons []Person, lots []Lot, fiscalYearStart time.Month, chargeKeys ...ChargeKey) Allocator {
+	return Allocator{Persons: persons, Lots: lots, ChargeKeys: chargeKeys, FiscalYearStart: fiscalYearStart}
+}
+
+// Split returns the share of every person, in the order of Persons, each lot
+// being charged to its latest owner. A zero chargeKeyID, or an unknown one,
+// splits with the general tantièmes.
+func (allocator Allocator) Split(amount Money, chargeKeyID int) []Money {
+	return allocator.split(amount, chargeKeyID, func(lot Lot) ([]int, []int) {
+		if owner := lot.LatestOwner(); owner != 0 {
+			return []int{owner}, []int{1}
+		}
+		return nil, nil
+	})
+}
+
+// SplitOver returns the share of every person, in the order of Persons, for
+// an amount covering the days from from to to (exclusive). When a lot changed
+// hands in between, its share is split between the owners pro rata of the
+// days they held it.
+func (allocator Allocator) SplitOver(amount Money, chargeKeyID int, from, to time.Time) []Money {
+	return allocator.split(amount, chargeKeyID, func(lot Lot) ([]int, []int) {
+		return lot.Holdings(from, to)
+	})
+}
+
+// split allocates amount between the lots held by someone, then the share of
+// every lot between its holders.
+func (allocator Allocator) split(amount Money, chargeKeyID int, holdings func(lot Lot) ([]int, []int)) []Money {
+	positions := make(map[int]int, len(allocator.Persons))
+	for i, person := range allocator.Persons {
+		positions[person.ID] = i
+	}
+
+	chargeKey, hasChargeKey := allocator.chargeKey(chargeKeyID)
+
+	weights := make([]int, len(allocator.Lots))
+	holders := make([][]int, len(allocator.Lots))
+	days := make([][]int, len(allocator.Lots))
+	for i, lot := range allocator.Lots {
+		personIDs, personDays := holdings(lot)
+		for j, personID := range personIDs {
+			if _, ok := positions[personID]; ok {
+				holders[i] = append(holders[i], personID)
+				days[i] = append(days[i], personDays[j])
+			}
+		}
+		if len(holders[i]) > 0 {
+			weights[i] = lotWeight(lot, chargeKey, hasChargeKey)
+		}
+	}
+
+	shares := make([]Money, len(allocator.Persons))
+	for i, lotShare := range Allocate(amount, weights) {
+		if lotShare == 0 {
+			continue
+		}
+		for j, holderShare := range Allocate(lotShare, days[i]) {
+			shares[positions[holders[i][j]]] += holderShare
+		}
+	}
+	return shares
+}
+
+// UnallocatedOver returns the part of amount SplitOver charges to nobody,
+// which is all of it when no lot weighing in the charge key is held by a
+// person over the days from from to to (exclusive).
+func (allocator Allocator) UnallocatedOver(amount Money, chargeKeyID int, from, to time.Time) Money {
+	for _, share := range allocator.SplitOver(amount, chargeKeyID, from, to) {
+		amount -= share
+	}
+	return amount
+}
+
+// ShareOf returns the share of amount owed by person, each lot being charged
+// to its latest owner, or zero when the person is not part of the allocator.
+func (allocator Allocator) ShareOf(person *Person, amount Money, chargeKeyID int) Money {
+	return allocator.shareIn(person, allocator.Split(amount, chargeKeyID))
+}
+
+// ShareOver returns the share of amount owed by person for the days from
+// from to to (exclusive).
+func (allocator Allocator) ShareOver(person *Person, amount Money, chargeKeyID int, from, to time.Time) Money {
+	return allocator.shareIn(person, allocator.SplitOver(amount, chargeKeyID, from, to))
+}
+
+func (allocator Allocator) shareIn(person *Person, shares []Money) Money {
+	for i, share := range shares {
+		if allocator.Persons[i].ID == person.ID {
+			return share
+		}
+	}
+	return 0
+}
+
+// FiscalYearBounds returns the first day of a fiscal year and the first day
+// of the next one.
+func (allocator Allocator) FiscalYearBounds(fiscalYear int) (time.Time, time.Time) {
+	start := fiscalYearStartDate(fiscalYear, allocator.FiscalYearStart)
+	return start, start.AddDate(1, 0, 0)
+}
+
+// TotalTantiemes returns the sum of the tantièmes used by a charge key, or of
+// the general tantièmes when chargeKeyID is zero.
+func (allocator Allocator) TotalTantiemes(chargeKeyID int) int {
+	chargeKey, hasChargeKey := allocator.chargeKey(chargeKeyID)
+
+	total := 0
+	for _, lot := range allocator.Lots {
+		total += lotWeight(lot, chargeKey, hasChargeKey)
+	}
+	return total
+}
+
+// TantiemeOf returns the tantièmes of the lots person owns on date for a
+// charge key, or their general tantièmes when chargeKeyID is zero.
+func (allocator Allocator) TantiemeOf(person Person, chargeKeyID int, date time.Time) int {
+	chargeKey, hasChargeKey := allocator.chargeKey(chargeKeyID)
+
+	total := 0
+	for _, lot := range allocator.Lots {
+		if lot.OwnerAt(date) == person.ID {
+			total += lotWeight(lot, chargeKey, hasChargeKey)
+		}
+	}
+	return total
+}
+
+// ChargeKeyName returns the display name of a charge key.
+func (allocator Allocator) ChargeKeyName(chargeKeyID int) string {
+	if chargeKey, ok := allocator.chargeKey(chargeKeyID); ok {
+		return chargeKey.Name
+	}
+	return generalChargeKeyName
+}
+
+func (allocator Allocator) chargeKey(chargeKeyID int) (ChargeKey, bool) {
+	if chargeKeyID == 0 {
+		return ChargeKey{}, false
+	}
+	for _, chargeKey := range allocator.ChargeKeys {
+		if chargeKey.ID == chargeKeyID {
+			return chargeKey, true
+		}
+	}
+	return ChargeKey{}, false
+}
+
+func lotWeight(lot Lot, chargeKey ChargeKey, hasChargeKey bool) int {
+	if hasChargeKey {
+		return chargeKey.Tantieme(lot)
+	}
+	return lot.Tantieme
+}
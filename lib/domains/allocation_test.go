@@ -0,0 +1,118 @@
+package domains
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   Money
+		weights  []int
+		expected []Money
+	}{
+		{"even split", 100000, []int{1, 1}, []Money{50000, 50000}},
+		{"three ways", 100000, []int{1, 1, 1}, []Money{33334, 33333, 33333}},
+		{"largest remainder wins", 100, []int{1, 2, 4}, []Money{14, 29, 57}},
+		{"ties broken by position", 2, []int{1, 1, 1}, []Money{1, 1, 0}},
+		{"negative amount", -100000, []int{1, 1, 1}, []Money{-33334, -33333, -33333}},
+		{"zero weight gets nothing", 1001, []int{0, 1, 1}, []Money{0, 501, 500}},
+		{"no weights", 1000, []int{0, 0}, []Money{0, 0}},
+		{"empty", 1000, nil, []Money{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Allocate(tt.amount, tt.weights)
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("Allocate(%d, %v) = %v, expected %v", tt.amount, tt.weights, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAllocateAlwaysSumsToAmount(t *testing.T) {
+	weights := []int{137, 211, 89, 305, 58, 200}
+	for amount := Money(-5000); amount <= 5000; amount += 7 {
+		var total Money
+		for _, share := range Allocate(amount, weights) {
+			total += share
+		}
+		if total != amount {
+			t.Fatalf("Shares of %d sum to %d", amount, total)
+		}
+	}
+}
+
+func TestAllocatorSplit(t *testing.T) {
+	allocator := NewAllocator([]Person{
+		{ID: 1, Name: "A", Tantieme: 300},
+		{ID: 2, Name: "B", Tantieme: 300},
+		{ID: 3, Name: "C", Tantieme: 400},
+	})
+
+	if total := allocator.TotalTantiemes(0); total != 1000 {
+		t.Errorf("Expected 1000 tantiemes, got %d", total)
+	}
+
+	shares := allocator.Split(1001, 0)
+	if !slices.Equal(shares, []Money{300, 300, 401}) {
+		t.Errorf("Unexpected split %v", shares)
+	}
+
+	if share := allocator.ShareOf(&allocator.Persons[2], 1001, 0); share != 401 {
+		t.Errorf("Expected share 401, got %d", share)
+	}
+}
+
+func TestAllocatorSplitWithChargeKey(t *testing.T) {
+	persons := []Person{
+		{ID: 1, Name: "A", Tantieme: 300},
+		{ID: 2, Name: "B", Tantieme: 300},
+		{ID: 3, Name: "C", Tantieme: 400},
+	}
+	elevator := ChargeKey{ID: 7, Name: "Ascenseur", Tantiemes: map[int]int{2: 1, 3: 2}}
+	allocator := NewAllocator(persons, elevator)
+
+	if total := allocator.TotalTantiemes(7); total != 3 {
+		t.Errorf("Expected 3 tantiemes, got %d", total)
+	}
+
+	shares := allocator.Split(1000, 7)
+	if !slices.Equal(shares, []Money{0, 333, 667}) {
+		t.Errorf("Unexpected split %v", shares)
+	}
+
+	if name := allocator.ChargeKeyName(7); name != "Ascenseur" {
+		t.Errorf("Expected charge key name Ascenseur, got %s", name)
+	}
+
+	// Unknown keys fall back to the general tantiemes.
+	if name := allocator.ChargeKeyName(42); name != generalChargeKeyName {
+		t.Errorf("Expected general charge key name, got %s", name)
+	}
+	if shares := allocator.Split(1000, 42); !slices.Equal(shares, []Money{300, 300, 400}) {
+		t.Errorf("Unexpected fallback split %v", shares)
+	}
+}
+
+func TestAllocatorUnallocatedOver(t *testing.T) {
+	persons := []Person{{ID: 1, Name: "A"}}
+	lots := []Lot{
+		{ID: 1, Name: "Lot 1", Tantieme: 100, Owners: []Ownership{{LotID: 1, PersonID: 1}}},
+		// Sold by a person who left the building.
+		{ID: 2, Name: "Lot 2", Tantieme: 100, Owners: []Ownership{{LotID: 2, PersonID: 9}}},
+	}
+	empty := ChargeKey{ID: 7, Name: "Vide", Tantiemes: map[int]int{2: 10}}
+	allocator := NewLotAllocator(persons, lots, time.January, empty)
+	from, to := allocator.FiscalYearBounds(2025)
+
+	if unallocated := allocator.UnallocatedOver(1000, 0, from, to); unallocated != 0 {
+		t.Errorf("Expected the general tantiemes to allocate everything, got %d left", unallocated)
+	}
+	if unallocated := allocator.UnallocatedOver(1000, 7, from, to); unallocated != 1000 {
+		t.Errorf("Expected a key without holders to allocate nothing, got %d left", unallocated)
+	}
+}
@@ -0,0 +1,508 @@
+package domains
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+// The JSON API is served by the api binary under /api/v1. Every building
+// resource lives under /api/v1/coproprietes/{copropriete}, so that clients
+// never depend on the building selected in the web session.
+
+const (
+	defaultPerPage = 50
+	maxPerPage     = 200
+
+	// maxAPIBodySize bounds the JSON bodies accepted by the API.
+	maxAPIBodySize = 1 << 20
+)
+
+// Error codes of the API, stable for clients to branch on.
+const (
+	apiErrorInvalidRequest    = "invalid_request"
+	apiErrorUnauthorized      = "unauthorized"
+	apiErrorInsufficientScope = "insufficient_scope"
+	apiErrorNotFound          = "not_found"
+	apiErrorConflict          = "conflict"
+	apiErrorFreeTierLimit     = "free_tier_limit"
+	apiErrorUnsupportedMedia  = "unsupported_media_type"
+	apiErrorInternal          = "internal_error"
+)
+
+type apiErrorBody struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Pagination describes the page of a list response. Pages are numbered from
+// 1; TotalPages is 0 for an empty list.
+type Pagination struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Total      int `json:"total"`
+	TotalPages int `json:"total_pages"`
+}
+
+type apiListBody struct {
+	Data       any        `json:"data"`
+	Pagination Pagination `json:"pagination"`
+}
+
+type apiItemBody struct {
+	Data any `json:"data"`
+}
+
+// apiScope is what a building resource handler runs against, once the user
+// is authenticated and the building checked to be theirs.
+type apiScope struct {
+	DB            *sql.DB
+	UserID        string
+	CoproprieteID int
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Error writing JSON response: %v", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, apiErrorBody{Error: apiErrorDetail{Code: code, Message: message}})
+}
+
+// writeAPIInternalError logs err and answers with a generic message, so that
+// database details never reach API clients.
+func writeAPIInternalError(w http.ResponseWriter, err error) {
+	log.Printf("API error: %v", err)
+	writeAPIError(w, http.StatusInternalServerError, apiErrorInternal, "Internal server error")
+}
+
+// parsePagination reads the page and per_page query parameters.
+func parsePagination(values url.Values) (Pagination, error) {
+	pagination := Pagination{Page: 1, PerPage: defaultPerPage}
+	if value := values.Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return Pagination{}, fmt.Errorf("invalid page value")
+		}
+		pagination.Page = page
+	}
+	if value := values.Get("per_page"); value != "" {
+		perPage, err := strconv.Atoi(value)
+		if err != nil || perPage < 1 || perPage > maxPerPage {
+			return Pagination{}, fmt.Errorf("invalid per_page value: expected 1 to %d", maxPerPage)
+		}
+		pagination.PerPage = perPage
+	}
+	return pagination, nil
+}
+
+// paginate returns the page of items selected by pagination, along with the
+// pagination completed with the totals. A page past the end is empty.
+func paginate[T any](items []T, pagination Pagination) ([]T, Pagination) {
+	pagination.Total = len(items)
+	pagination.TotalPages = (len(items) + pagination.PerPage - 1) / pagination.PerPage
+
+	start := min((pagination.Page-1)*pagination.PerPage, len(items))
+	end := min(start+pagination.PerPage, len(items))
+	page := make([]T, 0, end-start)
+	return append(page, items[start:end]...), pagination
+}
+
+// writeAPIList answers with the page of items requested by the query
+// string.
+func writeAPIList[T any](w http.ResponseWriter, r *http.Request, items []T) {
+	pagination, err := parsePagination(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrorInvalidRequest, err.Error())
+		return
+	}
+
+	page, pagination := paginate(items, pagination)
+	writeJSON(w, http.StatusOK, apiListBody{Data: page, Pagination: pagination})
+}
+
+// decodeAPIRequest reads the JSON body of r into value, reporting the error
+// to the client. Requiring a JSON content type also keeps the session-based
+// API out of reach of cross-site form posts.
+func decodeAPIRequest(w http.ResponseWriter, r *http.Request, value any) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeAPIError(w, http.StatusUnsupportedMediaType, apiErrorUnsupportedMedia, "Content-Type must be application/json")
+		return false
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrorInvalidRequest, apiDecodeErrorMessage(err))
+		return false
+	}
+	if decoder.More() {
+		writeAPIError(w, http.StatusBadRequest, apiErrorInvalidRequest, "Request body must hold a single JSON object")
+		return false
+	}
+	return true
+}
+
+func apiDecodeErrorMessage(err error) string {
+	var typeError *json.UnmarshalTypeError
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.As(err, &typeError):
+		return fmt.Sprintf("invalid %s value", typeError.Field)
+	case errors.As(err, &maxBytesError):
+		return "Request body too large"
+	case errors.Is(err, io.EOF):
+		return "Request body is empty"
+	case errors.Is(err, ErrInvalidMoney):
+		return "invalid amount value"
+	}
+	return "Invalid JSON body: " + err.Error()
+}
+
+// apiAuthenticate returns the authenticated user and the database, reporting
+// the error to the client otherwise.
+func apiAuthenticate(w http.ResponseWriter, r *http.Request) (string, *sql.DB, bool) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		writeAPIError(w, http.StatusUnauthorized, apiErrorUnauthorized, "Authentication required")
+		return "", nil, false
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return "", nil, false
+	}
+	return userID, db, true
+}
+
+// apiCoproprieteScope authenticates the request and checks that the building
+// of the {copropriete} path value belongs to the user.
+func apiCoproprieteScope(w http.ResponseWriter, r *http.Request) (apiScope, bool) {
+	userID, db, ok := apiAuthenticate(w, r)
+	if !ok {
+		return apiScope{}, false
+	}
+
+	coproprieteID, err := strconv.Atoi(r.PathValue("copropriete"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrorInvalidRequest, "Invalid copropriete id")
+		return apiScope{}, false
+	}
+
+	owned, err := isCoproprieteOwnedBy(db, coproprieteID, userID)
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return apiScope{}, false
+	}
+	if !owned {
+		writeAPIError(w, http.StatusNotFound, apiErrorNotFound, "Copropriete not found")
+		return apiScope{}, false
+	}
+
+	return apiScope{DB: db, UserID: userID, CoproprieteID: coproprieteID}, true
+}
+
+// apiPathID reads the {id} path value, naming the resource in the error.
+func apiPathID(w http.ResponseWriter, r *http.Request, resource string) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrorInvalidRequest, "Invalid "+resource+" id")
+		return 0, false
+	}
+	return id, true
+}
+
+// apiPeriod reads the period query parameters, as accepted by the dashboard.
+func apiPeriod(w http.ResponseWriter, r *http.Request, scope apiScope) (Period, bool) {
+	fiscalYearStart, err := getFiscalYearStart(scope.DB, scope.CoproprieteID)
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return Period{}, false
+	}
+
+	period, err := ParsePeriod(r.URL.Query(), fiscalYearStart, time.Now())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrorInvalidRequest, err.Error())
+		return Period{}, false
+	}
+	return period, true
+}
+
+// checkAPICreationLimit applies the free-tier quota of a resource, as the web
+// handlers do, reporting the error to the client when it is reached.
+func checkAPICreationLimit(w http.ResponseWriter, allowed bool, err error, resource string) bool {
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return false
+	}
+	if !allowed {
+		writeAPIError(w, http.StatusForbidden, apiErrorFreeTierLimit, "Free plan limit reached for "+resource+", a premium subscription is required to add more")
+		return false
+	}
+	return true
+}
+
+type apiCopropriete struct {
+	ID              int    `json:"id"`
+	Name            string `json:"name"`
+	FiscalYearStart int    `json:"fiscal_year_start"` // month, 1 to 12
+	WorksFundRate   int    `json:"works_fund_rate"`
+}
+
+func newAPICopropriete(copropriete Copropriete) apiCopropriete {
+	return apiCopropriete{
+		ID:              copropriete.ID,
+		Name:            copropriete.Name,
+		FiscalYearStart: int(copropriete.FiscalYearStart),
+		WorksFundRate:   copropriete.WorksFundRate,
+	}
+}
+
+// APICoproprietesHandler lists the buildings of the user.
+func APICoproprietesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, db, ok := apiAuthenticate(w, r)
+	if !ok {
+		return
+	}
+
+	coproprietes, err := getUserCoproprietes(db, userID)
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return
+	}
+
+	items := make([]apiCopropriete, 0, len(coproprietes))
+	for _, copropriete := range coproprietes {
+		items = append(items, newAPICopropriete(copropriete))
+	}
+	writeAPIList(w, r, items)
+}
+
+type apiPeriodBody struct {
+	Kind       string `json:"kind"` // all, year, quarter or custom
+	Label      string `json:"label"`
+	FiscalYear int    `json:"fiscal_year,omitempty"`
+	Quarter    int    `json:"quarter,omitempty"`
+	From       string `json:"from,omitempty"`
+	To         string `json:"to,omitempty"` // inclusive
+}
+
+func newAPIPeriod(period Period) apiPeriodBody {
+	body := apiPeriodBody{Kind: period.Kind, Label: period.Label(), FiscalYear: period.FiscalYear, Quarter: period.Quarter}
+	if period.Kind == periodAll {
+		body.Kind = "all"
+	}
+	if !period.Start.IsZero() {
+		body.From = period.Start.Format(dateLayout)
+		body.To = period.LastDay().Format(dateLayout)
+	}
+	return body
+}
+
+// apiBalance is the account of a person over a period, as shown on the
+// dashboard.
+type apiBalance struct {
+	PersonID    int    `json:"person_id"`
+	Name        string `json:"name"`
+	Tantieme    int    `json:"tantieme"`
+	Called      Money  `json:"called"`
+	Paid        Money  `json:"paid"`
+	CarriedOver Money  `json:"carried_over"`
+	// Opening is what the person owed when the period started, and
+	// Outstanding what they owe at its end.
+	Opening     Money `json:"opening"`
+	Outstanding Money `json:"outstanding"`
+	// Charges is the balance of the calls for funds against the share of the
+	// expenses, see Person.CalculateLeft.
+	Charges   Money `json:"charges"`
+	WorksFund Money `json:"works_fund"`
+}
+
+func newAPIBalances(data DashboardData) []apiBalance {
+	balances := make([]apiBalance, 0, len(data.Persons))
+	for _, person := range data.Persons {
+		var called Money
+		for _, provision := range data.Provisions {
+			called += person.CalculateProvision(data.Allocator, provision)
+		}
+		balances = append(balances, apiBalance{
+			PersonID:    person.ID,
+			Name:        person.Name,
+			Tantieme:    person.Tantieme,
+			Called:      called,
+			Paid:        person.CalculatePaid(data.Payments),
+			CarriedOver: person.CalculateCarriedOver(data.Regularizations),
+			Opening:     data.OpeningBalance(person),
+			Outstanding: data.PersonOutstanding(person),
+			Charges:     person.CalculateLeft(data.Allocator, data.Bills, data.Provisions),
+			WorksFund:   person.CalculateWorksFund(data.Allocator, data.Provisions),
+		})
+	}
+	return balances
+}
+
+// loadAPIDashboardData loads the dashboard of the building over the period of
+// the query string.
+func loadAPIDashboardData(w http.ResponseWriter, r *http.Request) (DashboardData, bool) {
+	scope, ok := apiCoproprieteScope(w, r)
+	if !ok {
+		return DashboardData{}, false
+	}
+
+	period, ok := apiPeriod(w, r, scope)
+	if !ok {
+		return DashboardData{}, false
+	}
+
+	data, err := getDashboardData(scope.UserID, scope.CoproprieteID, period)
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return DashboardData{}, false
+	}
+	return data, true
+}
+
+// APIBalancesHandler lists the accounts of the persons of a building over a
+// period.
+func APIBalancesHandler(w http.ResponseWriter, r *http.Request) {
+	data, ok := loadAPIDashboardData(w, r)
+	if !ok {
+		return
+	}
+
+	writeAPIList(w, r, newAPIBalances(data))
+}
+
+type apiWorksFund struct {
+	Contributions Money `json:"contributions"`
+	Spent         Money `json:"spent"`
+	Balance       Money `json:"balance"`
+}
+
+type apiCategoryTotal struct {
+	Code   string  `json:"code"` // empty for the uncategorized bills
+	Label  string  `json:"label"`
+	Bills  int     `json:"bills"`
+	Amount Money   `json:"amount"`
+	Share  float64 `json:"share"` // percent of the bills
+}
+
+type apiSupplierTotal struct {
+	SupplierID int    `json:"supplier_id"` // zero for the bills without supplier
+	Name       string `json:"name"`
+	Bills      int    `json:"bills"`
+	Amount     Money  `json:"amount"`
+}
+
+type apiDashboardCounts struct {
+	Persons    int `json:"persons"`
+	Bills      int `json:"bills"`
+	Provisions int `json:"provisions"`
+	Payments   int `json:"payments"`
+}
+
+// apiDashboard is the aggregate of the dashboard. The entries themselves are
+// served by the paginated list endpoints.
+type apiDashboard struct {
+	Copropriete    apiCopropriete     `json:"copropriete"`
+	Period         apiPeriodBody      `json:"period"`
+	FiscalYears    []int              `json:"fiscal_years"`
+	IsPremium      bool               `json:"is_premium"`
+	TotalTantiemes int                `json:"total_tantiemes"`
+	Counts         apiDashboardCounts `json:"counts"`
+	Balance        Money              `json:"balance"`
+	Unallocated    Money              `json:"unallocated"`
+	Paid           Money              `json:"paid"`
+	CarriedOver    Money              `json:"carried_over"`
+	Opening        Money              `json:"opening"`
+	Outstanding    Money              `json:"outstanding"`
+	WorksFund      apiWorksFund       `json:"works_fund"`
+	Balances       []apiBalance       `json:"balances"`
+	CategoryTotals []apiCategoryTotal `json:"category_totals"`
+	SupplierTotals []apiSupplierTotal `json:"supplier_totals"`
+}
+
+func newAPIDashboard(data DashboardData) apiDashboard {
+	dashboard := apiDashboard{
+		Copropriete:    newAPICopropriete(data.Copropriete),
+		Period:         newAPIPeriod(data.Period),
+		FiscalYears:    data.FiscalYears,
+		IsPremium:      data.IsPremium,
+		TotalTantiemes: data.TotalTantiemes,
+		Counts: apiDashboardCounts{
+			Persons:    len(data.Persons),
+			Bills:      len(data.Bills),
+			Provisions: len(data.Provisions),
+			Payments:   len(data.Payments),
+		},
+		Balance:     data.Balance,
+		Unallocated: data.Unallocated,
+		Paid:        data.Paid,
+		CarriedOver: data.CarriedOver,
+		Opening:     data.Opening,
+		Outstanding: data.Outstanding,
+		WorksFund: apiWorksFund{
+			Contributions: data.WorksFund.Contributions,
+			Spent:         data.WorksFund.Spent,
+			Balance:       data.WorksFund.Balance(),
+		},
+		Balances:       newAPIBalances(data),
+		CategoryTotals: make([]apiCategoryTotal, 0, len(data.CategoryTotals)),
+		SupplierTotals: make([]apiSupplierTotal, 0, len(data.SupplierTotals)),
+	}
+	for _, total := range data.CategoryTotals {
+		dashboard.CategoryTotals = append(dashboard.CategoryTotals, apiCategoryTotal{
+			Code:   total.Category.Code,
+			Label:  total.Label(),
+			Bills:  total.Bills,
+			Amount: total.Amount,
+			Share:  total.Share,
+		})
+	}
+	for _, total := range data.SupplierTotals {
+		dashboard.SupplierTotals = append(dashboard.SupplierTotals, apiSupplierTotal{
+			SupplierID: total.Supplier.ID,
+			Name:       total.Supplier.Name,
+			Bills:      total.Bills,
+			Amount:     total.Amount,
+		})
+	}
+	return dashboard
+}
+
+// APIDashboardHandler returns the dashboard aggregate of a building over a
+// period.
+func APIDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	data, ok := loadAPIDashboardData(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiItemBody{Data: newAPIDashboard(data)})
+}
+
+// APINotFoundHandler answers the unknown routes of the API with a JSON error
+// rather than the plain text 404 of the mux.
+func APINotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusNotFound, apiErrorNotFound, "No route for "+r.Method+" "+r.URL.Path)
+}
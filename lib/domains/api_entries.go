@@ -0,0 +1,614 @@
+package domains
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+type apiPerson struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Tantieme int    `json:"tantieme"` // general tantièmes of the lots owned today
+}
+
+type apiPersonInput struct {
+	Name string `json:"name"`
+}
+
+func newAPIPerson(person Person) apiPerson {
+	return apiPerson{ID: person.ID, Name: person.Name, Tantieme: person.Tantieme}
+}
+
+// APIPersonsHandler lists the persons of a building.
+func APIPersonsHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := apiCoproprieteScope(w, r)
+	if !ok {
+		return
+	}
+
+	persons, err := getPersons(scope.DB, scope.UserID, scope.CoproprieteID)
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return
+	}
+
+	items := make([]apiPerson, 0, len(persons))
+	for _, person := range persons {
+		items = append(items, newAPIPerson(person))
+	}
+	writeAPIList(w, r, items)
+}
+
+func APIPersonHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := apiCoproprieteScope(w, r)
+	if !ok {
+		return
+	}
+
+	personID, ok := apiPathID(w, r, "person")
+	if !ok {
+		return
+	}
+
+	writeAPIPerson(w, scope, personID, http.StatusOK)
+}
+
+func APIAddPersonHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := apiCoproprieteScope(w, r)
+	if !ok {
+		return
+	}
+
+	canUserCreatePerson, err := helpers.CanUserCreatePerson(scope.DB, scope.UserID)
+	if !checkAPICreationLimit(w, canUserCreatePerson, err, "persons") {
+		return
+	}
+
+	input, ok := decodeAPIPersonInput(w, r)
+	if !ok {
+		return
+	}
+
+	var personID int
+	err = scope.DB.QueryRow("INSERT INTO persons (name, userId, coproprieteId) VALUES ($1, $2, $3) RETURNING id", input.Name, scope.UserID, scope.CoproprieteID).Scan(&personID)
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", apiResourcePath(scope, "persons", personID))
+	writeAPIPerson(w, scope, personID, http.StatusCreated)
+}
+
+func APIUpdatePersonHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := apiCoproprieteScope(w, r)
+	if !ok {
+		return
+	}
+
+	personID, ok := apiPathID(w, r, "person")
+	if !ok {
+		return
+	}
+
+	input, ok := decodeAPIPersonInput(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := scope.DB.Exec("UPDATE persons SET name = $1 WHERE id = $2 AND userId = $3 AND coproprieteId = $4", input.Name, personID, scope.UserID, scope.CoproprieteID)
+	if !checkAPIRowAffected(w, result, err, "Person") {
+		return
+	}
+
+	writeAPIPerson(w, scope, personID, http.StatusOK)
+}
+
+func APIDeletePersonHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := apiCoproprieteScope(w, r)
+	if !ok {
+		return
+	}
+
+	personID, ok := apiPathID(w, r, "person")
+	if !ok {
+		return
+	}
+
+	hasHistory, err := personHasHistory(scope.DB, personID, scope.UserID)
+	if err != nil && err != sql.ErrNoRows {
+		writeAPIInternalError(w, err)
+		return
+	}
+	if hasHistory {
+		writeAPIError(w, http.StatusConflict, apiErrorConflict, "person has payments, regularizations or lots")
+		return
+	}
+
+	result, err := scope.DB.Exec("DELETE FROM persons WHERE id = $1 AND userId = $2 AND coproprieteId = $3", personID, scope.UserID, scope.CoproprieteID)
+	if !checkAPIRowAffected(w, result, err, "Person") {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeAPIPersonInput(w http.ResponseWriter, r *http.Request) (apiPersonInput, bool) {
+	var input apiPersonInput
+	if !decodeAPIRequest(w, r, &input) {
+		return input, false
+	}
+
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		writeAPIError(w, http.StatusBadRequest, apiErrorInvalidRequest, "name cannot be empty")
+		return input, false
+	}
+	return input, true
+}
+
+// writeAPIPerson answers with a person of the building, along with their
+// tantièmes.
+func writeAPIPerson(w http.ResponseWriter, scope apiScope, personID int, status int) {
+	persons, err := getPersons(scope.DB, scope.UserID, scope.CoproprieteID)
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return
+	}
+
+	for _, person := range persons {
+		if person.ID == personID {
+			writeJSON(w, status, apiItemBody{Data: newAPIPerson(person)})
+			return
+		}
+	}
+	writeAPIError(w, http.StatusNotFound, apiErrorNotFound, "Person not found")
+}
+
+// apiEntry is a bill or a provision. Amounts are decimal strings, dates are
+// formatted as 2006-01-02 and a zero charge key stands for the general
+// tantièmes.
+type apiEntry struct {
+	ID          int    `json:"id"`
+	Label       string `json:"label"`
+	Amount      Money  `json:"amount"`
+	Date        string `json:"date"`
+	FiscalYear  int    `json:"fiscal_year"`
+	ChargeKeyID int    `json:"charge_key_id"`
+	WorksFund   bool   `json:"works_fund"`
+}
+
+type apiBill struct {
+	apiEntry
+	SupplierID int    `json:"supplier_id"`
+	Category   string `json:"category"`
+}
+
+type apiProvision struct {
+	apiEntry
+}
+
+// apiEntryInput is the body creating or replacing a bill or a provision. A
+// zero fiscal year stands for the one containing the date.
+type apiEntryInput struct {
+	Label       string `json:"label"`
+	Amount      *Money `json:"amount"`
+	Date        string `json:"date"`
+	FiscalYear  int    `json:"fiscal_year"`
+	ChargeKeyID int64  `json:"charge_key_id"`
+	WorksFund   bool   `json:"works_fund"`
+}
+
+type apiBillInput struct {
+	apiEntryInput
+	SupplierID int64  `json:"supplier_id"`
+	Category   string `json:"category"`
+}
+
+// apiEntryValues is an apiEntryInput checked against the building, ready to
+// be stored.
+type apiEntryValues struct {
+	Label       string
+	Amount      Money
+	Date        time.Time
+	FiscalYear  int
+	ChargeKeyID sql.NullInt64
+	WorksFund   bool
+}
+
+func newAPIBill(bill Bill) apiBill {
+	return apiBill{
+		apiEntry:   apiEntry{ID: bill.ID, Label: bill.Label, Amount: bill.Amount, Date: bill.Date.Format(dateLayout), FiscalYear: bill.FiscalYear, ChargeKeyID: bill.ChargeKeyID, WorksFund: bill.WorksFund},
+		SupplierID: bill.SupplierID,
+		Category:   bill.Category,
+	}
+}
+
+func newAPIProvision(provision Provision) apiProvision {
+	return apiProvision{
+		apiEntry: apiEntry{ID: provision.ID, Label: provision.Label, Amount: provision.Amount, Date: provision.Date.Format(dateLayout), FiscalYear: provision.FiscalYear, ChargeKeyID: provision.ChargeKeyID, WorksFund: provision.WorksFund},
+	}
+}
+
+// checkAPIEntryInput validates the fields shared by bills and provisions,
+// with the rules of the web forms, reporting the error to the client.
+func checkAPIEntryInput(w http.ResponseWriter, scope apiScope, input apiEntryInput) (apiEntryValues, bool) {
+	if input.Amount == nil || *input.Amount < 0 {
+		writeAPIError(w, http.StatusBadRequest, apiErrorInvalidRequest, "invalid amount value")
+		return apiEntryValues{}, false
+	}
+
+	date, err := time.Parse(dateLayout, input.Date)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrorInvalidRequest, "invalid date value")
+		return apiEntryValues{}, false
+	}
+
+	fiscalYearStart, err := getFiscalYearStart(scope.DB, scope.CoproprieteID)
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return apiEntryValues{}, false
+	}
+
+	fiscalYear, err := checkFiscalYear(date, input.FiscalYear, fiscalYearStart)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrorInvalidRequest, err.Error())
+		return apiEntryValues{}, false
+	}
+
+	chargeKeyID, err := checkChargeKeyID(scope.DB, input.ChargeKeyID, scope.UserID, scope.CoproprieteID)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrorInvalidRequest, err.Error())
+		return apiEntryValues{}, false
+	}
+
+	return apiEntryValues{
+		Label:       input.Label,
+		Amount:      *input.Amount,
+		Date:        date,
+		FiscalYear:  fiscalYear,
+		ChargeKeyID: chargeKeyID,
+		WorksFund:   input.WorksFund,
+	}, true
+}
+
+// decodeAPIEntryInput reads and checks the body of a provision.
+func decodeAPIEntryInput(w http.ResponseWriter, r *http.Request, scope apiScope) (apiEntryValues, bool) {
+	var input apiEntryInput
+	if !decodeAPIRequest(w, r, &input) {
+		return apiEntryValues{}, false
+	}
+
+	return checkAPIEntryInput(w, scope, input)
+}
+
+// decodeAPIBillInput reads and checks the body of a bill, returning its
+// supplier and category along with the shared fields.
+func decodeAPIBillInput(w http.ResponseWriter, r *http.Request, scope apiScope) (apiEntryValues, sql.NullInt64, string, bool) {
+	var input apiBillInput
+	if !decodeAPIRequest(w, r, &input) {
+		return apiEntryValues{}, sql.NullInt64{}, "", false
+	}
+
+	values, ok := checkAPIEntryInput(w, scope, input.apiEntryInput)
+	if !ok {
+		return apiEntryValues{}, sql.NullInt64{}, "", false
+	}
+
+	supplierID, err := checkSupplierID(scope.DB, input.SupplierID, scope.UserID, scope.CoproprieteID)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrorInvalidRequest, err.Error())
+		return apiEntryValues{}, sql.NullInt64{}, "", false
+	}
+
+	category, err := checkExpenseCategory(input.Category)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrorInvalidRequest, err.Error())
+		return apiEntryValues{}, sql.NullInt64{}, "", false
+	}
+
+	return values, supplierID, category, true
+}
+
+// checkAPIRowAffected reports the error of an update or a delete to the
+// client, or a 404 when no row of the building matched.
+func checkAPIRowAffected(w http.ResponseWriter, result sql.Result, err error, resource string) bool {
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return false
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		writeAPIError(w, http.StatusNotFound, apiErrorNotFound, resource+" not found")
+		return false
+	}
+	return true
+}
+
+// apiResourcePath returns the path of a resource of the building, for the
+// Location header of created resources.
+func apiResourcePath(scope apiScope, collection string, id int) string {
+	return "/api/v1/coproprietes/" + strconv.Itoa(scope.CoproprieteID) + "/" + collection + "/" + strconv.Itoa(id)
+}
+
+// APIBillsHandler lists the bills of a building over the period of the query
+// string, optionally restricted to a supplier.
+func APIBillsHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := apiCoproprieteScope(w, r)
+	if !ok {
+		return
+	}
+
+	period, ok := apiPeriod(w, r, scope)
+	if !ok {
+		return
+	}
+
+	bills, err := getBills(scope.DB, scope.UserID, scope.CoproprieteID)
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return
+	}
+
+	suppliers, err := getSuppliers(scope.DB, scope.UserID, scope.CoproprieteID)
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return
+	}
+
+	supplierID, err := parseSupplierFilter(r, suppliers)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiErrorInvalidRequest, err.Error())
+		return
+	}
+
+	items := make([]apiBill, 0, len(bills))
+	for _, bill := range bills {
+		if period.Includes(bill.Date, bill.FiscalYear) && (supplierID == 0 || bill.SupplierID == supplierID) {
+			items = append(items, newAPIBill(bill))
+		}
+	}
+	writeAPIList(w, r, items)
+}
+
+func APIBillHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := apiCoproprieteScope(w, r)
+	if !ok {
+		return
+	}
+
+	billID, ok := apiPathID(w, r, "bill")
+	if !ok {
+		return
+	}
+
+	writeAPIBill(w, scope, billID, http.StatusOK)
+}
+
+func APIAddBillHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := apiCoproprieteScope(w, r)
+	if !ok {
+		return
+	}
+
+	canUserCreateBill, err := helpers.CanUserCreateBill(scope.DB, scope.UserID)
+	if !checkAPICreationLimit(w, canUserCreateBill, err, "bills") {
+		return
+	}
+
+	values, supplierID, category, ok := decodeAPIBillInput(w, r, scope)
+	if !ok {
+		return
+	}
+
+	var billID int
+	err = scope.DB.QueryRow("INSERT INTO bills (label, amount, chargeKeyId, date, fiscalYear, worksFund, supplierId, category, userId, coproprieteId) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id", values.Label, values.Amount, values.ChargeKeyID, values.Date, values.FiscalYear, values.WorksFund, supplierID, category, scope.UserID, scope.CoproprieteID).Scan(&billID)
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", apiResourcePath(scope, "bills", billID))
+	writeAPIBill(w, scope, billID, http.StatusCreated)
+}
+
+func APIUpdateBillHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := apiCoproprieteScope(w, r)
+	if !ok {
+		return
+	}
+
+	billID, ok := apiPathID(w, r, "bill")
+	if !ok {
+		return
+	}
+
+	values, supplierID, category, ok := decodeAPIBillInput(w, r, scope)
+	if !ok {
+		return
+	}
+
+	result, err := scope.DB.Exec("UPDATE bills SET label = $1, amount = $2, chargeKeyId = $3, date = $4, fiscalYear = $5, worksFund = $6, supplierId = $7, category = $8 WHERE id = $9 AND userId = $10 AND coproprieteId = $11", values.Label, values.Amount, values.ChargeKeyID, values.Date, values.FiscalYear, values.WorksFund, supplierID, category, billID, scope.UserID, scope.CoproprieteID)
+	if !checkAPIRowAffected(w, result, err, "Bill") {
+		return
+	}
+
+	writeAPIBill(w, scope, billID, http.StatusOK)
+}
+
+func APIDeleteBillHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := apiCoproprieteScope(w, r)
+	if !ok {
+		return
+	}
+
+	billID, ok := apiPathID(w, r, "bill")
+	if !ok {
+		return
+	}
+
+	attachments, err := getAttachments(scope.DB, scope.UserID, "billId", billID)
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return
+	}
+
+	result, err := scope.DB.Exec("DELETE FROM bills WHERE id = $1 AND userId = $2 AND coproprieteId = $3", billID, scope.UserID, scope.CoproprieteID)
+	if !checkAPIRowAffected(w, result, err, "Bill") {
+		return
+	}
+	deleteAttachmentBlobs(attachments)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeAPIBill(w http.ResponseWriter, scope apiScope, billID int, status int) {
+	bill, coproprieteID, err := getBill(scope.DB, billID, scope.UserID)
+	if err == sql.ErrNoRows || (err == nil && coproprieteID != scope.CoproprieteID) {
+		writeAPIError(w, http.StatusNotFound, apiErrorNotFound, "Bill not found")
+		return
+	}
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return
+	}
+
+	writeJSON(w, status, apiItemBody{Data: newAPIBill(bill)})
+}
+
+// APIProvisionsHandler lists the provisions of a building over the period of
+// the query string.
+func APIProvisionsHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := apiCoproprieteScope(w, r)
+	if !ok {
+		return
+	}
+
+	period, ok := apiPeriod(w, r, scope)
+	if !ok {
+		return
+	}
+
+	provisions, err := getProvisions(scope.DB, scope.UserID, scope.CoproprieteID)
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return
+	}
+
+	items := make([]apiProvision, 0, len(provisions))
+	for _, provision := range provisions {
+		if period.Includes(provision.Date, provision.FiscalYear) {
+			items = append(items, newAPIProvision(provision))
+		}
+	}
+	writeAPIList(w, r, items)
+}
+
+func APIProvisionHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := apiCoproprieteScope(w, r)
+	if !ok {
+		return
+	}
+
+	provisionID, ok := apiPathID(w, r, "provision")
+	if !ok {
+		return
+	}
+
+	writeAPIProvision(w, scope, provisionID, http.StatusOK)
+}
+
+func APIAddProvisionHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := apiCoproprieteScope(w, r)
+	if !ok {
+		return
+	}
+
+	canUserCreateProvision, err := helpers.CanUserCreateProvision(scope.DB, scope.UserID)
+	if !checkAPICreationLimit(w, canUserCreateProvision, err, "provisions") {
+		return
+	}
+
+	values, ok := decodeAPIEntryInput(w, r, scope)
+	if !ok {
+		return
+	}
+
+	var provisionID int
+	err = scope.DB.QueryRow("INSERT INTO provisions (label, amount, chargeKeyId, date, fiscalYear, worksFund, userId, coproprieteId) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id", values.Label, values.Amount, values.ChargeKeyID, values.Date, values.FiscalYear, values.WorksFund, scope.UserID, scope.CoproprieteID).Scan(&provisionID)
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", apiResourcePath(scope, "provisions", provisionID))
+	writeAPIProvision(w, scope, provisionID, http.StatusCreated)
+}
+
+func APIUpdateProvisionHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := apiCoproprieteScope(w, r)
+	if !ok {
+		return
+	}
+
+	provisionID, ok := apiPathID(w, r, "provision")
+	if !ok {
+		return
+	}
+
+	values, ok := decodeAPIEntryInput(w, r, scope)
+	if !ok {
+		return
+	}
+
+	result, err := scope.DB.Exec("UPDATE provisions SET label = $1, amount = $2, chargeKeyId = $3, date = $4, fiscalYear = $5, worksFund = $6 WHERE id = $7 AND userId = $8 AND coproprieteId = $9", values.Label, values.Amount, values.ChargeKeyID, values.Date, values.FiscalYear, values.WorksFund, provisionID, scope.UserID, scope.CoproprieteID)
+	if !checkAPIRowAffected(w, result, err, "Provision") {
+		return
+	}
+
+	writeAPIProvision(w, scope, provisionID, http.StatusOK)
+}
+
+func APIDeleteProvisionHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := apiCoproprieteScope(w, r)
+	if !ok {
+		return
+	}
+
+	provisionID, ok := apiPathID(w, r, "provision")
+	if !ok {
+		return
+	}
+
+	attachments, err := getAttachments(scope.DB, scope.UserID, "provisionId", provisionID)
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return
+	}
+
+	result, err := scope.DB.Exec("DELETE FROM provisions WHERE id = $1 AND userId = $2 AND coproprieteId = $3", provisionID, scope.UserID, scope.CoproprieteID)
+	if !checkAPIRowAffected(w, result, err, "Provision") {
+		return
+	}
+	deleteAttachmentBlobs(attachments)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeAPIProvision(w http.ResponseWriter, scope apiScope, provisionID int, status int) {
+	provision, coproprieteID, err := getProvision(scope.DB, provisionID, scope.UserID)
+	if err == sql.ErrNoRows || (err == nil && coproprieteID != scope.CoproprieteID) {
+		writeAPIError(w, http.StatusNotFound, apiErrorNotFound, "Provision not found")
+		return
+	}
+	if err != nil {
+		writeAPIInternalError(w, err)
+		return
+	}
+
+	writeJSON(w, status, apiItemBody{Data: newAPIProvision(provision)})
+}
@@ -0,0 +1,233 @@
+package domains
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePagination(t *testing.T) {
+	pagination, err := parsePagination(url.Values{})
+	if err != nil || pagination.Page != 1 || pagination.PerPage != defaultPerPage {
+		t.Errorf("Expected the first page by default, got %+v (%v)", pagination, err)
+	}
+
+	pagination, err = parsePagination(url.Values{"page": {"3"}, "per_page": {"20"}})
+	if err != nil || pagination.Page != 3 || pagination.PerPage != 20 {
+		t.Errorf("Expected page 3 of 20, got %+v (%v)", pagination, err)
+	}
+
+	invalid := []url.Values{
+		{"page": {"0"}},
+		{"page": {"abc"}},
+		{"per_page": {"0"}},
+		{"per_page": {"201"}},
+	}
+	for _, values := range invalid {
+		if _, err := parsePagination(values); err == nil {
+			t.Errorf("Expected %v to be rejected", values)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+
+	page, pagination := paginate(items, Pagination{Page: 2, PerPage: 2})
+	if len(page) != 2 || page[0] != 3 || page[1] != 4 {
+		t.Errorf("Expected [3 4], got %v", page)
+	}
+	if pagination.Total != 5 || pagination.TotalPages != 3 {
+		t.Errorf("Expected 5 items over 3 pages, got %+v", pagination)
+	}
+
+	page, _ = paginate(items, Pagination{Page: 3, PerPage: 2})
+	if len(page) != 1 || page[0] != 5 {
+		t.Errorf("Expected the last page to hold [5], got %v", page)
+	}
+
+	page, _ = paginate(items, Pagination{Page: 4, PerPage: 2})
+	if page == nil || len(page) != 0 {
+		t.Errorf("Expected an empty page past the end, got %#v", page)
+	}
+
+	page, pagination = paginate([]int(nil), Pagination{Page: 1, PerPage: 50})
+	if page == nil || pagination.Total != 0 || pagination.TotalPages != 0 {
+		t.Errorf("Expected an empty page of an empty list, got %#v %+v", page, pagination)
+	}
+}
+
+func TestWriteAPIList(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeAPIList(w, httptest.NewRequest(http.MethodGet, "/api/v1/coproprietes/1/persons?per_page=1&page=2", nil), []apiPerson{{ID: 1, Name: "Alice"}, {ID: 2, Name: "Bob"}})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", w.Code)
+	}
+	expected := `{"data":[{"id":2,"name":"Bob","tantieme":0}],"pagination":{"page":2,"per_page":1,"total":2,"total_pages":2}}`
+	if body := strings.TrimSpace(w.Body.String()); body != expected {
+		t.Errorf("Expected %s, got %s", expected, body)
+	}
+
+	w = httptest.NewRecorder()
+	writeAPIList(w, httptest.NewRequest(http.MethodGet, "/api/v1/coproprietes?page=-1", nil), []apiCopropriete{})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid page, got %d", w.Code)
+	}
+}
+
+func TestWriteAPIError(t *testing.T) {
+	w := httptest.NewRecorder()
+	writeAPIError(w, http.StatusNotFound, apiErrorNotFound, "Bill not found")
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("Expected a JSON content type, got %s", contentType)
+	}
+	expected := `{"error":{"code":"not_found","message":"Bill not found"}}`
+	if body := strings.TrimSpace(w.Body.String()); body != expected {
+		t.Errorf("Expected %s, got %s", expected, body)
+	}
+}
+
+func decodeAPIErrorBody(t *testing.T, w *httptest.ResponseRecorder) apiErrorDetail {
+	t.Helper()
+	var body apiErrorBody
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected a JSON error body, got %s", w.Body.String())
+	}
+	return body.Error
+}
+
+func TestDecodeAPIRequest(t *testing.T) {
+	newRequest := func(contentType, body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/coproprietes/1/bills", strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		return r
+	}
+
+	var input apiBillInput
+	w := httptest.NewRecorder()
+	ok := decodeAPIRequest(w, newRequest("application/json; charset=utf-8", `{"label":"Ménage","amount":"120,50","date":"2025-03-01","supplier_id":3}`), &input)
+	if !ok {
+		t.Fatalf("Expected the body to be decoded, got %d %s", w.Code, w.Body.String())
+	}
+	if input.Label != "Ménage" || input.Amount == nil || *input.Amount != 12050 || input.Date != "2025-03-01" || input.SupplierID != 3 {
+		t.Errorf("Unexpected input %+v", input)
+	}
+
+	tests := []struct {
+		contentType string
+		body        string
+		status      int
+		code        string
+	}{
+		{"", `{"label":"x"}`, http.StatusUnsupportedMediaType, apiErrorUnsupportedMedia},
+		{"application/x-www-form-urlencoded", `label=x`, http.StatusUnsupportedMediaType, apiErrorUnsupportedMedia},
+		{"application/json", ``, http.StatusBadRequest, apiErrorInvalidRequest},
+		{"application/json", `{"label":"x","unknown":1}`, http.StatusBadRequest, apiErrorInvalidRequest},
+		{"application/json", `{"amount":"12,345"}`, http.StatusBadRequest, apiErrorInvalidRequest},
+		{"application/json", `{"fiscal_year":"2025"}`, http.StatusBadRequest, apiErrorInvalidRequest},
+		{"application/json", `{"label":"x"}{"label":"y"}`, http.StatusBadRequest, apiErrorInvalidRequest},
+	}
+	for _, tt := range tests {
+		var input apiBillInput
+		w := httptest.NewRecorder()
+		if decodeAPIRequest(w, newRequest(tt.contentType, tt.body), &input) {
+			t.Errorf("Expected %q (%s) to be rejected", tt.body, tt.contentType)
+			continue
+		}
+		if w.Code != tt.status {
+			t.Errorf("Expected %d for %q, got %d", tt.status, tt.body, w.Code)
+		}
+		if detail := decodeAPIErrorBody(t, w); detail.Code != tt.code || detail.Message == "" {
+			t.Errorf("Expected a %s error for %q, got %+v", tt.code, tt.body, detail)
+		}
+	}
+}
+
+func TestAPIRequiresAuthentication(t *testing.T) {
+	w := httptest.NewRecorder()
+	APICoproprietesHandler(w, httptest.NewRequest(http.MethodGet, "/api/v1/coproprietes", nil))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %d", w.Code)
+	}
+	if detail := decodeAPIErrorBody(t, w); detail.Code != apiErrorUnauthorized {
+		t.Errorf("Expected an unauthorized error, got %+v", detail)
+	}
+}
+
+func TestNewAPIPeriod(t *testing.T) {
+	all := newAPIPeriod(Period{FiscalYearStart: time.January})
+	if all.Kind != "all" || all.From != "" || all.To != "" {
+		t.Errorf("Expected an unbounded period, got %+v", all)
+	}
+
+	period := YearPeriod(2024, time.July)
+	year := newAPIPeriod(period)
+	if year.Kind != periodYear || year.FiscalYear != 2024 || year.From != "2024-07-01" || year.To != "2025-06-30" {
+		t.Errorf("Expected fiscal year 2024 from July to June, got %+v", year)
+	}
+}
+
+func TestNewAPIDashboard(t *testing.T) {
+	persons := []Person{{ID: 1, Name: "Alice", Tantieme: 600}, {ID: 2, Name: "Bob", Tantieme: 400}}
+	bills := []Bill{
+		{ID: 1, Label: "Ménage", Amount: 100000, Date: date(2025, time.February, 1), FiscalYear: 2025, Category: "611"},
+		{ID: 2, Label: "Ravalement", Amount: 50000, Date: date(2025, time.March, 1), FiscalYear: 2025, WorksFund: true},
+	}
+	provisions := []Provision{
+		{ID: 1, Label: "Appel T1", Amount: 150000, Date: date(2025, time.January, 1), FiscalYear: 2025},
+		{ID: 2, Label: "Fonds de travaux", Amount: 20000, Date: date(2025, time.January, 1), FiscalYear: 2025, WorksFund: true},
+	}
+	payments := []Payment{{ID: 1, PersonID: 1, Amount: 90000, Date: date(2025, time.January, 10)}}
+	data := DashboardData{
+		Copropriete:    Copropriete{ID: 7, Name: "Les Tilleuls", FiscalYearStart: time.January, WorksFundRate: 5},
+		Persons:        persons,
+		Bills:          bills,
+		Provisions:     provisions,
+		Payments:       payments,
+		Allocator:      NewAllocator(persons),
+		CategoryTotals: CategoryTotals(bills),
+		SupplierTotals: SupplierTotals(nil, bills),
+		WorksFund:      NewWorksFund(bills, provisions),
+		Period:         YearPeriod(2025, time.January),
+		FiscalYears:    []int{2025},
+	}
+
+	dashboard := newAPIDashboard(data)
+	if dashboard.Copropriete.ID != 7 || dashboard.Counts.Bills != 2 || dashboard.Counts.Payments != 1 {
+		t.Errorf("Unexpected dashboard %+v", dashboard)
+	}
+	if dashboard.WorksFund.Balance != -30000 {
+		t.Errorf("Expected a works fund balance of -300.00, got %s", dashboard.WorksFund.Balance.Decimal())
+	}
+	if len(dashboard.CategoryTotals) != 2 || dashboard.CategoryTotals[0].Code != "611" || dashboard.CategoryTotals[1].Label != "Non catégorisé" {
+		t.Errorf("Unexpected category totals %+v", dashboard.CategoryTotals)
+	}
+
+	if len(dashboard.Balances) != 2 {
+		t.Fatalf("Expected a balance per person, got %+v", dashboard.Balances)
+	}
+	alice := dashboard.Balances[0]
+	if alice.Called != 102000 || alice.Paid != 90000 || alice.Outstanding != 12000 || alice.Charges != 30000 || alice.WorksFund != 12000 {
+		t.Errorf("Unexpected balance of Alice %+v", alice)
+	}
+
+	encoded, err := json.Marshal(dashboard)
+	if err != nil {
+		t.Fatalf("Marshal returned error: %v", err)
+	}
+	if !strings.Contains(string(encoded), `"outstanding":"120.00"`) {
+		t.Errorf("Expected amounts as decimal strings, got %s", encoded)
+	}
+}
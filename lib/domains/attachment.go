@@ -0,0 +1,435 @@
+package domains
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+// maxAttachmentSize is the largest file that can be attached to a bill or a
+// provision.
+const maxAttachmentSize = 10 << 20
+
+// attachmentTypes are the accepted attachment types, detected from the
+// content of the file rather than trusted from the browser.
+var attachmentTypes = map[string]string{
+	"application/pdf": ".pdf",
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/webp":      ".webp",
+}
+
+// Attachment is a supporting document, such as the supplier invoice of a
+// bill. The file itself lives in the blob store under StorageKey.
+type Attachment struct {
+	ID          int
+	BillID      int
+	ProvisionID int
+	Filename    string
+	ContentType string
+	Size        int64
+	StorageKey  string
+	CreatedAt   time.Time
+}
+
+// IsImage reports whether the attachment is a picture rather than a PDF.
+func (attachment Attachment) IsImage() bool {
+	return strings.HasPrefix(attachment.ContentType, "image/")
+}
+
+// SizeLabel returns the size of the file for display, e.g. "1,2 Mo".
+func (attachment Attachment) SizeLabel() string {
+	switch {
+	case attachment.Size >= 1<<20:
+		return strings.Replace(fmt.Sprintf("%.1f Mo", float64(attachment.Size)/(1<<20)), ".", ",", 1)
+	case attachment.Size >= 1<<10:
+		return fmt.Sprintf("%d Ko", attachment.Size>>10)
+	default:
+		return fmt.Sprintf("%d o", attachment.Size)
+	}
+}
+
+// ArchivePath returns the path of the file in the export archive. The id
+// keeps two documents with the same name apart.
+func (attachment Attachment) ArchivePath() string {
+	return fmt.Sprintf("justificatifs/%d-%s", attachment.ID, attachment.Filename)
+}
+
+// detectAttachmentType returns the type of an uploaded file from its first
+// bytes, or an error when it is not an accepted type.
+func detectAttachmentType(head []byte) (string, error) {
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if _, ok := attachmentTypes[contentType]; !ok {
+		return "", fmt.Errorf("unsupported attachment type, PDF, JPEG, PNG or WebP expected")
+	}
+	return contentType, nil
+}
+
+// sanitizeFilename keeps the base name of an uploaded file without control
+// characters, and makes sure it has an extension matching its type.
+func sanitizeFilename(filename string, contentType string) string {
+	filename = path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	filename = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, filename)
+	filename = strings.TrimSpace(filename)
+	if filename == "" || filename == "." || filename == "/" {
+		filename = "justificatif"
+	}
+
+	extension := attachmentTypes[contentType]
+	if current := strings.ToLower(path.Ext(filename)); current != extension && !(contentType == "image/jpeg" && current == ".jpeg") {
+		filename += extension
+	}
+	return filename
+}
+
+func newStorageKey(coproprieteID int) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d/%s", coproprieteID, hex.EncodeToString(random)), nil
+}
+
+// LimitAttachmentUpload caps the size of the request body of an upload, so
+// that oversized files are refused before being parsed.
+func LimitAttachmentUpload(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+		handler(w, r)
+	}
+}
+
+func AddBillAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	billID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid bill id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, coproprieteID, err := getBill(db, billID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Bill not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !addAttachment(w, r, db, "billId", billID, userID, coproprieteID) {
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/bills/%d#attachments", billID), http.StatusFound)
+}
+
+func AddProvisionAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	provisionID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid provision id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, coproprieteID, err := getProvision(db, provisionID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Provision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !addAttachment(w, r, db, "provisionId", provisionID, userID, coproprieteID) {
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/provisions/%d#attachments", provisionID), http.StatusFound)
+}
+
+// addAttachment validates the uploaded file, stores it and records it
+// against the bill or provision in column. It reports false once the error
+// has been sent to the client.
+func addAttachment(w http.ResponseWriter, r *http.Request, db *sql.DB, column string, ownerID int, userID string, coproprieteID int) bool {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			http.Error(w, "Attachment is too large", http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, "Invalid attachment", http.StatusBadRequest)
+		}
+		return false
+	}
+	defer func() { _ = file.Close() }()
+
+	if header.Size > maxAttachmentSize {
+		http.Error(w, "Attachment is too large", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	if header.Size == 0 {
+		http.Error(w, "Attachment is empty", http.StatusBadRequest)
+		return false
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		http.Error(w, "Invalid attachment", http.StatusBadRequest)
+		return false
+	}
+	contentType, err := detectAttachmentType(head[:n])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return false
+	}
+
+	store, err := helpers.GetBlobStore()
+	if err != nil {
+		log.Printf("Error opening blob store: %v", err)
+		http.Error(w, "Attachments are unavailable", http.StatusInternalServerError)
+		return false
+	}
+
+	key, err := newStorageKey(coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+
+	content := io.MultiReader(bytes.NewReader(head[:n]), file)
+	if err := store.Put(key, content, header.Size, contentType); err != nil {
+		log.Printf("Error storing attachment: %v", err)
+		http.Error(w, "Failed to store attachment", http.StatusInternalServerError)
+		return false
+	}
+
+	_, err = db.Exec("INSERT INTO attachments ("+column+", filename, contentType, size, storageKey, userId, coproprieteId) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		ownerID, sanitizeFilename(header.Filename, contentType), contentType, header.Size, key, userID, coproprieteID)
+	if err != nil {
+		if err := store.Delete(key); err != nil {
+			log.Printf("Error deleting orphan attachment %s: %v", key, err)
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// AttachmentHandler sends an attached file. Images and PDF are shown inline
+// so that they open in the browser.
+func AttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	attachmentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid attachment id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	attachment, err := getAttachment(db, attachmentID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	store, err := helpers.GetBlobStore()
+	if err != nil {
+		log.Printf("Error opening blob store: %v", err)
+		http.Error(w, "Attachments are unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	blob, err := store.Get(attachment.StorageKey)
+	if errors.Is(err, helpers.ErrBlobNotFound) {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading attachment %d: %v", attachment.ID, err)
+		http.Error(w, "Failed to read attachment", http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = blob.Close() }()
+
+	disposition := "inline"
+	if r.URL.Query().Get("download") != "" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if _, err := io.Copy(w, blob); err != nil {
+		log.Printf("Error sending attachment %d: %v", attachment.ID, err)
+	}
+}
+
+func DeleteAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	attachmentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid attachment id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	attachment, err := getAttachment(db, attachmentID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := db.Exec("DELETE FROM attachments WHERE id = $1 AND userId = $2", attachmentID, userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	deleteAttachmentBlobs([]Attachment{attachment})
+
+	if attachment.ProvisionID != 0 {
+		http.Redirect(w, r, fmt.Sprintf("/provisions/%d#attachments", attachment.ProvisionID), http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/bills/%d#attachments", attachment.BillID), http.StatusFound)
+}
+
+// deleteAttachmentBlobs removes the files of attachments whose rows are
+// gone. Failures are only logged: an orphan file is harmless.
+func deleteAttachmentBlobs(attachments []Attachment) {
+	if len(attachments) == 0 {
+		return
+	}
+
+	store, err := helpers.GetBlobStore()
+	if err != nil {
+		log.Printf("Error opening blob store: %v", err)
+		return
+	}
+
+	for _, attachment := range attachments {
+		if err := store.Delete(attachment.StorageKey); err != nil {
+			log.Printf("Error deleting attachment %d: %v", attachment.ID, err)
+		}
+	}
+}
+
+const attachmentColumns = "id, COALESCE(billId, 0), COALESCE(provisionId, 0), filename, contentType, size, storageKey, createdAt"
+
+func scanAttachment(row interface{ Scan(...any) error }) (Attachment, error) {
+	var attachment Attachment
+	err := row.Scan(&attachment.ID, &attachment.BillID, &attachment.ProvisionID, &attachment.Filename, &attachment.ContentType, &attachment.Size, &attachment.StorageKey, &attachment.CreatedAt)
+	return attachment, err
+}
+
+// getAttachment loads an attachment by id, returning sql.ErrNoRows when it
+// does not exist or belongs to another user.
+func getAttachment(db *sql.DB, attachmentID int, userID string) (Attachment, error) {
+	return scanAttachment(db.QueryRow("SELECT "+attachmentColumns+" FROM attachments WHERE id = $1 AND userId = $2", attachmentID, userID))
+}
+
+// getAttachments loads the attachments of the bill or provision in column.
+func getAttachments(db *sql.DB, userID string, column string, ownerID int) ([]Attachment, error) {
+	return queryAttachments(db, "SELECT "+attachmentColumns+" FROM attachments WHERE userId = $1 AND "+column+" = $2 ORDER BY createdAt, id", userID, ownerID)
+}
+
+// getCoproprieteAttachments loads the attachments of every bill and provision
+// of a building.
+func getCoproprieteAttachments(db *sql.DB, userID string, coproprieteID int) ([]Attachment, error) {
+	return queryAttachments(db, "SELECT "+attachmentColumns+" FROM attachments WHERE userId = $1 AND coproprieteId = $2 ORDER BY createdAt, id", userID, coproprieteID)
+}
+
+// getBudgetCallAttachments loads the attachments of the calls for funds
+// generated from the budget of a fiscal year.
+func getBudgetCallAttachments(db *sql.DB, userID string, coproprieteID int, budgetYear int) ([]Attachment, error) {
+	return queryAttachments(db, "SELECT "+attachmentColumns+" FROM attachments WHERE userId = $1 AND provisionId IN (SELECT id FROM provisions WHERE budgetYear = $2 AND userId = $1 AND coproprieteId = $3) ORDER BY createdAt, id", userID, budgetYear, coproprieteID)
+}
+
+func queryAttachments(db *sql.DB, query string, args ...any) ([]Attachment, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var attachments []Attachment
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, rows.Err()
+}
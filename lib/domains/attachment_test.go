@@ -0,0 +1,122 @@
+package domains
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+func TestDetectAttachmentType(t *testing.T) {
+	tests := map[string]string{
+		"%PDF-1.7\n":                          "application/pdf",
+		"\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR": "image/png",
+		"\xff\xd8\xff\xe0\x00\x10JFIF":        "image/jpeg",
+	}
+	for head, expected := range tests {
+		if contentType, err := detectAttachmentType([]byte(head)); err != nil || contentType != expected {
+			t.Errorf("Expected %s, got %q (%v)", expected, contentType, err)
+		}
+	}
+
+	for _, head := range []string{"<html><script>alert(1)</script>", "MZ\x90\x00"} {
+		if _, err := detectAttachmentType([]byte(head)); err == nil {
+			t.Errorf("Expected %q to be rejected", head)
+		}
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		filename    string
+		contentType string
+		expected    string
+	}{
+		{"facture.pdf", "application/pdf", "facture.pdf"},
+		{`C:\Users\syndic\Facture Été.PDF`, "application/pdf", "Facture Été.PDF"},
+		{"../../etc/passwd", "application/pdf", "passwd.pdf"},
+		{"photo.jpeg", "image/jpeg", "photo.jpeg"},
+		{"scan\".png\n", "image/png", "scan.png"},
+		{"", "image/webp", "justificatif.webp"},
+	}
+	for _, test := range tests {
+		if filename := sanitizeFilename(test.filename, test.contentType); filename != test.expected {
+			t.Errorf("sanitizeFilename(%q): expected %q, got %q", test.filename, test.expected, filename)
+		}
+	}
+}
+
+func TestAttachmentSizeLabel(t *testing.T) {
+	tests := map[int64]string{
+		512:               "512 o",
+		2048:              "2 Ko",
+		1258291:           "1,2 Mo",
+		maxAttachmentSize: "10,0 Mo",
+	}
+	for size, expected := range tests {
+		if label := (Attachment{Size: size}).SizeLabel(); label != expected {
+			t.Errorf("Expected %s for %d bytes, got %s", expected, size, label)
+		}
+	}
+}
+
+func TestWriteReportArchiveIncludesAttachments(t *testing.T) {
+	store := helpers.NewLocalBlobStore(t.TempDir())
+	if err := store.Put("1/abc", strings.NewReader("%PDF-1.4"), 8, "application/pdf"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	data := DashboardData{
+		Allocator: NewAllocator(nil),
+		Bills: []Bill{{ID: 3, Label: "Ascenseur", Amount: 12000, Attachments: []Attachment{
+			{ID: 7, BillID: 3, Filename: "facture.pdf", StorageKey: "1/abc"},
+			{ID: 8, BillID: 3, Filename: "perdu.pdf", StorageKey: "1/missing"},
+		}}},
+	}
+
+	var buffer bytes.Buffer
+	if err := writeReportArchive(&buffer, data, store); err != nil {
+		t.Fatalf("writeReportArchive failed: %v", err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatalf("Invalid archive: %v", err)
+	}
+	var names []string
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+	}
+	if len(names) != 3 || !strings.HasSuffix(names[0], ".pdf") || !strings.HasSuffix(names[1], ".xlsx") || names[2] != "justificatifs/7-facture.pdf" {
+		t.Errorf("Expected the reports and the stored attachment, got %v", names)
+	}
+}
+
+func TestGetBudgetCallAttachments(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	columns := []string{"id", "billId", "provisionId", "filename", "contentType", "size", "storageKey", "createdAt"}
+	mock.ExpectQuery(`FROM attachments WHERE userId = \$1 AND provisionId IN \(SELECT id FROM provisions WHERE budgetYear = \$2 AND userId = \$1 AND coproprieteId = \$3\)`).
+		WithArgs("42", 2025, 7).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(3, 0, 12, "appel.pdf", "application/pdf", 2048, "42/abc", time.Now()))
+
+	attachments, err := getBudgetCallAttachments(db, "42", 7, 2025)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(attachments) != 1 || attachments[0].StorageKey != "42/abc" || attachments[0].ProvisionID != 12 {
+		t.Errorf("Expected the attachment of the generated call, got %+v", attachments)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -2,10 +2,13 @@ package domains
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/duscraft/tanzia/lib/helpers"
 
@@ -96,18 +99,57 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	rateLimiter.ResetAttempts(email)
 
+	twoFactorEnabled, err := isTwoFactorEnabled(db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if twoFactorEnabled {
+		if err := startTwoFactorLogin(w, userID, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/login/2fa", http.StatusFound)
+		return
+	}
+
+	completeLogin(w, r, store, db, userID, needsPasswordReset)
+}
+
+// completeLogin starts the session of userID once every factor has been
+// checked.
+func completeLogin(w http.ResponseWriter, r *http.Request, store session.Store, db *sql.DB, userID string, needsPasswordReset bool) {
 	if needsPasswordReset {
-		http.Redirect(w, r, "/reset-password#required", http.StatusFound)
+		// The user just proved their password, so they get a reset link right
+		// away rather than by email: no session is started until they have
+		// chosen a new password.
+		token, err := issuePasswordReset(db, userID, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, passwordResetPath(token)+"#required", http.StatusFound)
+		return
+	}
+
+	if err := startSession(w, store, userID); err != nil {
+		log.Printf("Session save error: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
 		return
 	}
 
+	http.Redirect(w, r, "/dashboard", http.StatusFound)
+}
+
+// startSession logs userID in under a new session id, recording when the
+// session started, and sets the session and CSRF cookies.
+func startSession(w http.ResponseWriter, store session.Store, userID string) error {
 	cookie := uuid.New()
 	store.Set(cookie.String(), userID)
+	store.Set(cookie.String()+":startedAt", strconv.FormatInt(time.Now().UnixMicro(), 10))
 
 	if err := store.Save(); err != nil {
-		log.Printf("Session save error: %v", err)
-		http.Error(w, "Session error", http.StatusInternalServerError)
-		return
+		return err
 	}
 
 	http.SetCookie(w, &http.Cookie{
@@ -124,8 +166,7 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	if err == nil {
 		helpers.SetCSRFCookie(w, csrfToken)
 	}
-
-	http.Redirect(w, r, "/dashboard", http.StatusFound)
+	return nil
 }
 
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
@@ -138,7 +179,7 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 
 	cookie, err := r.Cookie("tanzia-session")
 	if err == nil {
-		store.Delete(cookie.Value)
+		deleteSession(store, cookie.Value)
 	}
 
 	if err := store.Save(); err != nil {
@@ -156,7 +197,15 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/login", http.StatusFound)
 }
 
+// GetAuthenticatedUserID returns the user of the request: the owner of the
+// access token authenticated by BearerAuth, or else the user of the session.
+// Sessions started before the user's sessions were revoked, e.g. by a
+// password reset, are ended.
 func GetAuthenticatedUserID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if userID, ok := r.Context().Value(accessTokenUserKey{}).(string); ok {
+		return userID, true
+	}
+
 	store, err := session.Start(context.Background(), w, r)
 	if err != nil {
 		log.Printf("Session error: %v", err)
@@ -168,8 +217,57 @@ func GetAuthenticatedUserID(w http.ResponseWriter, r *http.Request) (string, boo
 		return "", false
 	}
 	id, ok := store.Get(cookie.Value)
+	if !ok {
+		return "", false
+	}
+	userID := fmt.Sprintf("%s", id)
+
+	// Sessions predating startedAt count as started at the epoch, so that
+	// they are ended by any revocation.
+	var startedAt int64
+	if value, ok := store.Get(cookie.Value + ":startedAt"); ok {
+		startedAt, _ = strconv.ParseInt(fmt.Sprintf("%v", value), 10, 64)
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		return "", false
+	}
 
-	return fmt.Sprintf("%s", id), ok
+	valid, err := isSessionValid(db, userID, startedAt)
+	if err != nil {
+		log.Printf("Error checking session: %v", err)
+		return "", false
+	}
+	if !valid {
+		deleteSession(store, cookie.Value)
+		if err := store.Save(); err != nil {
+			log.Printf("Session save error: %v", err)
+		}
+		return "", false
+	}
+
+	return userID, true
+}
+
+// isSessionValid reports whether a session of userID started at startedAt,
+// in Unix microseconds, outlives the revocations of the user's sessions.
+// Microseconds match the precision of sessionsRevokedAt, so that a session
+// started in the second of a revocation, but after it, stays valid.
+func isSessionValid(db *sql.DB, userID string, startedAt int64) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM users WHERE id = $1 AND (sessionsRevokedAt IS NULL OR sessionsRevokedAt <= to_timestamp($2 / 1e6)::timestamp)", userID, startedAt).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func deleteSession(store session.Store, sessionID string) {
+	store.Delete(sessionID)
+	store.Delete(sessionID + ":startedAt")
+	store.Delete(sessionID + ":copropriete")
 }
 
 func SignupHandler(w http.ResponseWriter, r *http.Request) {
@@ -220,30 +318,18 @@ func SignupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cookie := uuid.New()
-	store.Set(cookie.String(), fmt.Sprintf("%d", userID))
+	// The account can be used right away, but premium stays out of reach
+	// until the address is verified.
+	if err := sendEmailVerification(db, fmt.Sprintf("%d", userID), email, time.Now()); err != nil {
+		log.Printf("Error sending email verification: %v", err)
+	}
 
-	if err := store.Save(); err != nil {
+	if err := startSession(w, store, fmt.Sprintf("%d", userID)); err != nil {
 		log.Printf("Session save error: %v", err)
 		http.Error(w, "Session error", http.StatusInternalServerError)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "tanzia-session",
-		Value:    cookie.String(),
-		MaxAge:   86_400,
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteLaxMode,
-	})
-
-	csrfMgr := helpers.GetCSRFManager()
-	csrfToken, err := csrfMgr.CreateToken(cookie.String())
-	if err == nil {
-		helpers.SetCSRFCookie(w, csrfToken)
-	}
-
 	if redirect == "subscribe" {
 		http.Redirect(w, r, "/subscribe", http.StatusSeeOther)
 		return
@@ -292,16 +378,9 @@ func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if helpers.IsLegacyPassword(storedPassword) {
-		if currentPassword != storedPassword {
-			http.Redirect(w, r, "/reset-password#invalid", http.StatusFound)
-			return
-		}
-	} else {
-		if !helpers.CheckPassword(currentPassword, storedPassword) {
-			http.Redirect(w, r, "/reset-password#invalid", http.StatusFound)
-			return
-		}
+	if !checkStoredPassword(currentPassword, storedPassword) {
+		http.Redirect(w, r, "/reset-password#invalid", http.StatusFound)
+		return
 	}
 
 	hashedPassword, err := helpers.HashPassword(newPassword)
@@ -319,3 +398,12 @@ func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 
 	http.Redirect(w, r, "/reset-password#success", http.StatusFound)
 }
+
+// checkStoredPassword compares password with the stored password of a user,
+// which may still be a legacy plaintext one.
+func checkStoredPassword(password, storedPassword string) bool {
+	if helpers.IsLegacyPassword(storedPassword) {
+		return password == storedPassword
+	}
+	return helpers.CheckPassword(password, storedPassword)
+}
@@ -1,17 +1,37 @@
 package domains
 
 import (
+	"database/sql"
 	"html/template"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/duscraft/tanzia/lib/helpers"
 )
 
 type Bill struct {
-	Label  string
-	Amount float64
+	ID          int
+	Label       string
+	Amount      Money
+	ChargeKeyID int
+	Date        time.Time
+	FiscalYear  int
+	// WorksFund books the bill on the works fund (fonds de travaux) rather
+	// than on the charges of the fiscal year.
+	WorksFund   bool
+	SupplierID  int
+	Category    string // account code of class 6, see ExpenseCategory
+	Attachments []Attachment
+}
+
+type billFormData struct {
+	Bill       *Bill
+	ChargeKeys []ChargeKey
+	Suppliers  []Supplier
+	Categories []ExpenseCategoryGroup
+	Today      time.Time
 }
 
 func AddBillHandler(w http.ResponseWriter, r *http.Request) {
@@ -27,6 +47,12 @@ func AddBillHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	canUserCreateBill, err := helpers.CanUserCreateBill(db, userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -38,13 +64,43 @@ func AddBillHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	amount, err := strconv.ParseFloat(r.FormValue("amount"), 64)
-	if err != nil {
+	amount, err := ParseMoney(r.FormValue("amount"))
+	if err != nil || amount < 0 {
 		http.Error(w, "Invalid amount value", http.StatusBadRequest)
 		return
 	}
 
-	_, err = db.Exec("INSERT INTO bills (label, amount, userId) VALUES ($1, $2, $3)", r.FormValue("label"), amount, userID)
+	chargeKeyID, err := parseChargeKeyID(db, r, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	supplierID, err := parseSupplierID(db, r, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	category, err := parseExpenseCategory(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fiscalYearStart, err := getFiscalYearStart(db, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	date, fiscalYear, err := parseEntryDate(r, fiscalYearStart)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = db.Exec("INSERT INTO bills (label, amount, chargeKeyId, date, fiscalYear, worksFund, supplierId, category, userId, coproprieteId) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)", r.FormValue("label"), amount, chargeKeyID, date, fiscalYear, r.FormValue("works_fund") == "on", supplierID, category, userID, coproprieteID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -53,13 +109,222 @@ func AddBillHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/dashboard#bill_added", http.StatusFound)
 }
 
+func UpdateBillHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	billID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid bill id", http.StatusBadRequest)
+		return
+	}
+
+	amount, err := ParseMoney(r.FormValue("amount"))
+	if err != nil || amount < 0 {
+		http.Error(w, "Invalid amount value", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, coproprieteID, err := getBill(db, billID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Bill not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	chargeKeyID, err := parseChargeKeyID(db, r, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	supplierID, err := parseSupplierID(db, r, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	category, err := parseExpenseCategory(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fiscalYearStart, err := getFiscalYearStart(db, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	date, fiscalYear, err := parseEntryDate(r, fiscalYearStart)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = db.Exec("UPDATE bills SET label = $1, amount = $2, chargeKeyId = $3, date = $4, fiscalYear = $5, worksFund = $6, supplierId = $7, category = $8 WHERE id = $9 AND userId = $10", r.FormValue("label"), amount, chargeKeyID, date, fiscalYear, r.FormValue("works_fund") == "on", supplierID, category, billID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#bill_updated", http.StatusFound)
+}
+
+func DeleteBillHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	billID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid bill id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	attachments, err := getAttachments(db, userID, "billId", billID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM bills WHERE id = $1 AND userId = $2", billID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "Bill not found", http.StatusNotFound)
+		return
+	}
+	deleteAttachmentBlobs(attachments)
+
+	http.Redirect(w, r, "/dashboard#bill_deleted", http.StatusFound)
+}
+
 func BillsHandler(w http.ResponseWriter, r *http.Request) {
-	_, ok := GetAuthenticatedUserID(w, r)
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderBillForm(w, db, userID, coproprieteID, nil)
+}
+
+func EditBillHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
 	if !ok {
 		http.Redirect(w, r, "/logout", http.StatusFound)
 		return
 	}
 
+	billID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid bill id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	bill, coproprieteID, err := getBill(db, billID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Bill not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	bill.Attachments, err = getAttachments(db, userID, "billId", billID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderBillForm(w, db, userID, coproprieteID, &bill)
+}
+
+// getBill loads a bill by id along with the id of its copropriete, returning
+// sql.ErrNoRows when it does not exist or belongs to another user.
+func getBill(db *sql.DB, billID int, userID string) (Bill, int, error) {
+	var bill Bill
+	var coproprieteID int
+	err := db.QueryRow("SELECT id, label, amount, COALESCE(chargeKeyId, 0), date, fiscalYear, COALESCE(worksFund, FALSE), COALESCE(supplierId, 0), COALESCE(category, ''), coproprieteId FROM bills WHERE id = $1 AND userId = $2", billID, userID).
+		Scan(&bill.ID, &bill.Label, &bill.Amount, &bill.ChargeKeyID, &bill.Date, &bill.FiscalYear, &bill.WorksFund, &bill.SupplierID, &bill.Category, &coproprieteID)
+	return bill, coproprieteID, err
+}
+
+// getBills loads the bills of a building, oldest first.
+func getBills(db *sql.DB, userID string, coproprieteID int) ([]Bill, error) {
+	rows, err := db.Query("SELECT id, label, amount, COALESCE(chargeKeyId, 0), date, fiscalYear, COALESCE(worksFund, FALSE), COALESCE(supplierId, 0), COALESCE(category, '') FROM bills WHERE userId = $1 AND coproprieteId = $2 ORDER BY date, id", userID, coproprieteID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var bills []Bill
+	for rows.Next() {
+		var bill Bill
+		if err := rows.Scan(&bill.ID, &bill.Label, &bill.Amount, &bill.ChargeKeyID, &bill.Date, &bill.FiscalYear, &bill.WorksFund, &bill.SupplierID, &bill.Category); err != nil {
+			return nil, err
+		}
+		bills = append(bills, bill)
+	}
+	return bills, rows.Err()
+}
+
+func renderBillForm(w http.ResponseWriter, db *sql.DB, userID string, coproprieteID int, bill *Bill) {
+	chargeKeys, err := getChargeKeys(db, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	suppliers, err := getSuppliers(db, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	t, err := template.ParseFiles("lib/templates/edit-bills.html")
 	if err != nil {
 		log.Printf("Error parsing template: %v", err)
@@ -67,7 +332,7 @@ func BillsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := t.Execute(w, nil); err != nil {
+	if err := t.Execute(w, billFormData{Bill: bill, ChargeKeys: chargeKeys, Suppliers: suppliers, Categories: expenseCategoryGroups, Today: time.Now()}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
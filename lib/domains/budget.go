@@ -0,0 +1,723 @@
+package domains
+
+import (
+	"archive/zip"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+	"github.com/go-pdf/fpdf"
+)
+
+// BudgetLine is a line of the annual budget voted by the general assembly.
+// The budget is called in four equal quarterly calls for funds, each line
+// being split between owners with its charge key.
+type BudgetLine struct {
+	ID          int
+	FiscalYear  int
+	Label       string
+	Amount      Money
+	ChargeKeyID int
+}
+
+// QuarterAmount returns the part of the line called on quarter (1 to 4). The
+// four quarters always add up to the annual amount.
+func (line BudgetLine) QuarterAmount(quarter int) Money {
+	return Allocate(line.Amount, []int{1, 1, 1, 1})[quarter-1]
+}
+
+// QuarterlyProvisions turns the budget of a fiscal year into provisions, one
+// per line and per quarter, dated on the first day of the quarter.
+func QuarterlyProvisions(lines []BudgetLine, fiscalYear int, fiscalYearStart time.Month) []Provision {
+	var provisions []Provision
+	for quarter := 1; quarter <= 4; quarter++ {
+		for _, line := range lines {
+			provisions = append(provisions, Provision{
+				Label:       fmt.Sprintf("Appel de fonds T%d %s - %s", quarter, FiscalYearLabel(fiscalYear, fiscalYearStart), line.Label),
+				Amount:      line.QuarterAmount(quarter),
+				ChargeKeyID: line.ChargeKeyID,
+				Date:        quarterStartDate(fiscalYear, fiscalYearStart, quarter),
+				FiscalYear:  fiscalYear,
+			})
+		}
+	}
+	return provisions
+}
+
+func quarterStartDate(fiscalYear int, fiscalYearStart time.Month, quarter int) time.Time {
+	return fiscalYearStartDate(fiscalYear, fiscalYearStart).AddDate(0, 3*(quarter-1), 0)
+}
+
+type budgetPageData struct {
+	Copropriete  Copropriete
+	FiscalYear   int
+	YearLabel    string
+	PreviousYear int
+	NextYear     int
+	Line         *BudgetLine
+	Lines        []BudgetLine
+	Total        Money
+	WorksFund    BudgetLine
+	ChargeKeys   []ChargeKey
+	Persons      []Person
+	Allocator    Allocator
+	Quarters     []int
+	IsPremium    bool
+}
+
+// budgetData gathers what the budget page and the notices need for a
+// building and a fiscal year.
+type budgetData struct {
+	Copropriete Copropriete
+	FiscalYear  int
+	Lines       []BudgetLine
+	Persons     []Person
+	ChargeKeys  []ChargeKey
+	Allocator   Allocator
+}
+
+// WorksFundLine returns the contribution to the works fund called along with
+// the budget.
+func (data budgetData) WorksFundLine() BudgetLine {
+	return WorksFundLine(data.Lines, data.FiscalYear, data.Copropriete.WorksFundRate)
+}
+
+func BudgetHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fiscalYearStart, err := getFiscalYearStart(db, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fiscalYear := FiscalYearOf(time.Now(), fiscalYearStart)
+	if value := r.URL.Query().Get("year"); value != "" {
+		fiscalYear, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid year value", http.StatusBadRequest)
+			return
+		}
+	}
+
+	renderBudgetPage(w, db, userID, coproprieteID, fiscalYear, nil)
+}
+
+func EditBudgetLineHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	lineID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid budget line id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	line, coproprieteID, err := getBudgetLine(db, lineID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Budget line not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderBudgetPage(w, db, userID, coproprieteID, line.FiscalYear, &line)
+}
+
+func AddBudgetLineHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	line, chargeKeyID, err := parseBudgetLine(db, r, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = db.Exec("INSERT INTO budget_lines (fiscalYear, label, amount, chargeKeyId, userId, coproprieteId) VALUES ($1, $2, $3, $4, $5, $6)",
+		line.FiscalYear, line.Label, line.Amount, chargeKeyID, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/budget?year=%d#budget_line_added", line.FiscalYear), http.StatusFound)
+}
+
+func UpdateBudgetLineHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	lineID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid budget line id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, coproprieteID, err := getBudgetLine(db, lineID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Budget line not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	line, chargeKeyID, err := parseBudgetLine(db, r, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = db.Exec("UPDATE budget_lines SET fiscalYear = $1, label = $2, amount = $3, chargeKeyId = $4 WHERE id = $5 AND userId = $6",
+		line.FiscalYear, line.Label, line.Amount, chargeKeyID, lineID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/budget?year=%d#budget_line_updated", line.FiscalYear), http.StatusFound)
+}
+
+func DeleteBudgetLineHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	lineID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid budget line id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	line, _, err := getBudgetLine(db, lineID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Budget line not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := db.Exec("DELETE FROM budget_lines WHERE id = $1 AND userId = $2", lineID, userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/budget?year=%d#budget_line_deleted", line.FiscalYear), http.StatusFound)
+}
+
+// GenerateCallsHandler replaces the provisions generated for a fiscal year
+// with the quarterly calls for funds of its current budget. Provisions typed
+// by hand are left untouched, and so are payments since they are not tied to
+// a given call.
+func GenerateCallsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	fiscalYear, err := strconv.Atoi(r.FormValue("year"))
+	if err != nil {
+		http.Error(w, "Invalid year value", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	isPremium, err := helpers.IsUserPremium(db, userID)
+	if err != nil {
+		log.Printf("Error checking premium status: %v", err)
+		http.Error(w, "Could not verify subscription status", http.StatusInternalServerError)
+		return
+	}
+
+	if !isPremium {
+		http.Error(w, "Premium subscription required for calls for funds", http.StatusForbidden)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, err := getBudgetData(db, userID, coproprieteID, fiscalYear)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	attachments, err := getBudgetCallAttachments(db, userID, coproprieteID, fiscalYear)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("DELETE FROM provisions WHERE budgetYear = $1 AND userId = $2 AND coproprieteId = $3", fiscalYear, userID, coproprieteID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	provisions := QuarterlyProvisions(data.Lines, fiscalYear, data.Copropriete.FiscalYearStart)
+	provisions = append(provisions, WorksFundProvisions(data.WorksFundLine(), fiscalYear, data.Copropriete.FiscalYearStart)...)
+
+	for _, provision := range provisions {
+		chargeKeyID := sql.NullInt64{Int64: int64(provision.ChargeKeyID), Valid: provision.ChargeKeyID != 0}
+		_, err := tx.Exec("INSERT INTO provisions (label, amount, chargeKeyId, date, fiscalYear, budgetYear, worksFund, userId, coproprieteId) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+			provision.Label, provision.Amount, chargeKeyID, provision.Date, provision.FiscalYear, fiscalYear, provision.WorksFund, userID, coproprieteID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	deleteAttachmentBlobs(attachments)
+
+	http.Redirect(w, r, fmt.Sprintf("/budget?year=%d#calls_generated", fiscalYear), http.StatusFound)
+}
+
+// CallNoticeHandler sends the PDF call for funds of one owner for a quarter.
+func CallNoticeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	fiscalYear, quarter, ok := parseCallPath(w, r)
+	if !ok {
+		return
+	}
+
+	personID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid person id", http.StatusBadRequest)
+		return
+	}
+
+	data, ok := loadCallNoticeData(w, r, userID, fiscalYear)
+	if !ok {
+		return
+	}
+
+	for _, person := range data.Persons {
+		if person.ID != personID {
+			continue
+		}
+
+		pdf := newCallNotice(data, person, quarter)
+
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", callNoticeFilename(data, person, quarter)))
+
+		if err := pdf.Output(w); err != nil {
+			log.Printf("Error generating PDF: %v", err)
+			http.Error(w, "Failed to generate PDF", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Error(w, "Person not found", http.StatusNotFound)
+}
+
+// CallNoticesZipHandler sends a ZIP archive with the PDF call for funds of
+// every owner of the building for a quarter.
+func CallNoticesZipHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	fiscalYear, quarter, ok := parseCallPath(w, r)
+	if !ok {
+		return
+	}
+
+	data, ok := loadCallNoticeData(w, r, userID, fiscalYear)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=appels-de-fonds-T%d-%d.zip", quarter, fiscalYear))
+
+	if err := writeCallNoticesZip(w, data, quarter); err != nil {
+		log.Printf("Error generating ZIP: %v", err)
+		http.Error(w, "Failed to generate ZIP", http.StatusInternalServerError)
+	}
+}
+
+func writeCallNoticesZip(w io.Writer, data budgetData, quarter int) error {
+	archive := zip.NewWriter(w)
+	for _, person := range data.Persons {
+		file, err := archive.Create(callNoticeFilename(data, person, quarter))
+		if err != nil {
+			return err
+		}
+		if err := newCallNotice(data, person, quarter).Output(file); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+func parseCallPath(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	fiscalYear, err := strconv.Atoi(r.PathValue("year"))
+	if err != nil {
+		http.Error(w, "Invalid year value", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	quarter, err := strconv.Atoi(r.PathValue("quarter"))
+	if err != nil || quarter < 1 || quarter > 4 {
+		http.Error(w, "Invalid quarter value", http.StatusBadRequest)
+		return 0, 0, false
+	}
+
+	return fiscalYear, quarter, true
+}
+
+// loadCallNoticeData checks the premium status and loads the budget of the
+// current building, writing the error response itself when it fails.
+func loadCallNoticeData(w http.ResponseWriter, r *http.Request, userID string, fiscalYear int) (budgetData, bool) {
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return budgetData{}, false
+	}
+
+	isPremium, err := helpers.IsUserPremium(db, userID)
+	if err != nil {
+		log.Printf("Error checking premium status: %v", err)
+		http.Error(w, "Could not verify subscription status", http.StatusInternalServerError)
+		return budgetData{}, false
+	}
+
+	if !isPremium {
+		http.Error(w, "Premium subscription required for calls for funds", http.StatusForbidden)
+		return budgetData{}, false
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		log.Printf("Error resolving copropriete: %v", err)
+		http.Error(w, "Failed to load data", http.StatusInternalServerError)
+		return budgetData{}, false
+	}
+
+	data, err := getBudgetData(db, userID, coproprieteID, fiscalYear)
+	if err != nil {
+		log.Printf("Error getting budget data: %v", err)
+		http.Error(w, "Failed to load data", http.StatusInternalServerError)
+		return budgetData{}, false
+	}
+
+	return data, true
+}
+
+func callNoticeFilename(data budgetData, person Person, quarter int) string {
+	return fmt.Sprintf("appel-de-fonds-T%d-%d-%d.pdf", quarter, data.FiscalYear, person.ID)
+}
+
+// newCallNotice builds the call for funds sent to one owner for a quarter:
+// every budget line, then the works fund contribution, with the tantièmes the
+// owner holds on the due date for its charge key and the resulting share.
+func newCallNotice(data budgetData, person Person, quarter int) *fpdf.Fpdf {
+	fiscalYearStart := data.Copropriete.FiscalYearStart
+	dueDate := quarterStartDate(data.FiscalYear, fiscalYearStart, quarter)
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(15, 15, 15)
+	pdf.AddPage()
+
+	pdf.SetFont("Arial", "B", 20)
+	pdf.Cell(0, 12, "Appel de fonds")
+	pdf.Ln(15)
+
+	pdf.SetFont("Arial", "", 10)
+	pdf.Cell(0, 6, fmt.Sprintf("Copropriété: %s", data.Copropriete.Name))
+	pdf.Ln(6)
+	pdf.Cell(0, 6, fmt.Sprintf("Exercice: %s - Trimestre %d", FiscalYearLabel(data.FiscalYear, fiscalYearStart), quarter))
+	pdf.Ln(6)
+	pdf.Cell(0, 6, fmt.Sprintf("Exigible le: %s", dueDate.Format("02/01/2006")))
+	pdf.Ln(12)
+
+	pdf.SetFont("Arial", "B", 12)
+	pdf.Cell(0, 8, fmt.Sprintf("Copropriétaire: %s", person.Name))
+	pdf.Ln(12)
+
+	pdf.SetFont("Arial", "B", 9)
+	pdf.SetFillColor(220, 220, 220)
+	colWidths := []float64{55, 35, 30, 30, 30}
+	pdf.CellFormat(colWidths[0], 7, "Poste", "1", 0, "L", true, 0, "")
+	pdf.CellFormat(colWidths[1], 7, "Clé de répartition", "1", 0, "L", true, 0, "")
+	pdf.CellFormat(colWidths[2], 7, "Appel global", "1", 0, "R", true, 0, "")
+	pdf.CellFormat(colWidths[3], 7, "Tantièmes", "1", 0, "R", true, 0, "")
+	pdf.CellFormat(colWidths[4], 7, "Quote-part", "1", 1, "R", true, 0, "")
+
+	lines := append([]BudgetLine{}, data.Lines...)
+	if worksFund := data.WorksFundLine(); worksFund.Amount != 0 {
+		lines = append(lines, worksFund)
+	}
+
+	pdf.SetFont("Arial", "", 9)
+	var total Money
+	for i, line := range lines {
+		fill := i%2 == 0
+		if fill {
+			pdf.SetFillColor(245, 245, 245)
+		} else {
+			pdf.SetFillColor(255, 255, 255)
+		}
+
+		amount := line.QuarterAmount(quarter)
+		share := data.Allocator.ShareOver(&person, amount, line.ChargeKeyID, dueDate, dueDate.AddDate(0, 0, 1))
+		total += share
+
+		pdf.CellFormat(colWidths[0], 6, line.Label, "1", 0, "L", fill, 0, "")
+		pdf.CellFormat(colWidths[1], 6, data.Allocator.ChargeKeyName(line.ChargeKeyID), "1", 0, "L", fill, 0, "")
+		pdf.CellFormat(colWidths[2], 6, fmt.Sprintf("%s EUR", amount), "1", 0, "R", fill, 0, "")
+		pdf.CellFormat(colWidths[3], 6, fmt.Sprintf("%d / %d", data.Allocator.TantiemeOf(person, line.ChargeKeyID, dueDate), data.Allocator.TotalTantiemes(line.ChargeKeyID)), "1", 0, "R", fill, 0, "")
+		pdf.CellFormat(colWidths[4], 6, fmt.Sprintf("%s EUR", share), "1", 1, "R", fill, 0, "")
+	}
+
+	pdf.Ln(4)
+	pdf.SetFont("Arial", "B", 12)
+	pdf.SetFillColor(200, 200, 200)
+	pdf.CellFormat(120, 8, "Montant à régler", "1", 0, "L", true, 0, "")
+	pdf.CellFormat(60, 8, fmt.Sprintf("%s EUR", total), "1", 1, "R", true, 0, "")
+
+	pdf.Ln(20)
+	pdf.SetFont("Arial", "I", 8)
+	pdf.Cell(0, 5, fmt.Sprintf("Document généré automatiquement par Tanzia le %s", time.Now().Format("02/01/2006 15:04")))
+
+	return pdf
+}
+
+func getBudgetData(db *sql.DB, userID string, coproprieteID int, fiscalYear int) (budgetData, error) {
+	var copropriete Copropriete
+	err := db.QueryRow("SELECT id, name, COALESCE(fiscalYearStart, 1), COALESCE(worksFundRate, 5) FROM coproprietes WHERE id = $1 AND userId = $2", coproprieteID, userID).
+		Scan(&copropriete.ID, &copropriete.Name, &copropriete.FiscalYearStart, &copropriete.WorksFundRate)
+	if err != nil {
+		return budgetData{}, err
+	}
+
+	lines, err := getBudgetLines(db, userID, coproprieteID, fiscalYear)
+	if err != nil {
+		return budgetData{}, err
+	}
+
+	persons, err := getPersons(db, userID, coproprieteID)
+	if err != nil {
+		return budgetData{}, err
+	}
+
+	lots, err := getLots(db, userID, coproprieteID)
+	if err != nil {
+		return budgetData{}, err
+	}
+
+	chargeKeys, err := getChargeKeys(db, userID, coproprieteID)
+	if err != nil {
+		return budgetData{}, err
+	}
+
+	return budgetData{
+		Copropriete: copropriete,
+		FiscalYear:  fiscalYear,
+		Lines:       lines,
+		Persons:     persons,
+		ChargeKeys:  chargeKeys,
+		Allocator:   NewLotAllocator(persons, lots, copropriete.FiscalYearStart, chargeKeys...),
+	}, nil
+}
+
+func getBudgetLines(db *sql.DB, userID string, coproprieteID int, fiscalYear int) ([]BudgetLine, error) {
+	rows, err := db.Query("SELECT id, fiscalYear, label, amount, COALESCE(chargeKeyId, 0) FROM budget_lines WHERE userId = $1 AND coproprieteId = $2 AND fiscalYear = $3 ORDER BY id", userID, coproprieteID, fiscalYear)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var lines []BudgetLine
+	for rows.Next() {
+		var line BudgetLine
+		if err := rows.Scan(&line.ID, &line.FiscalYear, &line.Label, &line.Amount, &line.ChargeKeyID); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+// getBudgetLine loads a budget line by id along with the id of its
+// copropriete, returning sql.ErrNoRows when it does not exist or belongs to
+// another user.
+func getBudgetLine(db *sql.DB, lineID int, userID string) (BudgetLine, int, error) {
+	var line BudgetLine
+	var coproprieteID int
+	err := db.QueryRow("SELECT id, fiscalYear, label, amount, COALESCE(chargeKeyId, 0), coproprieteId FROM budget_lines WHERE id = $1 AND userId = $2", lineID, userID).
+		Scan(&line.ID, &line.FiscalYear, &line.Label, &line.Amount, &line.ChargeKeyID, &coproprieteID)
+	return line, coproprieteID, err
+}
+
+func parseBudgetLine(db *sql.DB, r *http.Request, userID string, coproprieteID int) (BudgetLine, sql.NullInt64, error) {
+	var line BudgetLine
+
+	fiscalYear, err := strconv.Atoi(r.FormValue("year"))
+	if err != nil || fiscalYear < 1900 || fiscalYear > 9999 {
+		return line, sql.NullInt64{}, fmt.Errorf("invalid year value")
+	}
+	line.FiscalYear = fiscalYear
+
+	line.Label = r.FormValue("label")
+	if line.Label == "" {
+		return line, sql.NullInt64{}, fmt.Errorf("label cannot be empty")
+	}
+
+	line.Amount, err = ParseMoney(r.FormValue("amount"))
+	if err != nil {
+		return line, sql.NullInt64{}, fmt.Errorf("invalid amount value")
+	}
+
+	chargeKeyID, err := parseChargeKeyID(db, r, userID, coproprieteID)
+	if err != nil {
+		return line, sql.NullInt64{}, err
+	}
+	line.ChargeKeyID = int(chargeKeyID.Int64)
+
+	return line, chargeKeyID, nil
+}
+
+func renderBudgetPage(w http.ResponseWriter, db *sql.DB, userID string, coproprieteID int, fiscalYear int, line *BudgetLine) {
+	data, err := getBudgetData(db, userID, coproprieteID, fiscalYear)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	isPremium, err := helpers.IsUserPremium(db, userID)
+	if err != nil {
+		log.Printf("Warning: could not check premium status for user %s: %v", userID, err)
+		isPremium = false
+	}
+
+	var total Money
+	for _, budgetLine := range data.Lines {
+		total += budgetLine.Amount
+	}
+
+	t, err := template.ParseFiles("lib/templates/budget.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	page := budgetPageData{
+		Copropriete:  data.Copropriete,
+		FiscalYear:   fiscalYear,
+		YearLabel:    FiscalYearLabel(fiscalYear, data.Copropriete.FiscalYearStart),
+		PreviousYear: fiscalYear - 1,
+		NextYear:     fiscalYear + 1,
+		Line:         line,
+		Lines:        data.Lines,
+		Total:        total,
+		WorksFund:    data.WorksFundLine(),
+		ChargeKeys:   data.ChargeKeys,
+		Persons:      data.Persons,
+		Allocator:    data.Allocator,
+		Quarters:     []int{1, 2, 3, 4},
+		IsPremium:    isPremium,
+	}
+
+	if err := t.Execute(w, page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
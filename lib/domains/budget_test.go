@@ -0,0 +1,100 @@
+package domains
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestBudgetLineQuarterAmountsAddUp(t *testing.T) {
+	line := BudgetLine{Amount: 100001}
+
+	var total Money
+	for quarter := 1; quarter <= 4; quarter++ {
+		total += line.QuarterAmount(quarter)
+	}
+
+	if total != line.Amount {
+		t.Errorf("Expected quarters to add up to %s, got %s", line.Amount, total)
+	}
+	if line.QuarterAmount(1) != 25001 || line.QuarterAmount(4) != 25000 {
+		t.Errorf("Unexpected quarter split %s / %s", line.QuarterAmount(1), line.QuarterAmount(4))
+	}
+}
+
+func TestQuarterlyProvisions(t *testing.T) {
+	lines := []BudgetLine{
+		{Label: "Entretien", Amount: 120000},
+		{Label: "Ascenseur", Amount: 40000, ChargeKeyID: 7},
+	}
+
+	provisions := QuarterlyProvisions(lines, 2024, time.July)
+
+	if len(provisions) != 8 {
+		t.Fatalf("Expected 8 provisions, got %d", len(provisions))
+	}
+
+	first, last := provisions[0], provisions[7]
+	if first.Label != "Appel de fonds T1 2024-2025 - Entretien" {
+		t.Errorf("Unexpected label %q", first.Label)
+	}
+	if !first.Date.Equal(date(2024, time.July, 1)) || first.FiscalYear != 2024 {
+		t.Errorf("Expected T1 on 01/07/2024 for fiscal year 2024, got %s / %d", first.Date, first.FiscalYear)
+	}
+	if !last.Date.Equal(date(2025, time.April, 1)) || last.FiscalYear != 2024 {
+		t.Errorf("Expected T4 on 01/04/2025 for fiscal year 2024, got %s / %d", last.Date, last.FiscalYear)
+	}
+	if last.ChargeKeyID != 7 || last.Amount != 10000 {
+		t.Errorf("Unexpected last provision %+v", last)
+	}
+}
+
+func TestCallNoticesShareTheQuarter(t *testing.T) {
+	persons := []Person{
+		{ID: 1, Name: "John Doe", Tantieme: 1},
+		{ID: 2, Name: "Jane Doe", Tantieme: 2},
+	}
+	chargeKey := ChargeKey{ID: 7, Name: "Ascenseur", Tantiemes: map[int]int{2: 10}}
+	allocator := NewAllocator(persons, chargeKey)
+	lines := []BudgetLine{{Amount: 100000}, {Amount: 40000, ChargeKeyID: 7}}
+
+	var total Money
+	for _, person := range persons {
+		for _, line := range lines {
+			total += allocator.ShareOf(&person, line.QuarterAmount(2), line.ChargeKeyID)
+		}
+	}
+
+	if total != 35000 {
+		t.Errorf("Expected owners to be called 350,00 in total, got %s", total)
+	}
+	due := quarterStartDate(2025, time.January, 2)
+	if allocator.TantiemeOf(persons[0], 7, due) != 0 || allocator.TantiemeOf(persons[1], 7, due) != 10 || allocator.TantiemeOf(persons[1], 0, due) != 2 {
+		t.Error("Unexpected tantièmes for the notices")
+	}
+}
+
+func TestWriteCallNoticesZip(t *testing.T) {
+	persons := []Person{{ID: 1, Name: "John Doe", Tantieme: 1}, {ID: 2, Name: "Jane Doe", Tantieme: 1}}
+	data := budgetData{
+		Copropriete: Copropriete{Name: "Les Tilleuls", FiscalYearStart: time.January},
+		FiscalYear:  2025,
+		Lines:       []BudgetLine{{Label: "Entretien", Amount: 120000}},
+		Persons:     persons,
+		Allocator:   NewAllocator(persons),
+	}
+
+	var buffer bytes.Buffer
+	if err := writeCallNoticesZip(&buffer, data, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archive.File) != 2 || archive.File[0].Name != "appel-de-fonds-T3-2025-1.pdf" {
+		t.Errorf("Unexpected archive content %v", archive.File)
+	}
+}
@@ -0,0 +1,420 @@
+package domains
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+const generalChargeKeyName = "Charges générales"
+
+// ChargeKey is a clé de répartition: a named grid of tantièmes used to split
+// special charges (elevator, staircase, parking...) between a subset of the
+// lots of a building. Bills and provisions without a charge key are split
+// with the general tantièmes of each lot.
+type ChargeKey struct {
+	ID        int
+	Name      string
+	Tantiemes map[int]int // lot id -> tantièmes
+}
+
+type chargeKeyFormData struct {
+	ChargeKey  *ChargeKey
+	ChargeKeys []ChargeKey
+	Lots       []Lot
+}
+
+// Tantieme returns the tantièmes of lot in this charge key.
+func (chargeKey ChargeKey) Tantieme(lot Lot) int {
+	return chargeKey.Tantiemes[lot.ID]
+}
+
+func ChargeKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderChargeKeyForm(w, db, userID, coproprieteID, nil)
+}
+
+func EditChargeKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	chargeKeyID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid charge key id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var coproprieteID int
+	err = db.QueryRow("SELECT coproprieteId FROM charge_keys WHERE id = $1 AND userId = $2", chargeKeyID, userID).Scan(&coproprieteID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Charge key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	chargeKeys, err := getChargeKeys(db, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, chargeKey := range chargeKeys {
+		if chargeKey.ID == chargeKeyID {
+			renderChargeKeyForm(w, db, userID, coproprieteID, &chargeKey)
+			return
+		}
+	}
+
+	http.Error(w, "Charge key not found", http.StatusNotFound)
+}
+
+func AddChargeKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	name := r.FormValue("name")
+	if name == "" {
+		http.Error(w, "Name cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	lots, err := getLots(db, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tantiemes, err := parseChargeKeyTantiemes(r, lots)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var chargeKeyID int
+	err = tx.QueryRow("INSERT INTO charge_keys (name, userId, coproprieteId) VALUES ($1, $2, $3) RETURNING id", name, userID, coproprieteID).Scan(&chargeKeyID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := saveChargeKeyTantiemes(tx, chargeKeyID, tantiemes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#charge_key_added", http.StatusFound)
+}
+
+func UpdateChargeKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	chargeKeyID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid charge key id", http.StatusBadRequest)
+		return
+	}
+
+	name := r.FormValue("name")
+	if name == "" {
+		http.Error(w, "Name cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var coproprieteID int
+	err = db.QueryRow("SELECT coproprieteId FROM charge_keys WHERE id = $1 AND userId = $2", chargeKeyID, userID).Scan(&coproprieteID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Charge key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	lots, err := getLots(db, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tantiemes, err := parseChargeKeyTantiemes(r, lots)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("UPDATE charge_keys SET name = $1 WHERE id = $2 AND userId = $3", name, chargeKeyID, userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM charge_key_lots WHERE chargeKeyId = $1", chargeKeyID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := saveChargeKeyTantiemes(tx, chargeKeyID, tantiemes); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#charge_key_updated", http.StatusFound)
+}
+
+func DeleteChargeKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	chargeKeyID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid charge key id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Bills and provisions using the key fall back to the general tantièmes
+	// through ON DELETE SET NULL.
+	result, err := db.Exec("DELETE FROM charge_keys WHERE id = $1 AND userId = $2", chargeKeyID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "Charge key not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#charge_key_deleted", http.StatusFound)
+}
+
+func getChargeKeys(db *sql.DB, userID string, coproprieteID int) ([]ChargeKey, error) {
+	rows, err := db.Query("SELECT id, name FROM charge_keys WHERE userId = $1 AND coproprieteId = $2 ORDER BY id", userID, coproprieteID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var chargeKeys []ChargeKey
+	for rows.Next() {
+		chargeKey := ChargeKey{Tantiemes: make(map[int]int)}
+		if err := rows.Scan(&chargeKey.ID, &chargeKey.Name); err != nil {
+			return nil, err
+		}
+		chargeKeys = append(chargeKeys, chargeKey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tantiemeRows, err := db.Query(`SELECT t.chargeKeyId, t.lotId, t.tantieme FROM charge_key_lots t
+		JOIN charge_keys k ON k.id = t.chargeKeyId
+		WHERE k.userId = $1 AND k.coproprieteId = $2`, userID, coproprieteID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tantiemeRows.Close() }()
+
+	for tantiemeRows.Next() {
+		var chargeKeyID, lotID, tantieme int
+		if err := tantiemeRows.Scan(&chargeKeyID, &lotID, &tantieme); err != nil {
+			return nil, err
+		}
+		for _, chargeKey := range chargeKeys {
+			if chargeKey.ID == chargeKeyID {
+				chargeKey.Tantiemes[lotID] = tantieme
+			}
+		}
+	}
+
+	return chargeKeys, tantiemeRows.Err()
+}
+
+// parseChargeKeyID reads the charge_key_id form value. Zero (or no value)
+// stands for the general tantièmes and is stored as NULL.
+func parseChargeKeyID(db *sql.DB, r *http.Request, userID string, coproprieteID int) (sql.NullInt64, error) {
+	value := r.FormValue("charge_key_id")
+	if value == "" || value == "0" {
+		return sql.NullInt64{}, nil
+	}
+
+	chargeKeyID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return sql.NullInt64{}, fmt.Errorf("invalid charge key value")
+	}
+
+	return checkChargeKeyID(db, chargeKeyID, userID, coproprieteID)
+}
+
+// checkChargeKeyID ensures a charge key belongs to the building. Zero stands
+// for the general tantièmes and is stored as NULL.
+func checkChargeKeyID(db *sql.DB, chargeKeyID int64, userID string, coproprieteID int) (sql.NullInt64, error) {
+	if chargeKeyID == 0 {
+		return sql.NullInt64{}, nil
+	}
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM charge_keys WHERE id = $1 AND userId = $2 AND coproprieteId = $3", chargeKeyID, userID, coproprieteID).Scan(&count)
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+	if count == 0 {
+		return sql.NullInt64{}, fmt.Errorf("invalid charge key value")
+	}
+
+	return sql.NullInt64{Int64: chargeKeyID, Valid: true}, nil
+}
+
+func parseChargeKeyTantiemes(r *http.Request, lots []Lot) (map[int]int, error) {
+	tantiemes := make(map[int]int)
+	for _, lot := range lots {
+		value := r.FormValue(fmt.Sprintf("tantieme_%d", lot.ID))
+		if value == "" {
+			continue
+		}
+		tantieme, err := strconv.Atoi(value)
+		if err != nil || tantieme < 0 {
+			return nil, fmt.Errorf("invalid tantieme value for %s", lot.Name)
+		}
+		if tantieme > 0 {
+			tantiemes[lot.ID] = tantieme
+		}
+	}
+	// Amounts cannot be split with a key without tantièmes.
+	if len(tantiemes) == 0 {
+		return nil, fmt.Errorf("a charge key needs tantiemes on at least one lot")
+	}
+	return tantiemes, nil
+}
+
+func saveChargeKeyTantiemes(tx *sql.Tx, chargeKeyID int, tantiemes map[int]int) error {
+	for lotID, tantieme := range tantiemes {
+		_, err := tx.Exec("INSERT INTO charge_key_lots (chargeKeyId, lotId, tantieme) VALUES ($1, $2, $3)", chargeKeyID, lotID, tantieme)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renderChargeKeyForm(w http.ResponseWriter, db *sql.DB, userID string, coproprieteID int, chargeKey *ChargeKey) {
+	lots, err := getLots(db, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	chargeKeys, err := getChargeKeys(db, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t, err := template.ParseFiles("lib/templates/edit-charge-keys.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	data := chargeKeyFormData{
+		ChargeKey:  chargeKey,
+		ChargeKeys: chargeKeys,
+		Lots:       lots,
+	}
+
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,30 @@
+package domains
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseChargeKeyTantiemes(t *testing.T) {
+	lots := []Lot{{ID: 1, Name: "Lot 1"}, {ID: 2, Name: "Lot 2"}}
+	parse := func(values url.Values) (map[int]int, error) {
+		r := httptest.NewRequest("POST", "/charge-keys", strings.NewReader(values.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return parseChargeKeyTantiemes(r, lots)
+	}
+
+	tantiemes, err := parse(url.Values{"tantieme_1": {"0"}, "tantieme_2": {"15"}})
+	if err != nil || len(tantiemes) != 1 || tantiemes[2] != 15 {
+		t.Errorf("Expected lot 2 only, got %v, %v", tantiemes, err)
+	}
+
+	// Nothing could be split with a key without tantièmes.
+	if _, err := parse(url.Values{"tantieme_1": {"0"}, "tantieme_2": {""}}); err == nil {
+		t.Error("Expected a key without tantiemes to be rejected")
+	}
+	if _, err := parse(url.Values{"tantieme_1": {"-1"}}); err == nil {
+		t.Error("Expected negative tantiemes to be rejected")
+	}
+}
@@ -0,0 +1,425 @@
+package domains
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+
+	"github.com/go-session/session/v3"
+)
+
+const defaultCoproprieteName = "Ma copropriété"
+
+type Copropriete struct {
+	ID              int
+	Name            string
+	FiscalYearStart time.Month
+	// WorksFundRate is the yearly contribution to the works fund, as a
+	// percentage of the budget.
+	WorksFundRate int
+	// RequireTwoFactor keeps two-factor authentication on for the owner of
+	// the building.
+	RequireTwoFactor bool
+}
+
+func CoproprieteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderCoproprieteForm(w, db, userID, nil)
+}
+
+func EditCoproprieteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	coproprieteID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid copropriete id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var copropriete Copropriete
+	err = db.QueryRow("SELECT id, name, COALESCE(fiscalYearStart, 1), COALESCE(worksFundRate, 5), COALESCE(requireTwoFactor, FALSE) FROM coproprietes WHERE id = $1 AND userId = $2", coproprieteID, userID).
+		Scan(&copropriete.ID, &copropriete.Name, &copropriete.FiscalYearStart, &copropriete.WorksFundRate, &copropriete.RequireTwoFactor)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Copropriete not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderCoproprieteForm(w, db, userID, &copropriete)
+}
+
+func AddCoproprieteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	name := r.FormValue("name")
+	if name == "" {
+		http.Error(w, "Name cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	fiscalYearStart, err := parseFiscalYearStart(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	worksFundRate, err := parseWorksFundRate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	requireTwoFactor, err := parseRequireTwoFactor(r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	canUserCreateCopropriete, err := helpers.CanUserCreateCopropriete(db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !canUserCreateCopropriete {
+		http.Redirect(w, r, "/dashboard#limit-coproprietes", http.StatusFound)
+		return
+	}
+
+	var coproprieteID int
+	err = db.QueryRow("INSERT INTO coproprietes (name, fiscalYearStart, worksFundRate, requireTwoFactor, userId) VALUES ($1, $2, $3, $4, $5) RETURNING id", name, int(fiscalYearStart), worksFundRate, requireTwoFactor, userID).Scan(&coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := setCurrentCoproprieteID(w, r, coproprieteID); err != nil {
+		log.Printf("Session save error: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#copropriete_added", http.StatusFound)
+}
+
+func UpdateCoproprieteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	coproprieteID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid copropriete id", http.StatusBadRequest)
+		return
+	}
+
+	name := r.FormValue("name")
+	if name == "" {
+		http.Error(w, "Name cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	fiscalYearStart, err := parseFiscalYearStart(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	worksFundRate, err := parseWorksFundRate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	requireTwoFactor, err := parseRequireTwoFactor(r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec("UPDATE coproprietes SET name = $1, fiscalYearStart = $2, worksFundRate = $3, requireTwoFactor = $4 WHERE id = $5 AND userId = $6", name, int(fiscalYearStart), worksFundRate, requireTwoFactor, coproprieteID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "Copropriete not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#copropriete_updated", http.StatusFound)
+}
+
+// SwitchCoproprieteHandler stores the building picked in the dashboard switcher
+// as the current building of the session.
+func SwitchCoproprieteHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	coproprieteID, err := strconv.Atoi(r.FormValue("copropriete_id"))
+	if err != nil {
+		http.Error(w, "Invalid copropriete value", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	owned, err := isCoproprieteOwnedBy(db, coproprieteID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !owned {
+		http.Error(w, "Copropriete not found", http.StatusNotFound)
+		return
+	}
+
+	if err := setCurrentCoproprieteID(w, r, coproprieteID); err != nil {
+		log.Printf("Session save error: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard", http.StatusFound)
+}
+
+// GetCurrentCoproprieteID returns the building selected in the session. When
+// nothing valid is selected it falls back to the user's first building,
+// creating a default one for accounts that have none yet.
+func GetCurrentCoproprieteID(w http.ResponseWriter, r *http.Request, db *sql.DB, userID string) (int, error) {
+	store, err := session.Start(context.Background(), w, r)
+	if err != nil {
+		return 0, fmt.Errorf("session error: %w", err)
+	}
+
+	key, ok := currentCoproprieteKey(r)
+	if !ok {
+		return 0, fmt.Errorf("no session cookie")
+	}
+
+	if value, ok := store.Get(key); ok {
+		if coproprieteID, err := strconv.Atoi(fmt.Sprintf("%v", value)); err == nil {
+			owned, err := isCoproprieteOwnedBy(db, coproprieteID, userID)
+			if err != nil {
+				return 0, err
+			}
+			if owned {
+				return coproprieteID, nil
+			}
+		}
+	}
+
+	var coproprieteID int
+	err = db.QueryRow("SELECT id FROM coproprietes WHERE userId = $1 ORDER BY id LIMIT 1", userID).Scan(&coproprieteID)
+	if err == sql.ErrNoRows {
+		err = db.QueryRow("INSERT INTO coproprietes (name, userId) VALUES ($1, $2) RETURNING id", defaultCoproprieteName, userID).Scan(&coproprieteID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error resolving copropriete: %w", err)
+	}
+
+	store.Set(key, strconv.Itoa(coproprieteID))
+	if err := store.Save(); err != nil {
+		return 0, fmt.Errorf("session save error: %w", err)
+	}
+
+	return coproprieteID, nil
+}
+
+func setCurrentCoproprieteID(w http.ResponseWriter, r *http.Request, coproprieteID int) error {
+	store, err := session.Start(context.Background(), w, r)
+	if err != nil {
+		return err
+	}
+
+	key, ok := currentCoproprieteKey(r)
+	if !ok {
+		return fmt.Errorf("no session cookie")
+	}
+
+	store.Set(key, strconv.Itoa(coproprieteID))
+	return store.Save()
+}
+
+func currentCoproprieteKey(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie("tanzia-session")
+	if err != nil {
+		return "", false
+	}
+	return cookie.Value + ":copropriete", true
+}
+
+func isCoproprieteOwnedBy(db *sql.DB, coproprieteID int, userID string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM coproprietes WHERE id = $1 AND userId = $2", coproprieteID, userID).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking copropriete ownership: %w", err)
+	}
+	return count > 0, nil
+}
+
+func getUserCoproprietes(db *sql.DB, userID string) ([]Copropriete, error) {
+	rows, err := db.Query("SELECT id, name, COALESCE(fiscalYearStart, 1), COALESCE(worksFundRate, 5) FROM coproprietes WHERE userId = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var coproprietes []Copropriete
+	for rows.Next() {
+		var copropriete Copropriete
+		if err := rows.Scan(&copropriete.ID, &copropriete.Name, &copropriete.FiscalYearStart, &copropriete.WorksFundRate); err != nil {
+			return nil, err
+		}
+		coproprietes = append(coproprietes, copropriete)
+	}
+	return coproprietes, rows.Err()
+}
+
+// getFiscalYearStart returns the month the fiscal year of a building starts in.
+func getFiscalYearStart(db *sql.DB, coproprieteID int) (time.Month, error) {
+	var fiscalYearStart time.Month
+	err := db.QueryRow("SELECT COALESCE(fiscalYearStart, 1) FROM coproprietes WHERE id = $1", coproprieteID).Scan(&fiscalYearStart)
+	return fiscalYearStart, err
+}
+
+func parseFiscalYearStart(r *http.Request) (time.Month, error) {
+	value := r.FormValue("fiscal_year_start")
+	if value == "" {
+		return time.January, nil
+	}
+	month, err := strconv.Atoi(value)
+	if err != nil || month < 1 || month > 12 {
+		return 0, fmt.Errorf("invalid fiscal year start value")
+	}
+	return time.Month(month), nil
+}
+
+// parseWorksFundRate reads the works_fund_rate form value. The law requires
+// at least 5% of the budget; zero is kept for buildings exempted by their
+// general assembly.
+func parseWorksFundRate(r *http.Request) (int, error) {
+	value := r.FormValue("works_fund_rate")
+	if value == "" {
+		return minimumWorksFundRate, nil
+	}
+	rate, err := strconv.Atoi(value)
+	if err != nil || rate < 0 || rate > 100 || (rate > 0 && rate < minimumWorksFundRate) {
+		return 0, fmt.Errorf("invalid works fund rate value")
+	}
+	return rate, nil
+}
+
+// parseRequireTwoFactor reads the require_two_factor checkbox. Only a user
+// who enabled two-factor authentication can require it, so that nobody
+// locks themselves out of a building.
+func parseRequireTwoFactor(r *http.Request, db *sql.DB, userID string) (bool, error) {
+	if r.FormValue("require_two_factor") == "" {
+		return false, nil
+	}
+	enabled, err := isTwoFactorEnabled(db, userID)
+	if err != nil {
+		return false, err
+	}
+	if !enabled {
+		return false, fmt.Errorf("two-factor authentication must be enabled to require it")
+	}
+	return true, nil
+}
+
+func renderCoproprieteForm(w http.ResponseWriter, db *sql.DB, userID string, copropriete *Copropriete) {
+	twoFactorEnabled, err := isTwoFactorEnabled(db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t, err := template.ParseFiles("lib/templates/edit-coproprietes.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	type month struct {
+		Number time.Month
+		Name   string
+	}
+
+	var months []month
+	for number := time.January; number <= time.December; number++ {
+		months = append(months, month{Number: number, Name: monthNames[number-1]})
+	}
+
+	data := struct {
+		Copropriete      *Copropriete
+		Months           []month
+		TwoFactorEnabled bool
+	}{
+		Copropriete:      copropriete,
+		Months:           months,
+		TwoFactorEnabled: twoFactorEnabled,
+	}
+
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -4,99 +4,346 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/duscraft/tanzia/lib/helpers"
 )
 
 type DashboardData struct {
-	Persons        []Person
-	Bills          []Bill
-	Provisions     []Provision
-	TotalTantiemes int
-	Balance        float64
-	IsPremium      bool
+	Copropriete  Copropriete
+	Coproprietes []Copropriete
+	Persons      []Person
+	Bills        []Bill
+	Provisions   []Provision
+	Payments     []Payment
+	// Regularizations carried over into the period by closed fiscal years.
+	Regularizations []Regularization
+	Calls           map[int][]Call // person id -> calls, in the order of Provisions
+	ChargeKeys      []ChargeKey
+	Suppliers       []Supplier
+	SupplierTotals  []SupplierTotal
+	CategoryTotals  []CategoryTotal
+	SupplierID      int // restricts the listed bills, see FilteredBills
+	Allocator       Allocator
+	TotalTantiemes  int
+	Balance         Money
+	// Unallocated is the part of the bills and provisions of the period
+	// charged to no person, see Allocator.UnallocatedOver.
+	Unallocated   Money
+	WorksFund     WorksFund
+	Paid          Money
+	CarriedOver   Money
+	Opening       Money
+	Outstanding   Money
+	IsPremium     bool
+	EmailVerified bool
+	Period        Period
+	FiscalYears   []int
+
+	// The entries booked before the period, which make the opening balances
+	// of the persons, see OpeningBalance.
+	earlierProvisions      []Provision
+	earlierPayments        []Payment
+	earlierRegularizations []Regularization
 }
 
-func getDashboardData(userID string) (DashboardData, error) {
+// getDashboardData loads the persons of a building along with its bills,
+// provisions and payments restricted to period, and the regularizations of
+// the fiscal years closed just before it. What the persons owe is counted up
+// to the end of the period, from the entries booked before it.
+func getDashboardData(userID string, coproprieteID int, period Period) (DashboardData, error) {
 	db, err := helpers.GetConnectionManager().GetConnection("postgres")
 	if err != nil {
 		return DashboardData{}, err
 	}
 
-	personRows, err := db.Query("SELECT name, tantieme FROM persons WHERE userId = $1", userID)
+	coproprietes, err := getUserCoproprietes(db, userID)
 	if err != nil {
 		return DashboardData{}, err
 	}
-	defer func() { _ = personRows.Close() }()
 
-	billRows, err := db.Query("SELECT label, amount FROM bills WHERE userId = $1", userID)
+	var current Copropriete
+	for _, copropriete := range coproprietes {
+		if copropriete.ID == coproprieteID {
+			current = copropriete
+		}
+	}
+
+	persons, err := getPersons(db, userID, coproprieteID)
 	if err != nil {
 		return DashboardData{}, err
 	}
-	defer func() { _ = billRows.Close() }()
 
-	provisionRows, err := db.Query("SELECT label, amount FROM provisions WHERE userId = $1", userID)
+	lots, err := getLots(db, userID, coproprieteID)
 	if err != nil {
 		return DashboardData{}, err
 	}
-	defer func() { _ = provisionRows.Close() }()
 
-	var persons []Person
-	var bills []Bill
-	var provisions []Provision
-	var balance float64
-	totalTantiemes := 0
+	attachments, err := getCoproprieteAttachments(db, userID, coproprieteID)
+	if err != nil {
+		return DashboardData{}, err
+	}
 
-	for personRows.Next() {
-		var person Person
-		if err := personRows.Scan(&person.Name, &person.Tantieme); err != nil {
-			return DashboardData{}, err
+	billAttachments := make(map[int][]Attachment)
+	provisionAttachments := make(map[int][]Attachment)
+	for _, attachment := range attachments {
+		if attachment.BillID != 0 {
+			billAttachments[attachment.BillID] = append(billAttachments[attachment.BillID], attachment)
+		} else {
+			provisionAttachments[attachment.ProvisionID] = append(provisionAttachments[attachment.ProvisionID], attachment)
 		}
-		persons = append(persons, person)
-		totalTantiemes += person.Tantieme
 	}
-	if err := personRows.Err(); err != nil {
+
+	allBills, err := getBills(db, userID, coproprieteID)
+	if err != nil {
+		return DashboardData{}, err
+	}
+
+	allProvisions, err := getProvisions(db, userID, coproprieteID)
+	if err != nil {
 		return DashboardData{}, err
 	}
 
-	for billRows.Next() {
-		var bill Bill
-		if err := billRows.Scan(&bill.Label, &bill.Amount); err != nil {
-			return DashboardData{}, err
+	var bills []Bill
+	var provisions, earlierProvisions []Provision
+	var balance Money
+	totalTantiemes := 0
+	fiscalYears := map[int]bool{FiscalYearOf(time.Now(), period.FiscalYearStart): true}
+
+	for _, person := range persons {
+		totalTantiemes += person.Tantieme
+	}
+
+	for _, bill := range allBills {
+		fiscalYears[bill.FiscalYear] = true
+		if !period.Includes(bill.Date, bill.FiscalYear) {
+			continue
+		}
+		if !bill.WorksFund {
+			balance -= bill.Amount
 		}
-		balance -= bill.Amount
+		bill.Attachments = billAttachments[bill.ID]
 		bills = append(bills, bill)
 	}
-	if err := billRows.Err(); err != nil {
+
+	for _, provision := range allProvisions {
+		fiscalYears[provision.FiscalYear] = true
+		if period.Precedes(provision.Date, provision.FiscalYear) {
+			earlierProvisions = append(earlierProvisions, provision)
+		}
+		if !period.Includes(provision.Date, provision.FiscalYear) {
+			continue
+		}
+		if !provision.WorksFund {
+			balance += provision.Amount
+		}
+		provision.Attachments = provisionAttachments[provision.ID]
+		provisions = append(provisions, provision)
+	}
+
+	chargeKeys, err := getChargeKeys(db, userID, coproprieteID)
+	if err != nil {
+		return DashboardData{}, err
+	}
+
+	suppliers, err := getSuppliers(db, userID, coproprieteID)
+	if err != nil {
+		return DashboardData{}, err
+	}
+
+	allPayments, err := getPayments(db, userID, coproprieteID)
+	if err != nil {
 		return DashboardData{}, err
 	}
 
-	for provisionRows.Next() {
-		var provision Provision
-		if err := provisionRows.Scan(&provision.Label, &provision.Amount); err != nil {
-			return DashboardData{}, err
+	var payments, earlierPayments []Payment
+	var paid Money
+	for _, payment := range allPayments {
+		fiscalYear := FiscalYearOf(payment.Date, period.FiscalYearStart)
+		if period.Precedes(payment.Date, fiscalYear) {
+			earlierPayments = append(earlierPayments, payment)
+		}
+		if period.Includes(payment.Date, fiscalYear) {
+			payments = append(payments, payment)
+			paid += payment.Amount
 		}
-		balance += provision.Amount
-		provisions = append(provisions, provision)
 	}
-	if err := provisionRows.Err(); err != nil {
+
+	allRegularizations, err := getRegularizations(db, userID, coproprieteID)
+	if err != nil {
 		return DashboardData{}, err
 	}
 
+	// A regularization opens the fiscal year following the closed one.
+	var regularizations, earlierRegularizations []Regularization
+	var carriedOver Money
+	for _, regularization := range allRegularizations {
+		openingYear := regularization.FiscalYear + 1
+		if period.Precedes(fiscalYearStartDate(openingYear, period.FiscalYearStart), openingYear) {
+			earlierRegularizations = append(earlierRegularizations, regularization)
+		}
+		if period.Includes(fiscalYearStartDate(openingYear, period.FiscalYearStart), openingYear) {
+			regularizations = append(regularizations, regularization)
+			carriedOver -= regularization.Amount
+		}
+	}
+
+	var called Money
+	for _, provision := range provisions {
+		called += provision.Amount
+	}
+
+	allocator := NewLotAllocator(persons, lots, period.FiscalYearStart, chargeKeys...)
+	data := DashboardData{
+		Allocator:              allocator,
+		Provisions:             provisions,
+		Payments:               payments,
+		Regularizations:        regularizations,
+		earlierProvisions:      earlierProvisions,
+		earlierPayments:        earlierPayments,
+		earlierRegularizations: earlierRegularizations,
+	}
+	calls := make(map[int][]Call)
+	var opening Money
+	for _, person := range persons {
+		calls[person.ID] = data.personCalls(person)
+		opening += data.OpeningBalance(person)
+	}
+
+	var sortedFiscalYears []int
+	for fiscalYear := range fiscalYears {
+		sortedFiscalYears = append(sortedFiscalYears, fiscalYear)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sortedFiscalYears)))
+
 	isPremium, err := helpers.IsUserPremium(db, userID)
 	if err != nil {
 		log.Printf("Warning: could not check premium status for user %s: %v", userID, err)
 		isPremium = false
 	}
 
-	return DashboardData{
-		Persons:        persons,
-		Bills:          bills,
-		Provisions:     provisions,
-		TotalTantiemes: totalTantiemes,
-		Balance:        balance,
-		IsPremium:      isPremium,
-	}, nil
+	emailVerified, err := isEmailVerified(db, userID)
+	if err != nil {
+		log.Printf("Warning: could not check email verification for user %s: %v", userID, err)
+		emailVerified = true
+	}
+
+	data.Copropriete = current
+	data.Coproprietes = coproprietes
+	data.Persons = persons
+	data.Bills = bills
+	data.Calls = calls
+	data.ChargeKeys = chargeKeys
+	data.Suppliers = suppliers
+	data.SupplierTotals = SupplierTotals(suppliers, bills)
+	data.CategoryTotals = CategoryTotals(bills)
+	data.TotalTantiemes = totalTantiemes
+	data.Balance = balance
+	data.Unallocated = unallocated(allocator, bills, provisions)
+	data.WorksFund = NewWorksFund(bills, provisions)
+	data.Paid = paid
+	data.CarriedOver = carriedOver
+	data.Opening = opening
+	data.Outstanding = opening + called + carriedOver - paid
+	data.IsPremium = isPremium
+	data.EmailVerified = emailVerified
+	data.Period = period
+	data.FiscalYears = sortedFiscalYears
+	return data, nil
+}
+
+// unallocated returns the part of bills and provisions charged to no person,
+// with the bounds of Person.CalculateDue and Person.CalculateProvision.
+func unallocated(allocator Allocator, bills []Bill, provisions []Provision) Money {
+	var total Money
+	for _, bill := range bills {
+		from, to := allocator.FiscalYearBounds(bill.FiscalYear)
+		total += allocator.UnallocatedOver(bill.Amount, bill.ChargeKeyID, from, to)
+	}
+	for _, provision := range provisions {
+		total += allocator.UnallocatedOver(provision.Amount, provision.ChargeKeyID, provision.Date, provision.Date.AddDate(0, 0, 1))
+	}
+	return total
+}
+
+// HasOpeningBalance reports whether entries were booked before the period.
+func (data DashboardData) HasOpeningBalance() bool {
+	return len(data.earlierProvisions) > 0 || len(data.earlierPayments) > 0 || len(data.earlierRegularizations) > 0
+}
+
+// OpeningBalance returns what the person owed when the period started. A
+// negative amount is a credit.
+func (data DashboardData) OpeningBalance(person Person) Money {
+	return person.CalculateOutstanding(data.Allocator, data.earlierProvisions, data.earlierPayments, data.earlierRegularizations)
+}
+
+// PersonOutstanding returns what the person owes at the end of the period:
+// their opening balance and the movements of the period.
+func (data DashboardData) PersonOutstanding(person Person) Money {
+	return data.OpeningBalance(person) + person.CalculateOutstanding(data.Allocator, data.Provisions, data.Payments, data.Regularizations)
+}
+
+// personCalls returns the calls of the period, in the order of Provisions.
+// Earlier calls are settled first by the earlier payments, so a call of the
+// period paid after it still shows as due, and a call paid in advance as
+// settled.
+func (data DashboardData) personCalls(person Person) []Call {
+	provisions := append(slices.Clone(data.earlierProvisions), data.Provisions...)
+	payments := append(slices.Clone(data.earlierPayments), data.Payments...)
+	regularizations := append(slices.Clone(data.earlierRegularizations), data.Regularizations...)
+	calls := person.Calls(data.Allocator, provisions, payments, regularizations)
+	return calls[len(data.earlierProvisions):]
+}
+
+// PersonName returns the name of a person of the building.
+func (data DashboardData) PersonName(personID int) string {
+	for _, person := range data.Persons {
+		if person.ID == personID {
+			return person.Name
+		}
+	}
+	return ""
+}
+
+// FilteredBills returns the bills of the supplier filter, or every bill
+// without one.
+func (data DashboardData) FilteredBills() []Bill {
+	if data.SupplierID == 0 {
+		return data.Bills
+	}
+	var bills []Bill
+	for _, bill := range data.Bills {
+		if bill.SupplierID == data.SupplierID {
+			bills = append(bills, bill)
+		}
+	}
+	return bills
+}
+
+// SupplierName returns the name of a supplier of the building.
+func (data DashboardData) SupplierName(supplierID int) string {
+	for _, supplier := range data.Suppliers {
+		if supplier.ID == supplierID {
+			return supplier.Name
+		}
+	}
+	return ""
+}
+
+// FilterQuery returns the query string selecting the period of the dashboard
+// and the expenses of a supplier, zero for every supplier.
+func (data DashboardData) FilterQuery(supplierID int) template.URL {
+	query := data.Period.Query()
+	if supplierID == 0 {
+		return query
+	}
+	if query != "" {
+		query += "&"
+	}
+	return query + template.URL("supplier="+strconv.Itoa(supplierID))
 }
 
 func DashboardHandler(w http.ResponseWriter, r *http.Request) {
@@ -106,13 +353,45 @@ func DashboardHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data, err := getDashboardData(userID)
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		log.Printf("Error resolving copropriete: %v", err)
+		http.Error(w, "Failed to load dashboard data", http.StatusInternalServerError)
+		return
+	}
+
+	fiscalYearStart, err := getFiscalYearStart(db, coproprieteID)
+	if err != nil {
+		log.Printf("Error resolving copropriete: %v", err)
+		http.Error(w, "Failed to load dashboard data", http.StatusInternalServerError)
+		return
+	}
+
+	period, err := ParsePeriod(r.URL.Query(), fiscalYearStart, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := getDashboardData(userID, coproprieteID, period)
 	if err != nil {
 		log.Printf("Error getting dashboard data: %v", err)
 		http.Error(w, "Failed to load dashboard data", http.StatusInternalServerError)
 		return
 	}
 
+	data.SupplierID, err = parseSupplierFilter(r, data.Suppliers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	t, err := template.ParseFiles("lib/templates/dashboard.html")
 	if err != nil {
 		log.Printf("Error parsing template: %v", err)
@@ -0,0 +1,54 @@
+package domains
+
+import (
+	"testing"
+)
+
+func TestDashboardOutstandingSpansPeriods(t *testing.T) {
+	person := Person{ID: 1, Name: "John Doe", Tantieme: 1}
+	allocator := NewAllocator([]Person{person})
+	q4 := Provision{ID: 1, Label: "T4 2024", Amount: 100000, Date: date(2024, 10, 1), FiscalYear: 2024}
+	q1 := Provision{ID: 2, Label: "T1 2025", Amount: 100000, Date: date(2025, 1, 1), FiscalYear: 2025}
+	payment := Payment{PersonID: 1, Date: date(2025, 1, 10), Amount: 100000}
+
+	// The call of 2024 is paid in 2025: it is due at the end of 2024.
+	data := DashboardData{Allocator: allocator, Provisions: []Provision{q4}}
+	if outstanding := data.PersonOutstanding(person); outstanding != 100000 {
+		t.Errorf("Expected 1 000,00 outstanding at the end of 2024, got %s", outstanding)
+	}
+
+	// In 2025 it opens the balance and the payment settles it rather than
+	// the call of 2025.
+	data = DashboardData{Allocator: allocator, Provisions: []Provision{q1}, Payments: []Payment{payment}, earlierProvisions: []Provision{q4}}
+	if opening := data.OpeningBalance(person); opening != 100000 {
+		t.Errorf("Expected a 1 000,00 opening balance, got %s", opening)
+	}
+	if outstanding := data.PersonOutstanding(person); outstanding != 100000 {
+		t.Errorf("Expected 1 000,00 outstanding at the end of 2025, got %s", outstanding)
+	}
+	if calls := data.personCalls(person); len(calls) != 1 || calls[0].Left() != 100000 {
+		t.Errorf("Expected the call of 2025 to be left unpaid, got %+v", calls)
+	}
+
+	// A payment made in advance settles the call of the period.
+	data = DashboardData{Allocator: allocator, Provisions: []Provision{q1}, earlierPayments: []Payment{{PersonID: 1, Date: date(2024, 12, 20), Amount: 100000}}}
+	if outstanding := data.PersonOutstanding(person); outstanding != 0 {
+		t.Errorf("Expected nothing outstanding, got %s", outstanding)
+	}
+	if calls := data.personCalls(person); calls[0].Left() != 0 {
+		t.Errorf("Expected the call paid in advance to be settled, got %s left", calls[0].Left())
+	}
+	if !data.HasOpeningBalance() || (DashboardData{}).HasOpeningBalance() {
+		t.Error("Expected an opening balance only with earlier entries")
+	}
+}
+
+func TestDashboardUnallocated(t *testing.T) {
+	allocator := NewAllocator([]Person{{ID: 1, Name: "A", Tantieme: 1}}, ChargeKey{ID: 7, Name: "Vide"})
+	bills := []Bill{{Amount: 50000, FiscalYear: 2025}, {Amount: 12000, ChargeKeyID: 7, FiscalYear: 2025}}
+	provisions := []Provision{{Amount: 30000, ChargeKeyID: 7, Date: date(2025, 1, 1), FiscalYear: 2025}}
+
+	if total := unallocated(allocator, bills, provisions); total != 42000 {
+		t.Errorf("Expected 420,00 charged to nobody, got %s", total)
+	}
+}
@@ -0,0 +1,220 @@
+package domains
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+const (
+	emailStatusPending = "pending"
+	emailStatusSent    = "sent"
+	emailStatusFailed  = "failed"
+
+	// maxEmailAttempts is how many deliveries of an email are tried before it
+	// is given up.
+	maxEmailAttempts = 6
+	emailBatchSize   = 20
+	// emailLease is how long a claimed email is left alone by the other
+	// workers, in case the one delivering it dies.
+	emailLease = 10 * time.Minute
+)
+
+// emailTemplateDir holds the templates of the emails: name.txt defines the
+// "subject" and the "text" body, name.html the "content" of the HTML body,
+// wrapped in layout.html.
+var emailTemplateDir = "lib/templates/email"
+
+// SentEmail is an email queued for a user, kept as a log once delivered.
+type SentEmail struct {
+	ID        int
+	To        string
+	Subject   string
+	Template  string
+	Status    string
+	Attempts  int
+	LastError string
+	CreatedAt time.Time
+	SentAt    time.Time // zero until delivered
+}
+
+func (email SentEmail) StatusLabel() string {
+	switch email.Status {
+	case emailStatusSent:
+		return "Envoyé"
+	case emailStatusFailed:
+		return "Échec"
+	}
+	return "En attente"
+}
+
+// renderEmail renders the email template name with data.
+func renderEmail(name string, data any) (helpers.Message, error) {
+	text, err := template.ParseFiles(filepath.Join(emailTemplateDir, name+".txt"))
+	if err != nil {
+		return helpers.Message{}, fmt.Errorf("error parsing email template: %w", err)
+	}
+	var subject, body bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return helpers.Message{}, fmt.Errorf("error rendering email subject: %w", err)
+	}
+	if err := text.ExecuteTemplate(&body, "text", data); err != nil {
+		return helpers.Message{}, fmt.Errorf("error rendering email: %w", err)
+	}
+
+	html, err := htmltemplate.ParseFiles(filepath.Join(emailTemplateDir, "layout.html"), filepath.Join(emailTemplateDir, name+".html"))
+	if err != nil {
+		return helpers.Message{}, fmt.Errorf("error parsing email template: %w", err)
+	}
+	var htmlBody bytes.Buffer
+	if err := html.ExecuteTemplate(&htmlBody, "layout", data); err != nil {
+		return helpers.Message{}, fmt.Errorf("error rendering email: %w", err)
+	}
+
+	return helpers.Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimLeft(body.String(), "\n"),
+		HTML:    htmlBody.String(),
+	}, nil
+}
+
+// enqueueEmail renders the email template name for the user and queues it
+// for RunEmailQueue to deliver.
+func enqueueEmail(db *sql.DB, userID string, to string, name string, data any) error {
+	message, err := renderEmail(name, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("INSERT INTO email_queue (toAddress, subject, textBody, htmlBody, template, status, userId) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		to, message.Subject, message.Text, message.HTML, name, emailStatusPending, userID)
+	if err != nil {
+		return fmt.Errorf("error queuing email: %w", err)
+	}
+	return nil
+}
+
+// RunEmailQueue delivers the queued emails now and then on every tick of
+// interval. It only returns when the mailer is misconfigured.
+func RunEmailQueue(interval time.Duration) {
+	mailer, err := helpers.GetMailer()
+	if err != nil {
+		log.Printf("Email queue: %v", err)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		db, err := helpers.GetConnectionManager().GetConnection("postgres")
+		if err != nil {
+			log.Printf("Email queue: %v", err)
+		} else if err := deliverEmails(db, mailer); err != nil {
+			log.Printf("Email queue: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// deliverEmails sends the emails due for delivery, in batches until none is
+// left. Failed deliveries are retried later, see emailRetryDelay.
+func deliverEmails(db *sql.DB, mailer helpers.Mailer) error {
+	for {
+		// Claiming the emails pushes their next attempt back, so that
+		// concurrent workers skip them.
+		rows, err := db.Query(`UPDATE email_queue SET nextAttemptAt = NOW() + make_interval(secs => $1)
+			WHERE id IN (SELECT id FROM email_queue WHERE status = $2 AND nextAttemptAt <= NOW() ORDER BY id LIMIT $3 FOR UPDATE SKIP LOCKED)
+			RETURNING id, toAddress, subject, textBody, COALESCE(htmlBody, ''), attempts`,
+			emailLease.Seconds(), emailStatusPending, emailBatchSize)
+		if err != nil {
+			return err
+		}
+
+		type claimed struct {
+			id       int
+			attempts int
+			message  helpers.Message
+		}
+		var batch []claimed
+		for rows.Next() {
+			var c claimed
+			if err := rows.Scan(&c.id, &c.message.To, &c.message.Subject, &c.message.Text, &c.message.HTML, &c.attempts); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			batch = append(batch, c)
+		}
+		_ = rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for _, c := range batch {
+			if err := recordEmailDelivery(db, c.id, c.attempts+1, mailer.Send(c.message)); err != nil {
+				log.Printf("Email queue: could not record delivery of email %d: %v", c.id, err)
+			}
+		}
+	}
+}
+
+// recordEmailDelivery records the outcome of the attempt-th delivery of an
+// email. The bodies of the emails leaving the queue, sent or given up, are
+// cleared: they may hold links with working tokens, which are only stored
+// hashed elsewhere.
+func recordEmailDelivery(db *sql.DB, emailID int, attempt int, sendErr error) error {
+	if sendErr == nil {
+		_, err := db.Exec("UPDATE email_queue SET status = $1, attempts = $2, sentAt = NOW(), lastError = NULL, textBody = NULL, htmlBody = NULL WHERE id = $3", emailStatusSent, attempt, emailID)
+		return err
+	}
+
+	log.Printf("Email queue: delivery %d of email %d failed: %v", attempt, emailID, sendErr)
+	if attempt >= maxEmailAttempts {
+		_, err := db.Exec("UPDATE email_queue SET status = $1, attempts = $2, lastError = $3, textBody = NULL, htmlBody = NULL WHERE id = $4",
+			emailStatusFailed, attempt, sendErr.Error(), emailID)
+		return err
+	}
+	_, err := db.Exec("UPDATE email_queue SET status = $1, attempts = $2, lastError = $3, nextAttemptAt = NOW() + make_interval(secs => $4) WHERE id = $5",
+		emailStatusPending, attempt, sendErr.Error(), emailRetryDelay(attempt).Seconds(), emailID)
+	return err
+}
+
+// emailRetryDelay is the delay before retrying an email whose attempt-th
+// delivery failed: one minute, doubling with every attempt.
+func emailRetryDelay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	return time.Minute << (attempt - 1)
+}
+
+func getSentEmails(db *sql.DB, userID string, limit int) ([]SentEmail, error) {
+	rows, err := db.Query("SELECT id, toAddress, subject, template, status, attempts, COALESCE(lastError, ''), createdAt, sentAt FROM email_queue WHERE userId = $1 ORDER BY createdAt DESC, id DESC LIMIT $2", userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var emails []SentEmail
+	for rows.Next() {
+		var email SentEmail
+		var sentAt sql.NullTime
+		if err := rows.Scan(&email.ID, &email.To, &email.Subject, &email.Template, &email.Status, &email.Attempts, &email.LastError, &email.CreatedAt, &sentAt); err != nil {
+			return nil, err
+		}
+		email.SentAt = sentAt.Time
+		emails = append(emails, email)
+	}
+	return emails, rows.Err()
+}
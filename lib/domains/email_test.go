@@ -0,0 +1,113 @@
+package domains
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func useEmailTemplates(t *testing.T) {
+	t.Helper()
+	previous := emailTemplateDir
+	emailTemplateDir = "../templates/email"
+	t.Cleanup(func() { emailTemplateDir = previous })
+}
+
+func TestRenderEmail(t *testing.T) {
+	useEmailTemplates(t)
+
+	message, err := renderEmail("password-reset", struct{ Link string }{"https://tanzia.example/reset-password/confirm?token=a.b&c"})
+	if err != nil {
+		t.Fatalf("renderEmail failed: %v", err)
+	}
+
+	if message.Subject != "Réinitialisation de votre mot de passe Tanzia" {
+		t.Errorf("Unexpected subject %q", message.Subject)
+	}
+	if !strings.HasPrefix(message.Text, "Bonjour,") || !strings.Contains(message.Text, "\nhttps://tanzia.example/reset-password/confirm?token=a.b&c\n") {
+		t.Errorf("Expected the raw link in the text body, got:\n%s", message.Text)
+	}
+	if !strings.Contains(message.HTML, `href="https://tanzia.example/reset-password/confirm?token=a.b&amp;c"`) {
+		t.Errorf("Expected the escaped link in the HTML body, got:\n%s", message.HTML)
+	}
+}
+
+func TestEmailTemplatesRender(t *testing.T) {
+	useEmailTemplates(t)
+
+	// Every email has both bodies, rendered from a zero value of its data
+	// so that a template mistake shows up here rather than in the queue.
+	names, err := filepath.Glob(filepath.Join(emailTemplateDir, "*.txt"))
+	if err != nil || len(names) == 0 {
+		t.Fatalf("No email templates found (%v)", err)
+	}
+	for _, name := range names {
+		name = strings.TrimSuffix(filepath.Base(name), ".txt")
+		if _, err := os.Stat(filepath.Join(emailTemplateDir, name+".html")); err != nil {
+			t.Errorf("Email %s has no HTML body: %v", name, err)
+			continue
+		}
+		if _, err := renderEmail(name, map[string]any{}); err != nil {
+			t.Errorf("Email %s: %v", name, err)
+		}
+	}
+}
+
+func TestEmailRetryDelay(t *testing.T) {
+	expected := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute}
+	for i, delay := range expected {
+		if got := emailRetryDelay(i + 1); got != delay {
+			t.Errorf("emailRetryDelay(%d) = %v, expected %v", i+1, got, delay)
+		}
+	}
+	if emailRetryDelay(0) != time.Minute {
+		t.Errorf("Expected the first delay for out of range attempts, got %v", emailRetryDelay(0))
+	}
+}
+
+func TestRecordEmailDeliveryClearsBodies(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	// Sent and given up emails lose their bodies, which may hold links with
+	// working tokens; emails still to be retried keep them.
+	mock.ExpectExec(`UPDATE email_queue SET status = \$1, attempts = \$2, sentAt = NOW\(\), lastError = NULL, textBody = NULL, htmlBody = NULL WHERE id = \$3`).
+		WithArgs(emailStatusSent, 1, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE email_queue SET status = \$1, attempts = \$2, lastError = \$3, nextAttemptAt = .* WHERE id = \$5`).
+		WithArgs(emailStatusPending, 2, "timeout", emailRetryDelay(2).Seconds(), 8).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE email_queue SET status = \$1, attempts = \$2, lastError = \$3, textBody = NULL, htmlBody = NULL WHERE id = \$4`).
+		WithArgs(emailStatusFailed, maxEmailAttempts, "timeout", 9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := recordEmailDelivery(db, 7, 1, nil); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := recordEmailDelivery(db, 8, 2, errors.New("timeout")); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := recordEmailDelivery(db, 9, maxEmailAttempts, errors.New("timeout")); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSentEmailStatusLabel(t *testing.T) {
+	for status, label := range map[string]string{emailStatusPending: "En attente", emailStatusSent: "Envoyé", emailStatusFailed: "Échec"} {
+		if got := (SentEmail{Status: status}).StatusLabel(); got != label {
+			t.Errorf("StatusLabel(%s) = %q, expected %q", status, got, label)
+		}
+	}
+}
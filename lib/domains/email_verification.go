@@ -0,0 +1,288 @@
+package domains
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+const (
+	emailVerificationPurpose  = "email-verification"
+	emailVerificationLifetime = 48 * time.Hour
+	// emailVerificationThrottle is the delay before another verification
+	// link can be sent for the same account.
+	emailVerificationThrottle = 2 * time.Minute
+)
+
+var (
+	ErrEmailTaken = errors.New("email already used by another account")
+	// ErrEmailVerificationOutdated is returned for a link verifying an address
+	// the account no longer uses nor asked to switch to.
+	ErrEmailVerificationOutdated = errors.New("verification link no longer matches the account")
+)
+
+// VerifyEmailHandler verifies the address of the link sent by
+// sendEmailVerification: the address of the account, or the new one it asked
+// to switch to. It works without a session, as links are often opened in
+// another browser.
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	userID, email, err := parseEmailVerificationToken(r.URL.Query().Get("token"), time.Now())
+	if err != nil {
+		http.Redirect(w, r, "/login#verification_expired", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = confirmEmail(db, userID, email)
+	if errors.Is(err, ErrEmailVerificationOutdated) {
+		http.Redirect(w, r, "/login#verification_expired", http.StatusFound)
+		return
+	}
+	if errors.Is(err, ErrEmailTaken) {
+		http.Redirect(w, r, "/login#email_taken", http.StatusFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if sessionUserID, ok := GetAuthenticatedUserID(w, r); ok && sessionUserID == userID {
+		http.Redirect(w, r, "/account#email_verified", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/login#email_verified", http.StatusFound)
+}
+
+// ResendEmailVerificationHandler sends the verification link of the address
+// waiting for it again.
+func ResendEmailVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var email, pendingEmail string
+	var verified bool
+	err = db.QueryRow("SELECT COALESCE(email, ''), COALESCE(pendingEmail, ''), emailVerifiedAt IS NOT NULL FROM users WHERE id = $1", userID).Scan(&email, &pendingEmail, &verified)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	target := pendingEmail
+	if target == "" {
+		if verified {
+			http.Redirect(w, r, "/account#email_verified", http.StatusFound)
+			return
+		}
+		target = email
+	}
+
+	if !checkEmailVerificationThrottle(w, r, db, userID) {
+		return
+	}
+
+	if err := sendEmailVerification(db, userID, target, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/account#verification_sent", http.StatusFound)
+}
+
+// ChangeEmailHandler starts switching the account to a new address, which
+// replaces the current one once verified. The current password is asked
+// again, and the current address is told about the change.
+func ChangeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	newEmail := strings.TrimSpace(r.FormValue("email"))
+	if !isValidEmail(newEmail) {
+		http.Redirect(w, r, "/account#invalid_email", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var email, storedPassword string
+	err = db.QueryRow("SELECT COALESCE(email, ''), password FROM users WHERE id = $1", userID).Scan(&email, &storedPassword)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !checkStoredPassword(r.FormValue("password"), storedPassword) {
+		http.Redirect(w, r, "/account#invalid_password", http.StatusFound)
+		return
+	}
+
+	if newEmail == email {
+		http.Redirect(w, r, "/account#same_email", http.StatusFound)
+		return
+	}
+
+	taken, err := isEmailTaken(db, newEmail, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		http.Redirect(w, r, "/account#email_taken", http.StatusFound)
+		return
+	}
+
+	if !checkEmailVerificationThrottle(w, r, db, userID) {
+		return
+	}
+
+	if _, err := db.Exec("UPDATE users SET pendingEmail = $1 WHERE id = $2", newEmail, userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := sendEmailVerification(db, userID, newEmail, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := enqueueEmail(db, userID, email, "email-change-notice", struct{ NewEmail string }{newEmail}); err != nil {
+		log.Printf("Error notifying email change: %v", err)
+	}
+
+	http.Redirect(w, r, "/account#email_change_sent", http.StatusFound)
+}
+
+// checkEmailVerificationThrottle redirects to the account page when a
+// verification link was sent too recently.
+func checkEmailVerificationThrottle(w http.ResponseWriter, r *http.Request, db *sql.DB, userID string) bool {
+	var recent bool
+	err := db.QueryRow("SELECT COALESCE(verificationSentAt > NOW() - make_interval(secs => $1), FALSE) FROM users WHERE id = $2", emailVerificationThrottle.Seconds(), userID).Scan(&recent)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if recent {
+		http.Redirect(w, r, "/account#verification_throttled", http.StatusFound)
+		return false
+	}
+	return true
+}
+
+// sendEmailVerification emails a link verifying email for userID.
+func sendEmailVerification(db *sql.DB, userID, email string, now time.Time) error {
+	token, err := helpers.SignToken(emailVerificationPurpose, userID+":"+email, now.Add(emailVerificationLifetime))
+	if err != nil {
+		return fmt.Errorf("error generating email verification token: %w", err)
+	}
+
+	if err := enqueueEmail(db, userID, email, "email-verification", struct{ Link string }{os.Getenv("DOMAIN") + emailVerificationPath(token)}); err != nil {
+		return err
+	}
+
+	_, err = db.Exec("UPDATE users SET verificationSentAt = NOW() WHERE id = $1", userID)
+	return err
+}
+
+// parseEmailVerificationToken returns the user and the address a
+// verification token was issued for.
+func parseEmailVerificationToken(token string, now time.Time) (string, string, error) {
+	subject, err := helpers.VerifySignedToken(emailVerificationPurpose, token, now)
+	if err != nil {
+		return "", "", err
+	}
+	userID, email, ok := strings.Cut(subject, ":")
+	if !ok || userID == "" || email == "" {
+		return "", "", helpers.ErrInvalidSignedToken
+	}
+	return userID, email, nil
+}
+
+// confirmEmail marks email as verified for userID: the pending address
+// replaces the current one, or the current one is verified. Verifying an
+// address twice is harmless.
+func confirmEmail(db *sql.DB, userID, email string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var current, pending string
+	err = tx.QueryRow("SELECT COALESCE(email, ''), COALESCE(pendingEmail, '') FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&current, &pending)
+	if err == sql.ErrNoRows {
+		return ErrEmailVerificationOutdated
+	}
+	if err != nil {
+		return err
+	}
+
+	switch email {
+	case pending:
+		var taken int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE email = $1 AND id <> $2", email, userID).Scan(&taken); err != nil {
+			return err
+		}
+		if taken > 0 {
+			return ErrEmailTaken
+		}
+		_, err = tx.Exec("UPDATE users SET email = pendingEmail, pendingEmail = NULL, emailVerifiedAt = NOW() WHERE id = $1", userID)
+	case current:
+		_, err = tx.Exec("UPDATE users SET emailVerifiedAt = COALESCE(emailVerifiedAt, NOW()) WHERE id = $1", userID)
+	default:
+		return ErrEmailVerificationOutdated
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func isEmailTaken(db *sql.DB, email, userID string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM users WHERE email = $1 AND id <> $2", email, userID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// isEmailVerified reports whether the current address of the user has been
+// verified.
+func isEmailVerified(db *sql.DB, userID string) (bool, error) {
+	var verified bool
+	err := db.QueryRow("SELECT emailVerifiedAt IS NOT NULL FROM users WHERE id = $1", userID).Scan(&verified)
+	return verified, err
+}
+
+func emailVerificationPath(token string) string {
+	return "/verify-email?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,49 @@
+package domains
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+func TestParseEmailVerificationToken(t *testing.T) {
+	now := time.Now()
+	token, err := helpers.SignToken(emailVerificationPurpose, "42:jean+copro@example.com", now.Add(emailVerificationLifetime))
+	if err != nil {
+		t.Fatalf("SignToken failed: %v", err)
+	}
+
+	path, err := url.Parse(emailVerificationPath(token))
+	if err != nil || path.Path != "/verify-email" {
+		t.Fatalf("Unexpected path %q (%v)", path, err)
+	}
+
+	userID, email, err := parseEmailVerificationToken(path.Query().Get("token"), now)
+	if err != nil || userID != "42" || email != "jean+copro@example.com" {
+		t.Errorf("Unexpected result %q, %q (%v)", userID, email, err)
+	}
+
+	if _, _, err := parseEmailVerificationToken(token, now.Add(emailVerificationLifetime+time.Second)); err == nil {
+		t.Error("Expected an expired token to be rejected")
+	}
+
+	// A password reset token, whose subject is a bare user id, must not
+	// verify anything.
+	reset, err := helpers.SignToken(passwordResetPurpose, "42", now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("SignToken failed: %v", err)
+	}
+	if _, _, err := parseEmailVerificationToken(reset, now); err == nil {
+		t.Error("Expected a token of another purpose to be rejected")
+	}
+
+	noEmail, err := helpers.SignToken(emailVerificationPurpose, "42", now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("SignToken failed: %v", err)
+	}
+	if _, _, err := parseEmailVerificationToken(noEmail, now); err == nil {
+		t.Error("Expected a token without address to be rejected")
+	}
+}
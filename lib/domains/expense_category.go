@@ -0,0 +1,165 @@
+package domains
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// ExpenseCategory is an expense account of class 6 of the chart of accounts
+// of copropriétés (décret n° 2005-240), such as 615 for repairs.
+type ExpenseCategory struct {
+	Code  string
+	Label string
+}
+
+// ExpenseCategoryGroup gathers the accounts of a two-digit chapter of class
+// 6, for the option groups of the bill form.
+type ExpenseCategoryGroup struct {
+	Label      string
+	Categories []ExpenseCategory
+}
+
+var expenseCategoryGroups = []ExpenseCategoryGroup{
+	{"60 - Achats de matières et fournitures", []ExpenseCategory{
+		{"601", "Eau"},
+		{"602", "Électricité"},
+		{"603", "Chauffage, énergie et combustibles"},
+		{"604", "Achats de produits d'entretien et petits équipements"},
+		{"605", "Matériel"},
+		{"606", "Fournitures"},
+	}},
+	{"61 - Services extérieurs", []ExpenseCategory{
+		{"611", "Nettoyage des locaux"},
+		{"612", "Locations immobilières"},
+		{"613", "Locations mobilières"},
+		{"614", "Contrats de maintenance"},
+		{"615", "Entretien et petites réparations"},
+		{"616", "Primes d'assurances"},
+	}},
+	{"62 - Frais d'administration et honoraires", []ExpenseCategory{
+		{"621", "Rémunérations du syndic sur gestion copropriété"},
+		{"622", "Autres honoraires du syndic"},
+		{"623", "Rémunérations de tiers intervenants"},
+		{"624", "Frais du conseil syndical"},
+	}},
+	{"63 - Impôts, taxes et versements assimilés", []ExpenseCategory{
+		{"632", "Taxe foncière"},
+		{"633", "Taxe sur les bureaux"},
+		{"634", "Taxe de balayage"},
+		{"635", "Autres impôts et taxes"},
+	}},
+	{"64 - Frais de personnel", []ExpenseCategory{
+		{"641", "Salaires"},
+		{"642", "Charges sociales et organismes sociaux"},
+		{"643", "Taxe sur les salaires"},
+		{"644", "Autres frais de personnel"},
+	}},
+	{"66 - Charges financières", []ExpenseCategory{
+		{"661", "Remboursement d'annuités d'emprunt"},
+		{"662", "Autres charges financières et agios"},
+	}},
+	{"67 - Charges pour travaux et opérations exceptionnelles", []ExpenseCategory{
+		{"671", "Travaux décidés par l'assemblée générale"},
+		{"672", "Travaux urgents"},
+		{"673", "Études techniques, diagnostic, consultation"},
+		{"677", "Pertes sur créances irrécouvrables"},
+		{"678", "Charges exceptionnelles"},
+	}},
+}
+
+// CategoryTotal is what was spent on an expense category over a period. The
+// zero category gathers the bills without one.
+type CategoryTotal struct {
+	Category ExpenseCategory
+	Bills    int
+	Amount   Money
+	// Share of the total of the bills, in percent.
+	Share float64
+}
+
+// getExpenseCategory returns the category of an account code.
+func getExpenseCategory(code string) (ExpenseCategory, bool) {
+	for _, group := range expenseCategoryGroups {
+		for _, category := range group.Categories {
+			if category.Code == code {
+				return category, true
+			}
+		}
+	}
+	return ExpenseCategory{}, false
+}
+
+// CategoryLabel returns the expense category of the bill, e.g. "615 -
+// Entretien et petites réparations", or an empty string without one.
+func (bill Bill) CategoryLabel() string {
+	category, ok := getExpenseCategory(bill.Category)
+	if !ok {
+		return ""
+	}
+	return category.Code + " - " + category.Label
+}
+
+// CategoryTotals returns the spending per expense category over bills, in
+// the order of the chart of accounts. Bills without a category come last.
+func CategoryTotals(bills []Bill) []CategoryTotal {
+	totals := make(map[string]*CategoryTotal)
+	var total Money
+	for _, bill := range bills {
+		category, _ := getExpenseCategory(bill.Category)
+		categoryTotal, ok := totals[category.Code]
+		if !ok {
+			categoryTotal = &CategoryTotal{Category: category}
+			totals[category.Code] = categoryTotal
+		}
+		categoryTotal.Bills++
+		categoryTotal.Amount += bill.Amount
+		total += bill.Amount
+	}
+
+	result := make([]CategoryTotal, 0, len(totals))
+	for _, categoryTotal := range totals {
+		if total != 0 {
+			categoryTotal.Share = float64(categoryTotal.Amount) * 100 / float64(total)
+		}
+		result = append(result, *categoryTotal)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if (result[i].Category.Code == "") != (result[j].Category.Code == "") {
+			return result[j].Category.Code == ""
+		}
+		return result[i].Category.Code < result[j].Category.Code
+	})
+	return result
+}
+
+// Label returns the label of the category of the total.
+func (total CategoryTotal) Label() string {
+	if total.Category.Code == "" {
+		return "Non catégorisé"
+	}
+	return total.Category.Code + " - " + total.Category.Label
+}
+
+// ShareLabel returns the share of the category for display, e.g. "12.50%".
+func (total CategoryTotal) ShareLabel() string {
+	return fmt.Sprintf("%.2f%%", total.Share)
+}
+
+// parseExpenseCategory reads the category form value of a bill. An empty
+// value leaves the bill uncategorized.
+func parseExpenseCategory(r *http.Request) (string, error) {
+	return checkExpenseCategory(r.FormValue("category"))
+}
+
+// checkExpenseCategory ensures code is an account of the chart of accounts.
+// An empty code leaves the bill uncategorized.
+func checkExpenseCategory(code string) (string, error) {
+	if code == "" {
+		return "", nil
+	}
+	if _, ok := getExpenseCategory(code); !ok {
+		return "", fmt.Errorf("invalid category value")
+	}
+	return code, nil
+}
@@ -0,0 +1,57 @@
+package domains
+
+import "testing"
+
+func TestCategoryTotals(t *testing.T) {
+	bills := []Bill{
+		{Amount: 30000, Category: "615"},
+		{Amount: 10000},
+		{Amount: 40000, Category: "603"},
+		{Amount: 10000, Category: "999"},
+		{Amount: 10000, Category: "615"},
+	}
+
+	totals := CategoryTotals(bills)
+	if len(totals) != 3 {
+		t.Fatalf("Expected 3 totals, got %+v", totals)
+	}
+
+	expected := []struct {
+		code   string
+		bills  int
+		amount Money
+		share  string
+	}{
+		{"603", 1, 40000, "40.00%"},
+		{"615", 2, 40000, "40.00%"},
+		{"", 2, 20000, "20.00%"},
+	}
+	for i, e := range expected {
+		total := totals[i]
+		if total.Category.Code != e.code || total.Bills != e.bills || total.Amount != e.amount || total.ShareLabel() != e.share {
+			t.Errorf("Total %d: expected %+v, got %+v (%s)", i, e, total, total.ShareLabel())
+		}
+	}
+	if label := totals[2].Label(); label != "Non catégorisé" {
+		t.Errorf("Expected unknown and missing categories to be gathered, got %q", label)
+	}
+}
+
+func TestExpenseCategoriesAreClassSixAccounts(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, group := range expenseCategoryGroups {
+		for _, category := range group.Categories {
+			if len(category.Code) != 3 || category.Code[0] != '6' || group.Label[:2] != category.Code[:2] {
+				t.Errorf("Account %s does not belong to chapter %q", category.Code, group.Label)
+			}
+			if seen[category.Code] {
+				t.Errorf("Account %s is listed twice", category.Code)
+			}
+			seen[category.Code] = true
+		}
+	}
+
+	if label := (Bill{Category: "616"}).CategoryLabel(); label != "616 - Primes d'assurances" {
+		t.Errorf("Unexpected label %q", label)
+	}
+}
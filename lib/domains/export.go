@@ -1,7 +1,10 @@
 package domains
 
 import (
+	"archive/zip"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"time"
@@ -11,38 +14,216 @@ import (
 	"github.com/xuri/excelize/v2"
 )
 
-func ExportPDFHandler(w http.ResponseWriter, r *http.Request) {
+// loadExportData loads the dashboard data of the current building for the
+// period in the query string. Exports are a premium feature; format names
+// the export in the error sent to free users. It reports false once the
+// error has been sent to the client.
+func loadExportData(w http.ResponseWriter, r *http.Request, format string) (DashboardData, bool) {
 	userID, ok := GetAuthenticatedUserID(w, r)
 	if !ok {
 		http.Redirect(w, r, "/logout", http.StatusFound)
-		return
+		return DashboardData{}, false
 	}
 
 	db, err := helpers.GetConnectionManager().GetConnection("postgres")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return DashboardData{}, false
 	}
 
 	isPremium, err := helpers.IsUserPremium(db, userID)
 	if err != nil {
 		log.Printf("Error checking premium status: %v", err)
 		http.Error(w, "Could not verify subscription status", http.StatusInternalServerError)
-		return
+		return DashboardData{}, false
 	}
 
 	if !isPremium {
-		http.Error(w, "Premium subscription required for PDF export", http.StatusForbidden)
-		return
+		http.Error(w, fmt.Sprintf("Premium subscription required for %s export", format), http.StatusForbidden)
+		return DashboardData{}, false
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		log.Printf("Error resolving copropriete: %v", err)
+		http.Error(w, "Failed to load data", http.StatusInternalServerError)
+		return DashboardData{}, false
 	}
 
-	data, err := getDashboardData(userID)
+	fiscalYearStart, err := getFiscalYearStart(db, coproprieteID)
+	if err != nil {
+		log.Printf("Error resolving copropriete: %v", err)
+		http.Error(w, "Failed to load data", http.StatusInternalServerError)
+		return DashboardData{}, false
+	}
+
+	period, err := ParsePeriod(r.URL.Query(), fiscalYearStart, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return DashboardData{}, false
+	}
+
+	data, err := getDashboardData(userID, coproprieteID, period)
 	if err != nil {
 		log.Printf("Error getting dashboard data: %v", err)
 		http.Error(w, "Failed to load data", http.StatusInternalServerError)
+		return DashboardData{}, false
+	}
+
+	data.SupplierID, err = parseSupplierFilter(r, data.Suppliers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return DashboardData{}, false
+	}
+
+	return data, true
+}
+
+func ExportPDFHandler(w http.ResponseWriter, r *http.Request) {
+	data, ok := loadExportData(w, r, "PDF")
+	if !ok {
+		return
+	}
+
+	pdf := newReportPDF(data)
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.pdf", reportFilename()))
+
+	if err := pdf.Output(w); err != nil {
+		log.Printf("Error generating PDF: %v", err)
+		http.Error(w, "Failed to generate PDF", http.StatusInternalServerError)
 		return
 	}
+}
 
+func ExportExcelHandler(w http.ResponseWriter, r *http.Request) {
+	data, ok := loadExportData(w, r, "Excel")
+	if !ok {
+		return
+	}
+
+	f := newReportExcel(data)
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Printf("Error closing Excel file: %v", err)
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.xlsx", reportFilename()))
+
+	if err := f.Write(w); err != nil {
+		log.Printf("Error generating Excel: %v", err)
+		http.Error(w, "Failed to generate Excel", http.StatusInternalServerError)
+		return
+	}
+}
+
+// ExportArchiveHandler sends a ZIP archive with the PDF and Excel reports
+// along with the supporting documents of the bills and provisions of the
+// period, ready to be handed over at the general assembly.
+func ExportArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	data, ok := loadExportData(w, r, "archive")
+	if !ok {
+		return
+	}
+
+	store, err := helpers.GetBlobStore()
+	if err != nil {
+		log.Printf("Error opening blob store: %v", err)
+		http.Error(w, "Attachments are unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.zip", reportFilename()))
+
+	if err := writeReportArchive(w, data, store); err != nil {
+		log.Printf("Error generating ZIP: %v", err)
+		http.Error(w, "Failed to generate ZIP", http.StatusInternalServerError)
+	}
+}
+
+func reportFilename() string {
+	return fmt.Sprintf("tanzia-rapport-%s", time.Now().Format("2006-01-02"))
+}
+
+// reportAttachments returns the attachments of the bills and provisions of
+// the report.
+func reportAttachments(data DashboardData) []Attachment {
+	var attachments []Attachment
+	for _, bill := range data.Bills {
+		attachments = append(attachments, bill.Attachments...)
+	}
+	for _, provision := range data.Provisions {
+		attachments = append(attachments, provision.Attachments...)
+	}
+	return attachments
+}
+
+// attachmentExpenseLabel returns the label of the bill or provision the
+// attachment belongs to.
+func attachmentExpenseLabel(data DashboardData, attachment Attachment) string {
+	for _, bill := range data.Bills {
+		if attachment.BillID == bill.ID {
+			return bill.Label
+		}
+	}
+	for _, provision := range data.Provisions {
+		if attachment.ProvisionID == provision.ID {
+			return "Provision : " + provision.Label
+		}
+	}
+	return ""
+}
+
+func writeReportArchive(w io.Writer, data DashboardData, store helpers.BlobStore) error {
+	archive := zip.NewWriter(w)
+
+	file, err := archive.Create(reportFilename() + ".pdf")
+	if err != nil {
+		return err
+	}
+	if err := newReportPDF(data).Output(file); err != nil {
+		return err
+	}
+
+	file, err = archive.Create(reportFilename() + ".xlsx")
+	if err != nil {
+		return err
+	}
+	f := newReportExcel(data)
+	defer func() { _ = f.Close() }()
+	if err := f.Write(file); err != nil {
+		return err
+	}
+
+	for _, attachment := range reportAttachments(data) {
+		blob, err := store.Get(attachment.StorageKey)
+		if errors.Is(err, helpers.ErrBlobNotFound) {
+			log.Printf("Attachment %d is missing from the blob store", attachment.ID)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		file, err := archive.Create(attachment.ArchivePath())
+		if err == nil {
+			_, err = io.Copy(file, blob)
+		}
+		_ = blob.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// newReportPDF renders the report of the building over the period of data.
+func newReportPDF(data DashboardData) *fpdf.Fpdf {
 	pdf := fpdf.New("P", "mm", "A4", "")
 	pdf.SetMargins(15, 15, 15)
 	pdf.AddPage()
@@ -52,6 +233,10 @@ func ExportPDFHandler(w http.ResponseWriter, r *http.Request) {
 	pdf.Ln(15)
 
 	pdf.SetFont("Arial", "", 10)
+	pdf.Cell(0, 6, fmt.Sprintf("Copropriété: %s", data.Copropriete.Name))
+	pdf.Ln(6)
+	pdf.Cell(0, 6, fmt.Sprintf("Période: %s", data.Period.Label()))
+	pdf.Ln(6)
 	pdf.Cell(0, 6, fmt.Sprintf("Date: %s", time.Now().Format("02/01/2006")))
 	pdf.Ln(12)
 
@@ -62,10 +247,12 @@ func ExportPDFHandler(w http.ResponseWriter, r *http.Request) {
 
 		pdf.SetFont("Arial", "B", 9)
 		pdf.SetFillColor(220, 220, 220)
-		colWidths := []float64{80, 50, 50}
-		pdf.CellFormat(colWidths[0], 7, "Libellé", "1", 0, "L", true, 0, "")
-		pdf.CellFormat(colWidths[1], 7, "Montant", "1", 0, "R", true, 0, "")
-		pdf.CellFormat(colWidths[2], 7, "Total Tantièmes", "1", 1, "R", true, 0, "")
+		colWidths := []float64{22, 58, 35, 40, 25}
+		pdf.CellFormat(colWidths[0], 7, "Date", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[1], 7, "Libellé", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[2], 7, "Montant", "1", 0, "R", true, 0, "")
+		pdf.CellFormat(colWidths[3], 7, "Clé de répartition", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[4], 7, "Tantièmes", "1", 1, "R", true, 0, "")
 
 		pdf.SetFont("Arial", "", 9)
 		for i, provision := range data.Provisions {
@@ -75,9 +262,43 @@ func ExportPDFHandler(w http.ResponseWriter, r *http.Request) {
 			} else {
 				pdf.SetFillColor(255, 255, 255)
 			}
-			pdf.CellFormat(colWidths[0], 6, provision.Label, "1", 0, "L", fill, 0, "")
-			pdf.CellFormat(colWidths[1], 6, fmt.Sprintf("%.2f EUR", provision.Amount), "1", 0, "R", fill, 0, "")
-			pdf.CellFormat(colWidths[2], 6, fmt.Sprintf("%d", data.TotalTantiemes), "1", 1, "R", fill, 0, "")
+			pdf.CellFormat(colWidths[0], 6, provision.Date.Format("02/01/2006"), "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[1], 6, provision.Label, "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[2], 6, fmt.Sprintf("%s EUR", provision.Amount), "1", 0, "R", fill, 0, "")
+			pdf.CellFormat(colWidths[3], 6, data.Allocator.ChargeKeyName(provision.ChargeKeyID), "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[4], 6, fmt.Sprintf("%d", data.Allocator.TotalTantiemes(provision.ChargeKeyID)), "1", 1, "R", fill, 0, "")
+		}
+
+		pdf.Ln(10)
+	}
+
+	if len(data.Payments) > 0 {
+		pdf.SetFont("Arial", "B", 14)
+		pdf.Cell(0, 10, "Paiements Reçus")
+		pdf.Ln(10)
+
+		pdf.SetFont("Arial", "B", 9)
+		pdf.SetFillColor(220, 220, 220)
+		colWidths := []float64{22, 50, 30, 43, 35}
+		pdf.CellFormat(colWidths[0], 7, "Date", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[1], 7, "Copropriétaire", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[2], 7, "Moyen", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[3], 7, "Référence", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[4], 7, "Montant", "1", 1, "R", true, 0, "")
+
+		pdf.SetFont("Arial", "", 9)
+		for i, payment := range data.Payments {
+			fill := i%2 == 0
+			if fill {
+				pdf.SetFillColor(245, 245, 245)
+			} else {
+				pdf.SetFillColor(255, 255, 255)
+			}
+			pdf.CellFormat(colWidths[0], 6, payment.Date.Format("02/01/2006"), "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[1], 6, data.PersonName(payment.PersonID), "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[2], 6, payment.MethodLabel(), "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[3], 6, payment.Reference, "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[4], 6, fmt.Sprintf("%s EUR", payment.Amount), "1", 1, "R", fill, 0, "")
 		}
 
 		pdf.Ln(10)
@@ -90,10 +311,12 @@ func ExportPDFHandler(w http.ResponseWriter, r *http.Request) {
 
 		pdf.SetFont("Arial", "B", 9)
 		pdf.SetFillColor(220, 220, 220)
-		colWidths := []float64{80, 50, 50}
-		pdf.CellFormat(colWidths[0], 7, "Libellé", "1", 0, "L", true, 0, "")
-		pdf.CellFormat(colWidths[1], 7, "Montant", "1", 0, "R", true, 0, "")
-		pdf.CellFormat(colWidths[2], 7, "Total Tantièmes", "1", 1, "R", true, 0, "")
+		colWidths := []float64{22, 58, 35, 40, 25}
+		pdf.CellFormat(colWidths[0], 7, "Date", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[1], 7, "Libellé", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[2], 7, "Montant", "1", 0, "R", true, 0, "")
+		pdf.CellFormat(colWidths[3], 7, "Clé de répartition", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[4], 7, "Tantièmes", "1", 1, "R", true, 0, "")
 
 		pdf.SetFont("Arial", "", 9)
 		for i, bill := range data.Bills {
@@ -103,9 +326,71 @@ func ExportPDFHandler(w http.ResponseWriter, r *http.Request) {
 			} else {
 				pdf.SetFillColor(255, 255, 255)
 			}
-			pdf.CellFormat(colWidths[0], 6, bill.Label, "1", 0, "L", fill, 0, "")
-			pdf.CellFormat(colWidths[1], 6, fmt.Sprintf("%.2f EUR", bill.Amount), "1", 0, "R", fill, 0, "")
-			pdf.CellFormat(colWidths[2], 6, fmt.Sprintf("%d", data.TotalTantiemes), "1", 1, "R", fill, 0, "")
+			pdf.CellFormat(colWidths[0], 6, bill.Date.Format("02/01/2006"), "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[1], 6, bill.Label, "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[2], 6, fmt.Sprintf("%s EUR", bill.Amount), "1", 0, "R", fill, 0, "")
+			pdf.CellFormat(colWidths[3], 6, data.Allocator.ChargeKeyName(bill.ChargeKeyID), "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[4], 6, fmt.Sprintf("%d", data.Allocator.TotalTantiemes(bill.ChargeKeyID)), "1", 1, "R", fill, 0, "")
+		}
+
+		pdf.Ln(10)
+	}
+
+	if len(data.CategoryTotals) > 0 {
+		pdf.SetFont("Arial", "B", 14)
+		pdf.Cell(0, 10, "Répartition par catégorie")
+		pdf.Ln(10)
+
+		pdf.SetFont("Arial", "B", 9)
+		pdf.SetFillColor(220, 220, 220)
+		colWidths := []float64{100, 20, 25, 35}
+		pdf.CellFormat(colWidths[0], 7, "Catégorie", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[1], 7, "Factures", "1", 0, "R", true, 0, "")
+		pdf.CellFormat(colWidths[2], 7, "Part (%)", "1", 0, "R", true, 0, "")
+		pdf.CellFormat(colWidths[3], 7, "Montant", "1", 1, "R", true, 0, "")
+
+		pdf.SetFont("Arial", "", 9)
+		for i, total := range data.CategoryTotals {
+			fill := i%2 == 0
+			if fill {
+				pdf.SetFillColor(245, 245, 245)
+			} else {
+				pdf.SetFillColor(255, 255, 255)
+			}
+			pdf.CellFormat(colWidths[0], 6, total.Label(), "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[1], 6, fmt.Sprintf("%d", total.Bills), "1", 0, "R", fill, 0, "")
+			pdf.CellFormat(colWidths[2], 6, total.ShareLabel(), "1", 0, "R", fill, 0, "")
+			pdf.CellFormat(colWidths[3], 6, fmt.Sprintf("%s EUR", total.Amount), "1", 1, "R", fill, 0, "")
+		}
+
+		pdf.Ln(10)
+	}
+
+	if attachments := reportAttachments(data); len(attachments) > 0 {
+		pdf.SetFont("Arial", "B", 14)
+		pdf.Cell(0, 10, "Justificatifs")
+		pdf.Ln(10)
+
+		pdf.SetFont("Arial", "B", 9)
+		pdf.SetFillColor(220, 220, 220)
+		colWidths := []float64{22, 50, 88, 20}
+		pdf.CellFormat(colWidths[0], 7, "Date", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[1], 7, "Dépense", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[2], 7, "Fichier", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[3], 7, "Taille", "1", 1, "R", true, 0, "")
+
+		pdf.SetFont("Arial", "", 9)
+		for i, attachment := range attachments {
+			fill := i%2 == 0
+			if fill {
+				pdf.SetFillColor(245, 245, 245)
+			} else {
+				pdf.SetFillColor(255, 255, 255)
+			}
+			pdf.CellFormat(colWidths[0], 6, attachment.CreatedAt.Format("02/01/2006"), "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[1], 6, attachmentExpenseLabel(data, attachment), "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[2], 6, attachment.ArchivePath(), "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[3], 6, attachment.SizeLabel(), "1", 1, "R", fill, 0, "")
 		}
 
 		pdf.Ln(10)
@@ -118,11 +403,13 @@ func ExportPDFHandler(w http.ResponseWriter, r *http.Request) {
 
 		pdf.SetFont("Arial", "B", 9)
 		pdf.SetFillColor(220, 220, 220)
-		colWidths := []float64{60, 40, 40, 40}
+		colWidths := []float64{45, 22, 20, 31, 31, 31}
 		pdf.CellFormat(colWidths[0], 7, "Nom", "1", 0, "L", true, 0, "")
 		pdf.CellFormat(colWidths[1], 7, "Tantièmes", "1", 0, "R", true, 0, "")
 		pdf.CellFormat(colWidths[2], 7, "Part (%)", "1", 0, "R", true, 0, "")
-		pdf.CellFormat(colWidths[3], 7, "Solde", "1", 1, "R", true, 0, "")
+		pdf.CellFormat(colWidths[3], 7, "Solde", "1", 0, "R", true, 0, "")
+		pdf.CellFormat(colWidths[4], 7, "Payé", "1", 0, "R", true, 0, "")
+		pdf.CellFormat(colWidths[5], 7, "Reste dû", "1", 1, "R", true, 0, "")
 
 		pdf.SetFont("Arial", "", 9)
 		for i, person := range data.Persons {
@@ -137,74 +424,75 @@ func ExportPDFHandler(w http.ResponseWriter, r *http.Request) {
 			if data.TotalTantiemes > 0 {
 				percentage = float64(person.Tantieme) / float64(data.TotalTantiemes) * 100
 			}
-			balance := person.CalculateLeft(data.TotalTantiemes, data.Bills, data.Provisions)
+			balance := person.CalculateLeft(data.Allocator, data.Bills, data.Provisions)
 
 			pdf.CellFormat(colWidths[0], 6, person.Name, "1", 0, "L", fill, 0, "")
 			pdf.CellFormat(colWidths[1], 6, fmt.Sprintf("%d", person.Tantieme), "1", 0, "R", fill, 0, "")
 			pdf.CellFormat(colWidths[2], 6, fmt.Sprintf("%.2f%%", percentage), "1", 0, "R", fill, 0, "")
-			pdf.CellFormat(colWidths[3], 6, fmt.Sprintf("%.2f EUR", balance), "1", 1, "R", fill, 0, "")
+			pdf.CellFormat(colWidths[3], 6, fmt.Sprintf("%s EUR", balance), "1", 0, "R", fill, 0, "")
+			pdf.CellFormat(colWidths[4], 6, fmt.Sprintf("%s EUR", person.CalculatePaid(data.Payments)), "1", 0, "R", fill, 0, "")
+			pdf.CellFormat(colWidths[5], 6, fmt.Sprintf("%s EUR", data.PersonOutstanding(person)), "1", 1, "R", fill, 0, "")
 		}
 
 		pdf.Ln(10)
 	}
 
-	pdf.SetFont("Arial", "B", 12)
-	pdf.SetFillColor(200, 200, 200)
-	pdf.CellFormat(100, 8, "Solde Global", "1", 0, "L", true, 0, "")
-	pdf.CellFormat(80, 8, fmt.Sprintf("%.2f EUR", data.Balance), "1", 1, "R", true, 0, "")
+	if !data.WorksFund.IsEmpty() {
+		pdf.SetFont("Arial", "B", 14)
+		pdf.Cell(0, 10, "Fonds de travaux")
+		pdf.Ln(10)
 
-	pdf.Ln(20)
-	pdf.SetFont("Arial", "I", 8)
-	pdf.Cell(0, 5, fmt.Sprintf("Document généré automatiquement par Tanzia le %s", time.Now().Format("02/01/2006 15:04")))
+		pdf.SetFont("Arial", "B", 9)
+		pdf.SetFillColor(220, 220, 220)
+		colWidths := []float64{120, 60}
+		pdf.CellFormat(colWidths[0], 7, "Copropriétaire", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[1], 7, "Cotisations", "1", 1, "R", true, 0, "")
 
-	w.Header().Set("Content-Type", "application/pdf")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=tanzia-rapport-%s.pdf", time.Now().Format("2006-01-02")))
+		pdf.SetFont("Arial", "", 9)
+		for i, person := range data.Persons {
+			fill := i%2 == 0
+			if fill {
+				pdf.SetFillColor(245, 245, 245)
+			} else {
+				pdf.SetFillColor(255, 255, 255)
+			}
+			pdf.CellFormat(colWidths[0], 6, person.Name, "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[1], 6, fmt.Sprintf("%s EUR", person.CalculateWorksFund(data.Allocator, data.Provisions)), "1", 1, "R", fill, 0, "")
+		}
+		pdf.SetFont("Arial", "B", 9)
+		pdf.SetFillColor(235, 235, 235)
+		pdf.CellFormat(colWidths[0], 6, "Travaux financés par le fonds", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[1], 6, fmt.Sprintf("%s EUR", -data.WorksFund.Spent), "1", 1, "R", true, 0, "")
 
-	if err := pdf.Output(w); err != nil {
-		log.Printf("Error generating PDF: %v", err)
-		http.Error(w, "Failed to generate PDF", http.StatusInternalServerError)
-		return
+		pdf.Ln(10)
 	}
-}
 
-func ExportExcelHandler(w http.ResponseWriter, r *http.Request) {
-	userID, ok := GetAuthenticatedUserID(w, r)
-	if !ok {
-		http.Redirect(w, r, "/logout", http.StatusFound)
-		return
+	pdf.SetFont("Arial", "B", 12)
+	pdf.SetFillColor(200, 200, 200)
+	pdf.CellFormat(100, 8, "Solde Global", "1", 0, "L", true, 0, "")
+	pdf.CellFormat(80, 8, fmt.Sprintf("%s EUR", data.Balance), "1", 1, "R", true, 0, "")
+	if data.CarriedOver != 0 {
+		pdf.CellFormat(100, 8, "Report des exercices clôturés", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(80, 8, fmt.Sprintf("%s EUR", data.CarriedOver), "1", 1, "R", true, 0, "")
 	}
-
-	db, err := helpers.GetConnectionManager().GetConnection("postgres")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	pdf.CellFormat(100, 8, "Reste dû sur appels de fonds", "1", 0, "L", true, 0, "")
+	pdf.CellFormat(80, 8, fmt.Sprintf("%s EUR", data.Outstanding), "1", 1, "R", true, 0, "")
+	if !data.WorksFund.IsEmpty() {
+		pdf.CellFormat(100, 8, "Solde du fonds de travaux", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(80, 8, fmt.Sprintf("%s EUR", data.WorksFund.Balance()), "1", 1, "R", true, 0, "")
 	}
 
-	isPremium, err := helpers.IsUserPremium(db, userID)
-	if err != nil {
-		log.Printf("Error checking premium status: %v", err)
-		http.Error(w, "Could not verify subscription status", http.StatusInternalServerError)
-		return
-	}
-
-	if !isPremium {
-		http.Error(w, "Premium subscription required for Excel export", http.StatusForbidden)
-		return
-	}
+	pdf.Ln(20)
+	pdf.SetFont("Arial", "I", 8)
+	pdf.Cell(0, 5, fmt.Sprintf("Document généré automatiquement par Tanzia le %s", time.Now().Format("02/01/2006 15:04")))
 
-	data, err := getDashboardData(userID)
-	if err != nil {
-		log.Printf("Error getting dashboard data: %v", err)
-		http.Error(w, "Failed to load data", http.StatusInternalServerError)
-		return
-	}
+	return pdf
+}
 
+// newReportExcel renders the report of the building over the period of data
+// as a workbook.
+func newReportExcel(data DashboardData) *excelize.File {
 	f := excelize.NewFile()
-	defer func() {
-		if err := f.Close(); err != nil {
-			log.Printf("Error closing Excel file: %v", err)
-		}
-	}()
 
 	// Header style
 	headerStyle, _ := f.NewStyle(&excelize.Style{
@@ -249,7 +537,10 @@ func ExportExcelHandler(w http.ResponseWriter, r *http.Request) {
 	f.SetCellValue(sheetName, "B1", "Tantièmes")
 	f.SetCellValue(sheetName, "C1", "Part (%)")
 	f.SetCellValue(sheetName, "D1", "Solde (EUR)")
-	f.SetCellStyle(sheetName, "A1", "D1", headerStyle)
+	f.SetCellValue(sheetName, "E1", "Payé (EUR)")
+	f.SetCellValue(sheetName, "F1", "Reste dû (EUR)")
+	f.SetCellValue(sheetName, "G1", "Fonds de travaux (EUR)")
+	f.SetCellStyle(sheetName, "A1", "G1", headerStyle)
 
 	for i, person := range data.Persons {
 		row := i + 2
@@ -257,20 +548,24 @@ func ExportExcelHandler(w http.ResponseWriter, r *http.Request) {
 		if data.TotalTantiemes > 0 {
 			percentage = float64(person.Tantieme) / float64(data.TotalTantiemes) * 100
 		}
-		balance := person.CalculateLeft(data.TotalTantiemes, data.Bills, data.Provisions)
+		balance := person.CalculateLeft(data.Allocator, data.Bills, data.Provisions)
 
 		f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), person.Name)
 		f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), person.Tantieme)
 		f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), fmt.Sprintf("%.2f%%", percentage))
-		f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), balance)
+		f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), balance.Float64())
+		f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), person.CalculatePaid(data.Payments).Float64())
+		f.SetCellValue(sheetName, fmt.Sprintf("F%d", row), data.PersonOutstanding(person).Float64())
+		f.SetCellValue(sheetName, fmt.Sprintf("G%d", row), person.CalculateWorksFund(data.Allocator, data.Provisions).Float64())
 		f.SetCellStyle(sheetName, fmt.Sprintf("A%d", row), fmt.Sprintf("C%d", row), dataStyle)
-		f.SetCellStyle(sheetName, fmt.Sprintf("D%d", row), fmt.Sprintf("D%d", row), currencyStyle)
+		f.SetCellStyle(sheetName, fmt.Sprintf("D%d", row), fmt.Sprintf("G%d", row), currencyStyle)
 	}
 
 	f.SetColWidth(sheetName, "A", "A", 25)
 	f.SetColWidth(sheetName, "B", "B", 12)
 	f.SetColWidth(sheetName, "C", "C", 12)
-	f.SetColWidth(sheetName, "D", "D", 15)
+	f.SetColWidth(sheetName, "D", "F", 15)
+	f.SetColWidth(sheetName, "G", "G", 22)
 
 	// === Provisions Sheet ===
 	if len(data.Provisions) > 0 {
@@ -279,39 +574,193 @@ func ExportExcelHandler(w http.ResponseWriter, r *http.Request) {
 
 		f.SetCellValue(sheetName, "A1", "Libellé")
 		f.SetCellValue(sheetName, "B1", "Montant (EUR)")
-		f.SetCellStyle(sheetName, "A1", "B1", headerStyle)
+		f.SetCellValue(sheetName, "C1", "Clé de répartition")
+		f.SetCellValue(sheetName, "D1", "Date")
+		f.SetCellValue(sheetName, "E1", "Exercice")
+		f.SetCellStyle(sheetName, "A1", "E1", headerStyle)
 
 		for i, provision := range data.Provisions {
 			row := i + 2
 			f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), provision.Label)
-			f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), provision.Amount)
+			f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), provision.Amount.Float64())
+			f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), data.Allocator.ChargeKeyName(provision.ChargeKeyID))
+			f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), provision.Date.Format("02/01/2006"))
+			f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), FiscalYearLabel(provision.FiscalYear, data.Period.FiscalYearStart))
 			f.SetCellStyle(sheetName, fmt.Sprintf("A%d", row), fmt.Sprintf("A%d", row), dataStyle)
 			f.SetCellStyle(sheetName, fmt.Sprintf("B%d", row), fmt.Sprintf("B%d", row), currencyStyle)
+			f.SetCellStyle(sheetName, fmt.Sprintf("C%d", row), fmt.Sprintf("E%d", row), dataStyle)
 		}
 
 		f.SetColWidth(sheetName, "A", "A", 40)
 		f.SetColWidth(sheetName, "B", "B", 15)
+		f.SetColWidth(sheetName, "C", "C", 25)
+		f.SetColWidth(sheetName, "D", "E", 12)
+	}
+
+	// === Paiements Sheet ===
+	if len(data.Payments) > 0 {
+		sheetName = "Paiements"
+		f.NewSheet(sheetName)
+
+		f.SetCellValue(sheetName, "A1", "Date")
+		f.SetCellValue(sheetName, "B1", "Copropriétaire")
+		f.SetCellValue(sheetName, "C1", "Moyen")
+		f.SetCellValue(sheetName, "D1", "Référence")
+		f.SetCellValue(sheetName, "E1", "Montant (EUR)")
+		f.SetCellStyle(sheetName, "A1", "E1", headerStyle)
+
+		for i, payment := range data.Payments {
+			row := i + 2
+			f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), payment.Date.Format("02/01/2006"))
+			f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), data.PersonName(payment.PersonID))
+			f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), payment.MethodLabel())
+			f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), payment.Reference)
+			f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), payment.Amount.Float64())
+			f.SetCellStyle(sheetName, fmt.Sprintf("A%d", row), fmt.Sprintf("D%d", row), dataStyle)
+			f.SetCellStyle(sheetName, fmt.Sprintf("E%d", row), fmt.Sprintf("E%d", row), currencyStyle)
+		}
+
+		f.SetColWidth(sheetName, "A", "A", 12)
+		f.SetColWidth(sheetName, "B", "B", 25)
+		f.SetColWidth(sheetName, "C", "C", 15)
+		f.SetColWidth(sheetName, "D", "D", 30)
+		f.SetColWidth(sheetName, "E", "E", 15)
 	}
 
 	// === Travaux Sheet ===
-	if len(data.Bills) > 0 {
+	if bills := data.FilteredBills(); len(bills) > 0 {
 		sheetName = "Travaux"
 		f.NewSheet(sheetName)
 
 		f.SetCellValue(sheetName, "A1", "Libellé")
 		f.SetCellValue(sheetName, "B1", "Montant (EUR)")
-		f.SetCellStyle(sheetName, "A1", "B1", headerStyle)
-
-		for i, bill := range data.Bills {
+		f.SetCellValue(sheetName, "C1", "Clé de répartition")
+		f.SetCellValue(sheetName, "D1", "Date")
+		f.SetCellValue(sheetName, "E1", "Exercice")
+		f.SetCellValue(sheetName, "F1", "Fournisseur")
+		f.SetCellValue(sheetName, "G1", "Catégorie")
+		f.SetCellStyle(sheetName, "A1", "G1", headerStyle)
+
+		for i, bill := range bills {
 			row := i + 2
 			f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), bill.Label)
-			f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), bill.Amount)
+			f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), bill.Amount.Float64())
+			f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), data.Allocator.ChargeKeyName(bill.ChargeKeyID))
+			f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), bill.Date.Format("02/01/2006"))
+			f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), FiscalYearLabel(bill.FiscalYear, data.Period.FiscalYearStart))
+			f.SetCellValue(sheetName, fmt.Sprintf("F%d", row), data.SupplierName(bill.SupplierID))
+			f.SetCellValue(sheetName, fmt.Sprintf("G%d", row), bill.CategoryLabel())
 			f.SetCellStyle(sheetName, fmt.Sprintf("A%d", row), fmt.Sprintf("A%d", row), dataStyle)
 			f.SetCellStyle(sheetName, fmt.Sprintf("B%d", row), fmt.Sprintf("B%d", row), currencyStyle)
+			f.SetCellStyle(sheetName, fmt.Sprintf("C%d", row), fmt.Sprintf("G%d", row), dataStyle)
 		}
 
 		f.SetColWidth(sheetName, "A", "A", 40)
 		f.SetColWidth(sheetName, "B", "B", 15)
+		f.SetColWidth(sheetName, "C", "C", 25)
+		f.SetColWidth(sheetName, "D", "E", 12)
+		f.SetColWidth(sheetName, "F", "F", 30)
+		f.SetColWidth(sheetName, "G", "G", 45)
+	}
+
+	// === Catégories Sheet ===
+	if len(data.CategoryTotals) > 0 {
+		sheetName = "Catégories"
+		f.NewSheet(sheetName)
+
+		f.SetCellValue(sheetName, "A1", "Compte")
+		f.SetCellValue(sheetName, "B1", "Catégorie")
+		f.SetCellValue(sheetName, "C1", "Factures")
+		f.SetCellValue(sheetName, "D1", "Part (%)")
+		f.SetCellValue(sheetName, "E1", "Total (EUR)")
+		f.SetCellStyle(sheetName, "A1", "E1", headerStyle)
+
+		for i, total := range data.CategoryTotals {
+			row := i + 2
+			label := total.Category.Label
+			if total.Category.Code == "" {
+				label = total.Label()
+			}
+			f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), total.Category.Code)
+			f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), label)
+			f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), total.Bills)
+			f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), total.ShareLabel())
+			f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), total.Amount.Float64())
+			f.SetCellStyle(sheetName, fmt.Sprintf("A%d", row), fmt.Sprintf("D%d", row), dataStyle)
+			f.SetCellStyle(sheetName, fmt.Sprintf("E%d", row), fmt.Sprintf("E%d", row), currencyStyle)
+		}
+
+		f.SetColWidth(sheetName, "A", "A", 10)
+		f.SetColWidth(sheetName, "B", "B", 50)
+		f.SetColWidth(sheetName, "C", "E", 12)
+	}
+
+	// === Fournisseurs Sheet ===
+	if len(data.Suppliers) > 0 && len(data.SupplierTotals) > 0 {
+		sheetName = "Fournisseurs"
+		f.NewSheet(sheetName)
+
+		f.SetCellValue(sheetName, "A1", "Fournisseur")
+		f.SetCellValue(sheetName, "B1", "Catégorie")
+		f.SetCellValue(sheetName, "C1", "SIRET")
+		f.SetCellValue(sheetName, "D1", "IBAN")
+		f.SetCellValue(sheetName, "E1", "Contact")
+		f.SetCellValue(sheetName, "F1", "Factures")
+		f.SetCellValue(sheetName, "G1", "Total (EUR)")
+		f.SetCellStyle(sheetName, "A1", "G1", headerStyle)
+
+		for i, total := range data.SupplierTotals {
+			row := i + 2
+			name := total.Supplier.Name
+			if total.Supplier.ID == 0 {
+				name = "Sans fournisseur"
+			}
+			f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), name)
+			f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), total.Supplier.CategoryLabel())
+			f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), total.Supplier.SIRET)
+			f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), total.Supplier.IBANLabel())
+			f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), total.Supplier.Contact)
+			f.SetCellValue(sheetName, fmt.Sprintf("F%d", row), total.Bills)
+			f.SetCellValue(sheetName, fmt.Sprintf("G%d", row), total.Amount.Float64())
+			f.SetCellStyle(sheetName, fmt.Sprintf("A%d", row), fmt.Sprintf("F%d", row), dataStyle)
+			f.SetCellStyle(sheetName, fmt.Sprintf("G%d", row), fmt.Sprintf("G%d", row), currencyStyle)
+		}
+
+		f.SetColWidth(sheetName, "A", "A", 30)
+		f.SetColWidth(sheetName, "B", "B", 22)
+		f.SetColWidth(sheetName, "C", "C", 18)
+		f.SetColWidth(sheetName, "D", "D", 38)
+		f.SetColWidth(sheetName, "E", "E", 30)
+		f.SetColWidth(sheetName, "F", "G", 12)
+	}
+
+	// === Justificatifs Sheet ===
+	if attachments := reportAttachments(data); len(attachments) > 0 {
+		sheetName = "Justificatifs"
+		f.NewSheet(sheetName)
+
+		f.SetCellValue(sheetName, "A1", "Dépense")
+		f.SetCellValue(sheetName, "B1", "Fichier")
+		f.SetCellValue(sheetName, "C1", "Type")
+		f.SetCellValue(sheetName, "D1", "Taille")
+		f.SetCellValue(sheetName, "E1", "Ajouté le")
+		f.SetCellValue(sheetName, "F1", "Chemin dans l'archive")
+		f.SetCellStyle(sheetName, "A1", "F1", headerStyle)
+
+		for i, attachment := range attachments {
+			row := i + 2
+			f.SetCellValue(sheetName, fmt.Sprintf("A%d", row), attachmentExpenseLabel(data, attachment))
+			f.SetCellValue(sheetName, fmt.Sprintf("B%d", row), attachment.Filename)
+			f.SetCellValue(sheetName, fmt.Sprintf("C%d", row), attachment.ContentType)
+			f.SetCellValue(sheetName, fmt.Sprintf("D%d", row), attachment.SizeLabel())
+			f.SetCellValue(sheetName, fmt.Sprintf("E%d", row), attachment.CreatedAt.Format("02/01/2006"))
+			f.SetCellValue(sheetName, fmt.Sprintf("F%d", row), attachment.ArchivePath())
+			f.SetCellStyle(sheetName, fmt.Sprintf("A%d", row), fmt.Sprintf("F%d", row), dataStyle)
+		}
+
+		f.SetColWidth(sheetName, "A", "B", 35)
+		f.SetColWidth(sheetName, "C", "E", 15)
+		f.SetColWidth(sheetName, "F", "F", 45)
 	}
 
 	// === Résumé Sheet ===
@@ -321,23 +770,36 @@ func ExportExcelHandler(w http.ResponseWriter, r *http.Request) {
 	f.SetCellValue(sheetName, "A1", "Rapport Tanzia")
 	f.SetCellValue(sheetName, "A2", "Date")
 	f.SetCellValue(sheetName, "B2", time.Now().Format("02/01/2006"))
-	f.SetCellValue(sheetName, "A4", "Total Tantièmes")
-	f.SetCellValue(sheetName, "B4", data.TotalTantiemes)
-	f.SetCellValue(sheetName, "A5", "Nombre de copropriétaires")
-	f.SetCellValue(sheetName, "B5", len(data.Persons))
-	f.SetCellValue(sheetName, "A6", "Solde Global (EUR)")
-	f.SetCellValue(sheetName, "B6", data.Balance)
-	f.SetCellStyle(sheetName, "B6", "B6", currencyStyle)
+	f.SetCellValue(sheetName, "A3", "Copropriété")
+	f.SetCellValue(sheetName, "B3", data.Copropriete.Name)
+	f.SetCellValue(sheetName, "A4", "Période")
+	f.SetCellValue(sheetName, "B4", data.Period.Label())
+	f.SetCellValue(sheetName, "A5", "Total Tantièmes")
+	f.SetCellValue(sheetName, "B5", data.TotalTantiemes)
+	f.SetCellValue(sheetName, "A6", "Nombre de copropriétaires")
+	f.SetCellValue(sheetName, "B6", len(data.Persons))
+	f.SetCellValue(sheetName, "A7", "Solde Global (EUR)")
+	f.SetCellValue(sheetName, "B7", data.Balance.Float64())
+	f.SetCellValue(sheetName, "A8", "Total payé (EUR)")
+	f.SetCellValue(sheetName, "B8", data.Paid.Float64())
+	f.SetCellValue(sheetName, "A9", "Report des exercices clôturés (EUR)")
+	f.SetCellValue(sheetName, "B9", data.CarriedOver.Float64())
+	f.SetCellValue(sheetName, "A10", "Reste dû (EUR)")
+	f.SetCellValue(sheetName, "B10", data.Outstanding.Float64())
+	f.SetCellValue(sheetName, "A11", "Fonds de travaux - cotisations (EUR)")
+	f.SetCellValue(sheetName, "B11", data.WorksFund.Contributions.Float64())
+	f.SetCellValue(sheetName, "A12", "Fonds de travaux - travaux financés (EUR)")
+	f.SetCellValue(sheetName, "B12", data.WorksFund.Spent.Float64())
+	f.SetCellValue(sheetName, "A13", "Fonds de travaux - solde (EUR)")
+	f.SetCellValue(sheetName, "B13", data.WorksFund.Balance().Float64())
+	f.SetCellStyle(sheetName, "B7", "B13", currencyStyle)
+	if data.SupplierID != 0 {
+		f.SetCellValue(sheetName, "A14", "Dépenses du fournisseur")
+		f.SetCellValue(sheetName, "B14", data.SupplierName(data.SupplierID))
+	}
 
 	f.SetColWidth(sheetName, "A", "A", 25)
 	f.SetColWidth(sheetName, "B", "B", 15)
 
-	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=tanzia-rapport-%s.xlsx", time.Now().Format("2006-01-02")))
-
-	if err := f.Write(w); err != nil {
-		log.Printf("Error generating Excel: %v", err)
-		http.Error(w, "Failed to generate Excel", http.StatusInternalServerError)
-		return
-	}
+	return f
 }
@@ -0,0 +1,226 @@
+package domains
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+
+	"github.com/go-session/session/v3"
+)
+
+const (
+	loginLinkPurpose  = "login-link"
+	loginLinkLifetime = 15 * time.Minute
+	// loginLinkThrottle is the delay before another login link can be sent
+	// to the same account.
+	loginLinkThrottle = 2 * time.Minute
+)
+
+// LoginLinkRequestHandler emails a single-use login link to the owner of an
+// email address, for users who would rather not type a password. As with
+// ForgotPasswordHandler, the answer does not tell whether the address has an
+// account. Requests are refused while logging in with the address is
+// locked, and count towards a lockout of their own.
+func LoginLinkRequestHandler(w http.ResponseWriter, r *http.Request) {
+	email := strings.TrimSpace(r.FormValue("email"))
+	if !isValidEmail(email) {
+		http.Redirect(w, r, "/login/link#invalid", http.StatusFound)
+		return
+	}
+
+	rateLimiter := helpers.GetRateLimiter()
+	for _, limiterKey := range []string{email, loginLinkLimiterKey(email)} {
+		if rateLimiter.IsLocked(limiterKey) {
+			remaining := rateLimiter.GetLockoutRemaining(limiterKey)
+			minutes := int(remaining.Minutes()) + 1
+			http.Redirect(w, r, fmt.Sprintf("/login/link#locked-%d", minutes), http.StatusFound)
+			return
+		}
+	}
+	rateLimiter.RecordFailedAttempt(loginLinkLimiterKey(email))
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var userID string
+	err = db.QueryRow("SELECT id FROM users WHERE email = $1", email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		http.Redirect(w, r, "/login/link#sent", http.StatusFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var recent int
+	err = db.QueryRow("SELECT COUNT(*) FROM login_links WHERE userId = $1 AND createdAt > NOW() - make_interval(secs => $2)", userID, loginLinkThrottle.Seconds()).Scan(&recent)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if recent > 0 {
+		http.Redirect(w, r, "/login/link#sent", http.StatusFound)
+		return
+	}
+
+	token, err := issueLoginLink(db, userID, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = enqueueEmail(db, userID, email, "login-link", struct{ Link string }{os.Getenv("DOMAIN") + loginLinkPath(token)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/login/link#sent", http.StatusFound)
+}
+
+// LoginLinkHandler logs in the owner of a login link, which is consumed. The
+// link is opened on a page posting it here, so that mail scanners following
+// links do not use it up. Locked accounts stay locked, and users who enabled
+// two-factor authentication are still asked for a code.
+func LoginLinkHandler(w http.ResponseWriter, r *http.Request) {
+	store, err := session.Start(context.Background(), w, r)
+	if err != nil {
+		log.Printf("Session error: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	token := r.FormValue("token")
+	userID, err := helpers.VerifySignedToken(loginLinkPurpose, token, time.Now())
+	if err != nil {
+		http.Redirect(w, r, "/login/link#expired", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var email string
+	if err := db.QueryRow("SELECT COALESCE(email, '') FROM users WHERE id = $1", userID).Scan(&email); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The link is kept while the account is locked, to be used once the
+	// lockout is over.
+	rateLimiter := helpers.GetRateLimiter()
+	if rateLimiter.IsLocked(email) {
+		remaining := rateLimiter.GetLockoutRemaining(email)
+		minutes := int(remaining.Minutes()) + 1
+		http.Redirect(w, r, fmt.Sprintf("/login#locked-%d", minutes), http.StatusFound)
+		return
+	}
+
+	needsPasswordReset, err := useLoginLink(db, userID, token)
+	if errors.Is(err, helpers.ErrInvalidSignedToken) {
+		http.Redirect(w, r, "/login/link#expired", http.StatusFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rateLimiter.ResetAttempts(email)
+	rateLimiter.ResetAttempts(loginLinkLimiterKey(email))
+
+	twoFactorEnabled, err := isTwoFactorEnabled(db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if twoFactorEnabled {
+		if err := startTwoFactorLogin(w, userID, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/login/2fa", http.StatusFound)
+		return
+	}
+
+	completeLogin(w, r, store, db, userID, needsPasswordReset)
+}
+
+// issueLoginLink returns a new login token for userID. Only its hash is
+// stored, and the links sent before stop working.
+func issueLoginLink(db *sql.DB, userID string, now time.Time) (string, error) {
+	token, err := helpers.SignToken(loginLinkPurpose, userID, now.Add(loginLinkLifetime))
+	if err != nil {
+		return "", fmt.Errorf("error generating login link token: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("DELETE FROM login_links WHERE userId = $1 AND usedAt IS NULL", userID); err != nil {
+		return "", err
+	}
+	_, err = tx.Exec("INSERT INTO login_links (tokenHash, expiresAt, userId) VALUES ($1, NOW() + make_interval(secs => $2), $3)", helpers.HashToken(token), loginLinkLifetime.Seconds(), userID)
+	if err != nil {
+		return "", err
+	}
+
+	return token, tx.Commit()
+}
+
+// useLoginLink consumes the login token of userID, whose address is then
+// known to be theirs, and returns whether they must change their password.
+// It returns ErrInvalidSignedToken when the token was already used or
+// replaced.
+func useLoginLink(db *sql.DB, userID, token string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.Exec("UPDATE login_links SET usedAt = NOW() WHERE tokenHash = $1 AND userId = $2 AND usedAt IS NULL AND expiresAt > NOW()", helpers.HashToken(token), userID)
+	if err != nil {
+		return false, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, helpers.ErrInvalidSignedToken
+	}
+
+	var needsPasswordReset bool
+	err = tx.QueryRow("UPDATE users SET emailVerifiedAt = COALESCE(emailVerifiedAt, NOW()) WHERE id = $1 RETURNING COALESCE(needs_password_reset, FALSE)", userID).Scan(&needsPasswordReset)
+	if err != nil {
+		return false, err
+	}
+
+	return needsPasswordReset, tx.Commit()
+}
+
+// loginLinkLimiterKey is the RateLimiter identifier counting the login
+// links requested for email, apart from its wrong passwords.
+func loginLinkLimiterKey(email string) string {
+	return "link:" + email
+}
+
+func loginLinkPath(token string) string {
+	return "/login/link/confirm?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,60 @@
+package domains
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+func TestLoginLinkPath(t *testing.T) {
+	token, err := helpers.SignToken(loginLinkPurpose, "42", time.Now().Add(loginLinkLifetime))
+	if err != nil {
+		t.Fatalf("SignToken failed: %v", err)
+	}
+
+	path, err := url.Parse(loginLinkPath(token))
+	if err != nil {
+		t.Fatalf("Invalid path: %v", err)
+	}
+	if path.Path != "/login/link/confirm" || path.Query().Get("token") != token {
+		t.Errorf("Unexpected path %q", path)
+	}
+
+	userID, err := helpers.VerifySignedToken(loginLinkPurpose, path.Query().Get("token"), time.Now())
+	if err != nil || userID != "42" {
+		t.Errorf("Expected the token to survive the URL, got %q (%v)", userID, err)
+	}
+	if _, err := helpers.VerifySignedToken(loginLinkPurpose, token, time.Now().Add(loginLinkLifetime)); err == nil {
+		t.Error("Expected the link to expire")
+	}
+
+	// A password reset link, which lives longer, must not log in.
+	resetToken, err := helpers.SignToken(passwordResetPurpose, "42", time.Now().Add(passwordResetLifetime))
+	if err != nil {
+		t.Fatalf("SignToken failed: %v", err)
+	}
+	if _, err := helpers.VerifySignedToken(loginLinkPurpose, resetToken, time.Now()); err == nil {
+		t.Error("Expected a password reset token to be rejected")
+	}
+}
+
+func TestLoginLinkLimiterKey(t *testing.T) {
+	// Link requests are counted apart from wrong passwords, so that asking
+	// for links does not lock the password out, nor the reverse.
+	rateLimiter := helpers.GetRateLimiter()
+	email := "link-limiter@example.com"
+	t.Cleanup(func() { rateLimiter.ResetAttempts(loginLinkLimiterKey(email)) })
+
+	locked := false
+	for range 5 {
+		locked = rateLimiter.RecordFailedAttempt(loginLinkLimiterKey(email))
+	}
+	if !locked || !rateLimiter.IsLocked(loginLinkLimiterKey(email)) {
+		t.Error("Expected link requests to be locked")
+	}
+	if rateLimiter.IsLocked(email) {
+		t.Error("Expected logging in with a password to stay possible")
+	}
+}
@@ -0,0 +1,516 @@
+package domains
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+// Lot is a unit of the building (apartment, cellar, parking...) carrying
+// tantièmes. Lots change hands: Owners is the ownership history, oldest first.
+type Lot struct {
+	ID       int
+	Name     string
+	Tantieme int
+	Owners   []Ownership
+}
+
+// Ownership records that a person owns a lot from Start until the start of
+// the next ownership of the lot. A zero Start means since the lot was created.
+type Ownership struct {
+	ID       int
+	LotID    int
+	PersonID int
+	Start    time.Time
+}
+
+type lotFormData struct {
+	Lot     *Lot
+	Lots    []Lot
+	Persons []Person
+	Today   time.Time
+}
+
+// PersonName returns the name of a person of the building.
+func (data lotFormData) PersonName(personID int) string {
+	for _, person := range data.Persons {
+		if person.ID == personID {
+			return person.Name
+		}
+	}
+	return ""
+}
+
+// OwnerAt returns the id of the person owning the lot on date, or zero when
+// nobody owned it yet.
+func (lot Lot) OwnerAt(date time.Time) int {
+	owner := 0
+	for _, ownership := range lot.Owners {
+		if !ownership.Start.IsZero() && ownership.Start.After(date) {
+			break
+		}
+		owner = ownership.PersonID
+	}
+	return owner
+}
+
+// LatestOwner returns the id of the last person the lot was sold to.
+func (lot Lot) LatestOwner() int {
+	if len(lot.Owners) == 0 {
+		return 0
+	}
+	return lot.Owners[len(lot.Owners)-1].PersonID
+}
+
+// Holdings returns the persons who owned the lot between from and to
+// (exclusive) along with the number of days each of them held it, in the
+// order they acquired it.
+func (lot Lot) Holdings(from, to time.Time) ([]int, []int) {
+	var personIDs, days []int
+	for i, ownership := range lot.Owners {
+		start := ownership.Start
+		if start.IsZero() || start.Before(from) {
+			start = from
+		}
+		end := to
+		if i+1 < len(lot.Owners) && lot.Owners[i+1].Start.Before(to) {
+			end = lot.Owners[i+1].Start
+		}
+		held := int(end.Sub(start).Hours() / 24)
+		if held <= 0 {
+			continue
+		}
+
+		found := false
+		for j, personID := range personIDs {
+			if personID == ownership.PersonID {
+				days[j] += held
+				found = true
+			}
+		}
+		if !found {
+			personIDs = append(personIDs, ownership.PersonID)
+			days = append(days, held)
+		}
+	}
+	return personIDs, days
+}
+
+func LotsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderLotForm(w, db, userID, coproprieteID, 0)
+}
+
+func EditLotHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	lotID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid lot id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := getLotCoproprieteID(db, lotID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Lot not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderLotForm(w, db, userID, coproprieteID, lotID)
+}
+
+func AddLotHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	name := r.FormValue("name")
+	if name == "" {
+		http.Error(w, "Name cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	tantieme, err := strconv.Atoi(r.FormValue("tantieme"))
+	if err != nil || tantieme < 0 {
+		http.Error(w, "Invalid tantieme value", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The first owner is optional: a lot can be created before being assigned.
+	var ownership Ownership
+	if value := r.FormValue("person_id"); value != "" && value != "0" {
+		ownership, err = parseOwnership(db, r, userID, coproprieteID, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var lotID int
+	err = tx.QueryRow("INSERT INTO lots (name, tantieme, userId, coproprieteId) VALUES ($1, $2, $3, $4) RETURNING id", name, tantieme, userID, coproprieteID).Scan(&lotID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if ownership.PersonID != 0 {
+		if _, err := tx.Exec("INSERT INTO lot_owners (lotId, personId, startDate) VALUES ($1, $2, $3)", lotID, ownership.PersonID, nullDate(ownership.Start)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#lot_added", http.StatusFound)
+}
+
+func UpdateLotHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	lotID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid lot id", http.StatusBadRequest)
+		return
+	}
+
+	name := r.FormValue("name")
+	if name == "" {
+		http.Error(w, "Name cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	tantieme, err := strconv.Atoi(r.FormValue("tantieme"))
+	if err != nil || tantieme < 0 {
+		http.Error(w, "Invalid tantieme value", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := db.Exec("UPDATE lots SET name = $1, tantieme = $2 WHERE id = $3 AND userId = $4", name, tantieme, lotID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "Lot not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#lot_updated", http.StatusFound)
+}
+
+func DeleteLotHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	lotID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid lot id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM lots WHERE id = $1 AND userId = $2", lotID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "Lot not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#lot_deleted", http.StatusFound)
+}
+
+// AddOwnershipHandler records the sale (mutation) of a lot to a person from
+// a given date.
+func AddOwnershipHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	lotID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid lot id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := getLotCoproprieteID(db, lotID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Lot not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ownership, err := parseOwnership(db, r, userID, coproprieteID, true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.Exec("INSERT INTO lot_owners (lotId, personId, startDate) VALUES ($1, $2, $3)", lotID, ownership.PersonID, ownership.Start); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/lots/%d#owner_added", lotID), http.StatusFound)
+}
+
+func DeleteOwnershipHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	lotID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid lot id", http.StatusBadRequest)
+		return
+	}
+
+	ownershipID, err := strconv.Atoi(r.PathValue("ownerId"))
+	if err != nil {
+		http.Error(w, "Invalid owner id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM lot_owners o USING lots l WHERE o.id = $1 AND o.lotId = $2 AND l.id = o.lotId AND l.userId = $3", ownershipID, lotID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "Owner not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/lots/%d#owner_deleted", lotID), http.StatusFound)
+}
+
+// getLotCoproprieteID returns the copropriete of a lot, or sql.ErrNoRows when
+// it does not exist or belongs to another user.
+func getLotCoproprieteID(db *sql.DB, lotID int, userID string) (int, error) {
+	var coproprieteID int
+	err := db.QueryRow("SELECT coproprieteId FROM lots WHERE id = $1 AND userId = $2", lotID, userID).Scan(&coproprieteID)
+	return coproprieteID, err
+}
+
+// getLots loads the lots of a building with their ownership history.
+func getLots(db *sql.DB, userID string, coproprieteID int) ([]Lot, error) {
+	rows, err := db.Query("SELECT id, name, tantieme FROM lots WHERE userId = $1 AND coproprieteId = $2 ORDER BY id", userID, coproprieteID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var lots []Lot
+	for rows.Next() {
+		var lot Lot
+		if err := rows.Scan(&lot.ID, &lot.Name, &lot.Tantieme); err != nil {
+			return nil, err
+		}
+		lots = append(lots, lot)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ownerRows, err := db.Query(`SELECT o.id, o.lotId, o.personId, o.startDate FROM lot_owners o
+		JOIN lots l ON l.id = o.lotId
+		WHERE l.userId = $1 AND l.coproprieteId = $2
+		ORDER BY o.startDate NULLS FIRST, o.id`, userID, coproprieteID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = ownerRows.Close() }()
+
+	for ownerRows.Next() {
+		var ownership Ownership
+		var start sql.NullTime
+		if err := ownerRows.Scan(&ownership.ID, &ownership.LotID, &ownership.PersonID, &start); err != nil {
+			return nil, err
+		}
+		ownership.Start = start.Time
+		for i := range lots {
+			if lots[i].ID == ownership.LotID {
+				lots[i].Owners = append(lots[i].Owners, ownership)
+			}
+		}
+	}
+
+	return lots, ownerRows.Err()
+}
+
+// parseOwnership reads the person_id and date form values, checking that the
+// person belongs to the copropriete. The date may only be omitted for the
+// first owner of a lot.
+func parseOwnership(db *sql.DB, r *http.Request, userID string, coproprieteID int, dateRequired bool) (Ownership, error) {
+	var ownership Ownership
+
+	personID, err := strconv.Atoi(r.FormValue("person_id"))
+	if err != nil {
+		return ownership, fmt.Errorf("invalid person value")
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM persons WHERE id = $1 AND userId = $2 AND coproprieteId = $3", personID, userID, coproprieteID).Scan(&count)
+	if err != nil {
+		return ownership, err
+	}
+	if count == 0 {
+		return ownership, fmt.Errorf("invalid person value")
+	}
+	ownership.PersonID = personID
+
+	value := r.FormValue("date")
+	if value == "" && !dateRequired {
+		return ownership, nil
+	}
+	ownership.Start, err = time.Parse(dateLayout, value)
+	if err != nil {
+		return ownership, fmt.Errorf("invalid date value")
+	}
+
+	return ownership, nil
+}
+
+func nullDate(date time.Time) sql.NullTime {
+	return sql.NullTime{Time: date, Valid: !date.IsZero()}
+}
+
+func renderLotForm(w http.ResponseWriter, db *sql.DB, userID string, coproprieteID int, lotID int) {
+	lots, err := getLots(db, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	persons, err := getPersons(db, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := lotFormData{
+		Lots:    lots,
+		Persons: persons,
+		Today:   time.Now(),
+	}
+	for i := range lots {
+		if lots[i].ID == lotID {
+			data.Lot = &lots[i]
+		}
+	}
+
+	t, err := template.ParseFiles("lib/templates/edit-lots.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
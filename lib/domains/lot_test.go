@@ -0,0 +1,108 @@
+package domains
+
+import (
+	"testing"
+	"time"
+)
+
+func soldLot() Lot {
+	return Lot{
+		ID:       10,
+		Name:     "Appartement 4",
+		Tantieme: 100,
+		Owners: []Ownership{
+			{ID: 1, LotID: 10, PersonID: 1},
+			{ID: 2, LotID: 10, PersonID: 2, Start: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+}
+
+func TestLotOwnerAt(t *testing.T) {
+	lot := soldLot()
+
+	if owner := lot.OwnerAt(time.Date(2025, 2, 28, 0, 0, 0, 0, time.UTC)); owner != 1 {
+		t.Errorf("Expected the seller to own the lot before the sale, got %d", owner)
+	}
+	if owner := lot.OwnerAt(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)); owner != 2 {
+		t.Errorf("Expected the buyer to own the lot from the sale, got %d", owner)
+	}
+	if owner := lot.LatestOwner(); owner != 2 {
+		t.Errorf("Expected the buyer to be the latest owner, got %d", owner)
+	}
+	if owner := (Lot{}).OwnerAt(time.Now()); owner != 0 {
+		t.Errorf("Expected a lot without owner to have none, got %d", owner)
+	}
+}
+
+func TestLotHoldings(t *testing.T) {
+	lot := soldLot()
+
+	personIDs, days := lot.Holdings(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	if len(personIDs) != 2 || personIDs[0] != 1 || personIDs[1] != 2 {
+		t.Fatalf("Expected the seller then the buyer, got %v", personIDs)
+	}
+	if days[0] != 59 || days[1] != 306 {
+		t.Errorf("Expected 59 and 306 days, got %v", days)
+	}
+
+	personIDs, _ = lot.Holdings(time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC))
+	if len(personIDs) != 1 || personIDs[0] != 2 {
+		t.Errorf("Expected only the buyer after the sale, got %v", personIDs)
+	}
+}
+
+func TestSaleSplitsExpensesProRata(t *testing.T) {
+	persons := []Person{
+		{ID: 1, Name: "Seller"},
+		{ID: 2, Name: "Buyer"},
+		{ID: 3, Name: "Neighbour"},
+	}
+	lots := []Lot{
+		soldLot(),
+		{ID: 11, Name: "Appartement 5", Tantieme: 100, Owners: []Ownership{{ID: 3, LotID: 11, PersonID: 3}}},
+	}
+	allocator := NewLotAllocator(persons, lots, time.January)
+	bill := Bill{Label: "Entretien", Amount: 365000, FiscalYear: 2025}
+
+	seller := persons[0].CalculateDue(allocator, bill)
+	buyer := persons[1].CalculateDue(allocator, bill)
+	neighbour := persons[2].CalculateDue(allocator, bill)
+
+	if seller != 29500 || buyer != 153000 || neighbour != 182500 {
+		t.Errorf("Expected 295,00 / 1530,00 / 1825,00, got %s / %s / %s", seller, buyer, neighbour)
+	}
+	if seller+buyer+neighbour != bill.Amount {
+		t.Errorf("Expected the shares to add up to %s", bill.Amount)
+	}
+}
+
+func TestCallIsDueByOwnerOnItsDate(t *testing.T) {
+	persons := []Person{{ID: 1, Name: "Seller"}, {ID: 2, Name: "Buyer"}}
+	allocator := NewLotAllocator(persons, []Lot{soldLot()}, time.January)
+
+	before := Provision{Amount: 50000, Date: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), FiscalYear: 2025}
+	after := Provision{Amount: 50000, Date: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), FiscalYear: 2025}
+
+	if persons[0].CalculateProvision(allocator, before) != 50000 || persons[1].CalculateProvision(allocator, before) != 0 {
+		t.Error("Expected the call before the sale to be due by the seller")
+	}
+	if persons[0].CalculateProvision(allocator, after) != 0 || persons[1].CalculateProvision(allocator, after) != 50000 {
+		t.Error("Expected the call after the sale to be due by the buyer")
+	}
+}
+
+func TestChargeKeyTantiemesFollowTheLot(t *testing.T) {
+	persons := []Person{{ID: 1, Name: "Seller"}, {ID: 2, Name: "Buyer"}}
+	elevator := ChargeKey{ID: 7, Name: "Ascenseur", Tantiemes: map[int]int{10: 40}}
+	allocator := NewLotAllocator(persons, []Lot{soldLot()}, time.January, elevator)
+
+	if tantieme := allocator.TantiemeOf(persons[0], 7, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)); tantieme != 40 {
+		t.Errorf("Expected the seller to hold 40 tantièmes before the sale, got %d", tantieme)
+	}
+	if tantieme := allocator.TantiemeOf(persons[0], 7, time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)); tantieme != 0 {
+		t.Errorf("Expected the seller to hold no tantième after the sale, got %d", tantieme)
+	}
+	if share := allocator.ShareOf(&persons[1], 10000, 7); share != 10000 {
+		t.Errorf("Expected the latest owner to bear the whole charge, got %s", share)
+	}
+}
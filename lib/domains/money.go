@@ -0,0 +1,214 @@
+package domains
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount of euros stored as an integer number of cents so that
+// sums and splits never drift like float64 amounts do.
+type Money int64
+
+var ErrInvalidMoney = errors.New("invalid amount")
+
+// maxMoney is the largest amount the NUMERIC(14, 2) columns can store.
+const maxMoney Money = 999_999_999_999_99
+
+// ParseMoney parses a user supplied amount. It accepts the French notation
+// ("1 234,56", "1.234,56 €") as well as the dot notation sent by number inputs
+// ("1234.56"). At most two decimals are allowed, and amounts the database
+// cannot store are rejected.
+func ParseMoney(value string) (Money, error) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimSuffix(value, "€")
+	value = strings.TrimSuffix(strings.ToUpper(value), "EUR")
+	value = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\u00a0', '\u202f', '\'':
+			return -1
+		}
+		return r
+	}, value)
+
+	negative := false
+	if strings.HasPrefix(value, "-") {
+		negative = true
+		value = value[1:]
+	} else if strings.HasPrefix(value, "+") {
+		value = value[1:]
+	}
+
+	// The last separator is the decimal one unless it is repeated, in which
+	// case the amount only has thousands separators ("1.234.567").
+	decimalSeparator := ""
+	lastComma := strings.LastIndex(value, ",")
+	lastDot := strings.LastIndex(value, ".")
+	switch {
+	case lastComma > lastDot && strings.Count(value, ",") == 1:
+		decimalSeparator = ","
+	case lastDot > lastComma && strings.Count(value, ".") == 1:
+		decimalSeparator = "."
+	}
+
+	if decimalSeparator == "" && lastComma >= 0 && lastDot >= 0 {
+		return 0, ErrInvalidMoney
+	}
+
+	units, cents := value, ""
+	if decimalSeparator != "" {
+		index := strings.LastIndex(value, decimalSeparator)
+		units, cents = value[:index], value[index+1:]
+	}
+	units = strings.NewReplacer(",", "", ".", "").Replace(units)
+
+	if units == "" && cents == "" {
+		return 0, ErrInvalidMoney
+	}
+	if units == "" {
+		units = "0"
+	}
+	if len(cents) > 2 {
+		return 0, fmt.Errorf("%w: more than two decimals", ErrInvalidMoney)
+	}
+	for len(cents) < 2 {
+		cents += "0"
+	}
+
+	for _, digits := range []string{units, cents} {
+		for _, r := range digits {
+			if r < '0' || r > '9' {
+				return 0, ErrInvalidMoney
+			}
+		}
+	}
+
+	whole, err := strconv.ParseInt(units, 10, 64)
+	if err != nil || whole > int64(maxMoney/100) {
+		return 0, ErrInvalidMoney
+	}
+	fraction, err := strconv.ParseInt(cents, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidMoney
+	}
+
+	amount := Money(whole*100 + fraction)
+	if amount > maxMoney {
+		return 0, ErrInvalidMoney
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// Cents returns the amount as an integer number of cents.
+func (m Money) Cents() int64 {
+	return int64(m)
+}
+
+// Float64 returns the amount in euros, for spreadsheet cells only.
+func (m Money) Float64() float64 {
+	return float64(m) / 100
+}
+
+// Decimal formats the amount with a dot and no grouping ("-1234.56"), the
+// representation used for storage.
+func (m Money) Decimal() string {
+	sign := ""
+	cents := int64(m)
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// String formats the amount in French notation ("-1 234,56"), without the
+// currency symbol.
+func (m Money) String() string {
+	sign := ""
+	cents := int64(m)
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+
+	units := strconv.FormatInt(cents/100, 10)
+	var grouped strings.Builder
+	for i, r := range units {
+		if i > 0 && (len(units)-i)%3 == 0 {
+			grouped.WriteRune(' ')
+		}
+		grouped.WriteRune(r)
+	}
+
+	return fmt.Sprintf("%s%s,%02d", sign, grouped.String(), cents%100)
+}
+
+// MarshalJSON encodes the amount as a decimal string ("-1234.56") so that
+// API clients never round it through a float.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.Decimal())
+}
+
+// UnmarshalJSON accepts the amount as a string, in any notation ParseMoney
+// understands, or as a JSON number with at most two decimals.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		var number json.Number
+		if err := json.Unmarshal(data, &number); err != nil {
+			return ErrInvalidMoney
+		}
+		value = number.String()
+	}
+	parsed, err := ParseMoney(value)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// Value stores the amount in a NUMERIC column.
+func (m Money) Value() (driver.Value, error) {
+	return m.Decimal(), nil
+}
+
+// Scan reads the amount from a NUMERIC (or legacy FLOAT) column.
+func (m *Money) Scan(src any) error {
+	switch value := src.(type) {
+	case nil:
+		*m = 0
+		return nil
+	case []byte:
+		return m.scanString(string(value))
+	case string:
+		return m.scanString(value)
+	case int64:
+		*m = Money(value * 100)
+		return nil
+	case float64:
+		cents := value * 100
+		if cents < 0 {
+			*m = Money(cents - 0.5)
+		} else {
+			*m = Money(cents + 0.5)
+		}
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into Money", src)
+}
+
+func (m *Money) scanString(value string) error {
+	parsed, err := ParseMoney(value)
+	if err != nil {
+		return fmt.Errorf("cannot scan %q into Money: %w", value, err)
+	}
+	*m = parsed
+	return nil
+}
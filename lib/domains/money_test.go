@@ -0,0 +1,167 @@
+package domains
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Money
+	}{
+		{"1234.56", 123456},
+		{"1 234,56", 123456},
+		{"1 234,56 €", 123456},
+		{"1 234,5", 123450},
+		{"1.234,56", 123456},
+		{"1,234.56", 123456},
+		{"1.234.567", 123456700},
+		{"1000", 100000},
+		{"0,05", 5},
+		{",5", 50},
+		{"-12,30", -1230},
+		{"+7", 700},
+		{"42 EUR", 4200},
+		{"999 999 999 999,99", 99999999999999},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.input)
+		if err != nil {
+			t.Errorf("ParseMoney(%q) returned error: %v", tt.input, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("ParseMoney(%q) = %d, expected %d", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestParseMoneyInvalid(t *testing.T) {
+	for _, input := range []string{"", "abc", "12,345", "1.2.3,4,5", "12€34", "-", "922337203685477580", "-922337203685477580", "1000000000000", "-1000000000000,00"} {
+		if _, err := ParseMoney(input); !errors.Is(err, ErrInvalidMoney) {
+			t.Errorf("ParseMoney(%q) should fail with ErrInvalidMoney, got %v", input, err)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		amount   Money
+		expected string
+	}{
+		{0, "0,00"},
+		{5, "0,05"},
+		{123456, "1 234,56"},
+		{100000000, "1 000 000,00"},
+		{-123456, "-1 234,56"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.amount.String(); got != tt.expected {
+			t.Errorf("Money(%d).String() = %q, expected %q", tt.amount, got, tt.expected)
+		}
+	}
+}
+
+func TestMoneyStringRoundTrip(t *testing.T) {
+	for _, amount := range []Money{0, 1, 99, 123456, -98765, 100000000} {
+		parsed, err := ParseMoney(amount.String())
+		if err != nil {
+			t.Fatalf("ParseMoney(%q) returned error: %v", amount.String(), err)
+		}
+		if parsed != amount {
+			t.Errorf("Round trip of %d gave %d", amount, parsed)
+		}
+	}
+}
+
+func TestMoneyDecimal(t *testing.T) {
+	if got := Money(-123456).Decimal(); got != "-1234.56" {
+		t.Errorf("Expected -1234.56, got %s", got)
+	}
+	if got := Money(7).Decimal(); got != "0.07" {
+		t.Errorf("Expected 0.07, got %s", got)
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		src      any
+		expected Money
+	}{
+		{[]byte("1234.56"), 123456},
+		{"-0.10", -10},
+		{int64(12), 1200},
+		{0.1 + 0.2, 30},
+		{-0.125, -13},
+		{nil, 0},
+	}
+
+	for _, tt := range tests {
+		var m Money
+		if err := m.Scan(tt.src); err != nil {
+			t.Errorf("Scan(%v) returned error: %v", tt.src, err)
+			continue
+		}
+		if m != tt.expected {
+			t.Errorf("Scan(%v) = %d, expected %d", tt.src, m, tt.expected)
+		}
+	}
+
+	var m Money
+	if err := m.Scan(true); err == nil {
+		t.Error("Scan of a bool should fail")
+	}
+}
+
+func TestMoneyValue(t *testing.T) {
+	value, err := Money(100050).Value()
+	if err != nil {
+		t.Fatalf("Value returned error: %v", err)
+	}
+	if value != "1000.50" {
+		t.Errorf("Expected 1000.50, got %v", value)
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	encoded, err := json.Marshal(struct {
+		Amount Money `json:"amount"`
+	}{Money(-123456)})
+	if err != nil {
+		t.Fatalf("Marshal returned error: %v", err)
+	}
+	if string(encoded) != `{"amount":"-1234.56"}` {
+		t.Errorf("Expected the amount as a decimal string, got %s", encoded)
+	}
+
+	tests := []struct {
+		input    string
+		expected Money
+	}{
+		{`"1234.56"`, 123456},
+		{`"1 234,56 €"`, 123456},
+		{`1234.5`, 123450},
+		{`-12`, -1200},
+	}
+	for _, tt := range tests {
+		var m Money
+		if err := json.Unmarshal([]byte(tt.input), &m); err != nil {
+			t.Errorf("Unmarshal(%s) returned error: %v", tt.input, err)
+			continue
+		}
+		if m != tt.expected {
+			t.Errorf("Unmarshal(%s) = %d, expected %d", tt.input, m, tt.expected)
+		}
+	}
+
+	for _, input := range []string{`"abc"`, `12.345`, `true`, `"1.234,567"`} {
+		var m Money
+		if err := json.Unmarshal([]byte(input), &m); !errors.Is(err, ErrInvalidMoney) {
+			t.Errorf("Unmarshal(%s) should fail with ErrInvalidMoney, got %v", input, err)
+		}
+	}
+}
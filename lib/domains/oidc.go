@@ -0,0 +1,284 @@
+package domains
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+
+	"github.com/go-session/session/v3"
+)
+
+const (
+	oidcLoginPurpose = "login-oidc"
+	// oidcLoginLifetime is how long a user has to log in at the identity
+	// provider.
+	oidcLoginLifetime = 10 * time.Minute
+	oidcLoginCookie   = "tanzia-oidc"
+	oidcLoginPath     = "/login/oidc"
+)
+
+var (
+	// errOIDCEmailUnverified is returned when the identity provider does not
+	// vouch for the address of a new identity.
+	errOIDCEmailUnverified = errors.New("identity provider did not verify the email address")
+	// errOIDCAccountUnverified is returned when the account with the address
+	// of a new identity never verified it: whoever created it may not own the
+	// address, so the identity is not linked to it.
+	errOIDCAccountUnverified = errors.New("account email address is not verified")
+)
+
+// OIDCLoginHandler sends the user to log in at the identity provider, as a
+// PKCE-protected authorization code flow.
+func OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, err := helpers.GetOIDCProvider(r.Context())
+	if errors.Is(err, helpers.ErrOIDCNotConfigured) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("OIDC error: %v", err)
+		http.Redirect(w, r, "/login#sso_failed", http.StatusFound)
+		return
+	}
+
+	login, err := helpers.NewOIDCLogin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := startOIDCLogin(w, login, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, provider.AuthCodeURL(login), http.StatusFound)
+}
+
+// OIDCCallbackHandler logs in the user coming back from the identity
+// provider. Identities seen for the first time are linked to the account
+// with the same verified address, or get a new account. As with a password,
+// locked accounts stay locked and two-factor authentication is enforced.
+func OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	store, err := session.Start(context.Background(), w, r)
+	if err != nil {
+		log.Printf("Session error: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	login, ok := pendingOIDCLogin(r, time.Now())
+	clearOIDCLogin(w)
+	if !ok || subtle.ConstantTimeCompare([]byte(r.FormValue("state")), []byte(login.State)) != 1 {
+		http.Redirect(w, r, "/login#sso_failed", http.StatusFound)
+		return
+	}
+	if oidcError := r.FormValue("error"); oidcError != "" {
+		log.Printf("OIDC login refused: %s %s", oidcError, r.FormValue("error_description"))
+		http.Redirect(w, r, "/login#sso_failed", http.StatusFound)
+		return
+	}
+
+	provider, err := helpers.GetOIDCProvider(r.Context())
+	if err != nil {
+		log.Printf("OIDC error: %v", err)
+		http.Redirect(w, r, "/login#sso_failed", http.StatusFound)
+		return
+	}
+
+	idToken, err := provider.Exchange(r.Context(), r.FormValue("code"), login.CodeVerifier)
+	if err != nil {
+		log.Printf("OIDC error: %v", err)
+		http.Redirect(w, r, "/login#sso_failed", http.StatusFound)
+		return
+	}
+	claims, err := provider.VerifyIDToken(r.Context(), idToken, login.Nonce, time.Now())
+	if err != nil {
+		log.Printf("OIDC error: %v", err)
+		http.Redirect(w, r, "/login#sso_failed", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, err := linkOIDCIdentity(db, claims)
+	if errors.Is(err, errOIDCEmailUnverified) {
+		http.Redirect(w, r, "/login#sso_email_unverified", http.StatusFound)
+		return
+	}
+	if errors.Is(err, errOIDCAccountUnverified) {
+		http.Redirect(w, r, "/login#sso_account_unverified", http.StatusFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var email string
+	var needsPasswordReset bool
+	err = db.QueryRow("SELECT COALESCE(email, ''), COALESCE(needs_password_reset, FALSE) FROM users WHERE id = $1", userID).Scan(&email, &needsPasswordReset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rateLimiter := helpers.GetRateLimiter()
+	if rateLimiter.IsLocked(email) {
+		remaining := rateLimiter.GetLockoutRemaining(email)
+		minutes := int(remaining.Minutes()) + 1
+		http.Redirect(w, r, fmt.Sprintf("/login#locked-%d", minutes), http.StatusFound)
+		return
+	}
+	rateLimiter.ResetAttempts(email)
+
+	twoFactorEnabled, err := isTwoFactorEnabled(db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if twoFactorEnabled {
+		if err := startTwoFactorLogin(w, userID, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/login/2fa", http.StatusFound)
+		return
+	}
+
+	completeLogin(w, r, store, db, userID, needsPasswordReset)
+}
+
+// linkOIDCIdentity returns the user logging in with the identity of claims,
+// linking it on first use to the account with the same address, which both
+// sides must have verified, or else to a new account.
+func linkOIDCIdentity(db *sql.DB, claims helpers.OIDCClaims) (string, error) {
+	var userID string
+	err := db.QueryRow("UPDATE oidc_identities SET lastUsedAt = NOW() WHERE issuer = $1 AND subject = $2 RETURNING userId", claims.Issuer, claims.Subject).Scan(&userID)
+	if err == nil {
+		return userID, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return "", errOIDCEmailUnverified
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Providers do not preserve the case of addresses: the verified account
+	// comes first among those differing by case only.
+	var verified bool
+	err = tx.QueryRow("SELECT id, emailVerifiedAt IS NOT NULL FROM users WHERE LOWER(email) = LOWER($1) ORDER BY emailVerifiedAt IS NULL, id LIMIT 1", claims.Email).Scan(&userID, &verified)
+	switch {
+	case err == sql.ErrNoRows:
+		userID, err = createOIDCUser(tx, claims)
+		if err != nil {
+			return "", err
+		}
+	case err != nil:
+		return "", err
+	case !verified:
+		return "", errOIDCAccountUnverified
+	}
+
+	_, err = tx.Exec("INSERT INTO oidc_identities (issuer, subject, email, lastUsedAt, userId) VALUES ($1, $2, $3, NOW(), $4)", claims.Issuer, claims.Subject, claims.Email, userID)
+	if err != nil {
+		return "", err
+	}
+	return userID, tx.Commit()
+}
+
+// createOIDCUser creates the account of a new identity, with the address
+// verified by the provider. Its random password can be replaced through the
+// forgotten password link.
+func createOIDCUser(tx *sql.Tx, claims helpers.OIDCClaims) (string, error) {
+	hashedPassword, err := helpers.HashPassword(rand.Text())
+	if err != nil {
+		return "", fmt.Errorf("error hashing password: %w", err)
+	}
+
+	var userID string
+	err = tx.QueryRow("INSERT INTO users (email, name, password, is_premium, needs_password_reset, emailVerifiedAt) VALUES ($1, $2, $3, $4, $5, NOW()) RETURNING id", claims.Email, oidcUserName(claims), hashedPassword, false, false).Scan(&userID)
+	return userID, err
+}
+
+// oidcUserName is the name of the account created for claims, which falls
+// back to the local part of the address.
+func oidcUserName(claims helpers.OIDCClaims) string {
+	if name := strings.TrimSpace(claims.Name); name != "" {
+		return name
+	}
+	local, _, _ := strings.Cut(claims.Email, "@")
+	return local
+}
+
+// startOIDCLogin keeps login in a short-lived signed cookie, which only the
+// browser starting the login sends back to the callback.
+func startOIDCLogin(w http.ResponseWriter, login helpers.OIDCLogin, now time.Time) error {
+	token, err := helpers.SignToken(oidcLoginPurpose, login.State+"."+login.Nonce+"."+login.CodeVerifier, now.Add(oidcLoginLifetime))
+	if err != nil {
+		return fmt.Errorf("error generating login token: %w", err)
+	}
+
+	// The provider redirects to the callback with a top-level GET, which
+	// carries Lax cookies.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcLoginCookie,
+		Value:    token,
+		Path:     oidcLoginPath,
+		MaxAge:   int(oidcLoginLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// pendingOIDCLogin returns the login started by the browser of r.
+func pendingOIDCLogin(r *http.Request, now time.Time) (helpers.OIDCLogin, bool) {
+	cookie, err := r.Cookie(oidcLoginCookie)
+	if err != nil {
+		return helpers.OIDCLogin{}, false
+	}
+	value, err := helpers.VerifySignedToken(oidcLoginPurpose, cookie.Value, now)
+	if err != nil {
+		return helpers.OIDCLogin{}, false
+	}
+
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return helpers.OIDCLogin{}, false
+	}
+	return helpers.OIDCLogin{State: parts[0], Nonce: parts[1], CodeVerifier: parts[2]}, true
+}
+
+func clearOIDCLogin(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcLoginCookie,
+		Value:    "",
+		Path:     oidcLoginPath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
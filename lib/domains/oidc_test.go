@@ -0,0 +1,59 @@
+package domains
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+func TestOIDCLoginCookie(t *testing.T) {
+	now := time.Now()
+	login, err := helpers.NewOIDCLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	if err := startOIDCLogin(recorder, login, now); err != nil {
+		t.Fatalf("startOIDCLogin failed: %v", err)
+	}
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oidcLoginCookie || !cookies[0].HttpOnly || cookies[0].Path != oidcLoginPath || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Fatalf("Unexpected cookies %+v", cookies)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/login/oidc/callback", nil)
+	r.AddCookie(cookies[0])
+	if pending, ok := pendingOIDCLogin(r, now); !ok || pending != login {
+		t.Errorf("Expected %+v, got %+v, %v", login, pending, ok)
+	}
+	if _, ok := pendingOIDCLogin(r, now.Add(oidcLoginLifetime+time.Second)); ok {
+		t.Error("Expected the pending login to expire")
+	}
+
+	// A checked password must not pass for a login started here.
+	recorder = httptest.NewRecorder()
+	if err := startTwoFactorLogin(recorder, "42", now); err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/login/oidc/callback", nil)
+	r.AddCookie(&http.Cookie{Name: oidcLoginCookie, Value: recorder.Result().Cookies()[0].Value})
+	if _, ok := pendingOIDCLogin(r, now); ok {
+		t.Error("Expected a two-factor login token to be rejected")
+	}
+}
+
+func TestOIDCUserName(t *testing.T) {
+	tests := map[string]helpers.OIDCClaims{
+		"Jane Doe": {Name: " Jane Doe ", Email: "jane@example.com"},
+		"jane":     {Email: "jane@example.com"},
+	}
+	for expected, claims := range tests {
+		if name := oidcUserName(claims); name != expected {
+			t.Errorf("Expected %q, got %q", expected, name)
+		}
+	}
+}
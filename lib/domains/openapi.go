@@ -0,0 +1,51 @@
+package domains
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec describes the routes of APIRoutes. TestOpenAPIMatchesRoutes and
+// TestOpenAPISchemasMatchBodies keep the two in sync.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// APIRoute is a route of the JSON API, with a net/http mux pattern.
+type APIRoute struct {
+	Pattern string
+	Handler http.HandlerFunc
+}
+
+// APIRoutes returns the routes served by the api binary.
+func APIRoutes() []APIRoute {
+	return []APIRoute{
+		{"GET /api/v1/openapi.json", APIOpenAPIHandler},
+		{"GET /api/v1/coproprietes", APICoproprietesHandler},
+		{"GET /api/v1/coproprietes/{copropriete}/dashboard", APIDashboardHandler},
+		{"GET /api/v1/coproprietes/{copropriete}/balances", APIBalancesHandler},
+		{"GET /api/v1/coproprietes/{copropriete}/persons", APIPersonsHandler},
+		{"POST /api/v1/coproprietes/{copropriete}/persons", APIAddPersonHandler},
+		{"GET /api/v1/coproprietes/{copropriete}/persons/{id}", APIPersonHandler},
+		{"PUT /api/v1/coproprietes/{copropriete}/persons/{id}", APIUpdatePersonHandler},
+		{"DELETE /api/v1/coproprietes/{copropriete}/persons/{id}", APIDeletePersonHandler},
+		{"GET /api/v1/coproprietes/{copropriete}/bills", APIBillsHandler},
+		{"POST /api/v1/coproprietes/{copropriete}/bills", APIAddBillHandler},
+		{"GET /api/v1/coproprietes/{copropriete}/bills/{id}", APIBillHandler},
+		{"PUT /api/v1/coproprietes/{copropriete}/bills/{id}", APIUpdateBillHandler},
+		{"DELETE /api/v1/coproprietes/{copropriete}/bills/{id}", APIDeleteBillHandler},
+		{"GET /api/v1/coproprietes/{copropriete}/provisions", APIProvisionsHandler},
+		{"POST /api/v1/coproprietes/{copropriete}/provisions", APIAddProvisionHandler},
+		{"GET /api/v1/coproprietes/{copropriete}/provisions/{id}", APIProvisionHandler},
+		{"PUT /api/v1/coproprietes/{copropriete}/provisions/{id}", APIUpdateProvisionHandler},
+		{"DELETE /api/v1/coproprietes/{copropriete}/provisions/{id}", APIDeleteProvisionHandler},
+	}
+}
+
+// APIOpenAPIHandler serves the OpenAPI 3 document of the API. It needs no
+// authentication, so that tools can fetch it before having a token.
+func APIOpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	_, _ = w.Write(openAPISpec)
+}
@@ -0,0 +1,245 @@
+package domains
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type openAPIDocument struct {
+	OpenAPI string `json:"openapi"`
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Parameters map[string]openAPIParameter `json:"parameters"`
+		Schemas    map[string]openAPISchema    `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	OperationID string             `json:"operationId"`
+	Parameters  []openAPIParameter `json:"parameters"`
+	Responses   map[string]any     `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Ref  string `json:"$ref"`
+	Name string `json:"name"`
+	In   string `json:"in"`
+}
+
+type openAPISchema struct {
+	Properties map[string]any `json:"properties"`
+	Required   []string       `json:"required"`
+}
+
+func loadOpenAPIDocument(t *testing.T) openAPIDocument {
+	t.Helper()
+	var doc openAPIDocument
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	if len(doc.Servers) != 1 {
+		t.Fatalf("Expected a single server, got %d", len(doc.Servers))
+	}
+	return doc
+}
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	doc := loadOpenAPIDocument(t)
+
+	var documented []string
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			documented = append(documented, strings.ToUpper(method)+" "+doc.Servers[0].URL+path)
+		}
+	}
+	var served []string
+	for _, route := range APIRoutes() {
+		served = append(served, route.Pattern)
+	}
+	sort.Strings(documented)
+	sort.Strings(served)
+	if !slices.Equal(documented, served) {
+		t.Errorf("openapi.json documents\n%s\nbut the API serves\n%s", strings.Join(documented, "\n"), strings.Join(served, "\n"))
+	}
+}
+
+func TestOpenAPIOperations(t *testing.T) {
+	doc := loadOpenAPIDocument(t)
+	pathParameter := regexp.MustCompile(`\{(\w+)\}`)
+
+	operationIDs := map[string]bool{}
+	for path, operations := range doc.Paths {
+		for method, operation := range operations {
+			if operation.OperationID == "" || operationIDs[operation.OperationID] {
+				t.Errorf("%s %s: missing or duplicate operationId %q", method, path, operation.OperationID)
+			}
+			operationIDs[operation.OperationID] = true
+			if len(operation.Responses) == 0 {
+				t.Errorf("%s %s: no responses", method, path)
+			}
+
+			var declared []string
+			for _, parameter := range operation.Parameters {
+				if parameter.Ref != "" {
+					name := strings.TrimPrefix(parameter.Ref, "#/components/parameters/")
+					resolved, ok := doc.Components.Parameters[name]
+					if !ok {
+						t.Errorf("%s %s: unknown parameter %s", method, path, parameter.Ref)
+					}
+					parameter = resolved
+				}
+				if parameter.In == "path" {
+					declared = append(declared, parameter.Name)
+				}
+			}
+			var expected []string
+			for _, match := range pathParameter.FindAllStringSubmatch(path, -1) {
+				expected = append(expected, match[1])
+			}
+			sort.Strings(declared)
+			sort.Strings(expected)
+			if !slices.Equal(declared, expected) {
+				t.Errorf("%s %s: path parameters %v, expected %v", method, path, declared, expected)
+			}
+		}
+	}
+}
+
+func TestOpenAPIReferencesResolve(t *testing.T) {
+	doc := loadOpenAPIDocument(t)
+	var raw map[string]any
+	if err := json.Unmarshal(openAPISpec, &raw); err != nil {
+		t.Fatal(err)
+	}
+	components := raw["components"].(map[string]any)
+
+	for _, match := range regexp.MustCompile(`"\$ref": "#/components/(\w+)/(\w+)"`).FindAllStringSubmatch(string(openAPISpec), -1) {
+		section, _ := components[match[1]].(map[string]any)
+		if _, ok := section[match[2]]; !ok {
+			t.Errorf("Unresolved reference #/components/%s/%s", match[1], match[2])
+		}
+	}
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("Unexpected OpenAPI version %q", doc.OpenAPI)
+	}
+}
+
+// jsonFields returns the JSON names of the fields of a struct type, walking
+// embedded structs like encoding/json, and whether each is always present.
+func jsonFields(typ reflect.Type) map[string]bool {
+	fields := map[string]bool{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Anonymous {
+			for name, always := range jsonFields(field.Type) {
+				fields[name] = always
+			}
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = !strings.Contains(options, "omitempty")
+	}
+	return fields
+}
+
+func TestOpenAPISchemasMatchBodies(t *testing.T) {
+	doc := loadOpenAPIDocument(t)
+
+	// Response bodies always carry their fields but the omitted ones, so the
+	// schema must require exactly those. Request bodies are only checked for
+	// their properties: handlers decide which ones are mandatory.
+	responses := map[string]any{
+		"Error":           apiErrorBody{},
+		"ErrorDetail":     apiErrorDetail{},
+		"Pagination":      Pagination{},
+		"Copropriete":     apiCopropriete{},
+		"Person":          apiPerson{},
+		"Bill":            apiBill{},
+		"Provision":       apiProvision{},
+		"Balance":         apiBalance{},
+		"Period":          apiPeriodBody{},
+		"WorksFund":       apiWorksFund{},
+		"CategoryTotal":   apiCategoryTotal{},
+		"SupplierTotal":   apiSupplierTotal{},
+		"DashboardCounts": apiDashboardCounts{},
+		"Dashboard":       apiDashboard{},
+		"PersonList":      apiListBody{},
+		"BillList":        apiListBody{},
+		"ProvisionList":   apiListBody{},
+		"CoproprieteList": apiListBody{},
+		"BalanceList":     apiListBody{},
+		"PersonItem":      apiItemBody{},
+		"BillItem":        apiItemBody{},
+		"ProvisionItem":   apiItemBody{},
+		"DashboardItem":   apiItemBody{},
+	}
+	requests := map[string]any{
+		"PersonInput":    apiPersonInput{},
+		"BillInput":      apiBillInput{},
+		"ProvisionInput": apiEntryInput{},
+	}
+
+	check := func(name string, body any, response bool) {
+		schema, ok := doc.Components.Schemas[name]
+		if !ok {
+			t.Errorf("Missing schema %s", name)
+			return
+		}
+		fields := jsonFields(reflect.TypeOf(body))
+
+		var properties, names, required, always []string
+		for property := range schema.Properties {
+			properties = append(properties, property)
+		}
+		for field, present := range fields {
+			names = append(names, field)
+			if present {
+				always = append(always, field)
+			}
+		}
+		required = append(required, schema.Required...)
+		sort.Strings(properties)
+		sort.Strings(names)
+		sort.Strings(required)
+		sort.Strings(always)
+
+		if !slices.Equal(properties, names) {
+			t.Errorf("Schema %s has properties %v, %T encodes %v", name, properties, body, names)
+		}
+		if response && !slices.Equal(required, always) {
+			t.Errorf("Schema %s requires %v, %T always encodes %v", name, required, body, always)
+		}
+	}
+
+	for name, body := range responses {
+		check(name, body, true)
+	}
+	for name, body := range requests {
+		check(name, body, false)
+	}
+}
+
+func TestAPIOpenAPIHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	APIOpenAPIHandler(w, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Unexpected response %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !json.Valid(w.Body.Bytes()) {
+		t.Error("Expected the document to be valid JSON")
+	}
+}
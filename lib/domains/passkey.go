@@ -0,0 +1,526 @@
+package domains
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+
+	"github.com/go-session/session/v3"
+)
+
+const (
+	maxPasskeyNameLength = 100
+	defaultPasskeyName   = "Passkey"
+
+	// passkeyChallengeLifetime is how long a ceremony may take, from the
+	// options to the response of the authenticator.
+	passkeyChallengeLifetime = 5 * time.Minute
+	passkeyLoginChallenge    = "webauthn:login"
+)
+
+// Passkey is a WebAuthn credential a user logs in with instead of their
+// password.
+type Passkey struct {
+	ID         int
+	Name       string
+	CreatedAt  time.Time
+	LastUsedAt time.Time // zero for a passkey never used
+}
+
+// passkeyCreationOptions and passkeyRequestOptions are the options of
+// navigator.credentials.create() and get(), with their buffers base64url
+// encoded.
+type passkeyCreationOptions struct {
+	Challenge              string                       `json:"challenge"`
+	RP                     passkeyRelyingParty          `json:"rp"`
+	User                   passkeyUser                  `json:"user"`
+	PubKeyCredParams       []passkeyCredentialParameter `json:"pubKeyCredParams"`
+	Timeout                int64                        `json:"timeout"`
+	Attestation            string                       `json:"attestation"`
+	AuthenticatorSelection passkeyAuthenticatorCriteria `json:"authenticatorSelection"`
+	ExcludeCredentials     []passkeyDescriptor          `json:"excludeCredentials"`
+}
+
+type passkeyRequestOptions struct {
+	Challenge        string              `json:"challenge"`
+	RPID             string              `json:"rpId"`
+	Timeout          int64               `json:"timeout"`
+	UserVerification string              `json:"userVerification"`
+	AllowCredentials []passkeyDescriptor `json:"allowCredentials"`
+}
+
+type passkeyRelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type passkeyUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type passkeyCredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type passkeyAuthenticatorCriteria struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+type passkeyDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// PasskeyRegistrationOptionsHandler starts the registration of a passkey
+// for the logged in user. Passkeys are discoverable, so that logging in
+// does not ask for the email first.
+func PasskeyRegistrationOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	store, err := session.Start(context.Background(), w, r)
+	if err != nil {
+		log.Printf("Session error: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	config, err := webAuthnConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var name, email string
+	if err := db.QueryRow("SELECT COALESCE(name, ''), COALESCE(email, '') FROM users WHERE id = $1", userID).Scan(&name, &email); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if name == "" {
+		name = email
+	}
+
+	// The passkeys already registered are excluded, so that an
+	// authenticator is not registered twice.
+	exclude := []passkeyDescriptor{}
+	rows, err := db.Query("SELECT credentialId FROM webauthn_credentials WHERE userId = $1", userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var credentialID string
+		if err := rows.Scan(&credentialID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		exclude = append(exclude, passkeyDescriptor{Type: "public-key", ID: credentialID})
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	challenge, err := startPasskeyCeremony(store, passkeyRegistrationChallenge(userID), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	options := passkeyCreationOptions{
+		Challenge:              challenge,
+		RP:                     passkeyRelyingParty{ID: config.RPID, Name: config.RPName},
+		User:                   passkeyUser{ID: passkeyUserHandle(userID), Name: email, DisplayName: name},
+		Timeout:                passkeyChallengeLifetime.Milliseconds(),
+		Attestation:            "none",
+		AuthenticatorSelection: passkeyAuthenticatorCriteria{ResidentKey: "required", RequireResidentKey: true, UserVerification: "preferred"},
+		ExcludeCredentials:     exclude,
+	}
+	for _, algorithm := range helpers.WebAuthnAlgorithms {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, passkeyCredentialParameter{Type: "public-key", Alg: algorithm})
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, options)
+}
+
+// AddPasskeyHandler registers the passkey created by the browser from the
+// options of PasskeyRegistrationOptionsHandler.
+func AddPasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	name, err := parsePasskeyName(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	store, err := session.Start(context.Background(), w, r)
+	if err != nil {
+		log.Printf("Session error: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	config, err := webAuthnConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	challenge, ok := takePasskeyChallenge(store, passkeyRegistrationChallenge(userID), time.Now())
+	if !ok {
+		http.Redirect(w, r, "/account#passkey_failed", http.StatusFound)
+		return
+	}
+
+	response, err := helpers.ParseWebAuthnResponse(r.FormValue("credential"))
+	if err != nil {
+		http.Redirect(w, r, "/account#passkey_failed", http.StatusFound)
+		return
+	}
+	credential, err := config.VerifyRegistration(challenge, response)
+	if err != nil {
+		log.Printf("Passkey registration rejected: %v", err)
+		http.Redirect(w, r, "/account#passkey_failed", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := db.Exec("INSERT INTO webauthn_credentials (credentialId, publicKey, signCount, name, userId) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (credentialId) DO NOTHING", credential.ID, credential.PublicKey, credential.SignCount, name, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Redirect(w, r, "/account#passkey_failed", http.StatusFound)
+		return
+	}
+
+	http.Redirect(w, r, "/account#passkey_added", http.StatusFound)
+}
+
+func RenamePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	passkeyID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid passkey id", http.StatusBadRequest)
+		return
+	}
+
+	name, err := parsePasskeyName(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := db.Exec("UPDATE webauthn_credentials SET name = $1 WHERE id = $2 AND userId = $3", name, passkeyID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/account#passkey_renamed", http.StatusFound)
+}
+
+func RevokePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	passkeyID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid passkey id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM webauthn_credentials WHERE id = $1 AND userId = $2", passkeyID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "Passkey not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/account#passkey_revoked", http.StatusFound)
+}
+
+// PasskeyLoginOptionsHandler starts a passkey login. No credential is
+// listed: the browser offers the passkeys it knows for the site.
+func PasskeyLoginOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	store, err := session.Start(context.Background(), w, r)
+	if err != nil {
+		log.Printf("Session error: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	config, err := webAuthnConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	challenge, err := startPasskeyCeremony(store, passkeyLoginChallenge, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, passkeyRequestOptions{
+		Challenge:        challenge,
+		RPID:             config.RPID,
+		Timeout:          passkeyChallengeLifetime.Milliseconds(),
+		UserVerification: "preferred",
+		AllowCredentials: []passkeyDescriptor{},
+	})
+}
+
+// PasskeyLoginHandler logs in the owner of the passkey used, as LoginHandler
+// does with a password. A locked account stays locked, and a user who
+// enabled two-factor authentication is still asked for a code unless the
+// authenticator verified them with a PIN or a biometric.
+func PasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	store, err := session.Start(context.Background(), w, r)
+	if err != nil {
+		log.Printf("Session error: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	config, err := webAuthnConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	challenge, ok := takePasskeyChallenge(store, passkeyLoginChallenge, time.Now())
+	if !ok {
+		http.Redirect(w, r, "/login#passkey_failed", http.StatusFound)
+		return
+	}
+
+	response, err := helpers.ParseWebAuthnResponse(r.FormValue("credential"))
+	if err != nil {
+		http.Redirect(w, r, "/login#passkey_failed", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var passkeyID int
+	var userID, email string
+	var needsPasswordReset bool
+	credential := helpers.WebAuthnCredential{ID: response.RawID}
+	err = db.QueryRow("SELECT c.id, c.publicKey, c.signCount, u.id, COALESCE(u.email, ''), COALESCE(u.needs_password_reset, FALSE) FROM webauthn_credentials c JOIN users u ON u.id = c.userId WHERE c.credentialId = $1", response.RawID).
+		Scan(&passkeyID, &credential.PublicKey, &credential.SignCount, &userID, &email, &needsPasswordReset)
+	if err == sql.ErrNoRows {
+		http.Redirect(w, r, "/login#passkey_failed", http.StatusFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rateLimiter := helpers.GetRateLimiter()
+	if rateLimiter.IsLocked(email) {
+		remaining := rateLimiter.GetLockoutRemaining(email)
+		minutes := int(remaining.Minutes()) + 1
+		http.Redirect(w, r, fmt.Sprintf("/login#locked-%d", minutes), http.StatusFound)
+		return
+	}
+
+	assertion, err := config.VerifyAssertion(challenge, credential, response)
+	if err == nil && string(assertion.UserHandle) != userID {
+		err = fmt.Errorf("%w: user handle does not match", helpers.ErrWebAuthnInvalid)
+	}
+	if errors.Is(err, helpers.ErrWebAuthnClonedAuthenticator) {
+		log.Printf("Passkey %d of user %s may have been cloned: %v", passkeyID, userID, err)
+	}
+	if err != nil {
+		http.Redirect(w, r, "/login#passkey_failed", http.StatusFound)
+		return
+	}
+
+	if _, err := db.Exec("UPDATE webauthn_credentials SET signCount = $1, lastUsedAt = NOW() WHERE id = $2", assertion.SignCount, passkeyID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rateLimiter.ResetAttempts(email)
+
+	if !assertion.UserVerified {
+		twoFactorEnabled, err := isTwoFactorEnabled(db, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if twoFactorEnabled {
+			if err := startTwoFactorLogin(w, userID, time.Now()); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, "/login/2fa", http.StatusFound)
+			return
+		}
+	}
+
+	completeLogin(w, r, store, db, userID, needsPasswordReset)
+}
+
+func getPasskeys(db *sql.DB, userID string) ([]Passkey, error) {
+	rows, err := db.Query("SELECT id, name, createdAt, lastUsedAt FROM webauthn_credentials WHERE userId = $1 ORDER BY createdAt DESC, id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var passkeys []Passkey
+	for rows.Next() {
+		var passkey Passkey
+		var lastUsedAt sql.NullTime
+		if err := rows.Scan(&passkey.ID, &passkey.Name, &passkey.CreatedAt, &lastUsedAt); err != nil {
+			return nil, err
+		}
+		passkey.LastUsedAt = lastUsedAt.Time
+		passkeys = append(passkeys, passkey)
+	}
+	return passkeys, rows.Err()
+}
+
+func parsePasskeyName(r *http.Request) (string, error) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		return defaultPasskeyName, nil
+	}
+	if len([]rune(name)) > maxPasskeyNameLength {
+		return "", fmt.Errorf("name cannot exceed %d characters", maxPasskeyNameLength)
+	}
+	return name, nil
+}
+
+// webAuthnConfig scopes passkeys to the host of DOMAIN.
+func webAuthnConfig() (helpers.WebAuthnConfig, error) {
+	domain := os.Getenv("DOMAIN")
+	if domain == "" {
+		domain = "http://localhost:8080"
+	}
+	return helpers.NewWebAuthnConfig(domain)
+}
+
+// passkeyUserHandle is the WebAuthn user handle of userID, which
+// authenticators return when logging in.
+func passkeyUserHandle(userID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(userID))
+}
+
+func passkeyRegistrationChallenge(userID string) string {
+	return "webauthn:registration:" + userID
+}
+
+// startPasskeyCeremony returns a new challenge, kept under key in the
+// session of the browser until takePasskeyChallenge.
+func startPasskeyCeremony(store session.Store, key string, now time.Time) (string, error) {
+	challenge, err := helpers.NewWebAuthnChallenge()
+	if err != nil {
+		return "", fmt.Errorf("error generating challenge: %w", err)
+	}
+
+	store.Set(key, challenge+"."+strconv.FormatInt(now.Add(passkeyChallengeLifetime).Unix(), 10))
+	if err := store.Save(); err != nil {
+		return "", fmt.Errorf("error saving challenge: %w", err)
+	}
+	return challenge, nil
+}
+
+// takePasskeyChallenge returns the challenge kept under key unless it
+// expired. A challenge is only returned once, so that a response cannot be
+// replayed.
+func takePasskeyChallenge(store session.Store, key string, now time.Time) (string, bool) {
+	value, ok := store.Get(key)
+	if !ok {
+		return "", false
+	}
+	store.Delete(key)
+	if err := store.Save(); err != nil {
+		log.Printf("Session save error: %v", err)
+		return "", false
+	}
+
+	challenge, expiry, ok := strings.Cut(fmt.Sprintf("%v", value), ".")
+	if !ok {
+		return "", false
+	}
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || now.Unix() >= expiresAt {
+		return "", false
+	}
+	return challenge, true
+}
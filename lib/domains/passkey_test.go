@@ -0,0 +1,86 @@
+package domains
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memorySession is a session.Store kept in memory.
+type memorySession map[string]any
+
+func (s memorySession) Context() context.Context  { return context.Background() }
+func (s memorySession) SessionID() string         { return "test" }
+func (s memorySession) Set(key string, value any) { s[key] = value }
+func (s memorySession) Save() error               { return nil }
+func (s memorySession) Flush() error              { clear(s); return nil }
+
+func (s memorySession) Get(key string) (any, bool) {
+	value, ok := s[key]
+	return value, ok
+}
+
+func (s memorySession) Delete(key string) any {
+	value := s[key]
+	delete(s, key)
+	return value
+}
+
+func TestPasskeyChallenge(t *testing.T) {
+	now := time.Now()
+	store := memorySession{}
+
+	challenge, err := startPasskeyCeremony(store, passkeyLoginChallenge, now)
+	if err != nil {
+		t.Fatalf("startPasskeyCeremony failed: %v", err)
+	}
+	if _, ok := takePasskeyChallenge(store, passkeyRegistrationChallenge("42"), now); ok {
+		t.Error("Expected the challenge of another ceremony to be missing")
+	}
+	if taken, ok := takePasskeyChallenge(store, passkeyLoginChallenge, now); !ok || taken != challenge {
+		t.Errorf("Expected %q, got %q, %v", challenge, taken, ok)
+	}
+	if _, ok := takePasskeyChallenge(store, passkeyLoginChallenge, now); ok {
+		t.Error("Expected the challenge to be used only once")
+	}
+
+	if _, err := startPasskeyCeremony(store, passkeyLoginChallenge, now); err != nil {
+		t.Fatalf("startPasskeyCeremony failed: %v", err)
+	}
+	if _, ok := takePasskeyChallenge(store, passkeyLoginChallenge, now.Add(passkeyChallengeLifetime)); ok {
+		t.Error("Expected the challenge to expire")
+	}
+	if len(store) != 0 {
+		t.Errorf("Expected expired challenges to be removed, got %v", store)
+	}
+}
+
+func TestPasskeyUserHandle(t *testing.T) {
+	handle, err := base64.RawURLEncoding.DecodeString(passkeyUserHandle("42"))
+	if err != nil || string(handle) != "42" {
+		t.Errorf("Unexpected user handle %q (%v)", handle, err)
+	}
+}
+
+func TestParsePasskeyName(t *testing.T) {
+	newRequest := func(name string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/account/passkeys", strings.NewReader(url.Values{"name": {name}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	if name, err := parsePasskeyName(newRequest(" iPhone ")); err != nil || name != "iPhone" {
+		t.Errorf("Expected iPhone, got %q (%v)", name, err)
+	}
+	if name, err := parsePasskeyName(newRequest("")); err != nil || name != defaultPasskeyName {
+		t.Errorf("Expected the default name, got %q (%v)", name, err)
+	}
+	if _, err := parsePasskeyName(newRequest(strings.Repeat("a", maxPasskeyNameLength+1))); err == nil {
+		t.Error("Expected a long name to be rejected")
+	}
+}
@@ -0,0 +1,186 @@
+package domains
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+const (
+	passwordResetPurpose  = "password-reset"
+	passwordResetLifetime = time.Hour
+	// passwordResetThrottle is the delay before another reset link can be
+	// sent to the same account.
+	passwordResetThrottle = 2 * time.Minute
+)
+
+// ForgotPasswordHandler emails a reset link to the owner of an email address.
+// The answer is the same whether or not the address has an account, so that
+// it cannot be used to find out who is registered.
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	email := strings.TrimSpace(r.FormValue("email"))
+	if !isValidEmail(email) {
+		http.Redirect(w, r, "/forgot-password#invalid", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var userID string
+	err = db.QueryRow("SELECT id FROM users WHERE email = $1", email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		http.Redirect(w, r, "/forgot-password#sent", http.StatusFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var recent int
+	err = db.QueryRow("SELECT COUNT(*) FROM password_resets WHERE userId = $1 AND createdAt > NOW() - make_interval(secs => $2)", userID, passwordResetThrottle.Seconds()).Scan(&recent)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if recent > 0 {
+		http.Redirect(w, r, "/forgot-password#sent", http.StatusFound)
+		return
+	}
+
+	token, err := issuePasswordReset(db, userID, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = enqueueEmail(db, userID, email, "password-reset", struct{ Link string }{os.Getenv("DOMAIN") + passwordResetPath(token)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/forgot-password#sent", http.StatusFound)
+}
+
+// ConfirmPasswordResetHandler sets the new password of the owner of a reset
+// token. The token is consumed and every session of the user is ended.
+func ConfirmPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	newPassword := r.FormValue("new_password")
+	confirmPassword := r.FormValue("confirm_password")
+
+	if newPassword != confirmPassword {
+		http.Redirect(w, r, passwordResetPath(token)+"#mismatch", http.StatusFound)
+		return
+	}
+
+	if err := helpers.ValidatePasswordStrength(newPassword); err != nil {
+		http.Redirect(w, r, passwordResetPath(token)+"#weak", http.StatusFound)
+		return
+	}
+
+	userID, err := helpers.VerifySignedToken(passwordResetPurpose, token, time.Now())
+	if err != nil {
+		http.Redirect(w, r, "/forgot-password#expired", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	hashedPassword, err := helpers.HashPassword(newPassword)
+	if err != nil {
+		log.Printf("Failed to hash password: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	email, err := resetPassword(db, userID, token, hashedPassword)
+	if errors.Is(err, helpers.ErrInvalidSignedToken) {
+		http.Redirect(w, r, "/forgot-password#expired", http.StatusFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	helpers.GetRateLimiter().ResetAttempts(email)
+	http.Redirect(w, r, "/login#password_reset", http.StatusFound)
+}
+
+// issuePasswordReset returns a new reset token for userID. Only its hash is
+// stored, and the links sent before stop working.
+func issuePasswordReset(db *sql.DB, userID string, now time.Time) (string, error) {
+	token, err := helpers.SignToken(passwordResetPurpose, userID, now.Add(passwordResetLifetime))
+	if err != nil {
+		return "", fmt.Errorf("error generating password reset token: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("DELETE FROM password_resets WHERE userId = $1 AND usedAt IS NULL", userID); err != nil {
+		return "", err
+	}
+	_, err = tx.Exec("INSERT INTO password_resets (tokenHash, expiresAt, userId) VALUES ($1, NOW() + make_interval(secs => $2), $3)", helpers.HashToken(token), passwordResetLifetime.Seconds(), userID)
+	if err != nil {
+		return "", err
+	}
+
+	return token, tx.Commit()
+}
+
+// resetPassword consumes the reset token of userID and replaces their
+// password, revoking their sessions. It returns the email of the user, or
+// ErrInvalidSignedToken when the token was already used or replaced.
+func resetPassword(db *sql.DB, userID, token, hashedPassword string) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.Exec("UPDATE password_resets SET usedAt = NOW() WHERE tokenHash = $1 AND userId = $2 AND usedAt IS NULL AND expiresAt > NOW()", helpers.HashToken(token), userID)
+	if err != nil {
+		return "", err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return "", helpers.ErrInvalidSignedToken
+	}
+
+	var email string
+	err = tx.QueryRow("UPDATE users SET password = $1, needs_password_reset = FALSE, sessionsRevokedAt = NOW() WHERE id = $2 RETURNING email", hashedPassword, userID).Scan(&email)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec("DELETE FROM password_resets WHERE userId = $1 AND usedAt IS NULL", userID); err != nil {
+		return "", err
+	}
+
+	return email, tx.Commit()
+}
+
+func passwordResetPath(token string) string {
+	return "/reset-password/confirm?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,51 @@
+package domains
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+func TestPasswordResetPath(t *testing.T) {
+	token, err := helpers.SignToken(passwordResetPurpose, "42", time.Now().Add(passwordResetLifetime))
+	if err != nil {
+		t.Fatalf("SignToken failed: %v", err)
+	}
+
+	path, err := url.Parse(passwordResetPath(token))
+	if err != nil {
+		t.Fatalf("Invalid path: %v", err)
+	}
+	if path.Path != "/reset-password/confirm" || path.Query().Get("token") != token {
+		t.Errorf("Unexpected path %q", path)
+	}
+
+	userID, err := helpers.VerifySignedToken(passwordResetPurpose, path.Query().Get("token"), time.Now())
+	if err != nil || userID != "42" {
+		t.Errorf("Expected the token to survive the URL, got %q (%v)", userID, err)
+	}
+}
+
+func TestIsSessionValidUsesMicroseconds(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	startedAt := time.Date(2025, time.March, 3, 10, 0, 0, 250_000_000, time.UTC).UnixMicro()
+	mock.ExpectQuery(`sessionsRevokedAt <= to_timestamp\(\$2 / 1e6\)::timestamp`).
+		WithArgs("42", startedAt).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	if valid, err := isSessionValid(db, "42", startedAt); err != nil || !valid {
+		t.Errorf("Expected a valid session, got %v, %v", valid, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
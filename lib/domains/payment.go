@@ -0,0 +1,361 @@
+package domains
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+var paymentMethods = []PaymentMethod{
+	{Code: "virement", Label: "Virement"},
+	{Code: "prelevement", Label: "Prélèvement"},
+	{Code: "cheque", Label: "Chèque"},
+	{Code: "especes", Label: "Espèces"},
+	{Code: "carte", Label: "Carte bancaire"},
+}
+
+type PaymentMethod struct {
+	Code  string
+	Label string
+}
+
+// Payment is money actually received from a person. Payments are not tied to
+// a given call for funds: they settle the oldest calls first.
+type Payment struct {
+	ID        int
+	PersonID  int
+	Date      time.Time
+	Amount    Money
+	Method    string
+	Reference string
+}
+
+// MethodLabel returns the display name of the payment method.
+func (payment Payment) MethodLabel() string {
+	for _, method := range paymentMethods {
+		if method.Code == payment.Method {
+			return method.Label
+		}
+	}
+	return payment.Method
+}
+
+// Call is the share of a provision called from one person, along with the
+// part of it already settled by their payments.
+type Call struct {
+	Provision Provision
+	Amount    Money
+	Paid      Money
+}
+
+func (call Call) Left() Money {
+	return call.Amount - call.Paid
+}
+
+// ApplyPayments settles calls oldest-first with the total of payments. Calls
+// must be sorted by date. Credit calls, such as credit notes, are added to the
+// payments and count as settled. It returns the settled calls and the credit
+// left once every call is paid.
+func ApplyPayments(calls []Call, payments []Payment) ([]Call, Money) {
+	var available Money
+	for _, payment := range payments {
+		available += payment.Amount
+	}
+	for _, call := range calls {
+		if call.Amount < 0 {
+			available -= call.Amount
+		}
+	}
+
+	settled := make([]Call, len(calls))
+	for i, call := range calls {
+		call.Paid = 0
+		switch {
+		case call.Amount < 0:
+			call.Paid = call.Amount
+		case available > 0:
+			call.Paid = min(call.Amount, available)
+			available -= call.Paid
+		}
+		settled[i] = call
+	}
+
+	return settled, max(available, 0)
+}
+
+type paymentFormData struct {
+	Payment *Payment
+	Persons []Person
+	Methods []PaymentMethod
+	Today   time.Time
+}
+
+func AddPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	payment, err := parsePayment(db, r, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = db.Exec("INSERT INTO payments (personId, date, amount, method, reference, userId, coproprieteId) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		payment.PersonID, payment.Date, payment.Amount, payment.Method, payment.Reference, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#payment_added", http.StatusFound)
+}
+
+func UpdatePaymentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	paymentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid payment id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, coproprieteID, err := getPayment(db, paymentID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Payment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	payment, err := parsePayment(db, r, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = db.Exec("UPDATE payments SET personId = $1, date = $2, amount = $3, method = $4, reference = $5 WHERE id = $6 AND userId = $7",
+		payment.PersonID, payment.Date, payment.Amount, payment.Method, payment.Reference, paymentID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#payment_updated", http.StatusFound)
+}
+
+func DeletePaymentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	paymentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid payment id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM payments WHERE id = $1 AND userId = $2", paymentID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "Payment not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#payment_deleted", http.StatusFound)
+}
+
+func PaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderPaymentForm(w, db, userID, coproprieteID, nil)
+}
+
+func EditPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	paymentID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid payment id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	payment, coproprieteID, err := getPayment(db, paymentID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Payment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderPaymentForm(w, db, userID, coproprieteID, &payment)
+}
+
+// getPayment loads a payment by id along with the id of its copropriete,
+// returning sql.ErrNoRows when it does not exist or belongs to another user.
+func getPayment(db *sql.DB, paymentID int, userID string) (Payment, int, error) {
+	var payment Payment
+	var coproprieteID int
+	err := db.QueryRow("SELECT id, personId, date, amount, method, reference, coproprieteId FROM payments WHERE id = $1 AND userId = $2", paymentID, userID).
+		Scan(&payment.ID, &payment.PersonID, &payment.Date, &payment.Amount, &payment.Method, &payment.Reference, &coproprieteID)
+	return payment, coproprieteID, err
+}
+
+func getPayments(db *sql.DB, userID string, coproprieteID int) ([]Payment, error) {
+	rows, err := db.Query("SELECT id, personId, date, amount, method, reference FROM payments WHERE userId = $1 AND coproprieteId = $2 ORDER BY date, id", userID, coproprieteID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var payments []Payment
+	for rows.Next() {
+		var payment Payment
+		if err := rows.Scan(&payment.ID, &payment.PersonID, &payment.Date, &payment.Amount, &payment.Method, &payment.Reference); err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
+
+// parsePayment reads a payment from the form, checking that the paying person
+// belongs to the copropriete.
+func parsePayment(db *sql.DB, r *http.Request, userID string, coproprieteID int) (Payment, error) {
+	var payment Payment
+
+	personID, err := strconv.Atoi(r.FormValue("person_id"))
+	if err != nil {
+		return payment, fmt.Errorf("invalid person value")
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM persons WHERE id = $1 AND userId = $2 AND coproprieteId = $3", personID, userID, coproprieteID).Scan(&count)
+	if err != nil {
+		return payment, err
+	}
+	if count == 0 {
+		return payment, fmt.Errorf("invalid person value")
+	}
+	payment.PersonID = personID
+
+	payment.Date, err = time.Parse(dateLayout, r.FormValue("date"))
+	if err != nil {
+		return payment, fmt.Errorf("invalid date value")
+	}
+
+	payment.Amount, err = ParseMoney(r.FormValue("amount"))
+	if err != nil || payment.Amount <= 0 {
+		return payment, fmt.Errorf("invalid amount value")
+	}
+
+	payment.Method = r.FormValue("method")
+	valid := false
+	for _, method := range paymentMethods {
+		valid = valid || method.Code == payment.Method
+	}
+	if !valid {
+		return payment, fmt.Errorf("invalid payment method")
+	}
+
+	payment.Reference = r.FormValue("reference")
+	return payment, nil
+}
+
+func renderPaymentForm(w http.ResponseWriter, db *sql.DB, userID string, coproprieteID int, payment *Payment) {
+	persons, err := getPersons(db, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t, err := template.ParseFiles("lib/templates/edit-payments.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	data := paymentFormData{
+		Payment: payment,
+		Persons: persons,
+		Methods: paymentMethods,
+		Today:   time.Now(),
+	}
+
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,93 @@
+package domains
+
+import (
+	"testing"
+)
+
+func TestApplyPaymentsOldestFirst(t *testing.T) {
+	calls := []Call{
+		{Provision: Provision{Label: "T1"}, Amount: 30000},
+		{Provision: Provision{Label: "T2"}, Amount: 30000},
+		{Provision: Provision{Label: "T3"}, Amount: 30000},
+	}
+	payments := []Payment{{Amount: 20000}, {Amount: 25000}}
+
+	settled, credit := ApplyPayments(calls, payments)
+
+	expected := []Money{30000, 15000, 0}
+	for i, call := range settled {
+		if call.Paid != expected[i] {
+			t.Errorf("Call %s: expected %s paid, got %s", call.Provision.Label, expected[i], call.Paid)
+		}
+	}
+	if settled[1].Left() != 15000 {
+		t.Errorf("Expected 150,00 left on T2, got %s", settled[1].Left())
+	}
+	if credit != 0 {
+		t.Errorf("Expected no credit, got %s", credit)
+	}
+	if calls[0].Paid != 0 {
+		t.Error("ApplyPayments should not modify its input")
+	}
+}
+
+func TestApplyPaymentsOverpayment(t *testing.T) {
+	calls := []Call{{Amount: 10000}, {Amount: -5000}, {Amount: 10000}}
+
+	settled, credit := ApplyPayments(calls, []Payment{{Amount: 25000}})
+
+	if settled[0].Paid != 10000 || settled[1].Left() != 0 || settled[2].Paid != 10000 {
+		t.Errorf("Unexpected settlement %+v", settled)
+	}
+	if credit != 10000 {
+		t.Errorf("Expected a 100,00 credit, got %s", credit)
+	}
+}
+
+func TestApplyPaymentsCreditCall(t *testing.T) {
+	// A credit note reduces what is owed on the other calls, even before any
+	// payment.
+	calls := []Call{{Provision: Provision{Label: "T1"}, Amount: 30000}, {Provision: Provision{Label: "Avoir"}, Amount: -10000}}
+
+	settled, credit := ApplyPayments(calls, nil)
+
+	if settled[0].Left() != 20000 {
+		t.Errorf("Expected 200,00 left on T1, got %s", settled[0].Left())
+	}
+	if settled[1].Left() != 0 {
+		t.Errorf("Expected the credit call to be settled, got %s left", settled[1].Left())
+	}
+	if credit != 0 {
+		t.Errorf("Expected no credit, got %s", credit)
+	}
+}
+
+func TestCalculateOutstanding(t *testing.T) {
+	persons := []Person{
+		{ID: 1, Name: "John Doe", Tantieme: 1},
+		{ID: 2, Name: "Jane Doe", Tantieme: 1},
+	}
+	allocator := NewAllocator(persons)
+	provisions := []Provision{{Label: "T1", Amount: 100000}, {Label: "T2", Amount: 100000}}
+	payments := []Payment{
+		{PersonID: 1, Amount: 60000},
+		{PersonID: 2, Amount: 120000},
+	}
+
+	john, jane := persons[0], persons[1]
+
+	if paid := john.CalculatePaid(payments); paid != 60000 {
+		t.Errorf("Expected 600,00 paid, got %s", paid)
+	}
+	if outstanding := john.CalculateOutstanding(allocator, provisions, payments, nil); outstanding != 40000 {
+		t.Errorf("Expected 400,00 outstanding, got %s", outstanding)
+	}
+	if outstanding := jane.CalculateOutstanding(allocator, provisions, payments, nil); outstanding != -20000 {
+		t.Errorf("Expected a 200,00 credit, got %s", outstanding)
+	}
+
+	calls := john.Calls(allocator, provisions, payments, nil)
+	if calls[0].Left() != 0 || calls[1].Left() != 40000 {
+		t.Errorf("Expected T1 settled and 400,00 left on T2, got %+v", calls)
+	}
+}
@@ -0,0 +1,217 @@
+package domains
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	periodAll     = ""
+	periodYear    = "year"
+	periodQuarter = "quarter"
+	periodCustom  = "custom"
+
+	dateLayout = "2006-01-02"
+)
+
+var monthNames = []string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"}
+
+// Period restricts the dashboard and the exports to part of the accounting.
+// A year selects the entries booked on that fiscal year, whatever their
+// operation date; a quarter or a custom range selects entries by operation
+// date. The zero value keeps every entry.
+type Period struct {
+	Kind            string
+	FiscalYear      int
+	Quarter         int
+	Start           time.Time
+	End             time.Time // exclusive
+	FiscalYearStart time.Month
+}
+
+// FiscalYearOf returns the fiscal year a date falls in. Fiscal years are named
+// after the calendar year they start in, so with a July start 15/03/2025
+// belongs to fiscal year 2024.
+func FiscalYearOf(date time.Time, fiscalYearStart time.Month) int {
+	if date.Month() < fiscalYearStart {
+		return date.Year() - 1
+	}
+	return date.Year()
+}
+
+// FiscalYearLabel returns "2025" for calendar fiscal years and "2024-2025"
+// for fiscal years straddling two calendar years.
+func FiscalYearLabel(fiscalYear int, fiscalYearStart time.Month) string {
+	if fiscalYearStart == time.January {
+		return strconv.Itoa(fiscalYear)
+	}
+	return fmt.Sprintf("%d-%d", fiscalYear, fiscalYear+1)
+}
+
+func fiscalYearStartDate(fiscalYear int, fiscalYearStart time.Month) time.Time {
+	return time.Date(fiscalYear, fiscalYearStart, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// YearPeriod returns the period selecting a whole fiscal year.
+func YearPeriod(fiscalYear int, fiscalYearStart time.Month) Period {
+	start := fiscalYearStartDate(fiscalYear, fiscalYearStart)
+	return Period{Kind: periodYear, FiscalYear: fiscalYear, Start: start, End: start.AddDate(1, 0, 0), FiscalYearStart: fiscalYearStart}
+}
+
+// ParsePeriod reads the period, year, quarter, from and to query parameters.
+// A year or quarter without an explicit year defaults to the fiscal year
+// containing today.
+func ParsePeriod(values url.Values, fiscalYearStart time.Month, today time.Time) (Period, error) {
+	period := Period{Kind: values.Get("period"), FiscalYearStart: fiscalYearStart}
+
+	switch period.Kind {
+	case periodAll, "all":
+		return Period{FiscalYearStart: fiscalYearStart}, nil
+
+	case periodYear, periodQuarter:
+		period.FiscalYear = FiscalYearOf(today, fiscalYearStart)
+		if value := values.Get("year"); value != "" {
+			year, err := strconv.Atoi(value)
+			if err != nil || year < 1900 || year > 9999 {
+				return Period{}, fmt.Errorf("invalid year value")
+			}
+			period.FiscalYear = year
+		}
+		period.Start = fiscalYearStartDate(period.FiscalYear, fiscalYearStart)
+		period.End = period.Start.AddDate(1, 0, 0)
+
+		if period.Kind == periodQuarter {
+			period.Quarter = int(today.Month()-fiscalYearStart+12)%12/3 + 1
+			if value := values.Get("quarter"); value != "" {
+				quarter, err := strconv.Atoi(value)
+				if err != nil || quarter < 1 || quarter > 4 {
+					return Period{}, fmt.Errorf("invalid quarter value")
+				}
+				period.Quarter = quarter
+			}
+			period.Start = period.Start.AddDate(0, 3*(period.Quarter-1), 0)
+			period.End = period.Start.AddDate(0, 3, 0)
+		}
+		return period, nil
+
+	case periodCustom:
+		from, err := time.Parse(dateLayout, values.Get("from"))
+		if err != nil {
+			return Period{}, fmt.Errorf("invalid start date")
+		}
+		to, err := time.Parse(dateLayout, values.Get("to"))
+		if err != nil {
+			return Period{}, fmt.Errorf("invalid end date")
+		}
+		if to.Before(from) {
+			return Period{}, fmt.Errorf("end date is before start date")
+		}
+		period.Start = from
+		period.End = to.AddDate(0, 0, 1)
+		return period, nil
+	}
+
+	return Period{}, fmt.Errorf("invalid period value")
+}
+
+// Includes reports whether an entry with the given operation date and fiscal
+// year belongs to the period.
+func (period Period) Includes(date time.Time, fiscalYear int) bool {
+	switch period.Kind {
+	case periodAll:
+		return true
+	case periodYear:
+		return fiscalYear == period.FiscalYear
+	}
+	return !date.Before(period.Start) && date.Before(period.End)
+}
+
+// Precedes reports whether an entry with the given operation date and fiscal
+// year was booked before the period, and so counts in the opening balances.
+func (period Period) Precedes(date time.Time, fiscalYear int) bool {
+	switch period.Kind {
+	case periodAll:
+		return false
+	case periodYear:
+		return fiscalYear < period.FiscalYear
+	}
+	return date.Before(period.Start)
+}
+
+func (period Period) Label() string {
+	last := period.LastDay().Format("02/01/2006")
+	switch period.Kind {
+	case periodYear:
+		return "Exercice " + FiscalYearLabel(period.FiscalYear, period.FiscalYearStart)
+	case periodQuarter:
+		return fmt.Sprintf("T%d exercice %s (du %s au %s)", period.Quarter, FiscalYearLabel(period.FiscalYear, period.FiscalYearStart), period.Start.Format("02/01/2006"), last)
+	case periodCustom:
+		return fmt.Sprintf("Du %s au %s", period.Start.Format("02/01/2006"), last)
+	}
+	return "Toutes les opérations"
+}
+
+// Query returns the query string selecting the period, so the export links
+// can carry the dashboard filter.
+func (period Period) Query() template.URL {
+	values := url.Values{}
+	switch period.Kind {
+	case periodYear:
+		values.Set("period", periodYear)
+		values.Set("year", strconv.Itoa(period.FiscalYear))
+	case periodQuarter:
+		values.Set("period", periodQuarter)
+		values.Set("year", strconv.Itoa(period.FiscalYear))
+		values.Set("quarter", strconv.Itoa(period.Quarter))
+	case periodCustom:
+		values.Set("period", periodCustom)
+		values.Set("from", period.Start.Format(dateLayout))
+		values.Set("to", period.LastDay().Format(dateLayout))
+	}
+	return template.URL(values.Encode())
+}
+
+// LastDay returns the last day of a bounded period, for the date inputs of
+// the filter form.
+func (period Period) LastDay() time.Time {
+	return period.End.AddDate(0, 0, -1)
+}
+
+// parseEntryDate reads the operation date and the fiscal year of a bill or a
+// provision. The fiscal year defaults to the one containing the date.
+func parseEntryDate(r *http.Request, fiscalYearStart time.Month) (time.Time, int, error) {
+	date, err := time.Parse(dateLayout, r.FormValue("date"))
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid date value")
+	}
+
+	fiscalYear := 0
+	if value := r.FormValue("fiscal_year"); value != "" {
+		fiscalYear, err = strconv.Atoi(value)
+		if err != nil {
+			return time.Time{}, 0, fmt.Errorf("invalid fiscal year value")
+		}
+	}
+
+	fiscalYear, err = checkFiscalYear(date, fiscalYear, fiscalYearStart)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	return date, fiscalYear, nil
+}
+
+// checkFiscalYear validates the fiscal year an entry is booked on. Zero
+// stands for the fiscal year containing date.
+func checkFiscalYear(date time.Time, fiscalYear int, fiscalYearStart time.Month) (int, error) {
+	if fiscalYear == 0 {
+		return FiscalYearOf(date, fiscalYearStart), nil
+	}
+	if fiscalYear < 1900 || fiscalYear > 9999 {
+		return 0, fmt.Errorf("invalid fiscal year value")
+	}
+	return fiscalYear, nil
+}
@@ -0,0 +1,183 @@
+package domains
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestFiscalYearOf(t *testing.T) {
+	tests := []struct {
+		date            time.Time
+		fiscalYearStart time.Month
+		expected        int
+	}{
+		{date(2025, time.January, 1), time.January, 2025},
+		{date(2025, time.December, 31), time.January, 2025},
+		{date(2025, time.March, 15), time.July, 2024},
+		{date(2025, time.July, 1), time.July, 2025},
+		{date(2025, time.June, 30), time.July, 2024},
+	}
+
+	for _, test := range tests {
+		if got := FiscalYearOf(test.date, test.fiscalYearStart); got != test.expected {
+			t.Errorf("FiscalYearOf(%s, %s) = %d, expected %d", test.date.Format(dateLayout), test.fiscalYearStart, got, test.expected)
+		}
+	}
+}
+
+func TestFiscalYearLabel(t *testing.T) {
+	if label := FiscalYearLabel(2025, time.January); label != "2025" {
+		t.Errorf("Expected 2025, got %s", label)
+	}
+	if label := FiscalYearLabel(2024, time.July); label != "2024-2025" {
+		t.Errorf("Expected 2024-2025, got %s", label)
+	}
+}
+
+func TestParsePeriodAll(t *testing.T) {
+	period, err := ParsePeriod(url.Values{}, time.January, date(2025, time.May, 10))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !period.Includes(date(1999, time.January, 1), 1999) {
+		t.Error("Expected the default period to include every entry")
+	}
+	if period.Query() != "" {
+		t.Errorf("Expected an empty query, got %s", period.Query())
+	}
+}
+
+func TestParsePeriodYear(t *testing.T) {
+	values := url.Values{"period": {"year"}, "year": {"2024"}}
+	period, err := ParsePeriod(values, time.July, date(2025, time.May, 10))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !period.Start.Equal(date(2024, time.July, 1)) || !period.End.Equal(date(2025, time.July, 1)) {
+		t.Errorf("Unexpected bounds %s - %s", period.Start, period.End)
+	}
+
+	// A year selects entries by fiscal year, even when dated outside of it.
+	if !period.Includes(date(2025, time.August, 3), 2024) {
+		t.Error("Expected an entry booked on 2024 to be included")
+	}
+	if period.Includes(date(2025, time.March, 3), 2025) {
+		t.Error("Expected an entry booked on 2025 to be excluded")
+	}
+
+	if label := period.Label(); label != "Exercice 2024-2025" {
+		t.Errorf("Unexpected label %s", label)
+	}
+}
+
+func TestParsePeriodQuarterDefaultsToToday(t *testing.T) {
+	values := url.Values{"period": {"quarter"}}
+	period, err := ParsePeriod(values, time.October, date(2025, time.February, 10))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if period.FiscalYear != 2024 || period.Quarter != 2 {
+		t.Fatalf("Expected Q2 of 2024, got Q%d of %d", period.Quarter, period.FiscalYear)
+	}
+	if !period.Start.Equal(date(2025, time.January, 1)) || !period.End.Equal(date(2025, time.April, 1)) {
+		t.Errorf("Unexpected bounds %s - %s", period.Start, period.End)
+	}
+	if !period.Includes(date(2025, time.March, 31), 2024) {
+		t.Error("Expected the last day of the quarter to be included")
+	}
+	if period.Includes(date(2025, time.April, 1), 2024) {
+		t.Error("Expected the first day of the next quarter to be excluded")
+	}
+
+	reparsed, err := ParsePeriod(mustParseQuery(t, string(period.Query())), time.October, date(2030, time.June, 1))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if reparsed != period {
+		t.Errorf("Query did not round-trip: %+v != %+v", reparsed, period)
+	}
+}
+
+func TestParsePeriodCustom(t *testing.T) {
+	values := url.Values{"period": {"custom"}, "from": {"2025-02-01"}, "to": {"2025-02-28"}}
+	period, err := ParsePeriod(values, time.January, date(2025, time.May, 10))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !period.Includes(date(2025, time.February, 28), 2025) {
+		t.Error("Expected the end date to be included")
+	}
+	if period.Includes(date(2025, time.March, 1), 2025) {
+		t.Error("Expected the day after the end date to be excluded")
+	}
+	if label := period.Label(); label != "Du 01/02/2025 au 28/02/2025" {
+		t.Errorf("Unexpected label %s", label)
+	}
+}
+
+func TestParsePeriodInvalid(t *testing.T) {
+	invalid := []url.Values{
+		{"period": {"decade"}},
+		{"period": {"year"}, "year": {"abc"}},
+		{"period": {"quarter"}, "quarter": {"5"}},
+		{"period": {"custom"}, "from": {"2025-02-01"}},
+		{"period": {"custom"}, "from": {"2025-02-01"}, "to": {"2025-01-01"}},
+	}
+
+	for _, values := range invalid {
+		if _, err := ParsePeriod(values, time.January, date(2025, time.May, 10)); err == nil {
+			t.Errorf("Expected an error for %v", values)
+		}
+	}
+}
+
+func mustParseQuery(t *testing.T, query string) url.Values {
+	t.Helper()
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		t.Fatalf("Invalid query %q: %v", query, err)
+	}
+	return values
+}
+
+func TestCheckFiscalYear(t *testing.T) {
+	fiscalYear, err := checkFiscalYear(date(2025, time.March, 15), 0, time.July)
+	if err != nil || fiscalYear != 2024 {
+		t.Errorf("Expected the fiscal year of the date, got %d (%v)", fiscalYear, err)
+	}
+
+	fiscalYear, err = checkFiscalYear(date(2025, time.March, 15), 2025, time.July)
+	if err != nil || fiscalYear != 2025 {
+		t.Errorf("Expected the given fiscal year, got %d (%v)", fiscalYear, err)
+	}
+
+	for _, invalid := range []int{-1, 1899, 10000} {
+		if _, err := checkFiscalYear(date(2025, time.March, 15), invalid, time.July); err == nil {
+			t.Errorf("Expected fiscal year %d to be rejected", invalid)
+		}
+	}
+}
+
+func TestPeriodPrecedes(t *testing.T) {
+	year := YearPeriod(2025, time.July)
+	if !year.Precedes(date(2025, 8, 1), 2024) || year.Precedes(date(2025, 6, 1), 2025) {
+		t.Error("Expected a year to compare fiscal years")
+	}
+
+	quarter := Period{Kind: periodQuarter, Start: date(2025, 1, 1), End: date(2025, 4, 1)}
+	if !quarter.Precedes(date(2024, 12, 31), 2024) || quarter.Precedes(date(2025, 1, 1), 2024) {
+		t.Error("Expected a quarter to compare dates")
+	}
+
+	if (Period{}).Precedes(date(1990, 1, 1), 1990) {
+		t.Error("Expected nothing to precede every operation")
+	}
+}
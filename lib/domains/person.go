@@ -1,16 +1,22 @@
 package domains
 
 import (
+	"database/sql"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/duscraft/tanzia/lib/helpers"
 )
 
 type Person struct {
-	Name     string
+	ID   int
+	Name string
+	// Tantieme is the sum of the general tantièmes of the lots the person
+	// owns today.
 	Tantieme int
 }
 
@@ -21,16 +27,39 @@ func PersonHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	t, err := template.ParseFiles("lib/templates/edit-persons.html")
+	renderPersonForm(w, nil)
+}
+
+func EditPersonHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	personID, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		log.Printf("Error parsing template: %v", err)
-		http.Error(w, "Template error", http.StatusInternalServerError)
+		http.Error(w, "Invalid person id", http.StatusBadRequest)
 		return
 	}
 
-	if err := t.Execute(w, nil); err != nil {
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+
+	person, err := getPerson(db, personID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Person not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderPersonForm(w, &person)
 }
 
 func AddPersonHandler(w http.ResponseWriter, r *http.Request) {
@@ -46,6 +75,12 @@ func AddPersonHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	canUserCreatePerson, err := helpers.CanUserCreatePerson(db, userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -57,39 +92,278 @@ func AddPersonHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tantieme, err := strconv.Atoi(r.FormValue("tantieme"))
+	_, err = db.Exec("INSERT INTO persons (name, userId, coproprieteId) VALUES ($1, $2, $3)", r.FormValue("name"), userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#person_added", http.StatusFound)
+}
+
+func UpdatePersonHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	personID, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
-		http.Error(w, "Invalid tantieme value", http.StatusBadRequest)
+		http.Error(w, "Invalid person id", http.StatusBadRequest)
 		return
 	}
 
-	_, err = db.Exec("INSERT INTO persons (name, tantieme, userId) VALUES ($1, $2, $3)", r.FormValue("name"), tantieme, userID)
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	http.Redirect(w, r, "/dashboard#person_added", http.StatusFound)
+	result, err := db.Exec("UPDATE persons SET name = $1 WHERE id = $2 AND userId = $3", r.FormValue("name"), personID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "Person not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#person_updated", http.StatusFound)
+}
+
+func DeletePersonHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	personID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid person id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	hasHistory, err := personHasHistory(db, personID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Person not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if hasHistory {
+		http.Redirect(w, r, fmt.Sprintf("/persons/%d#has_history", personID), http.StatusFound)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM persons WHERE id = $1 AND userId = $2", personID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "Person not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#person_deleted", http.StatusFound)
+}
+
+// personHasHistory reports whether a person of the user has payments,
+// regularizations or lots, which would be deleted along with them. It
+// returns sql.ErrNoRows when the person does not exist or belongs to another
+// user.
+func personHasHistory(db *sql.DB, personID int, userID string) (bool, error) {
+	var hasHistory bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM payments WHERE personId = p.id)
+		OR EXISTS (SELECT 1 FROM regularizations WHERE personId = p.id)
+		OR EXISTS (SELECT 1 FROM lot_owners WHERE personId = p.id)
+		FROM persons p WHERE p.id = $1 AND p.userId = $2`, personID, userID).Scan(&hasHistory)
+	return hasHistory, err
+}
+
+// getPerson loads a person by id, returning sql.ErrNoRows when it does not
+// exist or belongs to another user.
+func getPerson(db *sql.DB, personID int, userID string) (Person, error) {
+	var person Person
+	var coproprieteID int
+	err := db.QueryRow("SELECT id, name, coproprieteId FROM persons WHERE id = $1 AND userId = $2", personID, userID).
+		Scan(&person.ID, &person.Name, &coproprieteID)
+	if err != nil {
+		return person, err
+	}
+
+	lots, err := getLots(db, userID, coproprieteID)
+	if err != nil {
+		return person, err
+	}
+	person.Tantieme = tantiemeAt(person, lots, time.Now())
+	return person, nil
+}
+
+func getPersons(db *sql.DB, userID string, coproprieteID int) ([]Person, error) {
+	rows, err := db.Query("SELECT id, name FROM persons WHERE userId = $1 AND coproprieteId = $2 ORDER BY id", userID, coproprieteID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var persons []Person
+	for rows.Next() {
+		var person Person
+		if err := rows.Scan(&person.ID, &person.Name); err != nil {
+			return nil, err
+		}
+		persons = append(persons, person)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	lots, err := getLots(db, userID, coproprieteID)
+	if err != nil {
+		return nil, err
+	}
+	today := time.Now()
+	for i := range persons {
+		persons[i].Tantieme = tantiemeAt(persons[i], lots, today)
+	}
+	return persons, nil
+}
+
+// tantiemeAt returns the general tantièmes of the lots person owns on date.
+func tantiemeAt(person Person, lots []Lot, date time.Time) int {
+	tantieme := 0
+	for _, lot := range lots {
+		if lot.OwnerAt(date) == person.ID {
+			tantieme += lot.Tantieme
+		}
+	}
+	return tantieme
+}
+
+func renderPersonForm(w http.ResponseWriter, person *Person) {
+	t, err := template.ParseFiles("lib/templates/edit-persons.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := t.Execute(w, person); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
 }
 
-func (person *Person) CalculateDue(totalTantiemes int, bill Bill) float64 {
-	return float64(person.Tantieme) / float64(totalTantiemes) * bill.Amount
+// CalculateDue returns the share of an expense owed by the person. Expenses
+// cover their whole fiscal year, so after a sale the seller and the buyer pay
+// pro rata of the days they owned the lot.
+func (person *Person) CalculateDue(allocator Allocator, bill Bill) Money {
+	from, to := allocator.FiscalYearBounds(bill.FiscalYear)
+	return allocator.ShareOver(person, bill.Amount, bill.ChargeKeyID, from, to)
 }
 
-func (person *Person) CalculateProvision(totalTantiemes int, provision Provision) float64 {
-	return float64(person.Tantieme) / float64(totalTantiemes) * provision.Amount
+// CalculateProvision returns the share of a call for funds owed by the
+// person. A call is due by whoever owns the lot on its date.
+func (person *Person) CalculateProvision(allocator Allocator, provision Provision) Money {
+	return allocator.ShareOver(person, provision.Amount, provision.ChargeKeyID, provision.Date, provision.Date.AddDate(0, 0, 1))
 }
 
-func (person *Person) CalculateLeft(totalTantiemes int, bills []Bill, provisions []Provision) float64 {
-	var balance float64 = 0
+// CalculateLeft returns the balance of the charges of the person: what they
+// were called for minus their share of the expenses. Works fund entries are
+// left out, see CalculateWorksFund.
+func (person *Person) CalculateLeft(allocator Allocator, bills []Bill, provisions []Provision) Money {
+	var balance Money
 
 	for _, bill := range bills {
-		balance -= person.CalculateDue(totalTantiemes, bill)
+		if !bill.WorksFund {
+			balance -= person.CalculateDue(allocator, bill)
+		}
 	}
 
 	for _, provision := range provisions {
-		balance += person.CalculateProvision(totalTantiemes, provision)
+		if !provision.WorksFund {
+			balance += person.CalculateProvision(allocator, provision)
+		}
 	}
 
 	return balance
 }
+
+// CalculateWorksFund returns what the person was called for the works fund.
+// Contributions stay with the lots: they are not refunded on a sale.
+func (person *Person) CalculateWorksFund(allocator Allocator, provisions []Provision) Money {
+	var contributed Money
+	for _, provision := range provisions {
+		if provision.WorksFund {
+			contributed += person.CalculateProvision(allocator, provision)
+		}
+	}
+	return contributed
+}
+
+// Calls returns the calls for funds of the person, one per provision in the
+// order of provisions. The balance carried over from closed fiscal years is
+// settled first, then the calls oldest-first, with the person's payments.
+func (person *Person) Calls(allocator Allocator, provisions []Provision, payments []Payment, regularizations []Regularization) []Call {
+	calls := make([]Call, len(provisions))
+	for i, provision := range provisions {
+		calls[i] = Call{Provision: provision, Amount: person.CalculateProvision(allocator, provision)}
+	}
+
+	// A credit carried over pays like a payment, a debit is paid before any call.
+	own := []Payment{{PersonID: person.ID, Amount: -person.CalculateCarriedOver(regularizations)}}
+	for _, payment := range payments {
+		if payment.PersonID == person.ID {
+			own = append(own, payment)
+		}
+	}
+
+	calls, _ = ApplyPayments(calls, own)
+	return calls
+}
+
+func (person *Person) CalculatePaid(payments []Payment) Money {
+	var paid Money
+	for _, payment := range payments {
+		if payment.PersonID == person.ID {
+			paid += payment.Amount
+		}
+	}
+	return paid
+}
+
+// CalculateCarriedOver returns what the person owes from the regularizations
+// of closed fiscal years. A negative amount is a credit.
+func (person *Person) CalculateCarriedOver(regularizations []Regularization) Money {
+	var carried Money
+	for _, regularization := range regularizations {
+		if regularization.PersonID == person.ID {
+			carried -= regularization.Amount
+		}
+	}
+	return carried
+}
+
+// CalculateOutstanding returns what the person still owes on the calls for
+// funds and the balance carried over from closed fiscal years once their
+// payments are deducted. A negative amount is a credit.
+func (person *Person) CalculateOutstanding(allocator Allocator, provisions []Provision, payments []Payment, regularizations []Regularization) Money {
+	var called Money
+	for _, provision := range provisions {
+		called += person.CalculateProvision(allocator, provision)
+	}
+	return called + person.CalculateCarriedOver(regularizations) - person.CalculatePaid(payments)
+}
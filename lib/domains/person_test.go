@@ -1,79 +1,144 @@
 package domains
 
 import (
+	"database/sql"
 	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
 )
 
 func TestCalculateDue(t *testing.T) {
 	person := Person{
+		ID:       1,
 		Name:     "John Doe",
 		Tantieme: 2,
 	}
+	allocator := NewAllocator([]Person{person, {ID: 2, Name: "Jane Doe", Tantieme: 3}})
 	bill := Bill{
 		Label:  "Electricity",
-		Amount: 1000,
+		Amount: 100000,
 	}
-	totalTantiemes := 5
 
-	expectedDue := 400.0 // 2/5 * 1000
-	calculatedDue := person.CalculateDue(totalTantiemes, bill)
+	expectedDue := Money(40000) // 2/5 * 1000 €
+	calculatedDue := person.CalculateDue(allocator, bill)
 
 	if calculatedDue != expectedDue {
-		t.Errorf("Expected due %.2f, but got %.2f", expectedDue, calculatedDue)
+		t.Errorf("Expected due %s, but got %s", expectedDue, calculatedDue)
 	}
 }
 
 func TestCalculateProvision(t *testing.T) {
 	person := Person{
+		ID:       1,
 		Name:     "John Doe",
 		Tantieme: 2,
 	}
+	allocator := NewAllocator([]Person{person, {ID: 2, Name: "Jane Doe", Tantieme: 3}})
 	provision := Provision{
 		Label:  "Trimestre 1 2025",
-		Amount: 1000,
+		Amount: 100000,
 	}
-	totalTantiemes := 5
 
-	expectedProvision := 400.0 // 2/5 * 1000
-	calculatedProvision := person.CalculateProvision(totalTantiemes, provision)
+	expectedProvision := Money(40000) // 2/5 * 1000 €
+	calculatedProvision := person.CalculateProvision(allocator, provision)
 
 	if calculatedProvision != expectedProvision {
-		t.Errorf("Expected provision %.2f, but got %.2f", expectedProvision, calculatedProvision)
+		t.Errorf("Expected provision %s, but got %s", expectedProvision, calculatedProvision)
 	}
 }
 
 func TestCalculateLeft(t *testing.T) {
 	person := Person{
+		ID:       1,
 		Name:     "John Doe",
 		Tantieme: 5,
 	}
+	allocator := NewAllocator([]Person{person, {ID: 2, Name: "Jane Doe", Tantieme: 5}})
 
 	provisions := []Provision{
 		{
 			Label:  "Trimestre 1 2025",
-			Amount: 2000,
+			Amount: 200000,
 		},
 		{
 			Label:  "Trimestre 2 2025",
-			Amount: 2200,
+			Amount: 220000,
 		},
 	}
 
 	bills := []Bill{
 		{
 			Label:  "Travaux 1 2025",
-			Amount: 1800,
+			Amount: 180000,
 		},
 		{
 			Label:  "Travaux 2 2025",
-			Amount: 2600,
+			Amount: 260000,
 		},
 	}
 
-	expectedLeft := -100.0
-	calculatedLeft := person.CalculateLeft(10, bills, provisions)
+	expectedLeft := Money(-10000)
+	calculatedLeft := person.CalculateLeft(allocator, bills, provisions)
 
 	if calculatedLeft != expectedLeft {
-		t.Errorf("Expected balance of %.2f, but got %.2f", expectedLeft, calculatedLeft)
+		t.Errorf("Expected balance of %s, but got %s", expectedLeft, calculatedLeft)
+	}
+}
+
+func TestCalculateDueSumsToBillAmount(t *testing.T) {
+	persons := []Person{
+		{ID: 1, Name: "A", Tantieme: 1},
+		{ID: 2, Name: "B", Tantieme: 1},
+		{ID: 3, Name: "C", Tantieme: 1},
+	}
+	allocator := NewAllocator(persons)
+	bill := Bill{
+		Label:  "Ascenseur",
+		Amount: 100000,
+	}
+
+	var total Money
+	for _, person := range persons {
+		total += person.CalculateDue(allocator, bill)
+	}
+
+	if total != bill.Amount {
+		t.Errorf("Expected shares to sum to %s, but got %s", bill.Amount, total)
+	}
+}
+
+func TestCalculateDueForUnknownPerson(t *testing.T) {
+	allocator := NewAllocator([]Person{{ID: 1, Name: "A", Tantieme: 1}})
+	stranger := Person{ID: 42, Name: "Stranger", Tantieme: 1}
+
+	if due := stranger.CalculateDue(allocator, Bill{Amount: 100000}); due != 0 {
+		t.Errorf("Expected no due for a person outside the allocator, but got %s", due)
+	}
+}
+
+func TestPersonHasHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	query := `SELECT EXISTS \(SELECT 1 FROM payments WHERE personId = p.id\)\s+OR EXISTS \(SELECT 1 FROM regularizations WHERE personId = p.id\)\s+OR EXISTS \(SELECT 1 FROM lot_owners WHERE personId = p.id\)\s+FROM persons p WHERE p.id = \$1 AND p.userId = \$2`
+	mock.ExpectQuery(query).WithArgs(1, "42").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(query).WithArgs(2, "42").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(query).WithArgs(3, "42").WillReturnRows(sqlmock.NewRows([]string{"exists"}))
+
+	if hasHistory, err := personHasHistory(db, 1, "42"); err != nil || !hasHistory {
+		t.Errorf("Expected a person with history, got %v, %v", hasHistory, err)
+	}
+	if hasHistory, err := personHasHistory(db, 2, "42"); err != nil || hasHistory {
+		t.Errorf("Expected a person without history, got %v, %v", hasHistory, err)
+	}
+	if _, err := personHasHistory(db, 3, "42"); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows for another user's person, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
 	}
 }
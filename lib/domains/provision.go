@@ -1,17 +1,33 @@
 package domains
 
 import (
+	"database/sql"
 	"html/template"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/duscraft/tanzia/lib/helpers"
 )
 
 type Provision struct {
-	Label  string
-	Amount float64
+	ID          int
+	Label       string
+	Amount      Money
+	ChargeKeyID int
+	Date        time.Time
+	FiscalYear  int
+	// WorksFund books the provision on the works fund (fonds de travaux) rather
+	// than on the charges of the fiscal year.
+	WorksFund   bool
+	Attachments []Attachment
+}
+
+type provisionFormData struct {
+	Provision  *Provision
+	ChargeKeys []ChargeKey
+	Today      time.Time
 }
 
 func AddProvisionHandler(w http.ResponseWriter, r *http.Request) {
@@ -27,6 +43,12 @@ func AddProvisionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	canUserCreateProvision, err := helpers.CanUserCreateProvision(db, userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -38,13 +60,31 @@ func AddProvisionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	amount, err := strconv.ParseFloat(r.FormValue("amount"), 64)
-	if err != nil {
+	amount, err := ParseMoney(r.FormValue("amount"))
+	if err != nil || amount < 0 {
 		http.Error(w, "Invalid amount value", http.StatusBadRequest)
 		return
 	}
 
-	_, err = db.Exec("INSERT INTO provisions (label, amount, userId) VALUES ($1, $2, $3)", r.FormValue("label"), amount, userID)
+	chargeKeyID, err := parseChargeKeyID(db, r, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fiscalYearStart, err := getFiscalYearStart(db, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	date, fiscalYear, err := parseEntryDate(r, fiscalYearStart)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = db.Exec("INSERT INTO provisions (label, amount, chargeKeyId, date, fiscalYear, worksFund, userId, coproprieteId) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)", r.FormValue("label"), amount, chargeKeyID, date, fiscalYear, r.FormValue("works_fund") == "on", userID, coproprieteID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -53,13 +93,204 @@ func AddProvisionHandler(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/dashboard#provision_added", http.StatusFound)
 }
 
+func UpdateProvisionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	provisionID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid provision id", http.StatusBadRequest)
+		return
+	}
+
+	amount, err := ParseMoney(r.FormValue("amount"))
+	if err != nil || amount < 0 {
+		http.Error(w, "Invalid amount value", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, coproprieteID, err := getProvision(db, provisionID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Provision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	chargeKeyID, err := parseChargeKeyID(db, r, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fiscalYearStart, err := getFiscalYearStart(db, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	date, fiscalYear, err := parseEntryDate(r, fiscalYearStart)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, err = db.Exec("UPDATE provisions SET label = $1, amount = $2, chargeKeyId = $3, date = $4, fiscalYear = $5, worksFund = $6 WHERE id = $7 AND userId = $8", r.FormValue("label"), amount, chargeKeyID, date, fiscalYear, r.FormValue("works_fund") == "on", provisionID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#provision_updated", http.StatusFound)
+}
+
+func DeleteProvisionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	provisionID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid provision id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	attachments, err := getAttachments(db, userID, "provisionId", provisionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM provisions WHERE id = $1 AND userId = $2", provisionID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "Provision not found", http.StatusNotFound)
+		return
+	}
+	deleteAttachmentBlobs(attachments)
+
+	http.Redirect(w, r, "/dashboard#provision_deleted", http.StatusFound)
+}
+
 func ProvisionsHandler(w http.ResponseWriter, r *http.Request) {
-	_, ok := GetAuthenticatedUserID(w, r)
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderProvisionForm(w, db, userID, coproprieteID, nil)
+}
+
+func EditProvisionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
 	if !ok {
 		http.Redirect(w, r, "/logout", http.StatusFound)
 		return
 	}
 
+	provisionID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid provision id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	provision, coproprieteID, err := getProvision(db, provisionID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Provision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	provision.Attachments, err = getAttachments(db, userID, "provisionId", provisionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderProvisionForm(w, db, userID, coproprieteID, &provision)
+}
+
+// getProvision loads a provision by id along with the id of its copropriete,
+// returning sql.ErrNoRows when it does not exist or belongs to another user.
+func getProvision(db *sql.DB, provisionID int, userID string) (Provision, int, error) {
+	var provision Provision
+	var coproprieteID int
+	err := db.QueryRow("SELECT id, label, amount, COALESCE(chargeKeyId, 0), date, fiscalYear, COALESCE(worksFund, FALSE), coproprieteId FROM provisions WHERE id = $1 AND userId = $2", provisionID, userID).
+		Scan(&provision.ID, &provision.Label, &provision.Amount, &provision.ChargeKeyID, &provision.Date, &provision.FiscalYear, &provision.WorksFund, &coproprieteID)
+	return provision, coproprieteID, err
+}
+
+// getProvisions loads the provisions of a building, oldest first.
+func getProvisions(db *sql.DB, userID string, coproprieteID int) ([]Provision, error) {
+	rows, err := db.Query("SELECT id, label, amount, COALESCE(chargeKeyId, 0), date, fiscalYear, COALESCE(worksFund, FALSE) FROM provisions WHERE userId = $1 AND coproprieteId = $2 ORDER BY date, id", userID, coproprieteID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var provisions []Provision
+	for rows.Next() {
+		var provision Provision
+		if err := rows.Scan(&provision.ID, &provision.Label, &provision.Amount, &provision.ChargeKeyID, &provision.Date, &provision.FiscalYear, &provision.WorksFund); err != nil {
+			return nil, err
+		}
+		provisions = append(provisions, provision)
+	}
+	return provisions, rows.Err()
+}
+
+func renderProvisionForm(w http.ResponseWriter, db *sql.DB, userID string, coproprieteID int, provision *Provision) {
+	chargeKeys, err := getChargeKeys(db, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	t, err := template.ParseFiles("lib/templates/edit-provisions.html")
 	if err != nil {
 		log.Printf("Error parsing template: %v", err)
@@ -67,7 +298,7 @@ func ProvisionsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := t.Execute(w, nil); err != nil {
+	if err := t.Execute(w, provisionFormData{Provision: provision, ChargeKeys: chargeKeys, Today: time.Now()}); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
@@ -0,0 +1,603 @@
+package domains
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+// upcomingMonths is how far ahead the recurring bills page lists the next
+// occurrences.
+const upcomingMonths = 3
+
+var recurrenceFrequencies = []Frequency{
+	{Code: "monthly", Label: "Mensuelle", Months: 1},
+	{Code: "quarterly", Label: "Trimestrielle", Months: 3},
+	{Code: "half-yearly", Label: "Semestrielle", Months: 6},
+	{Code: "yearly", Label: "Annuelle", Months: 12},
+}
+
+type Frequency struct {
+	Code   string
+	Label  string
+	Months int
+}
+
+// RecurringBill is the template of an expense coming back at a fixed
+// frequency, such as the elevator maintenance or the insurance. The scheduler
+// materializes each occurrence into a real bill, or a provision for
+// scheduled calls for funds, on its due date.
+type RecurringBill struct {
+	ID          int
+	Label       string
+	Amount      Money
+	ChargeKeyID int
+	Frequency   string
+	Start       time.Time
+	End         time.Time // inclusive, zero when the template never ends
+	Provision   bool
+	SupplierID  int
+	Category    string // account code of class 6, see ExpenseCategory
+	// Generated counts the occurrences already materialized. Occurrences are
+	// numbered from the start date, so editing a template never books the
+	// same occurrence twice.
+	Generated int
+}
+
+// UpcomingOccurrence is an occurrence of a recurring bill not materialized
+// yet.
+type UpcomingOccurrence struct {
+	RecurringBill RecurringBill
+	Date          time.Time
+}
+
+type recurringBillFormData struct {
+	RecurringBill  *RecurringBill
+	RecurringBills []RecurringBill
+	Upcoming       []UpcomingOccurrence
+	ChargeKeys     []ChargeKey
+	Suppliers      []Supplier
+	Categories     []ExpenseCategoryGroup
+	Frequencies    []Frequency
+	CanCreateBill  bool
+	CanCreateCall  bool
+	Today          time.Time
+}
+
+// FrequencyLabel returns the display name of the frequency.
+func (recurring RecurringBill) FrequencyLabel() string {
+	for _, frequency := range recurrenceFrequencies {
+		if frequency.Code == recurring.Frequency {
+			return frequency.Label
+		}
+	}
+	return recurring.Frequency
+}
+
+func (recurring RecurringBill) months() int {
+	for _, frequency := range recurrenceFrequencies {
+		if frequency.Code == recurring.Frequency {
+			return frequency.Months
+		}
+	}
+	return 0
+}
+
+// chargeKeyID and supplierID store the zero ids as NULL.
+func (recurring RecurringBill) chargeKeyID() sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(recurring.ChargeKeyID), Valid: recurring.ChargeKeyID != 0}
+}
+
+func (recurring RecurringBill) supplierID() sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(recurring.SupplierID), Valid: recurring.SupplierID != 0}
+}
+
+// Occurrence returns the date of the n-th occurrence, counting from zero.
+// Occurrences keep the day of the start date, falling back on the last day of
+// shorter months: a bill starting on 31/01 is due on 28/02, then 31/03.
+func (recurring RecurringBill) Occurrence(n int) time.Time {
+	months := int(recurring.Start.Month()) - 1 + n*recurring.months()
+	year, month := recurring.Start.Year()+months/12, time.Month(months%12+1)
+	lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	return time.Date(year, month, min(recurring.Start.Day(), lastDay), 0, 0, 0, 0, time.UTC)
+}
+
+// Due returns the dates of the occurrences not materialized yet up to date,
+// included.
+func (recurring RecurringBill) Due(date time.Time) []time.Time {
+	return recurring.occurrencesUntil(time.Date(date.Year(), date.Month(), date.Day()+1, 0, 0, 0, 0, time.UTC))
+}
+
+// occurrencesUntil returns the dates of the occurrences not materialized yet
+// before until, excluded.
+func (recurring RecurringBill) occurrencesUntil(until time.Time) []time.Time {
+	if recurring.months() == 0 {
+		return nil
+	}
+	var dates []time.Time
+	for n := recurring.Generated; ; n++ {
+		date := recurring.Occurrence(n)
+		if !date.Before(until) || (!recurring.End.IsZero() && date.After(recurring.End)) {
+			return dates
+		}
+		dates = append(dates, date)
+	}
+}
+
+// UpcomingOccurrences returns the occurrences of the recurring bills due
+// before until, sorted by date.
+func UpcomingOccurrences(recurringBills []RecurringBill, until time.Time) []UpcomingOccurrence {
+	var upcoming []UpcomingOccurrence
+	for _, recurring := range recurringBills {
+		for _, date := range recurring.occurrencesUntil(until) {
+			upcoming = append(upcoming, UpcomingOccurrence{RecurringBill: recurring, Date: date})
+		}
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool {
+		return upcoming[i].Date.Before(upcoming[j].Date)
+	})
+	return upcoming
+}
+
+// RunRecurringBillScheduler materializes the due occurrences of every
+// recurring bill now and then on every tick of interval. It never returns.
+func RunRecurringBillScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		db, err := helpers.GetConnectionManager().GetConnection("postgres")
+		if err != nil {
+			log.Printf("Recurring bills: %v", err)
+		} else if err := materializeRecurringBills(db, time.Now()); err != nil {
+			log.Printf("Recurring bills: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// materializeRecurringBills books the occurrences of every recurring bill
+// due on date.
+func materializeRecurringBills(db *sql.DB, date time.Time) error {
+	rows, err := db.Query("SELECT r.id, r.userId, COALESCE(c.fiscalYearStart, 1) FROM recurring_bills r JOIN coproprietes c ON c.id = r.coproprieteId ORDER BY r.id")
+	if err != nil {
+		return err
+	}
+
+	type target struct {
+		id              int
+		userID          string
+		fiscalYearStart time.Month
+	}
+	var targets []target
+	for rows.Next() {
+		var t target
+		if err := rows.Scan(&t.id, &t.userID, &t.fiscalYearStart); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		targets = append(targets, t)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, t := range targets {
+		if err := materializeRecurringBill(db, t.id, t.userID, t.fiscalYearStart, date); err != nil {
+			log.Printf("Recurring bills: could not materialize recurring bill %d: %v", t.id, err)
+		}
+	}
+	return nil
+}
+
+// materializeRecurringBill books the due occurrences of one recurring bill,
+// oldest first. Occurrences are left pending while the free tier limit is
+// reached, so that they are booked once the user upgrades or makes room.
+func materializeRecurringBill(db *sql.DB, recurringBillID int, userID string, fiscalYearStart time.Month, date time.Time) error {
+	recurring, coproprieteID, err := getRecurringBill(db, recurringBillID, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, due := range recurring.Due(date) {
+		var canCreate bool
+		if recurring.Provision {
+			canCreate, err = helpers.CanUserCreateProvision(db, userID)
+		} else {
+			canCreate, err = helpers.CanUserCreateBill(db, userID)
+		}
+		if err != nil {
+			return err
+		}
+		if !canCreate {
+			return nil
+		}
+
+		booked, err := bookOccurrence(db, recurring, due, FiscalYearOf(due, fiscalYearStart), userID, coproprieteID)
+		if err != nil || !booked {
+			return err
+		}
+		recurring.Generated++
+	}
+	return nil
+}
+
+// bookOccurrence inserts the next occurrence of a recurring bill. Bills
+// carry the supplier and category of the recurring bill, which provisions do
+// not have. It reports false without booking anything when another run got
+// there first.
+func bookOccurrence(db *sql.DB, recurring RecurringBill, date time.Time, fiscalYear int, userID string, coproprieteID int) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.Exec("UPDATE recurring_bills SET generated = generated + 1 WHERE id = $1 AND generated = $2", recurring.ID, recurring.Generated)
+	if err != nil {
+		return false, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+
+	if recurring.Provision {
+		_, err = tx.Exec("INSERT INTO provisions (label, amount, chargeKeyId, date, fiscalYear, userId, coproprieteId) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+			recurring.Label, recurring.Amount, recurring.chargeKeyID(), date, fiscalYear, userID, coproprieteID)
+	} else {
+		_, err = tx.Exec("INSERT INTO bills (label, amount, chargeKeyId, date, fiscalYear, supplierId, category, userId, coproprieteId) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+			recurring.Label, recurring.Amount, recurring.chargeKeyID(), date, fiscalYear, recurring.supplierID(), recurring.Category, userID, coproprieteID)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+func RecurringBillsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderRecurringBillForm(w, db, userID, coproprieteID, nil)
+}
+
+func EditRecurringBillHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	recurringBillID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recurring bill id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recurring, coproprieteID, err := getRecurringBill(db, recurringBillID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Recurring bill not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderRecurringBillForm(w, db, userID, coproprieteID, &recurring)
+}
+
+// AddRecurringBillHandler saves a recurring bill and books right away the
+// occurrences already due, if it starts in the past.
+func AddRecurringBillHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recurring, err := parseRecurringBill(db, r, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fiscalYearStart, err := getFiscalYearStart(db, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var recurringBillID int
+	err = db.QueryRow("INSERT INTO recurring_bills (label, amount, chargeKeyId, frequency, startDate, endDate, provision, supplierId, category, userId, coproprieteId) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id",
+		recurring.Label, recurring.Amount, recurring.chargeKeyID(), recurring.Frequency, recurring.Start, nullDate(recurring.End), recurring.Provision, recurring.supplierID(), recurring.Category, userID, coproprieteID).Scan(&recurringBillID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := materializeRecurringBill(db, recurringBillID, userID, fiscalYearStart, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#recurring_bill_added", http.StatusFound)
+}
+
+// UpdateRecurringBillHandler changes the occurrences to come. Bills already
+// booked are left as they are.
+func UpdateRecurringBillHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	recurringBillID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recurring bill id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, coproprieteID, err := getRecurringBill(db, recurringBillID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Recurring bill not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	recurring, err := parseRecurringBill(db, r, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fiscalYearStart, err := getFiscalYearStart(db, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = db.Exec("UPDATE recurring_bills SET label = $1, amount = $2, chargeKeyId = $3, frequency = $4, startDate = $5, endDate = $6, provision = $7, supplierId = $8, category = $9 WHERE id = $10 AND userId = $11",
+		recurring.Label, recurring.Amount, recurring.chargeKeyID(), recurring.Frequency, recurring.Start, nullDate(recurring.End), recurring.Provision, recurring.supplierID(), recurring.Category, recurringBillID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := materializeRecurringBill(db, recurringBillID, userID, fiscalYearStart, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#recurring_bill_updated", http.StatusFound)
+}
+
+// DeleteRecurringBillHandler stops a recurring bill. The bills it already
+// booked are kept.
+func DeleteRecurringBillHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	recurringBillID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid recurring bill id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM recurring_bills WHERE id = $1 AND userId = $2", recurringBillID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "Recurring bill not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#recurring_bill_deleted", http.StatusFound)
+}
+
+// getRecurringBill loads a recurring bill by id along with the id of its
+// copropriete, returning sql.ErrNoRows when it does not exist or belongs to
+// another user.
+func getRecurringBill(db *sql.DB, recurringBillID int, userID string) (RecurringBill, int, error) {
+	var recurring RecurringBill
+	var end sql.NullTime
+	var coproprieteID int
+	err := db.QueryRow("SELECT id, label, amount, COALESCE(chargeKeyId, 0), frequency, startDate, endDate, provision, COALESCE(supplierId, 0), COALESCE(category, ''), generated, coproprieteId FROM recurring_bills WHERE id = $1 AND userId = $2", recurringBillID, userID).
+		Scan(&recurring.ID, &recurring.Label, &recurring.Amount, &recurring.ChargeKeyID, &recurring.Frequency, &recurring.Start, &end, &recurring.Provision, &recurring.SupplierID, &recurring.Category, &recurring.Generated, &coproprieteID)
+	recurring.End = end.Time
+	return recurring, coproprieteID, err
+}
+
+func getRecurringBills(db *sql.DB, userID string, coproprieteID int) ([]RecurringBill, error) {
+	rows, err := db.Query("SELECT id, label, amount, COALESCE(chargeKeyId, 0), frequency, startDate, endDate, provision, COALESCE(supplierId, 0), COALESCE(category, ''), generated FROM recurring_bills WHERE userId = $1 AND coproprieteId = $2 ORDER BY label, id", userID, coproprieteID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var recurringBills []RecurringBill
+	for rows.Next() {
+		var recurring RecurringBill
+		var end sql.NullTime
+		if err := rows.Scan(&recurring.ID, &recurring.Label, &recurring.Amount, &recurring.ChargeKeyID, &recurring.Frequency, &recurring.Start, &end, &recurring.Provision, &recurring.SupplierID, &recurring.Category, &recurring.Generated); err != nil {
+			return nil, err
+		}
+		recurring.End = end.Time
+		recurringBills = append(recurringBills, recurring)
+	}
+	return recurringBills, rows.Err()
+}
+
+func parseRecurringBill(db *sql.DB, r *http.Request, userID string, coproprieteID int) (RecurringBill, error) {
+	var recurring RecurringBill
+
+	recurring.Label = r.FormValue("label")
+	if recurring.Label == "" {
+		return recurring, fmt.Errorf("label cannot be empty")
+	}
+
+	var err error
+	recurring.Amount, err = ParseMoney(r.FormValue("amount"))
+	if err != nil || recurring.Amount <= 0 {
+		return recurring, fmt.Errorf("invalid amount value")
+	}
+
+	recurring.Frequency = r.FormValue("frequency")
+	if recurring.months() == 0 {
+		return recurring, fmt.Errorf("invalid frequency value")
+	}
+
+	recurring.Start, err = time.Parse(dateLayout, r.FormValue("start"))
+	if err != nil {
+		return recurring, fmt.Errorf("invalid start date value")
+	}
+
+	if value := r.FormValue("end"); value != "" {
+		recurring.End, err = time.Parse(dateLayout, value)
+		if err != nil || recurring.End.Before(recurring.Start) {
+			return recurring, fmt.Errorf("invalid end date value")
+		}
+	}
+
+	recurring.Provision = r.FormValue("provision") == "on"
+
+	chargeKeyID, err := parseChargeKeyID(db, r, userID, coproprieteID)
+	if err != nil {
+		return recurring, err
+	}
+	recurring.ChargeKeyID = int(chargeKeyID.Int64)
+
+	supplierID, err := parseSupplierID(db, r, userID, coproprieteID)
+	if err != nil {
+		return recurring, err
+	}
+	recurring.SupplierID = int(supplierID.Int64)
+
+	recurring.Category, err = parseExpenseCategory(r)
+	if err != nil {
+		return recurring, err
+	}
+
+	return recurring, nil
+}
+
+func renderRecurringBillForm(w http.ResponseWriter, db *sql.DB, userID string, coproprieteID int, recurring *RecurringBill) {
+	recurringBills, err := getRecurringBills(db, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	chargeKeys, err := getChargeKeys(db, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	suppliers, err := getSuppliers(db, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	canCreateBill, err := helpers.CanUserCreateBill(db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	canCreateCall, err := helpers.CanUserCreateProvision(db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t, err := template.ParseFiles("lib/templates/edit-recurring-bills.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	data := recurringBillFormData{
+		RecurringBill:  recurring,
+		RecurringBills: recurringBills,
+		Upcoming:       UpcomingOccurrences(recurringBills, now.AddDate(0, upcomingMonths, 0)),
+		ChargeKeys:     chargeKeys,
+		Suppliers:      suppliers,
+		Categories:     expenseCategoryGroups,
+		Frequencies:    recurrenceFrequencies,
+		CanCreateBill:  canCreateBill,
+		CanCreateCall:  canCreateCall,
+		Today:          now,
+	}
+
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,111 @@
+package domains
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRecurringBillOccurrenceKeepsTheDayOfTheMonth(t *testing.T) {
+	recurring := RecurringBill{Frequency: "monthly", Start: time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)}
+
+	expected := []time.Time{
+		time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 2, 28, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 4, 30, 0, 0, 0, 0, time.UTC),
+	}
+	for n, date := range expected {
+		if occurrence := recurring.Occurrence(n); !occurrence.Equal(date) {
+			t.Errorf("Expected occurrence %d on %s, got %s", n, date.Format(dateLayout), occurrence.Format(dateLayout))
+		}
+	}
+
+	recurring.Frequency = "quarterly"
+	if occurrence := recurring.Occurrence(4); !occurrence.Equal(time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the fifth quarterly occurrence on 31/01/2026, got %s", occurrence.Format(dateLayout))
+	}
+}
+
+func TestRecurringBillDue(t *testing.T) {
+	recurring := RecurringBill{
+		Frequency: "quarterly",
+		Start:     time.Date(2025, 1, 15, 0, 0, 0, 0, time.UTC),
+		End:       time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC),
+		Generated: 1,
+	}
+
+	due := recurring.Due(time.Date(2025, 7, 15, 18, 30, 0, 0, time.UTC))
+	if len(due) != 2 || !due[0].Equal(time.Date(2025, 4, 15, 0, 0, 0, 0, time.UTC)) || !due[1].Equal(time.Date(2025, 7, 15, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the April and July occurrences to be due, got %v", due)
+	}
+
+	if due := recurring.Due(time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)); len(due) != 3 {
+		t.Errorf("Expected no occurrence after the end date, got %v", due)
+	}
+
+	if due := (RecurringBill{Frequency: "weekly", Start: recurring.Start}).Due(time.Now()); due != nil {
+		t.Errorf("Expected no occurrence with an unknown frequency, got %v", due)
+	}
+}
+
+func TestUpcomingOccurrencesAreSortedByDate(t *testing.T) {
+	recurringBills := []RecurringBill{
+		{ID: 1, Label: "Assurance", Frequency: "yearly", Start: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: 2, Label: "Ménage", Frequency: "monthly", Start: time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)},
+	}
+
+	upcoming := UpcomingOccurrences(recurringBills, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC))
+	labels := make([]string, len(upcoming))
+	for i, occurrence := range upcoming {
+		labels[i] = occurrence.RecurringBill.Label
+	}
+	if len(labels) != 3 || labels[0] != "Ménage" || labels[1] != "Assurance" || labels[2] != "Ménage" {
+		t.Errorf("Expected Ménage, Assurance, Ménage, got %v", labels)
+	}
+}
+
+func TestBookOccurrenceCarriesSupplierAndCategory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	recurring := RecurringBill{ID: 3, Label: "Entretien ascenseur", Amount: 18000, ChargeKeyID: 4, Frequency: "quarterly", SupplierID: 5, Category: "615", Generated: 2}
+	date := time.Date(2025, 7, 15, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE recurring_bills SET generated = generated \+ 1 WHERE id = \$1 AND generated = \$2`).
+		WithArgs(3, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO bills \(label, amount, chargeKeyId, date, fiscalYear, supplierId, category, userId, coproprieteId\)`).
+		WithArgs("Entretien ascenseur", "180.00", int64(4), date, 2025, int64(5), "615", "1", 2).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	booked, err := bookOccurrence(db, recurring, date, 2025, "1", 2)
+	if err != nil || !booked {
+		t.Fatalf("Expected the occurrence to be booked, got %v, %v", booked, err)
+	}
+
+	// Provisions have neither supplier nor category.
+	recurring.Provision, recurring.SupplierID, recurring.Category = true, 0, ""
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE recurring_bills SET generated`).
+		WithArgs(3, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO provisions \(label, amount, chargeKeyId, date, fiscalYear, userId, coproprieteId\)`).
+		WithArgs("Entretien ascenseur", "180.00", int64(4), date, 2025, "1", 2).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if booked, err := bookOccurrence(db, recurring, date, 2025, "1", 2); err != nil || !booked {
+		t.Fatalf("Expected the provision to be booked, got %v, %v", booked, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,497 @@
+package domains
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+	"github.com/go-pdf/fpdf"
+)
+
+// Regularization is the result of closing a fiscal year for one person: what
+// they were called for minus their share of the actual expenses. A positive
+// amount is a credit in favour of the person, a negative one is due by them.
+// It is carried over into the opening balance of the next fiscal year.
+type Regularization struct {
+	ID         int
+	PersonID   int
+	FiscalYear int
+	Amount     Money
+}
+
+// RegularizationLine details the regularization of a person for a fiscal
+// year, along with the amount stored when the year was closed.
+type RegularizationLine struct {
+	Person   Person
+	Called   Money
+	Expenses Money
+	Balance  Money
+	Closed   Money
+	IsClosed bool
+}
+
+// Regularize computes the regularization of every person of the allocator
+// for a fiscal year. Only the bills and provisions booked on that fiscal year
+// are taken into account; the works fund is not refundable and stays out of
+// it.
+func Regularize(allocator Allocator, bills []Bill, provisions []Provision, fiscalYear int) []RegularizationLine {
+	var yearBills []Bill
+	for _, bill := range bills {
+		if bill.FiscalYear == fiscalYear && !bill.WorksFund {
+			yearBills = append(yearBills, bill)
+		}
+	}
+
+	var yearProvisions []Provision
+	for _, provision := range provisions {
+		if provision.FiscalYear == fiscalYear && !provision.WorksFund {
+			yearProvisions = append(yearProvisions, provision)
+		}
+	}
+
+	lines := make([]RegularizationLine, len(allocator.Persons))
+	for i, person := range allocator.Persons {
+		line := RegularizationLine{Person: person}
+		for _, provision := range yearProvisions {
+			line.Called += person.CalculateProvision(allocator, provision)
+		}
+		for _, bill := range yearBills {
+			line.Expenses += person.CalculateDue(allocator, bill)
+		}
+		line.Balance = person.CalculateLeft(allocator, yearBills, yearProvisions)
+		lines[i] = line
+	}
+	return lines
+}
+
+// Regularization returns the regularization to store when closing the year.
+func (line RegularizationLine) Regularization(fiscalYear int) Regularization {
+	return Regularization{PersonID: line.Person.ID, FiscalYear: fiscalYear, Amount: line.Balance}
+}
+
+// IsStale reports whether the entries of a closed year changed since it was
+// closed.
+func (line RegularizationLine) IsStale() bool {
+	return line.IsClosed && line.Closed != line.Balance
+}
+
+type regularizationPageData struct {
+	Copropriete   Copropriete
+	FiscalYear    int
+	YearLabel     string
+	NextYearLabel string
+	PreviousYear  int
+	NextYear      int
+	Lines         []RegularizationLine
+	IsClosed      bool
+	IsPremium     bool
+}
+
+func RegularizationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fiscalYearStart, err := getFiscalYearStart(db, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The year to close is usually the one that just ended.
+	fiscalYear := FiscalYearOf(time.Now(), fiscalYearStart) - 1
+	if value := r.URL.Query().Get("year"); value != "" {
+		fiscalYear, err = strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid year value", http.StatusBadRequest)
+			return
+		}
+	}
+
+	data, lines, err := getRegularizationData(db, userID, coproprieteID, fiscalYear, fiscalYearStart)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t, err := template.ParseFiles("lib/templates/regularizations.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	page := regularizationPageData{
+		Copropriete:   data.Copropriete,
+		FiscalYear:    fiscalYear,
+		YearLabel:     FiscalYearLabel(fiscalYear, fiscalYearStart),
+		NextYearLabel: FiscalYearLabel(fiscalYear+1, fiscalYearStart),
+		PreviousYear:  fiscalYear - 1,
+		NextYear:      fiscalYear + 1,
+		Lines:         lines,
+		IsPremium:     data.IsPremium,
+	}
+	for _, line := range lines {
+		page.IsClosed = page.IsClosed || line.IsClosed
+	}
+
+	if err := t.Execute(w, page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// CloseFiscalYearHandler stores the regularization of every person for a
+// fiscal year, so that it is carried over into the next one. Closing a year
+// again replaces its regularizations.
+func CloseFiscalYearHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	fiscalYear, err := strconv.Atoi(r.FormValue("year"))
+	if err != nil {
+		http.Error(w, "Invalid year value", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fiscalYearStart, err := getFiscalYearStart(db, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, lines, err := getRegularizationData(db, userID, coproprieteID, fiscalYear, fiscalYearStart)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("DELETE FROM regularizations WHERE fiscalYear = $1 AND userId = $2 AND coproprieteId = $3", fiscalYear, userID, coproprieteID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, line := range lines {
+		regularization := line.Regularization(fiscalYear)
+		_, err := tx.Exec("INSERT INTO regularizations (fiscalYear, personId, amount, userId, coproprieteId) VALUES ($1, $2, $3, $4, $5)",
+			regularization.FiscalYear, regularization.PersonID, regularization.Amount, userID, coproprieteID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/regularizations?year=%d#year_closed", fiscalYear), http.StatusFound)
+}
+
+// ReopenFiscalYearHandler deletes the regularizations of a fiscal year, which
+// are then no longer carried over.
+func ReopenFiscalYearHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	fiscalYear, err := strconv.Atoi(r.PathValue("year"))
+	if err != nil {
+		http.Error(w, "Invalid year value", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := db.Exec("DELETE FROM regularizations WHERE fiscalYear = $1 AND userId = $2 AND coproprieteId = $3", fiscalYear, userID, coproprieteID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/regularizations?year=%d#year_reopened", fiscalYear), http.StatusFound)
+}
+
+// RegularizationStatementHandler sends the PDF regularization statement of
+// one person for a fiscal year.
+func RegularizationStatementHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	fiscalYear, err := strconv.Atoi(r.PathValue("year"))
+	if err != nil {
+		http.Error(w, "Invalid year value", http.StatusBadRequest)
+		return
+	}
+
+	personID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid person id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	isPremium, err := helpers.IsUserPremium(db, userID)
+	if err != nil {
+		log.Printf("Error checking premium status: %v", err)
+		http.Error(w, "Could not verify subscription status", http.StatusInternalServerError)
+		return
+	}
+
+	if !isPremium {
+		http.Error(w, "Premium subscription required for regularization statements", http.StatusForbidden)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		log.Printf("Error resolving copropriete: %v", err)
+		http.Error(w, "Failed to load data", http.StatusInternalServerError)
+		return
+	}
+
+	fiscalYearStart, err := getFiscalYearStart(db, coproprieteID)
+	if err != nil {
+		log.Printf("Error resolving copropriete: %v", err)
+		http.Error(w, "Failed to load data", http.StatusInternalServerError)
+		return
+	}
+
+	data, lines, err := getRegularizationData(db, userID, coproprieteID, fiscalYear, fiscalYearStart)
+	if err != nil {
+		log.Printf("Error getting regularization data: %v", err)
+		http.Error(w, "Failed to load data", http.StatusInternalServerError)
+		return
+	}
+
+	for _, line := range lines {
+		if line.Person.ID != personID {
+			continue
+		}
+
+		pdf := newRegularizationStatement(data, line, fiscalYear)
+
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=regularisation-%d-%d.pdf", fiscalYear, personID))
+
+		if err := pdf.Output(w); err != nil {
+			log.Printf("Error generating PDF: %v", err)
+			http.Error(w, "Failed to generate PDF", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	http.Error(w, "Person not found", http.StatusNotFound)
+}
+
+// newRegularizationStatement builds the statement of a person for a closed
+// or closing fiscal year: their share of every provision and expense, and
+// the resulting credit or debit carried over into the next fiscal year.
+func newRegularizationStatement(data DashboardData, line RegularizationLine, fiscalYear int) *fpdf.Fpdf {
+	person := line.Person
+	fiscalYearStart := data.Period.FiscalYearStart
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(15, 15, 15)
+	pdf.AddPage()
+
+	pdf.SetFont("Arial", "B", 20)
+	pdf.Cell(0, 12, "Régularisation des charges")
+	pdf.Ln(15)
+
+	pdf.SetFont("Arial", "", 10)
+	pdf.Cell(0, 6, fmt.Sprintf("Copropriété: %s", data.Copropriete.Name))
+	pdf.Ln(6)
+	status := "provisoire"
+	if line.IsClosed {
+		status = "clôturé"
+	}
+	pdf.Cell(0, 6, fmt.Sprintf("Exercice: %s (%s)", FiscalYearLabel(fiscalYear, fiscalYearStart), status))
+	pdf.Ln(12)
+
+	pdf.SetFont("Arial", "B", 12)
+	pdf.Cell(0, 8, fmt.Sprintf("Copropriétaire: %s", person.Name))
+	pdf.Ln(12)
+
+	type entry struct {
+		Date        time.Time
+		Label       string
+		ChargeKeyID int
+		Share       Money
+	}
+
+	section := func(title string, entries []entry, total Money, totalLabel string) {
+		pdf.SetFont("Arial", "B", 14)
+		pdf.Cell(0, 10, title)
+		pdf.Ln(10)
+
+		pdf.SetFont("Arial", "B", 9)
+		pdf.SetFillColor(220, 220, 220)
+		colWidths := []float64{22, 78, 45, 35}
+		pdf.CellFormat(colWidths[0], 7, "Date", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[1], 7, "Libellé", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[2], 7, "Clé de répartition", "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[3], 7, "Quote-part", "1", 1, "R", true, 0, "")
+
+		pdf.SetFont("Arial", "", 9)
+		for i, entry := range entries {
+			fill := i%2 == 0
+			if fill {
+				pdf.SetFillColor(245, 245, 245)
+			} else {
+				pdf.SetFillColor(255, 255, 255)
+			}
+			pdf.CellFormat(colWidths[0], 6, entry.Date.Format("02/01/2006"), "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[1], 6, entry.Label, "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[2], 6, data.Allocator.ChargeKeyName(entry.ChargeKeyID), "1", 0, "L", fill, 0, "")
+			pdf.CellFormat(colWidths[3], 6, fmt.Sprintf("%s EUR", entry.Share), "1", 1, "R", fill, 0, "")
+		}
+
+		pdf.SetFont("Arial", "B", 9)
+		pdf.SetFillColor(220, 220, 220)
+		pdf.CellFormat(colWidths[0]+colWidths[1]+colWidths[2], 7, totalLabel, "1", 0, "L", true, 0, "")
+		pdf.CellFormat(colWidths[3], 7, fmt.Sprintf("%s EUR", total), "1", 1, "R", true, 0, "")
+		pdf.Ln(10)
+	}
+
+	var provisions []entry
+	for _, provision := range data.Provisions {
+		provisions = append(provisions, entry{provision.Date, provision.Label, provision.ChargeKeyID, person.CalculateProvision(data.Allocator, provision)})
+	}
+	section("Provisions appelées", provisions, line.Called, "Total des provisions appelées")
+
+	var bills []entry
+	for _, bill := range data.Bills {
+		bills = append(bills, entry{bill.Date, bill.Label, bill.ChargeKeyID, person.CalculateDue(data.Allocator, bill)})
+	}
+	section("Dépenses réelles", bills, line.Expenses, "Total des dépenses réelles")
+
+	result := "Complément à régler"
+	if line.Balance >= 0 {
+		result = "Trop-perçu en votre faveur"
+	}
+	amount := line.Balance
+	if amount < 0 {
+		amount = -amount
+	}
+
+	pdf.SetFont("Arial", "B", 12)
+	pdf.SetFillColor(200, 200, 200)
+	pdf.CellFormat(120, 8, result, "1", 0, "L", true, 0, "")
+	pdf.CellFormat(60, 8, fmt.Sprintf("%s EUR", amount), "1", 1, "R", true, 0, "")
+	pdf.Ln(4)
+
+	pdf.SetFont("Arial", "", 9)
+	pdf.MultiCell(0, 5, fmt.Sprintf("Ce montant est reporté au solde d'ouverture de l'exercice %s et sera imputé sur vos prochains appels de fonds.", FiscalYearLabel(fiscalYear+1, fiscalYearStart)), "", "L", false)
+
+	pdf.Ln(16)
+	pdf.SetFont("Arial", "I", 8)
+	pdf.Cell(0, 5, fmt.Sprintf("Document généré automatiquement par Tanzia le %s", time.Now().Format("02/01/2006 15:04")))
+
+	return pdf
+}
+
+// getRegularizationData loads the entries booked on a fiscal year and the
+// regularization of every person, flagged with what was stored if the year
+// is closed.
+func getRegularizationData(db *sql.DB, userID string, coproprieteID int, fiscalYear int, fiscalYearStart time.Month) (DashboardData, []RegularizationLine, error) {
+	data, err := getDashboardData(userID, coproprieteID, YearPeriod(fiscalYear, fiscalYearStart))
+	if err != nil {
+		return DashboardData{}, nil, err
+	}
+
+	regularizations, err := getRegularizations(db, userID, coproprieteID)
+	if err != nil {
+		return DashboardData{}, nil, err
+	}
+
+	lines := Regularize(data.Allocator, data.Bills, data.Provisions, fiscalYear)
+	for i, line := range lines {
+		for _, regularization := range regularizations {
+			if regularization.FiscalYear == fiscalYear && regularization.PersonID == line.Person.ID {
+				lines[i].Closed = regularization.Amount
+				lines[i].IsClosed = true
+			}
+		}
+	}
+
+	return data, lines, nil
+}
+
+func getRegularizations(db *sql.DB, userID string, coproprieteID int) ([]Regularization, error) {
+	rows, err := db.Query("SELECT id, personId, fiscalYear, amount FROM regularizations WHERE userId = $1 AND coproprieteId = $2 ORDER BY fiscalYear, personId", userID, coproprieteID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var regularizations []Regularization
+	for rows.Next() {
+		var regularization Regularization
+		if err := rows.Scan(&regularization.ID, &regularization.PersonID, &regularization.FiscalYear, &regularization.Amount); err != nil {
+			return nil, err
+		}
+		regularizations = append(regularizations, regularization)
+	}
+	return regularizations, rows.Err()
+}
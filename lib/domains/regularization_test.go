@@ -0,0 +1,91 @@
+package domains
+
+import (
+	"testing"
+)
+
+func TestRegularize(t *testing.T) {
+	persons := []Person{
+		{ID: 1, Name: "John Doe", Tantieme: 1},
+		{ID: 2, Name: "Jane Doe", Tantieme: 3},
+	}
+	allocator := NewAllocator(persons)
+	provisions := []Provision{
+		{Amount: 100000, FiscalYear: 2024},
+		{Amount: 50000, FiscalYear: 2025},
+	}
+	bills := []Bill{
+		{Amount: 80000, FiscalYear: 2024},
+		{Amount: 40000, FiscalYear: 2024},
+		{Amount: 99999, FiscalYear: 2023},
+	}
+
+	lines := Regularize(allocator, bills, provisions, 2024)
+
+	john, jane := lines[0], lines[1]
+	if john.Called != 25000 || john.Expenses != 30000 || john.Balance != -5000 {
+		t.Errorf("Unexpected regularization for John %+v", john)
+	}
+	if jane.Called != 75000 || jane.Expenses != 90000 || jane.Balance != -15000 {
+		t.Errorf("Unexpected regularization for Jane %+v", jane)
+	}
+
+	regularization := john.Regularization(2024)
+	if regularization.PersonID != 1 || regularization.FiscalYear != 2024 || regularization.Amount != -5000 {
+		t.Errorf("Unexpected stored regularization %+v", regularization)
+	}
+}
+
+func TestRegularizationIsStale(t *testing.T) {
+	line := RegularizationLine{Balance: 1000, Closed: 1000, IsClosed: true}
+	if line.IsStale() {
+		t.Error("Expected a regularization matching the entries not to be stale")
+	}
+
+	line.Balance = 1200
+	if !line.IsStale() {
+		t.Error("Expected a regularization differing from the entries to be stale")
+	}
+
+	if (RegularizationLine{Balance: 1200}).IsStale() {
+		t.Error("Expected an open year not to be stale")
+	}
+}
+
+func TestCarriedOverBalance(t *testing.T) {
+	persons := []Person{
+		{ID: 1, Name: "John Doe", Tantieme: 1},
+		{ID: 2, Name: "Jane Doe", Tantieme: 1},
+	}
+	allocator := NewAllocator(persons)
+	provisions := []Provision{{Label: "T1", Amount: 60000}, {Label: "T2", Amount: 60000}}
+	payments := []Payment{{PersonID: 1, Amount: 30000}}
+	regularizations := []Regularization{
+		{PersonID: 1, FiscalYear: 2024, Amount: -10000},
+		{PersonID: 2, FiscalYear: 2024, Amount: 40000},
+	}
+
+	john, jane := persons[0], persons[1]
+
+	if carried := john.CalculateCarriedOver(regularizations); carried != 10000 {
+		t.Errorf("Expected 100,00 carried over, got %s", carried)
+	}
+	if outstanding := john.CalculateOutstanding(allocator, provisions, payments, regularizations); outstanding != 40000 {
+		t.Errorf("Expected 400,00 outstanding, got %s", outstanding)
+	}
+	if outstanding := jane.CalculateOutstanding(allocator, provisions, nil, regularizations); outstanding != 20000 {
+		t.Errorf("Expected 200,00 outstanding, got %s", outstanding)
+	}
+
+	// John's payment settles the debit carried over first.
+	calls := john.Calls(allocator, provisions, payments, regularizations)
+	if calls[0].Left() != 10000 || calls[1].Left() != 30000 {
+		t.Errorf("Expected 100,00 left on T1 and T2 unpaid, got %+v", calls)
+	}
+
+	// Jane's credit pays for her first call.
+	calls = jane.Calls(allocator, provisions, nil, regularizations)
+	if calls[0].Left() != 0 || calls[1].Left() != 20000 {
+		t.Errorf("Expected T1 settled by the credit, got %+v", calls)
+	}
+}
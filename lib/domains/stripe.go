@@ -41,13 +41,21 @@ func CreateCheckoutSessionHandler(w http.ResponseWriter, r *http.Request) {
 
 	var email string
 	var stripeCustomerID sql.NullString
-	err = db.QueryRow("SELECT email, stripe_customer_id FROM users WHERE id = $1", userID).Scan(&email, &stripeCustomerID)
+	var emailVerified bool
+	err = db.QueryRow("SELECT email, stripe_customer_id, emailVerifiedAt IS NOT NULL FROM users WHERE id = $1", userID).Scan(&email, &stripeCustomerID, &emailVerified)
 	if err != nil {
 		log.Printf("Error fetching user: %v", err)
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
 
+	// Stripe customers are tied to the address of the account, which has to
+	// be proven first.
+	if !emailVerified {
+		http.Redirect(w, r, "/account#verify_required", http.StatusFound)
+		return
+	}
+
 	domain := os.Getenv("DOMAIN")
 	if domain == "" {
 		domain = "http://localhost:8080"
@@ -64,6 +72,8 @@ func CreateCheckoutSessionHandler(w http.ResponseWriter, r *http.Request) {
 		SuccessURL: stripe.String(domain + "/dashboard?payment=success"),
 		CancelURL:  stripe.String(domain + "/dashboard?payment=cancelled"),
 		Mode:       stripe.String(string(stripe.CheckoutSessionModeSubscription)),
+		// Sent back with checkout.session.completed to find the user.
+		ClientReferenceID: stripe.String(userID),
 		LineItems: []*stripe.CheckoutSessionLineItemParams{
 			{
 				Price:    stripe.String(priceID),
@@ -213,10 +223,20 @@ func handleCheckoutCompleted(db *sql.DB, event stripe.Event) error {
 
 	log.Printf("Checkout completed for customer %s (%s)", customerID, customerEmail)
 
-	_, err := db.Exec(
-		"UPDATE users SET is_premium = TRUE, stripe_customer_id = $1 WHERE email = $2",
-		customerID, customerEmail,
-	)
+	// Sessions created by CreateCheckoutSessionHandler name the user. Older
+	// ones are matched by email, but only to an account that verified it.
+	var err error
+	if checkoutSession.ClientReferenceID != "" {
+		_, err = db.Exec(
+			"UPDATE users SET is_premium = TRUE, stripe_customer_id = $1 WHERE id = $2",
+			customerID, checkoutSession.ClientReferenceID,
+		)
+	} else {
+		_, err = db.Exec(
+			"UPDATE users SET is_premium = TRUE, stripe_customer_id = $1 WHERE email = $2 AND emailVerifiedAt IS NOT NULL",
+			customerID, customerEmail,
+		)
+	}
 	if err != nil {
 		log.Printf("Error updating user premium status: %v", err)
 		return err
@@ -0,0 +1,468 @@
+package domains
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+// laPosteSIREN is the SIREN of La Poste, whose establishments have SIRETs
+// that do not pass the Luhn check.
+const laPosteSIREN = "356000000"
+
+type SupplierCategory struct {
+	Code  string
+	Label string
+}
+
+var supplierCategories = []SupplierCategory{
+	{"cleaning", "Entretien et ménage"},
+	{"elevator", "Ascenseur"},
+	{"energy", "Eau et énergie"},
+	{"insurance", "Assurance"},
+	{"works", "Travaux et bâtiment"},
+	{"green_spaces", "Espaces verts"},
+	{"fees", "Honoraires"},
+	{"other", "Autre"},
+}
+
+// Supplier is a fournisseur of the building: the contractor or company a
+// bill is paid to.
+type Supplier struct {
+	ID       int
+	Name     string
+	SIRET    string
+	IBAN     string
+	Contact  string
+	Category string
+}
+
+// SupplierTotal is what was spent with a supplier over a period. The zero
+// supplier gathers the bills without one.
+type SupplierTotal struct {
+	Supplier Supplier
+	Bills    int
+	Amount   Money
+}
+
+type supplierFormData struct {
+	Supplier   *Supplier
+	Suppliers  []Supplier
+	Categories []SupplierCategory
+}
+
+// CategoryLabel returns the label of the category of the supplier.
+func (supplier Supplier) CategoryLabel() string {
+	for _, category := range supplierCategories {
+		if category.Code == supplier.Category {
+			return category.Label
+		}
+	}
+	return ""
+}
+
+// IBANLabel returns the IBAN in groups of four characters, as printed on a
+// RIB.
+func (supplier Supplier) IBANLabel() string {
+	var groups []string
+	for i := 0; i < len(supplier.IBAN); i += 4 {
+		groups = append(groups, supplier.IBAN[i:min(i+4, len(supplier.IBAN))])
+	}
+	return strings.Join(groups, " ")
+}
+
+// SupplierTotals returns the spending per supplier over bills, largest
+// first. Bills without a supplier are gathered last under the zero supplier.
+func SupplierTotals(suppliers []Supplier, bills []Bill) []SupplierTotal {
+	totals := make(map[int]*SupplierTotal)
+	for _, bill := range bills {
+		total, ok := totals[bill.SupplierID]
+		if !ok {
+			total = &SupplierTotal{}
+			for _, supplier := range suppliers {
+				if supplier.ID == bill.SupplierID {
+					total.Supplier = supplier
+				}
+			}
+			totals[bill.SupplierID] = total
+		}
+		total.Bills++
+		total.Amount += bill.Amount
+	}
+
+	result := make([]SupplierTotal, 0, len(totals))
+	for _, total := range totals {
+		result = append(result, *total)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if (result[i].Supplier.ID == 0) != (result[j].Supplier.ID == 0) {
+			return result[j].Supplier.ID == 0
+		}
+		if result[i].Amount != result[j].Amount {
+			return result[i].Amount > result[j].Amount
+		}
+		return result[i].Supplier.Name < result[j].Supplier.Name
+	})
+	return result
+}
+
+// normalizeSIRET removes the spaces of a SIRET and checks its 14 digits
+// against the Luhn key.
+func normalizeSIRET(value string) (string, error) {
+	siret := strings.Join(strings.Fields(value), "")
+	if siret == "" {
+		return "", nil
+	}
+	if len(siret) != 14 {
+		return "", fmt.Errorf("invalid SIRET value")
+	}
+
+	sum, digits := 0, 0
+	for i, c := range siret {
+		if c < '0' || c > '9' {
+			return "", fmt.Errorf("invalid SIRET value")
+		}
+		digit := int(c - '0')
+		digits += digit
+		if i%2 == 0 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+
+	if sum%10 != 0 && !(strings.HasPrefix(siret, laPosteSIREN) && digits%5 == 0) {
+		return "", fmt.Errorf("invalid SIRET value")
+	}
+	return siret, nil
+}
+
+// normalizeIBAN removes the spaces of an IBAN, upper-cases it and checks its
+// ISO 13616 check digits.
+func normalizeIBAN(value string) (string, error) {
+	iban := strings.ToUpper(strings.Join(strings.Fields(value), ""))
+	if iban == "" {
+		return "", nil
+	}
+	if len(iban) < 15 || len(iban) > 34 || iban[0] < 'A' || iban[0] > 'Z' || iban[1] < 'A' || iban[1] > 'Z' {
+		return "", fmt.Errorf("invalid IBAN value")
+	}
+
+	remainder := 0
+	for _, c := range iban[4:] + iban[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			remainder = (remainder*10 + int(c-'0')) % 97
+		case c >= 'A' && c <= 'Z':
+			remainder = (remainder*100 + int(c-'A') + 10) % 97
+		default:
+			return "", fmt.Errorf("invalid IBAN value")
+		}
+	}
+	if remainder != 1 {
+		return "", fmt.Errorf("invalid IBAN value")
+	}
+	return iban, nil
+}
+
+func parseSupplier(r *http.Request) (Supplier, error) {
+	supplier := Supplier{
+		Name:     strings.TrimSpace(r.FormValue("name")),
+		Contact:  strings.TrimSpace(r.FormValue("contact")),
+		Category: r.FormValue("category"),
+	}
+	if supplier.Name == "" {
+		return Supplier{}, fmt.Errorf("name cannot be empty")
+	}
+	if supplier.CategoryLabel() == "" {
+		return Supplier{}, fmt.Errorf("invalid category value")
+	}
+
+	var err error
+	if supplier.SIRET, err = normalizeSIRET(r.FormValue("siret")); err != nil {
+		return Supplier{}, err
+	}
+	if supplier.IBAN, err = normalizeIBAN(r.FormValue("iban")); err != nil {
+		return Supplier{}, err
+	}
+	return supplier, nil
+}
+
+// parseSupplierID reads the supplier_id form value of a bill. Zero (or no
+// value) stands for no supplier and is stored as NULL.
+func parseSupplierID(db *sql.DB, r *http.Request, userID string, coproprieteID int) (sql.NullInt64, error) {
+	value := r.FormValue("supplier_id")
+	if value == "" || value == "0" {
+		return sql.NullInt64{}, nil
+	}
+
+	supplierID, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return sql.NullInt64{}, fmt.Errorf("invalid supplier value")
+	}
+
+	return checkSupplierID(db, supplierID, userID, coproprieteID)
+}
+
+// checkSupplierID ensures a supplier belongs to the building. Zero stands for
+// no supplier and is stored as NULL.
+func checkSupplierID(db *sql.DB, supplierID int64, userID string, coproprieteID int) (sql.NullInt64, error) {
+	if supplierID == 0 {
+		return sql.NullInt64{}, nil
+	}
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM suppliers WHERE id = $1 AND userId = $2 AND coproprieteId = $3", supplierID, userID, coproprieteID).Scan(&count)
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+	if count == 0 {
+		return sql.NullInt64{}, fmt.Errorf("invalid supplier value")
+	}
+
+	return sql.NullInt64{Int64: supplierID, Valid: true}, nil
+}
+
+// parseSupplierFilter reads the supplier query parameter restricting the
+// expenses of the dashboard and of the Excel export. Zero keeps every
+// supplier.
+func parseSupplierFilter(r *http.Request, suppliers []Supplier) (int, error) {
+	value := r.URL.Query().Get("supplier")
+	if value == "" {
+		return 0, nil
+	}
+
+	supplierID, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid supplier value")
+	}
+	for _, supplier := range suppliers {
+		if supplier.ID == supplierID {
+			return supplierID, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid supplier value")
+}
+
+func SuppliersHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderSupplierForm(w, db, userID, coproprieteID, nil)
+}
+
+func EditSupplierHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	supplierID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid supplier id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	supplier, coproprieteID, err := getSupplier(db, supplierID, userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Supplier not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderSupplierForm(w, db, userID, coproprieteID, &supplier)
+}
+
+func AddSupplierHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	supplier, err := parseSupplier(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = db.Exec("INSERT INTO suppliers (name, siret, iban, contact, category, userId, coproprieteId) VALUES ($1, $2, $3, $4, $5, $6, $7)", supplier.Name, supplier.SIRET, supplier.IBAN, supplier.Contact, supplier.Category, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#supplier_added", http.StatusFound)
+}
+
+func UpdateSupplierHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	supplierID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid supplier id", http.StatusBadRequest)
+		return
+	}
+
+	supplier, err := parseSupplier(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := db.Exec("UPDATE suppliers SET name = $1, siret = $2, iban = $3, contact = $4, category = $5 WHERE id = $6 AND userId = $7", supplier.Name, supplier.SIRET, supplier.IBAN, supplier.Contact, supplier.Category, supplierID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "Supplier not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#supplier_updated", http.StatusFound)
+}
+
+func DeleteSupplierHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	supplierID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid supplier id", http.StatusBadRequest)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Bills of the supplier are kept without one through ON DELETE SET NULL.
+	result, err := db.Exec("DELETE FROM suppliers WHERE id = $1 AND userId = $2", supplierID, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "Supplier not found", http.StatusNotFound)
+		return
+	}
+
+	http.Redirect(w, r, "/dashboard#supplier_deleted", http.StatusFound)
+}
+
+// getSupplier loads a supplier by id along with the id of its copropriete,
+// returning sql.ErrNoRows when it does not exist or belongs to another user.
+func getSupplier(db *sql.DB, supplierID int, userID string) (Supplier, int, error) {
+	var supplier Supplier
+	var coproprieteID int
+	err := db.QueryRow("SELECT id, name, siret, iban, contact, category, coproprieteId FROM suppliers WHERE id = $1 AND userId = $2", supplierID, userID).
+		Scan(&supplier.ID, &supplier.Name, &supplier.SIRET, &supplier.IBAN, &supplier.Contact, &supplier.Category, &coproprieteID)
+	return supplier, coproprieteID, err
+}
+
+func getSuppliers(db *sql.DB, userID string, coproprieteID int) ([]Supplier, error) {
+	rows, err := db.Query("SELECT id, name, siret, iban, contact, category FROM suppliers WHERE userId = $1 AND coproprieteId = $2 ORDER BY name, id", userID, coproprieteID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var suppliers []Supplier
+	for rows.Next() {
+		var supplier Supplier
+		if err := rows.Scan(&supplier.ID, &supplier.Name, &supplier.SIRET, &supplier.IBAN, &supplier.Contact, &supplier.Category); err != nil {
+			return nil, err
+		}
+		suppliers = append(suppliers, supplier)
+	}
+	return suppliers, rows.Err()
+}
+
+func renderSupplierForm(w http.ResponseWriter, db *sql.DB, userID string, coproprieteID int, supplier *Supplier) {
+	suppliers, err := getSuppliers(db, userID, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t, err := template.ParseFiles("lib/templates/edit-suppliers.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	data := supplierFormData{
+		Supplier:   supplier,
+		Suppliers:  suppliers,
+		Categories: supplierCategories,
+	}
+
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
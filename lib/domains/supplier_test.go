@@ -0,0 +1,99 @@
+package domains
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestNormalizeSIRET(t *testing.T) {
+	valid := map[string]string{
+		"":                  "",
+		"732 829 320 00074": "73282932000074",
+		"35600000049837":    "35600000049837", // La Poste, checked on the sum of its digits
+	}
+	for value, expected := range valid {
+		if siret, err := normalizeSIRET(value); err != nil || siret != expected {
+			t.Errorf("normalizeSIRET(%q): expected %q, got %q (%v)", value, expected, siret, err)
+		}
+	}
+
+	for _, value := range []string{"73282932000075", "7328293200007", "7328293200007A", "35600000049838"} {
+		if _, err := normalizeSIRET(value); err == nil {
+			t.Errorf("Expected SIRET %q to be rejected", value)
+		}
+	}
+}
+
+func TestNormalizeIBAN(t *testing.T) {
+	valid := map[string]string{
+		"":                                  "",
+		"fr76 3000 6000 0112 3456 7890 189": "FR7630006000011234567890189",
+		"GB82WEST12345698765432":            "GB82WEST12345698765432",
+	}
+	for value, expected := range valid {
+		if iban, err := normalizeIBAN(value); err != nil || iban != expected {
+			t.Errorf("normalizeIBAN(%q): expected %q, got %q (%v)", value, expected, iban, err)
+		}
+	}
+
+	for _, value := range []string{"FR7630006000011234567890188", "FR76", "7630006000011234567890189", "FR76-3000-6000-0112-3456-7890-189"} {
+		if _, err := normalizeIBAN(value); err == nil {
+			t.Errorf("Expected IBAN %q to be rejected", value)
+		}
+	}
+
+	if label := (Supplier{IBAN: "FR7630006000011234567890189"}).IBANLabel(); label != "FR76 3000 6000 0112 3456 7890 189" {
+		t.Errorf("Unexpected IBAN label %q", label)
+	}
+}
+
+func TestSupplierTotals(t *testing.T) {
+	suppliers := []Supplier{{ID: 1, Name: "Otis"}, {ID: 2, Name: "Clean"}, {ID: 3, Name: "Unused"}}
+	bills := []Bill{
+		{Amount: 10000, SupplierID: 2},
+		{Amount: 5000},
+		{Amount: 30000, SupplierID: 1},
+		{Amount: 20000, SupplierID: 2},
+	}
+
+	totals := SupplierTotals(suppliers, bills)
+	if len(totals) != 3 {
+		t.Fatalf("Expected 3 totals, got %v", totals)
+	}
+	if totals[0].Supplier.Name != "Clean" || totals[0].Amount != 30000 || totals[0].Bills != 2 {
+		t.Errorf("Expected Clean first with 300 € over 2 bills, got %+v", totals[0])
+	}
+	if totals[1].Supplier.Name != "Otis" || totals[1].Amount != 30000 {
+		t.Errorf("Expected Otis second, got %+v", totals[1])
+	}
+	if totals[2].Supplier.ID != 0 || totals[2].Amount != 5000 {
+		t.Errorf("Expected the bills without supplier last, got %+v", totals[2])
+	}
+}
+
+func TestDashboardSupplierFilter(t *testing.T) {
+	period, err := ParsePeriod(url.Values{"period": {"year"}, "year": {"2025"}}, time.January, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := DashboardData{
+		Period: period,
+		Bills:  []Bill{{ID: 1, SupplierID: 2}, {ID: 2}, {ID: 3, SupplierID: 2}},
+	}
+
+	if bills := data.FilteredBills(); len(bills) != 3 {
+		t.Errorf("Expected every bill without filter, got %v", bills)
+	}
+	data.SupplierID = 2
+	if bills := data.FilteredBills(); len(bills) != 2 || bills[0].ID != 1 || bills[1].ID != 3 {
+		t.Errorf("Expected the bills of the supplier, got %v", bills)
+	}
+
+	if query := data.FilterQuery(2); query != "period=year&year=2025&supplier=2" {
+		t.Errorf("Unexpected filter query %q", query)
+	}
+	if query := (DashboardData{}).FilterQuery(2); query != "supplier=2" {
+		t.Errorf("Unexpected filter query %q", query)
+	}
+}
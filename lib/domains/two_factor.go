@@ -0,0 +1,500 @@
+package domains
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+
+	"github.com/go-session/session/v3"
+)
+
+const (
+	totpIssuer = "Tanzia"
+	// recoveryCodeCount is how many recovery codes a user gets at once.
+	recoveryCodeCount = 10
+
+	twoFactorLoginPurpose = "login-2fa"
+	// twoFactorLoginLifetime is how long a user has to type their code once
+	// their password was checked.
+	twoFactorLoginLifetime = 5 * time.Minute
+	twoFactorLoginCookie   = "tanzia-2fa"
+)
+
+type twoFactorData struct {
+	Email   string
+	Enabled bool
+	// Secret and ProvisioningURI are those being enrolled, before
+	// two-factor authentication is enabled.
+	Secret          string
+	ProvisioningURI template.URL
+	// RecoveryCodes are the codes just generated, shown once.
+	RecoveryCodes     []string
+	RecoveryCodesLeft int
+	// RequiredBy are the buildings requiring two-factor authentication,
+	// which then cannot be disabled.
+	RequiredBy []string
+}
+
+// TwoFactorLoginHandler checks the TOTP or recovery code of a user whose
+// password was accepted by LoginHandler, and starts their session. Wrong
+// codes count towards a lockout of their own, which logging in again with
+// the password does not reset.
+func TwoFactorLoginHandler(w http.ResponseWriter, r *http.Request) {
+	store, err := session.Start(context.Background(), w, r)
+	if err != nil {
+		log.Printf("Session error: %v", err)
+		http.Error(w, "Session error", http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := pendingTwoFactorLogin(r, time.Now())
+	if !ok {
+		http.Redirect(w, r, "/login#2fa_expired", http.StatusFound)
+		return
+	}
+
+	rateLimiter := helpers.GetRateLimiter()
+	limiterKey := twoFactorLimiterKey(userID)
+	if rateLimiter.IsLocked(limiterKey) {
+		clearTwoFactorLogin(w)
+		remaining := rateLimiter.GetLockoutRemaining(limiterKey)
+		minutes := int(remaining.Minutes()) + 1
+		http.Redirect(w, r, fmt.Sprintf("/login#locked-%d", minutes), http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	valid, err := verifySecondFactor(db, userID, r.FormValue("code"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		if rateLimiter.RecordFailedAttempt(limiterKey) {
+			clearTwoFactorLogin(w)
+			http.Redirect(w, r, "/login#locked-15", http.StatusFound)
+			return
+		}
+		remaining := rateLimiter.GetRemainingAttempts(limiterKey)
+		http.Redirect(w, r, fmt.Sprintf("/login/2fa#invalid-%d", remaining), http.StatusFound)
+		return
+	}
+
+	rateLimiter.ResetAttempts(limiterKey)
+	clearTwoFactorLogin(w)
+
+	var needsPasswordReset bool
+	err = db.QueryRow("SELECT COALESCE(needs_password_reset, FALSE) FROM users WHERE id = $1", userID).Scan(&needsPasswordReset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	completeLogin(w, r, store, db, userID, needsPasswordReset)
+}
+
+// TwoFactorHandler shows the two-factor authentication settings, along with
+// a new secret to enroll while it is disabled.
+func TwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderTwoFactor(w, db, userID, nil)
+}
+
+// EnableTwoFactorHandler enables two-factor authentication once the user
+// proved their authenticator app has the enrolled secret, and shows their
+// recovery codes.
+func EnableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var secret string
+	err = db.QueryRow("SELECT COALESCE(totpSecret, '') FROM users WHERE id = $1 AND totpEnabledAt IS NULL", userID).Scan(&secret)
+	if err == sql.ErrNoRows || secret == "" {
+		http.Redirect(w, r, "/account/2fa", http.StatusFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	valid, err := acceptTOTP(db, userID, secret, r.FormValue("code"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Redirect(w, r, "/account/2fa#invalid", http.StatusFound)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("UPDATE users SET totpEnabledAt = NOW() WHERE id = $1", userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderTwoFactor(w, db, userID, codes)
+}
+
+// RegenerateRecoveryCodesHandler replaces the recovery codes of the user,
+// e.g. once most of them were used.
+func RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !confirmSecondFactor(w, r, db, userID) {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderTwoFactor(w, db, userID, codes)
+}
+
+// DisableTwoFactorHandler turns two-factor authentication off, given the
+// password and a code of the user. It is refused while one of their
+// buildings requires it.
+func DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	requiredBy, err := getTwoFactorBuildings(db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(requiredBy) > 0 {
+		http.Redirect(w, r, "/account/2fa#required", http.StatusFound)
+		return
+	}
+
+	var storedPassword string
+	if err := db.QueryRow("SELECT password FROM users WHERE id = $1", userID).Scan(&storedPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !checkStoredPassword(r.FormValue("password"), storedPassword) {
+		http.Redirect(w, r, "/account/2fa#invalid_password", http.StatusFound)
+		return
+	}
+
+	if !confirmSecondFactor(w, r, db, userID) {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("UPDATE users SET totpSecret = NULL, totpEnabledAt = NULL, totpLastCounter = NULL WHERE id = $1", userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE userId = $1", userID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/account/2fa#disabled", http.StatusFound)
+}
+
+// confirmSecondFactor checks the code form value of a logged in user before
+// a sensitive change, redirecting to the settings page when it is wrong.
+// Failures share the lockout of TwoFactorLoginHandler.
+func confirmSecondFactor(w http.ResponseWriter, r *http.Request, db *sql.DB, userID string) bool {
+	rateLimiter := helpers.GetRateLimiter()
+	limiterKey := twoFactorLimiterKey(userID)
+	if rateLimiter.IsLocked(limiterKey) {
+		http.Redirect(w, r, "/account/2fa#locked", http.StatusFound)
+		return false
+	}
+
+	valid, err := verifySecondFactor(db, userID, r.FormValue("code"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !valid {
+		rateLimiter.RecordFailedAttempt(limiterKey)
+		http.Redirect(w, r, "/account/2fa#invalid", http.StatusFound)
+		return false
+	}
+
+	rateLimiter.ResetAttempts(limiterKey)
+	return true
+}
+
+func renderTwoFactor(w http.ResponseWriter, db *sql.DB, userID string, recoveryCodes []string) {
+	data := twoFactorData{RecoveryCodes: recoveryCodes}
+	var secret string
+	err := db.QueryRow("SELECT COALESCE(email, ''), totpEnabledAt IS NOT NULL, COALESCE(totpSecret, '') FROM users WHERE id = $1", userID).Scan(&data.Email, &data.Enabled, &secret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !data.Enabled {
+		// The secret is kept until enrollment completes, so that reloading
+		// the page does not invalidate an app already set up.
+		if secret == "" {
+			secret, err = helpers.GenerateTOTPSecret()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if _, err := db.Exec("UPDATE users SET totpSecret = $1 WHERE id = $2 AND totpEnabledAt IS NULL", secret, userID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		data.Secret = secret
+		// The URI only holds the base32 secret and the escaped address.
+		data.ProvisioningURI = template.URL(helpers.TOTPProvisioningURI(totpIssuer, data.Email, secret))
+	}
+
+	err = db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE userId = $1 AND usedAt IS NULL", userID).Scan(&data.RecoveryCodesLeft)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data.RequiredBy, err = getTwoFactorBuildings(db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t, err := template.ParseFiles("lib/templates/two-factor.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	// The page shows secrets: keep it out of caches.
+	w.Header().Set("Cache-Control", "no-store")
+	if err := t.Execute(w, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// verifySecondFactor checks code, either a TOTP code or an unused recovery
+// code, for a user who enabled two-factor authentication. A code is only
+// accepted once.
+func verifySecondFactor(db *sql.DB, userID, code string, now time.Time) (bool, error) {
+	if recoveryCode := helpers.NormalizeRecoveryCode(code); recoveryCode != "" {
+		result, err := db.Exec("UPDATE recovery_codes SET usedAt = NOW() WHERE userId = $1 AND codeHash = $2 AND usedAt IS NULL", userID, helpers.HashToken(recoveryCode))
+		if err != nil {
+			return false, err
+		}
+		affected, err := result.RowsAffected()
+		return affected > 0, err
+	}
+
+	var secret string
+	err := db.QueryRow("SELECT totpSecret FROM users WHERE id = $1 AND totpEnabledAt IS NOT NULL", userID).Scan(&secret)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return acceptTOTP(db, userID, secret, code, now)
+}
+
+// acceptTOTP checks code against secret and records its time step, so that
+// a code seen by someone else cannot be replayed.
+func acceptTOTP(db *sql.DB, userID, secret, code string, now time.Time) (bool, error) {
+	counter, ok := helpers.ValidateTOTP(secret, code, now)
+	if !ok {
+		return false, nil
+	}
+
+	result, err := db.Exec("UPDATE users SET totpLastCounter = $1 WHERE id = $2 AND (totpLastCounter IS NULL OR totpLastCounter < $1)", counter, userID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// replaceRecoveryCodes returns new recovery codes for userID, invalidating
+// the previous ones. Only their hashes are stored.
+func replaceRecoveryCodes(tx *sql.Tx, userID string) ([]string, error) {
+	codes, err := helpers.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, fmt.Errorf("error generating recovery codes: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE userId = $1", userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (codeHash, userId) VALUES ($1, $2)", helpers.HashToken(code), userID); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+func isTwoFactorEnabled(db *sql.DB, userID string) (bool, error) {
+	var enabled bool
+	err := db.QueryRow("SELECT totpEnabledAt IS NOT NULL FROM users WHERE id = $1", userID).Scan(&enabled)
+	return enabled, err
+}
+
+// getTwoFactorBuildings returns the names of the buildings of userID that
+// require two-factor authentication.
+func getTwoFactorBuildings(db *sql.DB, userID string) ([]string, error) {
+	rows, err := db.Query("SELECT name FROM coproprietes WHERE userId = $1 AND requireTwoFactor ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// startTwoFactorLogin remembers, in a short-lived signed cookie, that the
+// password of userID was checked.
+func startTwoFactorLogin(w http.ResponseWriter, userID string, now time.Time) error {
+	token, err := helpers.SignToken(twoFactorLoginPurpose, userID, now.Add(twoFactorLoginLifetime))
+	if err != nil {
+		return fmt.Errorf("error generating login token: %w", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     twoFactorLoginCookie,
+		Value:    token,
+		Path:     "/login",
+		MaxAge:   int(twoFactorLoginLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// pendingTwoFactorLogin returns the user whose password was checked by the
+// login form of r.
+func pendingTwoFactorLogin(r *http.Request, now time.Time) (string, bool) {
+	cookie, err := r.Cookie(twoFactorLoginCookie)
+	if err != nil {
+		return "", false
+	}
+	userID, err := helpers.VerifySignedToken(twoFactorLoginPurpose, cookie.Value, now)
+	return userID, err == nil
+}
+
+func clearTwoFactorLogin(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     twoFactorLoginCookie,
+		Value:    "",
+		Path:     "/login",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// twoFactorLimiterKey is the RateLimiter identifier counting the wrong codes
+// of userID, apart from their wrong passwords.
+func twoFactorLimiterKey(userID string) string {
+	return "2fa:" + userID
+}
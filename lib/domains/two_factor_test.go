@@ -0,0 +1,48 @@
+package domains
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+func TestTwoFactorLoginCookie(t *testing.T) {
+	now := time.Now()
+	recorder := httptest.NewRecorder()
+	if err := startTwoFactorLogin(recorder, "42", now); err != nil {
+		t.Fatalf("startTwoFactorLogin failed: %v", err)
+	}
+
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != twoFactorLoginCookie || !cookies[0].HttpOnly || cookies[0].Path != "/login" {
+		t.Fatalf("Unexpected cookies %+v", cookies)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/login/2fa", nil)
+	r.AddCookie(cookies[0])
+	if userID, ok := pendingTwoFactorLogin(r, now); !ok || userID != "42" {
+		t.Errorf("Expected user 42, got %q, %v", userID, ok)
+	}
+	if _, ok := pendingTwoFactorLogin(r, now.Add(twoFactorLoginLifetime+time.Second)); ok {
+		t.Error("Expected the pending login to expire")
+	}
+
+	// A token made for another purpose, such as a password reset link, must
+	// not pass for a checked password.
+	token, err := helpers.SignToken(passwordResetPurpose, "42", now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("SignToken failed: %v", err)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/login/2fa", nil)
+	r.AddCookie(&http.Cookie{Name: twoFactorLoginCookie, Value: token})
+	if _, ok := pendingTwoFactorLogin(r, now); ok {
+		t.Error("Expected a password reset token to be rejected")
+	}
+
+	if _, ok := pendingTwoFactorLogin(httptest.NewRequest(http.MethodPost, "/login/2fa", nil), now); ok {
+		t.Error("Expected a request without cookie to be rejected")
+	}
+}
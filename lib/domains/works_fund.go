@@ -0,0 +1,176 @@
+package domains
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/duscraft/tanzia/lib/helpers"
+)
+
+// minimumWorksFundRate is the minimum yearly contribution to the works fund
+// required by the ALUR law, as a percentage of the budget.
+const minimumWorksFundRate = 5
+
+// WorksFund is the ledger of the works fund (fonds de travaux): the
+// contributions called from the owners and the works it paid for. It is kept
+// apart from the charges since contributions are attached to the lots and not
+// refunded when a lot is sold.
+type WorksFund struct {
+	Contributions Money
+	Spent         Money
+}
+
+// NewWorksFund returns the ledger of the works fund entries among bills and
+// provisions.
+func NewWorksFund(bills []Bill, provisions []Provision) WorksFund {
+	var fund WorksFund
+	for _, provision := range provisions {
+		if provision.WorksFund {
+			fund.Contributions += provision.Amount
+		}
+	}
+	for _, bill := range bills {
+		if bill.WorksFund {
+			fund.Spent += bill.Amount
+		}
+	}
+	return fund
+}
+
+// Balance returns what is left in the fund.
+func (fund WorksFund) Balance() Money {
+	return fund.Contributions - fund.Spent
+}
+
+// IsEmpty reports whether the ledger has no entry at all.
+func (fund WorksFund) IsEmpty() bool {
+	return fund.Contributions == 0 && fund.Spent == 0
+}
+
+// WorksFundContribution returns the yearly contribution to the works fund
+// for a budget, rounded up to the cent so that it never falls below rate.
+func WorksFundContribution(budget Money, rate int) Money {
+	if budget <= 0 || rate <= 0 {
+		return 0
+	}
+	return Money((int64(budget)*int64(rate) + 99) / 100)
+}
+
+// WorksFundLine returns the works fund contribution of a budget as a budget
+// line split with the general tantièmes.
+func WorksFundLine(lines []BudgetLine, fiscalYear int, rate int) BudgetLine {
+	var budget Money
+	for _, line := range lines {
+		budget += line.Amount
+	}
+	return BudgetLine{
+		FiscalYear: fiscalYear,
+		Label:      fmt.Sprintf("Fonds de travaux (%d %%)", rate),
+		Amount:     WorksFundContribution(budget, rate),
+	}
+}
+
+// WorksFundProvisions turns the works fund contribution of a fiscal year into
+// four quarterly provisions, dated on the first day of each quarter.
+func WorksFundProvisions(line BudgetLine, fiscalYear int, fiscalYearStart time.Month) []Provision {
+	if line.Amount == 0 {
+		return nil
+	}
+	provisions := make([]Provision, 4)
+	for quarter := 1; quarter <= 4; quarter++ {
+		provisions[quarter-1] = Provision{
+			Label:      fmt.Sprintf("Fonds de travaux T%d %s", quarter, FiscalYearLabel(fiscalYear, fiscalYearStart)),
+			Amount:     line.QuarterAmount(quarter),
+			Date:       quarterStartDate(fiscalYear, fiscalYearStart, quarter),
+			FiscalYear: fiscalYear,
+			WorksFund:  true,
+		}
+	}
+	return provisions
+}
+
+type worksFundPageData struct {
+	Copropriete Copropriete
+	YearLabel   string
+	Line        BudgetLine
+	Fund        WorksFund
+	Provisions  []Provision
+	Bills       []Bill
+	Persons     []Person
+	Allocator   Allocator
+}
+
+// WorksFundHandler shows the works fund ledger since the building was
+// created, with the contributions called from every owner.
+func WorksFundHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := GetAuthenticatedUserID(w, r)
+	if !ok {
+		http.Redirect(w, r, "/logout", http.StatusFound)
+		return
+	}
+
+	db, err := helpers.GetConnectionManager().GetConnection("postgres")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	coproprieteID, err := GetCurrentCoproprieteID(w, r, db, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fiscalYearStart, err := getFiscalYearStart(db, coproprieteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, err := getDashboardData(userID, coproprieteID, Period{FiscalYearStart: fiscalYearStart})
+	if err != nil {
+		log.Printf("Error getting dashboard data: %v", err)
+		http.Error(w, "Failed to load data", http.StatusInternalServerError)
+		return
+	}
+
+	fiscalYear := FiscalYearOf(time.Now(), fiscalYearStart)
+	lines, err := getBudgetLines(db, userID, coproprieteID, fiscalYear)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page := worksFundPageData{
+		Copropriete: data.Copropriete,
+		YearLabel:   FiscalYearLabel(fiscalYear, fiscalYearStart),
+		Line:        WorksFundLine(lines, fiscalYear, data.Copropriete.WorksFundRate),
+		Fund:        data.WorksFund,
+		Persons:     data.Persons,
+		Allocator:   data.Allocator,
+	}
+	for _, provision := range data.Provisions {
+		if provision.WorksFund {
+			page.Provisions = append(page.Provisions, provision)
+		}
+	}
+	for _, bill := range data.Bills {
+		if bill.WorksFund {
+			page.Bills = append(page.Bills, bill)
+		}
+	}
+
+	t, err := template.ParseFiles("lib/templates/works-fund.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	if err := t.Execute(w, page); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,76 @@
+package domains
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWorksFundContributionRoundsUp(t *testing.T) {
+	if amount := WorksFundContribution(1000001, 5); amount != 50001 {
+		t.Errorf("Expected 500,01, got %s", amount)
+	}
+	if amount := WorksFundContribution(1000000, 5); amount != 50000 {
+		t.Errorf("Expected 500,00, got %s", amount)
+	}
+	if amount := WorksFundContribution(1000000, 0); amount != 0 {
+		t.Errorf("Expected no contribution with a zero rate, got %s", amount)
+	}
+}
+
+func TestWorksFundProvisionsAddUpToTheContribution(t *testing.T) {
+	lines := []BudgetLine{{Label: "Entretien", Amount: 600000}, {Label: "Assurance", Amount: 400001}}
+	line := WorksFundLine(lines, 2025, 5)
+	if line.Amount != 50001 {
+		t.Fatalf("Expected a contribution of 500,01, got %s", line.Amount)
+	}
+
+	provisions := WorksFundProvisions(line, 2025, time.January)
+	if len(provisions) != 4 {
+		t.Fatalf("Expected 4 provisions, got %d", len(provisions))
+	}
+	var total Money
+	for _, provision := range provisions {
+		if !provision.WorksFund {
+			t.Errorf("Expected %q to be booked on the works fund", provision.Label)
+		}
+		total += provision.Amount
+	}
+	if total != line.Amount {
+		t.Errorf("Expected the provisions to add up to %s, got %s", line.Amount, total)
+	}
+
+	if provisions := WorksFundProvisions(BudgetLine{}, 2025, time.January); provisions != nil {
+		t.Errorf("Expected no provision without contribution, got %v", provisions)
+	}
+}
+
+func TestWorksFundStaysOutOfTheCharges(t *testing.T) {
+	persons := []Person{{ID: 1, Name: "Alice", Tantieme: 600}, {ID: 2, Name: "Bob", Tantieme: 400}}
+	allocator := NewAllocator(persons)
+	bills := []Bill{
+		{Label: "Entretien", Amount: 100000, FiscalYear: 2025},
+		{Label: "Ravalement", Amount: 30000, FiscalYear: 2025, WorksFund: true},
+	}
+	provisions := []Provision{
+		{Label: "Appel de fonds", Amount: 100000, FiscalYear: 2025},
+		{Label: "Fonds de travaux", Amount: 50000, FiscalYear: 2025, WorksFund: true},
+	}
+
+	fund := NewWorksFund(bills, provisions)
+	if fund.Contributions != 50000 || fund.Spent != 30000 || fund.Balance() != 20000 {
+		t.Errorf("Expected 500,00 contributed, 300,00 spent and 200,00 left, got %+v", fund)
+	}
+
+	if left := persons[0].CalculateLeft(allocator, bills, provisions); left != 0 {
+		t.Errorf("Expected the charges to be balanced, got %s", left)
+	}
+	if contributed := persons[0].CalculateWorksFund(allocator, provisions); contributed != 30000 {
+		t.Errorf("Expected Alice to contribute 300,00, got %s", contributed)
+	}
+
+	for _, line := range Regularize(allocator, bills, provisions, 2025) {
+		if line.Balance != 0 {
+			t.Errorf("Expected no regularization for %s, got %s", line.Person.Name, line.Balance)
+		}
+	}
+}
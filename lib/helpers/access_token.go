@@ -0,0 +1,47 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+const (
+	// AccessTokenPrefix starts every personal access token, so that leaked
+	// tokens are easy to spot in logs and by secret scanners.
+	AccessTokenPrefix = "tzp_"
+	accessTokenLength = 32
+	// accessTokenHintLength is how many characters of the token are kept in
+	// clear to tell the tokens of an account apart.
+	accessTokenHintLength = len(AccessTokenPrefix) + 6
+)
+
+// GenerateAccessToken returns a new personal access token along with the
+// hint identifying it on the account page.
+func GenerateAccessToken() (token, hint string, err error) {
+	bytes := make([]byte, accessTokenLength)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", "", err
+	}
+	token = AccessTokenPrefix + base64.RawURLEncoding.EncodeToString(bytes)
+	return token, token[:accessTokenHintLength], nil
+}
+
+// HashAccessToken returns the hash under which a token is stored. Tokens
+// are 256 random bits, so a plain SHA-256 is enough: unlike passwords they
+// cannot be guessed, and it lets a token be looked up by its hash.
+func HashAccessToken(token string) string {
+	return HashToken(token)
+}
+
+// BearerToken returns the token of the Authorization header of r, if it
+// uses the Bearer scheme.
+func BearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
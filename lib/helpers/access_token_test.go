@@ -0,0 +1,62 @@
+package helpers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGenerateAccessToken(t *testing.T) {
+	token, hint, err := GenerateAccessToken()
+	if err != nil {
+		t.Fatalf("GenerateAccessToken failed: %v", err)
+	}
+	if !strings.HasPrefix(token, AccessTokenPrefix) || len(token) != len(AccessTokenPrefix)+43 {
+		t.Errorf("Unexpected token %q", token)
+	}
+	if !strings.HasPrefix(token, hint) || len(hint) != accessTokenHintLength {
+		t.Errorf("Expected hint %q to start the token", hint)
+	}
+
+	other, _, err := GenerateAccessToken()
+	if err != nil || other == token {
+		t.Errorf("Expected two distinct tokens, got %q twice (%v)", token, err)
+	}
+}
+
+func TestHashAccessToken(t *testing.T) {
+	hash := HashAccessToken("tzp_example")
+	if hash != HashAccessToken("tzp_example") {
+		t.Error("Expected the hash to be deterministic")
+	}
+	if hash == HashAccessToken("tzp_example2") || len(hash) != 64 || strings.Contains(hash, "example") {
+		t.Errorf("Unexpected hash %q", hash)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected string
+		ok       bool
+	}{
+		{"Bearer tzp_abc", "tzp_abc", true},
+		{"bearer tzp_abc", "tzp_abc", true},
+		{"Bearer  tzp_abc ", "tzp_abc", true},
+		{"Basic dXNlcjpwYXNz", "", false},
+		{"Bearer", "", false},
+		{"Bearer ", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/v1/coproprietes", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		token, ok := BearerToken(r)
+		if token != tt.expected || ok != tt.ok {
+			t.Errorf("BearerToken(%q) = %q, %v, expected %q, %v", tt.header, token, ok, tt.expected, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrBlobNotFound is returned by BlobStore.Get when no blob has the key.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps the files uploaded by users, such as the invoices attached
+// to bills. Keys are slash-separated paths chosen by the application.
+type BlobStore interface {
+	Put(key string, content io.Reader, size int64, contentType string) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+var (
+	blobStore     BlobStore
+	blobStoreErr  error
+	blobStoreOnce sync.Once
+)
+
+// GetBlobStore returns the blob store configured by the environment:
+// STORAGE_DRIVER is "local" (the default) or "s3". The local driver writes
+// under STORAGE_PATH; the S3 driver talks to S3_ENDPOINT with S3_REGION,
+// S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY.
+func GetBlobStore() (BlobStore, error) {
+	blobStoreOnce.Do(func() {
+		switch driver := os.Getenv("STORAGE_DRIVER"); driver {
+		case "", "local":
+			root := os.Getenv("STORAGE_PATH")
+			if root == "" {
+				root = "data/attachments"
+			}
+			blobStore = NewLocalBlobStore(root)
+		case "s3":
+			blobStore, blobStoreErr = NewS3BlobStore(S3Config{
+				Endpoint:        os.Getenv("S3_ENDPOINT"),
+				Region:          os.Getenv("S3_REGION"),
+				Bucket:          os.Getenv("S3_BUCKET"),
+				AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+				SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			})
+		default:
+			blobStoreErr = fmt.Errorf("unknown storage driver %q", driver)
+		}
+	})
+	return blobStore, blobStoreErr
+}
+
+// LocalBlobStore keeps blobs as files under a root directory.
+type LocalBlobStore struct {
+	root string
+}
+
+func NewLocalBlobStore(root string) *LocalBlobStore {
+	return &LocalBlobStore{root: root}
+}
+
+func (store *LocalBlobStore) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+	return filepath.Join(store.root, filepath.FromSlash(key)), nil
+}
+
+func (store *LocalBlobStore) Put(key string, content io.Reader, size int64, contentType string) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("error creating blob directory: %w", err)
+	}
+
+	// Write to a temporary file first so that a failed upload never leaves a
+	// truncated blob behind.
+	file, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("error creating blob: %w", err)
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+
+	if _, err := io.Copy(file, content); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("error writing blob: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing blob: %w", err)
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("error writing blob: %w", err)
+	}
+	return nil
+}
+
+func (store *LocalBlobStore) Get(key string) (io.ReadCloser, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading blob: %w", err)
+	}
+	return file, nil
+}
+
+func (store *LocalBlobStore) Delete(key string) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error deleting blob: %w", err)
+	}
+	return nil
+}
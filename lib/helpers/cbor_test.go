@@ -0,0 +1,161 @@
+package helpers
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// encodeCBOR encodes the values decodeCBOR produces, for tests building
+// authenticator responses. Map keys are sorted, integers first, as CTAP2
+// authenticators do.
+func encodeCBOR(value any) []byte {
+	switch value := value.(type) {
+	case int:
+		return encodeCBOR(int64(value))
+	case int64:
+		if value < 0 {
+			return encodeCBORHead(1, uint64(-1-value))
+		}
+		return encodeCBORHead(0, uint64(value))
+	case []byte:
+		return append(encodeCBORHead(2, uint64(len(value))), value...)
+	case string:
+		return append(encodeCBORHead(3, uint64(len(value))), value...)
+	case []any:
+		encoded := encodeCBORHead(4, uint64(len(value)))
+		for _, item := range value {
+			encoded = append(encoded, encodeCBOR(item)...)
+		}
+		return encoded
+	case map[any]any:
+		keys := make([]any, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, aIsInt := cborIntKey(keys[i])
+			b, bIsInt := cborIntKey(keys[j])
+			if aIsInt != bIsInt {
+				return aIsInt
+			}
+			if aIsInt {
+				return a < b
+			}
+			return keys[i].(string) < keys[j].(string)
+		})
+		encoded := encodeCBORHead(5, uint64(len(value)))
+		for _, key := range keys {
+			encoded = append(encoded, encodeCBOR(key)...)
+			encoded = append(encoded, encodeCBOR(value[key])...)
+		}
+		return encoded
+	case bool:
+		if value {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	case nil:
+		return []byte{0xf6}
+	}
+	panic("encodeCBOR: unsupported type")
+}
+
+func cborIntKey(key any) (int64, bool) {
+	switch key := key.(type) {
+	case int:
+		return int64(key), true
+	case int64:
+		return key, true
+	}
+	return 0, false
+}
+
+func encodeCBORHead(major byte, argument uint64) []byte {
+	switch {
+	case argument < 24:
+		return []byte{major<<5 | byte(argument)}
+	case argument <= 0xff:
+		return []byte{major<<5 | 24, byte(argument)}
+	case argument <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(argument))
+	case argument <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(argument))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, argument)
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	// Examples of RFC 8949 appendix A.
+	examples := map[string]any{
+		"00":                 int64(0),
+		"17":                 int64(23),
+		"1818":               int64(24),
+		"1903e8":             int64(1000),
+		"1b000000e8d4a51000": int64(1000000000000),
+		"20":                 int64(-1),
+		"3903e7":             int64(-1000),
+		"40":                 []byte{},
+		"4401020304":         []byte{1, 2, 3, 4},
+		"6161":               "a",
+		"62c3bc":             "ü",
+		"83010203":           []any{int64(1), int64(2), int64(3)},
+		"a201020304":         map[any]any{int64(1): int64(2), int64(3): int64(4)},
+		"a26161016162820203": map[any]any{"a": int64(1), "b": []any{int64(2), int64(3)}},
+		"f4":                 false,
+		"f5":                 true,
+		"f6":                 nil,
+		"f93c00":             float64(1),
+		"fb3ff199999999999a": 1.1,
+		"c11a514b67b0":       int64(1363896240),
+	}
+	for input, expected := range examples {
+		data, _ := hex.DecodeString(input)
+		value, rest, err := decodeCBOR(data)
+		if err != nil || len(rest) != 0 || !reflect.DeepEqual(value, expected) {
+			t.Errorf("decodeCBOR(%s) = %#v, %x (%v), expected %#v", input, value, rest, err, expected)
+		}
+	}
+
+	value, rest, err := decodeCBOR([]byte{0x01, 0x02})
+	if err != nil || value != int64(1) || len(rest) != 1 {
+		t.Errorf("Expected the bytes following the item to be returned, got %v, %x (%v)", value, rest, err)
+	}
+}
+
+func TestDecodeCBORRejectsInvalidData(t *testing.T) {
+	invalid := []string{
+		"",                   // empty
+		"18",                 // truncated argument
+		"4401",               // truncated byte string
+		"9bffffffffffffffff", // array longer than the data
+		"5f4101ff",           // indefinite length
+		"a20102" + "0103",    // duplicate key
+		"a1800102",           // array as a map key
+		"1bffffffffffffffff", // integer overflow
+	}
+	for _, input := range invalid {
+		data, _ := hex.DecodeString(input)
+		if value, _, err := decodeCBOR(data); err == nil {
+			t.Errorf("Expected decodeCBOR(%s) to fail, got %#v", input, value)
+		}
+	}
+
+	nested := make([]byte, cborMaxDepth+2)
+	for i := range nested {
+		nested[i] = 0x81
+	}
+	if _, _, err := decodeCBOR(append(nested, 0x00)); err == nil {
+		t.Error("Expected deeply nested items to be rejected")
+	}
+}
+
+func TestEncodeCBORRoundTrip(t *testing.T) {
+	value := map[any]any{"fmt": "none", "attStmt": map[any]any{}, "authData": []byte{1, 2}, int64(-2): int64(-300), int64(1): []any{true, nil}}
+	decoded, rest, err := decodeCBOR(encodeCBOR(value))
+	if err != nil || len(rest) != 0 || !reflect.DeepEqual(decoded, value) {
+		t.Errorf("Round trip gave %#v (%v)", decoded, err)
+	}
+}
@@ -89,9 +89,70 @@ func getURL(driver string) string {
 func createTables(db *sql.DB) error {
 	queries := []string{
 		"CREATE TABLE IF NOT EXISTS users (id SERIAL PRIMARY KEY, name TEXT, email TEXT UNIQUE, password TEXT, is_premium BOOLEAN DEFAULT FALSE, stripe_customer_id TEXT, needs_password_reset BOOLEAN DEFAULT FALSE)",
-		"CREATE TABLE IF NOT EXISTS persons (name TEXT, tantieme INTEGER, userId INTEGER REFERENCES users(id))",
-		"CREATE TABLE IF NOT EXISTS bills (label TEXT, amount FLOAT, userId INTEGER REFERENCES users(id))",
-		"CREATE TABLE IF NOT EXISTS provisions (label TEXT, amount FLOAT, userId INTEGER REFERENCES users(id))",
+		"CREATE TABLE IF NOT EXISTS coproprietes (id SERIAL PRIMARY KEY, name TEXT, fiscalYearStart INTEGER DEFAULT 1, worksFundRate INTEGER DEFAULT 5, userId INTEGER REFERENCES users(id))",
+		"CREATE TABLE IF NOT EXISTS persons (id SERIAL PRIMARY KEY, name TEXT, tantieme INTEGER, userId INTEGER REFERENCES users(id), coproprieteId INTEGER REFERENCES coproprietes(id))",
+		// persons, bills and provisions predate their id, which the tables
+		// referencing them need: it is added to older databases right away.
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='persons' AND column_name='id'
+			) THEN
+				ALTER TABLE persons ADD COLUMN id SERIAL PRIMARY KEY;
+			END IF;
+		END $$;`,
+		"CREATE TABLE IF NOT EXISTS lots (id SERIAL PRIMARY KEY, name TEXT, tantieme INTEGER, userId INTEGER REFERENCES users(id), coproprieteId INTEGER REFERENCES coproprietes(id))",
+		// A NULL startDate means the person has owned the lot since it was created.
+		"CREATE TABLE IF NOT EXISTS lot_owners (id SERIAL PRIMARY KEY, lotId INTEGER REFERENCES lots(id) ON DELETE CASCADE, personId INTEGER REFERENCES persons(id) ON DELETE CASCADE, startDate DATE)",
+		"CREATE TABLE IF NOT EXISTS charge_keys (id SERIAL PRIMARY KEY, name TEXT, userId INTEGER REFERENCES users(id), coproprieteId INTEGER REFERENCES coproprietes(id))",
+		// persons.tantieme and charge_key_tantiemes predate lots; they are only
+		// read by the migration moving them to lots.
+		"CREATE TABLE IF NOT EXISTS charge_key_tantiemes (chargeKeyId INTEGER REFERENCES charge_keys(id) ON DELETE CASCADE, personId INTEGER REFERENCES persons(id) ON DELETE CASCADE, tantieme INTEGER, PRIMARY KEY (chargeKeyId, personId))",
+		"CREATE TABLE IF NOT EXISTS charge_key_lots (chargeKeyId INTEGER REFERENCES charge_keys(id) ON DELETE CASCADE, lotId INTEGER REFERENCES lots(id) ON DELETE CASCADE, tantieme INTEGER, PRIMARY KEY (chargeKeyId, lotId))",
+		"CREATE TABLE IF NOT EXISTS suppliers (id SERIAL PRIMARY KEY, name TEXT, siret TEXT, iban TEXT, contact TEXT, category TEXT, userId INTEGER REFERENCES users(id), coproprieteId INTEGER REFERENCES coproprietes(id))",
+		"CREATE TABLE IF NOT EXISTS bills (id SERIAL PRIMARY KEY, label TEXT, amount NUMERIC(14, 2), date DATE DEFAULT CURRENT_DATE, fiscalYear INTEGER, chargeKeyId INTEGER REFERENCES charge_keys(id) ON DELETE SET NULL, worksFund BOOLEAN DEFAULT FALSE, supplierId INTEGER REFERENCES suppliers(id) ON DELETE SET NULL, category TEXT, userId INTEGER REFERENCES users(id), coproprieteId INTEGER REFERENCES coproprietes(id))",
+		"CREATE TABLE IF NOT EXISTS provisions (id SERIAL PRIMARY KEY, label TEXT, amount NUMERIC(14, 2), date DATE DEFAULT CURRENT_DATE, fiscalYear INTEGER, chargeKeyId INTEGER REFERENCES charge_keys(id) ON DELETE SET NULL, budgetYear INTEGER, worksFund BOOLEAN DEFAULT FALSE, userId INTEGER REFERENCES users(id), coproprieteId INTEGER REFERENCES coproprietes(id))",
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='bills' AND column_name='id'
+			) THEN
+				ALTER TABLE bills ADD COLUMN id SERIAL PRIMARY KEY;
+			END IF;
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='provisions' AND column_name='id'
+			) THEN
+				ALTER TABLE provisions ADD COLUMN id SERIAL PRIMARY KEY;
+			END IF;
+		END $$;`,
+		"CREATE TABLE IF NOT EXISTS payments (id SERIAL PRIMARY KEY, personId INTEGER REFERENCES persons(id) ON DELETE CASCADE, date DATE, amount NUMERIC(14, 2), method TEXT, reference TEXT, userId INTEGER REFERENCES users(id), coproprieteId INTEGER REFERENCES coproprietes(id))",
+		"CREATE TABLE IF NOT EXISTS regularizations (id SERIAL PRIMARY KEY, fiscalYear INTEGER, personId INTEGER REFERENCES persons(id) ON DELETE CASCADE, amount NUMERIC(14, 2), userId INTEGER REFERENCES users(id), coproprieteId INTEGER REFERENCES coproprietes(id), UNIQUE (fiscalYear, personId))",
+		"CREATE TABLE IF NOT EXISTS budget_lines (id SERIAL PRIMARY KEY, fiscalYear INTEGER, label TEXT, amount NUMERIC(14, 2), chargeKeyId INTEGER REFERENCES charge_keys(id) ON DELETE SET NULL, userId INTEGER REFERENCES users(id), coproprieteId INTEGER REFERENCES coproprietes(id))",
+		"CREATE TABLE IF NOT EXISTS attachments (id SERIAL PRIMARY KEY, billId INTEGER REFERENCES bills(id) ON DELETE CASCADE, provisionId INTEGER REFERENCES provisions(id) ON DELETE CASCADE, filename TEXT, contentType TEXT, size BIGINT, storageKey TEXT, createdAt TIMESTAMP DEFAULT NOW(), userId INTEGER REFERENCES users(id), coproprieteId INTEGER REFERENCES coproprietes(id))",
+		"CREATE TABLE IF NOT EXISTS recurring_bills (id SERIAL PRIMARY KEY, label TEXT, amount NUMERIC(14, 2), chargeKeyId INTEGER REFERENCES charge_keys(id) ON DELETE SET NULL, frequency TEXT, startDate DATE, endDate DATE, provision BOOLEAN DEFAULT FALSE, generated INTEGER DEFAULT 0, supplierId INTEGER REFERENCES suppliers(id) ON DELETE SET NULL, category TEXT, userId INTEGER REFERENCES users(id), coproprieteId INTEGER REFERENCES coproprietes(id))",
+		// Personal access tokens are stored hashed, see HashAccessToken. A NULL
+		// expiresAt never expires.
+		"CREATE TABLE IF NOT EXISTS access_tokens (id SERIAL PRIMARY KEY, name TEXT, tokenHash TEXT UNIQUE, hint TEXT, scope TEXT, expiresAt TIMESTAMP, lastUsedAt TIMESTAMP, createdAt TIMESTAMP DEFAULT NOW(), userId INTEGER REFERENCES users(id) ON DELETE CASCADE)",
+		// Password reset tokens are single use: usedAt is set when consumed.
+		"CREATE TABLE IF NOT EXISTS password_resets (id SERIAL PRIMARY KEY, tokenHash TEXT UNIQUE, expiresAt TIMESTAMP, usedAt TIMESTAMP, createdAt TIMESTAMP DEFAULT NOW(), userId INTEGER REFERENCES users(id) ON DELETE CASCADE)",
+		// Emails are delivered from this queue and kept as a log once sent:
+		// status is pending, sent or failed. The bodies are cleared once the
+		// email leaves the queue, as they may hold links with working tokens.
+		"CREATE TABLE IF NOT EXISTS email_queue (id SERIAL PRIMARY KEY, toAddress TEXT, subject TEXT, textBody TEXT, htmlBody TEXT, template TEXT, status TEXT DEFAULT 'pending', attempts INTEGER DEFAULT 0, lastError TEXT, nextAttemptAt TIMESTAMP DEFAULT NOW(), createdAt TIMESTAMP DEFAULT NOW(), sentAt TIMESTAMP, userId INTEGER REFERENCES users(id) ON DELETE CASCADE)",
+		// Recovery codes replace a TOTP code once each; they are stored hashed.
+		"CREATE TABLE IF NOT EXISTS recovery_codes (id SERIAL PRIMARY KEY, codeHash TEXT, usedAt TIMESTAMP, createdAt TIMESTAMP DEFAULT NOW(), userId INTEGER REFERENCES users(id) ON DELETE CASCADE)",
+		// Passkeys: credentialId is base64url encoded and publicKey is the
+		// COSE key, see WebAuthnCredential.
+		"CREATE TABLE IF NOT EXISTS webauthn_credentials (id SERIAL PRIMARY KEY, credentialId TEXT UNIQUE, publicKey BYTEA, signCount BIGINT DEFAULT 0, name TEXT, createdAt TIMESTAMP DEFAULT NOW(), lastUsedAt TIMESTAMP, userId INTEGER REFERENCES users(id) ON DELETE CASCADE)",
+		// Accounts of OpenID Connect providers, identified by their issuer and
+		// subject; email is the address they had when linked.
+		"CREATE TABLE IF NOT EXISTS oidc_identities (id SERIAL PRIMARY KEY, issuer TEXT, subject TEXT, email TEXT, createdAt TIMESTAMP DEFAULT NOW(), lastUsedAt TIMESTAMP, userId INTEGER REFERENCES users(id) ON DELETE CASCADE, UNIQUE (issuer, subject))",
+		// Login links are single use like password resets: usedAt is set when
+		// consumed.
+		"CREATE TABLE IF NOT EXISTS login_links (id SERIAL PRIMARY KEY, tokenHash TEXT UNIQUE, expiresAt TIMESTAMP, usedAt TIMESTAMP, createdAt TIMESTAMP DEFAULT NOW(), userId INTEGER REFERENCES users(id) ON DELETE CASCADE)",
 	}
 
 	for _, query := range queries {
@@ -119,6 +180,254 @@ func createTables(db *sql.DB) error {
 				ALTER TABLE users ADD COLUMN needs_password_reset BOOLEAN DEFAULT FALSE;
 			END IF;
 		END $$;`,
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='persons' AND column_name='coproprieteid'
+			) THEN
+				ALTER TABLE persons ADD COLUMN coproprieteId INTEGER REFERENCES coproprietes(id);
+			END IF;
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='bills' AND column_name='coproprieteid'
+			) THEN
+				ALTER TABLE bills ADD COLUMN coproprieteId INTEGER REFERENCES coproprietes(id);
+			END IF;
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='provisions' AND column_name='coproprieteid'
+			) THEN
+				ALTER TABLE provisions ADD COLUMN coproprieteId INTEGER REFERENCES coproprietes(id);
+			END IF;
+		END $$;`,
+		// Amounts used to be stored as FLOAT, which cannot represent cents exactly.
+		`DO $$
+		BEGIN
+			IF EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='bills' AND column_name='amount' AND data_type='double precision'
+			) THEN
+				ALTER TABLE bills ALTER COLUMN amount TYPE NUMERIC(14, 2) USING ROUND(amount::numeric, 2);
+			END IF;
+			IF EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='provisions' AND column_name='amount' AND data_type='double precision'
+			) THEN
+				ALTER TABLE provisions ALTER COLUMN amount TYPE NUMERIC(14, 2) USING ROUND(amount::numeric, 2);
+			END IF;
+		END $$;`,
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='bills' AND column_name='chargekeyid'
+			) THEN
+				ALTER TABLE bills ADD COLUMN chargeKeyId INTEGER REFERENCES charge_keys(id) ON DELETE SET NULL;
+			END IF;
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='provisions' AND column_name='chargekeyid'
+			) THEN
+				ALTER TABLE provisions ADD COLUMN chargeKeyId INTEGER REFERENCES charge_keys(id) ON DELETE SET NULL;
+			END IF;
+		END $$;`,
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='coproprietes' AND column_name='fiscalyearstart'
+			) THEN
+				ALTER TABLE coproprietes ADD COLUMN fiscalYearStart INTEGER DEFAULT 1;
+			END IF;
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='bills' AND column_name='date'
+			) THEN
+				ALTER TABLE bills ADD COLUMN date DATE DEFAULT CURRENT_DATE;
+				ALTER TABLE bills ADD COLUMN fiscalYear INTEGER;
+			END IF;
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='provisions' AND column_name='date'
+			) THEN
+				ALTER TABLE provisions ADD COLUMN date DATE DEFAULT CURRENT_DATE;
+				ALTER TABLE provisions ADD COLUMN fiscalYear INTEGER;
+			END IF;
+		END $$;`,
+		// Provisions generated from the budget remember its fiscal year so that
+		// generating again replaces them.
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='provisions' AND column_name='budgetyear'
+			) THEN
+				ALTER TABLE provisions ADD COLUMN budgetYear INTEGER;
+			END IF;
+		END $$;`,
+		// Works fund (fonds de travaux) contributions are provisions and the
+		// works it pays for are bills, both kept out of the charges balance.
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='coproprietes' AND column_name='worksfundrate'
+			) THEN
+				ALTER TABLE coproprietes ADD COLUMN worksFundRate INTEGER DEFAULT 5;
+			END IF;
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='bills' AND column_name='worksfund'
+			) THEN
+				ALTER TABLE bills ADD COLUMN worksFund BOOLEAN DEFAULT FALSE;
+			END IF;
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='provisions' AND column_name='worksfund'
+			) THEN
+				ALTER TABLE provisions ADD COLUMN worksFund BOOLEAN DEFAULT FALSE;
+			END IF;
+		END $$;`,
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='bills' AND column_name='supplierid'
+			) THEN
+				ALTER TABLE bills ADD COLUMN supplierId INTEGER REFERENCES suppliers(id) ON DELETE SET NULL;
+			END IF;
+		END $$;`,
+		// Bills are categorized by their account of class 6 of the chart of
+		// accounts of copropriétés, e.g. '615'.
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='bills' AND column_name='category'
+			) THEN
+				ALTER TABLE bills ADD COLUMN category TEXT;
+			END IF;
+		END $$;`,
+		// Rows created before multi-copropriete support are moved to a default
+		// building owned by the same user.
+		`INSERT INTO coproprietes (name, userId)
+		SELECT 'Ma copropriété', u.id FROM users u
+		WHERE NOT EXISTS (SELECT 1 FROM coproprietes c WHERE c.userId = u.id)
+		AND (
+			EXISTS (SELECT 1 FROM persons p WHERE p.userId = u.id AND p.coproprieteId IS NULL)
+			OR EXISTS (SELECT 1 FROM bills b WHERE b.userId = u.id AND b.coproprieteId IS NULL)
+			OR EXISTS (SELECT 1 FROM provisions pr WHERE pr.userId = u.id AND pr.coproprieteId IS NULL)
+		)`,
+		`UPDATE persons SET coproprieteId = (SELECT MIN(c.id) FROM coproprietes c WHERE c.userId = persons.userId) WHERE coproprieteId IS NULL`,
+		`UPDATE bills SET coproprieteId = (SELECT MIN(c.id) FROM coproprietes c WHERE c.userId = bills.userId) WHERE coproprieteId IS NULL`,
+		`UPDATE provisions SET coproprieteId = (SELECT MIN(c.id) FROM coproprietes c WHERE c.userId = provisions.userId) WHERE coproprieteId IS NULL`,
+		// Entries without a fiscal year are booked on the one containing their date.
+		`UPDATE bills SET fiscalYear = EXTRACT(YEAR FROM date - make_interval(months => (SELECT COALESCE(c.fiscalYearStart, 1) - 1 FROM coproprietes c WHERE c.id = bills.coproprieteId))) WHERE fiscalYear IS NULL`,
+		`UPDATE provisions SET fiscalYear = EXTRACT(YEAR FROM date - make_interval(months => (SELECT COALESCE(c.fiscalYearStart, 1) - 1 FROM coproprietes c WHERE c.id = provisions.coproprieteId))) WHERE fiscalYear IS NULL`,
+		// Tantièmes used to be held by persons directly: every person still
+		// carrying some gets a lot of their own, along with their charge key
+		// tantièmes.
+		`DO $$
+		DECLARE
+			person RECORD;
+			newLotId INTEGER;
+		BEGIN
+			FOR person IN SELECT id, name, tantieme, userId, coproprieteId FROM persons WHERE tantieme IS NOT NULL ORDER BY id LOOP
+				INSERT INTO lots (name, tantieme, userId, coproprieteId)
+				VALUES ('Lot de ' || person.name, person.tantieme, person.userId, person.coproprieteId)
+				RETURNING id INTO newLotId;
+				INSERT INTO lot_owners (lotId, personId) VALUES (newLotId, person.id);
+				INSERT INTO charge_key_lots (chargeKeyId, lotId, tantieme)
+				SELECT chargeKeyId, newLotId, tantieme FROM charge_key_tantiemes WHERE personId = person.id;
+				UPDATE persons SET tantieme = NULL WHERE id = person.id;
+			END LOOP;
+		END $$;`,
+		// Sessions started before sessionsRevokedAt are no longer valid, see
+		// GetAuthenticatedUserID.
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='users' AND column_name='sessionsrevokedat'
+			) THEN
+				ALTER TABLE users ADD COLUMN sessionsRevokedAt TIMESTAMP;
+			END IF;
+		END $$;`,
+		// Addresses are verified by a link sent to them. pendingEmail is the new
+		// address of a user changing theirs, until it is verified.
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='users' AND column_name='emailverifiedat'
+			) THEN
+				ALTER TABLE users ADD COLUMN emailVerifiedAt TIMESTAMP;
+				-- Paying customers had their address checked by Stripe already.
+				UPDATE users SET emailVerifiedAt = NOW() WHERE stripe_customer_id IS NOT NULL;
+			END IF;
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='users' AND column_name='pendingemail'
+			) THEN
+				ALTER TABLE users ADD COLUMN pendingEmail TEXT;
+			END IF;
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='users' AND column_name='verificationsentat'
+			) THEN
+				ALTER TABLE users ADD COLUMN verificationSentAt TIMESTAMP;
+			END IF;
+		END $$;`,
+		// Two-factor authentication is on once totpEnabledAt is set: until then
+		// totpSecret is only being enrolled. totpLastCounter is the time step of
+		// the last accepted code, which cannot be used again.
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='users' AND column_name='totpsecret'
+			) THEN
+				ALTER TABLE users ADD COLUMN totpSecret TEXT;
+			END IF;
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='users' AND column_name='totpenabledat'
+			) THEN
+				ALTER TABLE users ADD COLUMN totpEnabledAt TIMESTAMP;
+			END IF;
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='users' AND column_name='totplastcounter'
+			) THEN
+				ALTER TABLE users ADD COLUMN totpLastCounter BIGINT;
+			END IF;
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='coproprietes' AND column_name='requiretwofactor'
+			) THEN
+				ALTER TABLE coproprietes ADD COLUMN requireTwoFactor BOOLEAN DEFAULT FALSE;
+			END IF;
+		END $$;`,
+		// Recurring bills pass their supplier and category on to the bills
+		// they book.
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='recurring_bills' AND column_name='supplierid'
+			) THEN
+				ALTER TABLE recurring_bills ADD COLUMN supplierId INTEGER REFERENCES suppliers(id) ON DELETE SET NULL;
+			END IF;
+			IF NOT EXISTS (
+				SELECT 1 FROM information_schema.columns 
+				WHERE table_name='recurring_bills' AND column_name='category'
+			) THEN
+				ALTER TABLE recurring_bills ADD COLUMN category TEXT;
+			END IF;
+		END $$;`,
+		// Emails which left the queue used to keep their bodies.
+		`UPDATE email_queue SET textBody = NULL, htmlBody = NULL WHERE status <> 'pending' AND (textBody IS NOT NULL OR htmlBody IS NOT NULL)`,
 	}
 
 	for _, migration := range migrations {
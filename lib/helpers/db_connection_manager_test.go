@@ -0,0 +1,45 @@
+package helpers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// TestCreateTablesAddsIDsBeforeReferences checks that databases created
+// before persons, bills and provisions had an id get it before any table
+// referencing them is created, which would fail otherwise.
+func TestCreateTablesAddsIDsBeforeReferences(t *testing.T) {
+	var executed []string
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherFunc(func(_, actual string) error {
+		executed = append(executed, actual)
+		return nil
+	})))
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	for range 200 {
+		mock.ExpectExec("").WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	if err := createTables(db); err != nil {
+		t.Fatalf("createTables failed: %v", err)
+	}
+
+	for _, table := range []string{"persons", "bills", "provisions"} {
+		added, referenced := -1, -1
+		for i, statement := range executed {
+			if added < 0 && strings.Contains(statement, "ALTER TABLE "+table+" ADD COLUMN id ") {
+				added = i
+			}
+			if referenced < 0 && strings.Contains(statement, "REFERENCES "+table+"(id)") {
+				referenced = i
+			}
+		}
+		if added < 0 || referenced < 0 || added > referenced {
+			t.Errorf("Expected the id of %s to be added (statement %d) before it is referenced (statement %d)", table, added, referenced)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package helpers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an email with a plain text body and an optional HTML
+// alternative.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers emails. Deliveries are not retried by mailers: the email
+// queue of the application takes care of it.
+type Mailer interface {
+	Send(message Message) error
+}
+
+var (
+	mailer     Mailer
+	mailerErr  error
+	mailerOnce sync.Once
+)
+
+// GetMailer returns the mailer configured by the environment: MAIL_DRIVER is
+// "file" (the default) or "smtp", and emails are sent from MAIL_FROM. The
+// file driver writes .eml files under MAIL_OUTBOX_PATH, for development; the
+// SMTP driver talks to SMTP_HOST on SMTP_PORT with SMTP_USERNAME and
+// SMTP_PASSWORD.
+func GetMailer() (Mailer, error) {
+	mailerOnce.Do(func() {
+		from := os.Getenv("MAIL_FROM")
+		switch driver := os.Getenv("MAIL_DRIVER"); driver {
+		case "", "file":
+			if from == "" {
+				from = "Tanzia <noreply@localhost>"
+			}
+			dir := os.Getenv("MAIL_OUTBOX_PATH")
+			if dir == "" {
+				dir = "data/outbox"
+			}
+			mailer = NewFileMailer(dir, from)
+		case "smtp":
+			mailer, mailerErr = NewSMTPMailer(SMTPConfig{
+				Host:     os.Getenv("SMTP_HOST"),
+				Port:     os.Getenv("SMTP_PORT"),
+				Username: os.Getenv("SMTP_USERNAME"),
+				Password: os.Getenv("SMTP_PASSWORD"),
+				From:     from,
+			})
+		default:
+			mailerErr = fmt.Errorf("unknown mail driver %q", driver)
+		}
+	})
+	return mailer, mailerErr
+}
+
+// FileMailer writes every email as an .eml file in a directory, where it can
+// be opened by any mail client.
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir, from string) *FileMailer {
+	return &FileMailer{dir: dir, from: from}
+}
+
+func (m *FileMailer) Send(message Message) error {
+	if err := os.MkdirAll(m.dir, 0o750); err != nil {
+		return fmt.Errorf("error creating outbox: %w", err)
+	}
+
+	now := time.Now()
+	data, err := formatMessage(m.from, message, now)
+	if err != nil {
+		return err
+	}
+
+	suffix, err := randomHex(4)
+	if err != nil {
+		return err
+	}
+	name := now.UTC().Format("20060102T150405.000000000") + "-" + suffix + ".eml"
+	if err := os.WriteFile(filepath.Join(m.dir, name), data, 0o640); err != nil {
+		return fmt.Errorf("error writing email: %w", err)
+	}
+	return nil
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     string // 587 when empty
+	Username string // no authentication when empty
+	Password string
+	From     string
+}
+
+// SMTPMailer sends emails through an SMTP server, upgrading the connection
+// with STARTTLS when the server offers it.
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSMTPMailer(config SMTPConfig) (*SMTPMailer, error) {
+	if config.Host == "" {
+		return nil, fmt.Errorf("SMTP_HOST is not set")
+	}
+	if config.From == "" {
+		return nil, fmt.Errorf("MAIL_FROM is not set")
+	}
+	if config.Port == "" {
+		config.Port = "587"
+	}
+
+	mailer := &SMTPMailer{addr: config.Host + ":" + config.Port, from: config.From}
+	if config.Username != "" {
+		mailer.auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+	}
+	return mailer, nil
+}
+
+func (m *SMTPMailer) Send(message Message) error {
+	from, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %w", err)
+	}
+
+	data, err := formatMessage(m.from, message, time.Now())
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, from.Address, []string{to.Address}, data)
+}
+
+// formatMessage returns the RFC 5322 message of an email from from. Bodies
+// are quoted-printable, in a multipart/alternative when there is an HTML
+// version.
+func formatMessage(from string, message Message, date time.Time) ([]byte, error) {
+	if strings.ContainsAny(message.To+message.Subject+from, "\r\n") {
+		return nil, fmt.Errorf("invalid email header")
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	domain := "localhost"
+	if address, err := mail.ParseAddress(from); err == nil {
+		if _, host, ok := strings.Cut(address.Address, "@"); ok {
+			domain = host
+		}
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("From: " + from + "\r\n")
+	buffer.WriteString("To: " + message.To + "\r\n")
+	buffer.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", message.Subject) + "\r\n")
+	buffer.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	buffer.WriteString("Message-ID: <" + id + "@" + domain + ">\r\n")
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+
+	if message.HTML == "" {
+		writeMessagePart(&buffer, "text/plain", message.Text)
+		return buffer.Bytes(), nil
+	}
+
+	boundary, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	buffer.WriteString("Content-Type: multipart/alternative;\r\n\tboundary=\"" + boundary + "\"\r\n\r\n")
+	buffer.WriteString("--" + boundary + "\r\n")
+	writeMessagePart(&buffer, "text/plain", message.Text)
+	buffer.WriteString("\r\n--" + boundary + "\r\n")
+	writeMessagePart(&buffer, "text/html", message.HTML)
+	buffer.WriteString("\r\n--" + boundary + "--\r\n")
+	return buffer.Bytes(), nil
+}
+
+func writeMessagePart(buffer *bytes.Buffer, contentType, body string) {
+	buffer.WriteString("Content-Type: " + contentType + "; charset=utf-8\r\n")
+	buffer.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	writer := quotedprintable.NewWriter(buffer)
+	_, _ = writer.Write([]byte(body))
+	_ = writer.Close()
+}
+
+func randomHex(n int) (string, error) {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
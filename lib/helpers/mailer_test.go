@@ -0,0 +1,215 @@
+package helpers
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readMessage parses an email, returning its headers and its bodies by
+// content type.
+func readMessage(t *testing.T, data []byte) (mail.Header, map[string]string) {
+	t.Helper()
+	message, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("Invalid message: %v", err)
+	}
+
+	bodies := map[string]string{}
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("Invalid Content-Type: %v", err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		body, _ := io.ReadAll(quotedprintable.NewReader(message.Body))
+		bodies[mediaType] = string(body)
+		return message.Header, bodies
+	}
+
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Invalid part: %v", err)
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		// multipart.Reader decodes quoted-printable parts itself.
+		body, _ := io.ReadAll(part)
+		bodies[partType] = string(body)
+	}
+	return message.Header, bodies
+}
+
+func TestFormatMessage(t *testing.T) {
+	date := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+	data, err := formatMessage("Tanzia <noreply@tanzia.example>", Message{
+		To:      "alice@example.com",
+		Subject: "Réinitialisation",
+		Text:    "Bonjour,\nUne ligne très longue " + strings.Repeat("x", 100),
+		HTML:    "<p>Bonjour,</p>",
+	}, date)
+	if err != nil {
+		t.Fatalf("formatMessage failed: %v", err)
+	}
+
+	header, bodies := readMessage(t, data)
+	subject, _ := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	if subject != "Réinitialisation" || header.Get("To") != "alice@example.com" || header.Get("Date") != "Sun, 18 Oct 2026 12:00:00 +0000" {
+		t.Errorf("Unexpected headers %v", header)
+	}
+	if !strings.HasSuffix(header.Get("Message-Id"), "@tanzia.example>") {
+		t.Errorf("Unexpected Message-ID %q", header.Get("Message-Id"))
+	}
+	if bodies["text/plain"] != "Bonjour,\r\nUne ligne très longue "+strings.Repeat("x", 100) {
+		t.Errorf("Unexpected text body %q", bodies["text/plain"])
+	}
+	if bodies["text/html"] != "<p>Bonjour,</p>" {
+		t.Errorf("Unexpected HTML body %q", bodies["text/html"])
+	}
+	for _, line := range strings.Split(string(data), "\r\n") {
+		if len(line) > 78 {
+			t.Errorf("Line longer than 78 characters: %q", line)
+		}
+	}
+}
+
+func TestFormatMessageRejectsHeaderInjection(t *testing.T) {
+	for _, message := range []Message{
+		{To: "alice@example.com\r\nBcc: eve@example.com", Subject: "x"},
+		{To: "alice@example.com", Subject: "x\nBcc: eve@example.com"},
+	} {
+		if _, err := formatMessage("noreply@tanzia.example", message, time.Now()); err == nil {
+			t.Errorf("Expected %+v to be rejected", message)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	mailer := NewFileMailer(dir, "Tanzia <noreply@tanzia.example>")
+
+	for i := 0; i < 2; i++ {
+		if err := mailer.Send(Message{To: "alice@example.com", Subject: "Test", Text: "Bonjour"}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("Expected 2 emails in the outbox, got %v (%v)", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, bodies := readMessage(t, data)
+	if header.Get("From") != "Tanzia <noreply@tanzia.example>" || bodies["text/plain"] != "Bonjour" {
+		t.Errorf("Unexpected email %v %v", header, bodies)
+	}
+}
+
+// serveSMTP answers a single SMTP session on listener, sending the envelope
+// and the data it receives on the returned channel.
+func serveSMTP(t *testing.T, listener net.Listener) <-chan []string {
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+		var transcript []string
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch command {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "MAIL", "RCPT":
+				transcript = append(transcript, line)
+				reply("250 OK")
+			case "DATA":
+				reply("354 Go ahead")
+				var data strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				transcript = append(transcript, data.String())
+				reply("250 Queued")
+			case "QUIT":
+				reply("221 Bye")
+				received <- transcript
+				return
+			default:
+				reply("502 Unsupported")
+			}
+		}
+	}()
+	return received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+	received := serveSMTP(t, listener)
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	mailer, err := NewSMTPMailer(SMTPConfig{Host: host, Port: port, From: "Tanzia <noreply@tanzia.example>"})
+	if err != nil {
+		t.Fatalf("NewSMTPMailer failed: %v", err)
+	}
+
+	if err := mailer.Send(Message{To: "Alice <alice@example.com>", Subject: "Test", Text: "Bonjour", HTML: "<p>Bonjour</p>"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	select {
+	case transcript := <-received:
+		if len(transcript) != 3 || transcript[0] != "MAIL FROM:<noreply@tanzia.example>" || transcript[1] != "RCPT TO:<alice@example.com>" {
+			t.Fatalf("Unexpected envelope %q", transcript)
+		}
+		_, bodies := readMessage(t, []byte(transcript[2]))
+		if bodies["text/plain"] != "Bonjour" || bodies["text/html"] != "<p>Bonjour</p>" {
+			t.Errorf("Unexpected bodies %v", bodies)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The SMTP server received nothing")
+	}
+}
+
+func TestNewSMTPMailerRequiresConfiguration(t *testing.T) {
+	if _, err := NewSMTPMailer(SMTPConfig{From: "noreply@tanzia.example"}); err == nil {
+		t.Error("Expected a missing host to be rejected")
+	}
+	if _, err := NewSMTPMailer(SMTPConfig{Host: "smtp.example.com"}); err == nil {
+		t.Error("Expected a missing sender to be rejected")
+	}
+}
@@ -0,0 +1,500 @@
+package helpers
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// oidcKeysRefreshInterval throttles the refreshes of the signing keys of
+	// the provider, made when an ID token is signed by an unknown key.
+	oidcKeysRefreshInterval = time.Minute
+	// oidcClockSkew is the leeway given to the clock of the provider.
+	oidcClockSkew = time.Minute
+	// oidcMaxResponseSize bounds the documents read from the provider.
+	oidcMaxResponseSize = 1 << 20
+)
+
+var (
+	ErrOIDCNotConfigured  = errors.New("OIDC is not configured")
+	ErrOIDCInvalidIDToken = errors.New("invalid ID token")
+)
+
+// OIDCConfig is the registration of Tanzia as a client of an OpenID Connect
+// provider.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty for a public client
+	RedirectURL  string
+	Scopes       []string // openid, email and profile when empty
+}
+
+// OIDCProvider logs users in with the authorization code flow and PKCE.
+type OIDCProvider struct {
+	config   OIDCConfig
+	client   *http.Client
+	metadata oidcMetadata
+
+	mu            sync.Mutex
+	keys          []oidcKey
+	keysFetchedAt time.Time
+}
+
+// OIDCLogin holds the random values of a login, kept by the browser between
+// the redirection to the provider and the callback.
+type OIDCLogin struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+// OIDCClaims is the identity asserted by an ID token.
+type OIDCClaims struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type oidcMetadata struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	JWKSURI                       string   `json:"jwks_uri"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+	TokenEndpointAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+type oidcKey struct {
+	ID  string
+	Key crypto.PublicKey
+}
+
+var (
+	oidcProvider   *OIDCProvider
+	oidcProviderMu sync.Mutex
+)
+
+// GetOIDCProvider returns the provider configured by the environment:
+// OIDC_ISSUER, OIDC_CLIENT_ID and OIDC_CLIENT_SECRET, with OIDC_SCOPES
+// overriding the default scopes. Users are sent back to DOMAIN on
+// /login/oidc/callback. Discovery is tried again on the next call when it
+// fails, e.g. while the provider is unreachable.
+func GetOIDCProvider(ctx context.Context) (*OIDCProvider, error) {
+	oidcProviderMu.Lock()
+	defer oidcProviderMu.Unlock()
+
+	if oidcProvider != nil {
+		return oidcProvider, nil
+	}
+
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, ErrOIDCNotConfigured
+	}
+	domain := os.Getenv("DOMAIN")
+	if domain == "" {
+		domain = "http://localhost:8080"
+	}
+
+	provider, err := DiscoverOIDCProvider(ctx, &http.Client{Timeout: 10 * time.Second}, OIDCConfig{
+		Issuer:       issuer,
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  strings.TrimSuffix(domain, "/") + "/login/oidc/callback",
+		Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+	})
+	if err != nil {
+		return nil, err
+	}
+	oidcProvider = provider
+	return oidcProvider, nil
+}
+
+// OIDCProviderName returns the name of the provider shown on the login page,
+// OIDC_NAME, or an empty string when OIDC is not configured.
+func OIDCProviderName() string {
+	if os.Getenv("OIDC_ISSUER") == "" {
+		return ""
+	}
+	if name := os.Getenv("OIDC_NAME"); name != "" {
+		return name
+	}
+	return "SSO"
+}
+
+// DiscoverOIDCProvider reads the configuration the issuer publishes under
+// /.well-known/openid-configuration.
+func DiscoverOIDCProvider(ctx context.Context, client *http.Client, config OIDCConfig) (*OIDCProvider, error) {
+	if config.ClientID == "" {
+		return nil, fmt.Errorf("OIDC_CLIENT_ID is not set")
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	provider := &OIDCProvider{config: config, client: client}
+	discoveryURL := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := provider.getJSON(ctx, discoveryURL, &provider.metadata); err != nil {
+		return nil, fmt.Errorf("error discovering OIDC provider: %w", err)
+	}
+
+	metadata := provider.metadata
+	if metadata.Issuer != config.Issuer {
+		return nil, fmt.Errorf("OIDC provider announces issuer %q instead of %q", metadata.Issuer, config.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC provider configuration is incomplete")
+	}
+	// Providers which do not announce their PKCE methods are given the
+	// benefit of the doubt.
+	if len(metadata.CodeChallengeMethodsSupported) > 0 && !slices.Contains(metadata.CodeChallengeMethodsSupported, "S256") {
+		return nil, fmt.Errorf("OIDC provider does not support PKCE with S256")
+	}
+	return provider, nil
+}
+
+// NewOIDCLogin returns the random values of a new login.
+func NewOIDCLogin() (OIDCLogin, error) {
+	var values [3]string
+	for i := range values {
+		buffer := make([]byte, 32)
+		if _, err := rand.Read(buffer); err != nil {
+			return OIDCLogin{}, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(buffer)
+	}
+	return OIDCLogin{State: values[0], Nonce: values[1], CodeVerifier: values[2]}, nil
+}
+
+// AuthCodeURL returns the URL of the provider starting login.
+func (p *OIDCProvider) AuthCodeURL(login OIDCLogin) string {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {oidcCodeChallenge(login.CodeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(p.metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.metadata.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange trades the authorization code of the callback for an ID token,
+// which must then go through VerifyIDToken.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	// client_secret_basic is the default of the specification, used unless
+	// the provider only accepts client_secret_post.
+	basic := p.config.ClientSecret != "" && (len(p.metadata.TokenEndpointAuthMethods) == 0 || slices.Contains(p.metadata.TokenEndpointAuthMethods, "client_secret_basic"))
+	if !basic {
+		form.Set("client_id", p.config.ClientID)
+		if p.config.ClientSecret != "" {
+			form.Set("client_secret", p.config.ClientSecret)
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if basic {
+		request.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("error calling token endpoint: %w", err)
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(response.Body, oidcMaxResponseSize)).Decode(&body); err != nil {
+		return "", fmt.Errorf("error reading token response (status %d): %w", response.StatusCode, err)
+	}
+	if response.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("token endpoint refused the code: %s %s", body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("token response has no ID token")
+	}
+	return body.IDToken, nil
+}
+
+// VerifyIDToken checks the signature and the claims of an ID token issued
+// for the login with the given nonce, and returns the identity it asserts.
+// RS256 and ES256 signatures are supported.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string, now time.Time) (OIDCClaims, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return OIDCClaims{}, fmt.Errorf("%w: malformed token", ErrOIDCInvalidIDToken)
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return OIDCClaims{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return OIDCClaims{}, fmt.Errorf("%w: malformed signature", ErrOIDCInvalidIDToken)
+	}
+	if err := p.verifyJWTSignature(ctx, header.Algorithm, header.KeyID, parts[0]+"."+parts[1], signature, now); err != nil {
+		return OIDCClaims{}, err
+	}
+
+	var claims struct {
+		Issuer          string       `json:"iss"`
+		Subject         string       `json:"sub"`
+		Audience        oidcAudience `json:"aud"`
+		AuthorizedParty string       `json:"azp"`
+		ExpiresAt       float64      `json:"exp"`
+		IssuedAt        float64      `json:"iat"`
+		Nonce           string       `json:"nonce"`
+		Email           string       `json:"email"`
+		EmailVerified   oidcBool     `json:"email_verified"`
+		Name            string       `json:"name"`
+		GivenName       string       `json:"given_name"`
+		FamilyName      string       `json:"family_name"`
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return OIDCClaims{}, err
+	}
+
+	switch {
+	case claims.Issuer != p.metadata.Issuer:
+		return OIDCClaims{}, fmt.Errorf("%w: unexpected issuer %q", ErrOIDCInvalidIDToken, claims.Issuer)
+	case !slices.Contains(claims.Audience, p.config.ClientID):
+		return OIDCClaims{}, fmt.Errorf("%w: issued for another client", ErrOIDCInvalidIDToken)
+	case claims.AuthorizedParty != "" && claims.AuthorizedParty != p.config.ClientID:
+		return OIDCClaims{}, fmt.Errorf("%w: authorized party is another client", ErrOIDCInvalidIDToken)
+	case claims.Subject == "":
+		return OIDCClaims{}, fmt.Errorf("%w: missing subject", ErrOIDCInvalidIDToken)
+	case !now.Before(time.Unix(int64(claims.ExpiresAt), 0).Add(oidcClockSkew)):
+		return OIDCClaims{}, fmt.Errorf("%w: expired", ErrOIDCInvalidIDToken)
+	case time.Unix(int64(claims.IssuedAt), 0).After(now.Add(oidcClockSkew)):
+		return OIDCClaims{}, fmt.Errorf("%w: issued in the future", ErrOIDCInvalidIDToken)
+	case nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return OIDCClaims{}, fmt.Errorf("%w: unexpected nonce", ErrOIDCInvalidIDToken)
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = strings.TrimSpace(claims.GivenName + " " + claims.FamilyName)
+	}
+	return OIDCClaims{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          name,
+	}, nil
+}
+
+// verifyJWTSignature checks signature against the keys of the provider
+// matching keyID, fetching them again once when none matches, as happens
+// after the provider rotated its keys.
+func (p *OIDCProvider) verifyJWTSignature(ctx context.Context, algorithm, keyID, signed string, signature []byte, now time.Time) error {
+	if algorithm != "RS256" && algorithm != "ES256" {
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrOIDCInvalidIDToken, algorithm)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if attempt > 0 || p.keys == nil {
+			if !p.keysFetchedAt.IsZero() && now.Sub(p.keysFetchedAt) < oidcKeysRefreshInterval {
+				break
+			}
+			if err := p.fetchKeys(ctx, now); err != nil {
+				return err
+			}
+		}
+
+		found := false
+		for _, key := range p.keys {
+			if keyID != "" && key.ID != keyID {
+				continue
+			}
+			found = true
+			if verifyJWTKey(algorithm, key.Key, signed, signature) {
+				return nil
+			}
+		}
+		if found {
+			return fmt.Errorf("%w: bad signature", ErrOIDCInvalidIDToken)
+		}
+	}
+	return fmt.Errorf("%w: unknown signing key %q", ErrOIDCInvalidIDToken, keyID)
+}
+
+func (p *OIDCProvider) fetchKeys(ctx context.Context, now time.Time) error {
+	var jwks struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+			Curve   string `json:"crv"`
+			X       string `json:"x"`
+			Y       string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.metadata.JWKSURI, &jwks); err != nil {
+		return fmt.Errorf("error fetching OIDC signing keys: %w", err)
+	}
+
+	keys := []oidcKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.KeyType {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil || len(n) < 256 || len(e) == 0 || len(e) > 4 {
+				continue
+			}
+			exponent := new(big.Int).SetBytes(e)
+			keys = append(keys, oidcKey{ID: jwk.KeyID, Key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}})
+		case "EC":
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if jwk.Curve != "P-256" || errX != nil || errY != nil || len(x) != 32 || len(y) != 32 {
+				continue
+			}
+			key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+			if err != nil {
+				continue
+			}
+			keys = append(keys, oidcKey{ID: jwk.KeyID, Key: key})
+		}
+	}
+
+	p.keys = keys
+	p.keysFetchedAt = now
+	return nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, value any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, response.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(response.Body, oidcMaxResponseSize)).Decode(value)
+}
+
+func verifyJWTKey(algorithm string, key crypto.PublicKey, signed string, signature []byte) bool {
+	hash := sha256.Sum256([]byte(signed))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return algorithm == "RS256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil
+	case *ecdsa.PublicKey:
+		// JWS carries ECDSA signatures as r and s side by side.
+		if algorithm != "ES256" || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, hash[:], r, s)
+	}
+	return false
+}
+
+func decodeJWTPart(part string, value any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: malformed token", ErrOIDCInvalidIDToken)
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("%w: %v", ErrOIDCInvalidIDToken, err)
+	}
+	return nil
+}
+
+func oidcCodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// oidcAudience is the aud claim, either a string or an array of strings.
+type oidcAudience []string
+
+func (audience *oidcAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*audience = oidcAudience{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(audience))
+}
+
+// oidcBool is a boolean claim, which some providers send as a string.
+type oidcBool bool
+
+func (value *oidcBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*value = true
+	case "false", `"false"`, "null":
+		*value = false
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	return nil
+}
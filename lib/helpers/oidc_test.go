@@ -0,0 +1,380 @@
+package helpers
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockOIDCIssuer is a local OpenID Connect provider issuing ID tokens for
+// the codes registered with authorize.
+type mockOIDCIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu sync.Mutex
+	// keys are published under jwks_uri, by kid; signingKey signs the ID
+	// tokens.
+	keys       map[string]crypto.Signer
+	signingKey string
+	// codes holds the PKCE challenge and the claims of each pending code.
+	codes map[string]mockOIDCCode
+	// jwksRequests counts the fetches of the keys.
+	jwksRequests int
+}
+
+type mockOIDCCode struct {
+	challenge string
+	claims    map[string]any
+}
+
+const (
+	mockOIDCClientID     = "tanzia"
+	mockOIDCClientSecret = "s3cr:et"
+)
+
+func newMockOIDCIssuer(t *testing.T) *mockOIDCIssuer {
+	t.Helper()
+	issuer := &mockOIDCIssuer{t: t, keys: map[string]crypto.Signer{}, codes: map[string]mockOIDCCode{}}
+	issuer.addKey("rsa-1", mustGenerateRSAKey(t))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                issuer.server.URL,
+			"authorization_endpoint":                issuer.server.URL + "/authorize",
+			"token_endpoint":                        issuer.server.URL + "/token",
+			"jwks_uri":                              issuer.server.URL + "/jwks",
+			"code_challenge_methods_supported":      []string{"plain", "S256"},
+			"token_endpoint_auth_methods_supported": []string{"client_secret_basic"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", issuer.serveKeys)
+	mux.HandleFunc("POST /token", issuer.serveToken)
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func mustGenerateRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func (issuer *mockOIDCIssuer) addKey(kid string, key crypto.Signer) {
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+	issuer.keys[kid] = key
+	issuer.signingKey = kid
+}
+
+func (issuer *mockOIDCIssuer) serveKeys(w http.ResponseWriter, r *http.Request) {
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+	issuer.jwksRequests++
+
+	encode := func(value []byte) string { return base64.RawURLEncoding.EncodeToString(value) }
+	keys := []map[string]string{
+		// Encryption keys must not be used to check signatures.
+		{"kty": "RSA", "kid": "encryption", "use": "enc", "n": encode(make([]byte, 256)), "e": "AQAB"},
+	}
+	for kid, key := range issuer.keys {
+		switch public := key.Public().(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": encode(public.N.Bytes()), "e": encode(big.NewInt(int64(public.E)).Bytes())})
+		case *ecdsa.PublicKey:
+			point, _ := public.Bytes()
+			keys = append(keys, map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": encode(point[1:33]), "y": encode(point[33:])})
+		}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+}
+
+func (issuer *mockOIDCIssuer) serveToken(w http.ResponseWriter, r *http.Request) {
+	fail := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		fail("invalid_client")
+		return
+	}
+	clientID, _ = url.QueryUnescape(clientID)
+	secret, _ = url.QueryUnescape(secret)
+	if clientID != mockOIDCClientID || secret != mockOIDCClientSecret {
+		fail("invalid_client")
+		return
+	}
+
+	issuer.mu.Lock()
+	code, ok := issuer.codes[r.FormValue("code")]
+	delete(issuer.codes, r.FormValue("code"))
+	issuer.mu.Unlock()
+	if !ok || r.FormValue("grant_type") != "authorization_code" || r.FormValue("redirect_uri") != "https://tanzia.example/login/oidc/callback" {
+		fail("invalid_grant")
+		return
+	}
+	if oidcCodeChallenge(r.FormValue("code_verifier")) != code.challenge {
+		fail("invalid_grant")
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "token_type": "Bearer", "id_token": issuer.sign(code.claims)})
+}
+
+// authorize plays the authorization endpoint: it checks the request and
+// returns a code for an ID token with the given claims, on top of the
+// standard ones.
+func (issuer *mockOIDCIssuer) authorize(authURL string, claims map[string]any) string {
+	issuer.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		issuer.t.Fatal(err)
+	}
+	query := u.Query()
+	if u.Path != "/authorize" || query.Get("response_type") != "code" || query.Get("client_id") != mockOIDCClientID ||
+		query.Get("code_challenge_method") != "S256" || !strings.Contains(query.Get("scope"), "openid") {
+		issuer.t.Fatalf("Unexpected authorization request %s", authURL)
+	}
+
+	now := time.Now().Unix()
+	all := map[string]any{"iss": issuer.server.URL, "aud": mockOIDCClientID, "sub": "user-1", "iat": now, "exp": now + 300, "nonce": query.Get("nonce")}
+	for name, value := range claims {
+		if value == nil {
+			delete(all, name)
+		} else {
+			all[name] = value
+		}
+	}
+
+	code := base64.RawURLEncoding.EncodeToString([]byte(query.Get("state")))
+	issuer.mu.Lock()
+	issuer.codes[code] = mockOIDCCode{challenge: query.Get("code_challenge"), claims: all}
+	issuer.mu.Unlock()
+	return code
+}
+
+func (issuer *mockOIDCIssuer) sign(claims map[string]any) string {
+	issuer.mu.Lock()
+	kid := issuer.signingKey
+	key := issuer.keys[kid]
+	issuer.mu.Unlock()
+	return signTestJWT(issuer.t, kid, key, claims)
+}
+
+func signTestJWT(t *testing.T, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	algorithm := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		algorithm = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": algorithm, "kid": kid, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, hash[:])
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (issuer *mockOIDCIssuer) discover(t *testing.T) *OIDCProvider {
+	t.Helper()
+	provider, err := DiscoverOIDCProvider(context.Background(), issuer.server.Client(), OIDCConfig{
+		Issuer:       issuer.server.URL,
+		ClientID:     mockOIDCClientID,
+		ClientSecret: mockOIDCClientSecret,
+		RedirectURL:  "https://tanzia.example/login/oidc/callback",
+	})
+	if err != nil {
+		t.Fatalf("DiscoverOIDCProvider failed: %v", err)
+	}
+	return provider
+}
+
+// login runs a whole login with the given claims, and returns the result of
+// the verification of the ID token.
+func (issuer *mockOIDCIssuer) login(t *testing.T, provider *OIDCProvider, claims map[string]any) (OIDCClaims, error) {
+	t.Helper()
+	login, err := NewOIDCLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := issuer.authorize(provider.AuthCodeURL(login), claims)
+	idToken, err := provider.Exchange(context.Background(), code, login.CodeVerifier)
+	if err != nil {
+		t.Fatalf("Exchange failed: %v", err)
+	}
+	return provider.VerifyIDToken(context.Background(), idToken, login.Nonce, time.Now())
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newMockOIDCIssuer(t)
+	provider := issuer.discover(t)
+
+	claims, err := issuer.login(t, provider, map[string]any{"email": "jane@example.com", "email_verified": true, "given_name": "Jane", "family_name": "Doe"})
+	if err != nil {
+		t.Fatalf("VerifyIDToken failed: %v", err)
+	}
+	expected := OIDCClaims{Issuer: issuer.server.URL, Subject: "user-1", Email: "jane@example.com", EmailVerified: true, Name: "Jane Doe"}
+	if claims != expected {
+		t.Errorf("Expected %+v, got %+v", expected, claims)
+	}
+
+	// Some providers send email_verified as a string, and aud as an array.
+	claims, err = issuer.login(t, provider, map[string]any{"email": "jane@example.com", "email_verified": "true", "aud": []string{mockOIDCClientID, "other"}, "azp": mockOIDCClientID})
+	if err != nil || !claims.EmailVerified {
+		t.Errorf("Unexpected claims %+v (%v)", claims, err)
+	}
+
+	claims, err = issuer.login(t, provider, map[string]any{"email": "jane@example.com"})
+	if err != nil || claims.EmailVerified {
+		t.Errorf("Expected an unverified email, got %+v (%v)", claims, err)
+	}
+}
+
+func TestOIDCRejectsInvalidIDTokens(t *testing.T) {
+	issuer := newMockOIDCIssuer(t)
+	provider := issuer.discover(t)
+	now := time.Now().Unix()
+
+	invalid := map[string]map[string]any{
+		"another issuer":         {"iss": "https://evil.example"},
+		"another client":         {"aud": "other"},
+		"another party":          {"aud": []string{mockOIDCClientID, "other"}, "azp": "other"},
+		"expired":                {"exp": now - 3600},
+		"issued in the future":   {"iat": now + 3600},
+		"another nonce":          {"nonce": "replayed"},
+		"no nonce":               {"nonce": nil},
+		"no subject":             {"sub": ""},
+		"invalid email_verified": {"email_verified": "yes"},
+	}
+	for name, claims := range invalid {
+		if _, err := issuer.login(t, provider, claims); !errors.Is(err, ErrOIDCInvalidIDToken) {
+			t.Errorf("Expected a token with %s to be rejected, got %v", name, err)
+		}
+	}
+
+	login, _ := NewOIDCLogin()
+	code := issuer.authorize(provider.AuthCodeURL(login), nil)
+	idToken, err := provider.Exchange(context.Background(), code, login.CodeVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(idToken, ".")
+
+	forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2]
+	if _, err := provider.VerifyIDToken(context.Background(), forged, login.Nonce, time.Now()); !errors.Is(err, ErrOIDCInvalidIDToken) {
+		t.Errorf("Expected a forged token to be rejected, got %v", err)
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+	if _, err := provider.VerifyIDToken(context.Background(), unsigned, login.Nonce, time.Now()); !errors.Is(err, ErrOIDCInvalidIDToken) {
+		t.Errorf("Expected an unsigned token to be rejected, got %v", err)
+	}
+
+	// A token signed with the secret of the client, as if it were an HMAC
+	// key, must not pass either.
+	hmacHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","kid":"rsa-1"}`))
+	if _, err := provider.VerifyIDToken(context.Background(), hmacHeader+"."+parts[1]+"."+parts[2], login.Nonce, time.Now()); !errors.Is(err, ErrOIDCInvalidIDToken) {
+		t.Errorf("Expected an HS256 token to be rejected, got %v", err)
+	}
+
+	var claims map[string]any
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	_ = json.Unmarshal(payload, &claims)
+	if _, err := provider.VerifyIDToken(context.Background(), signTestJWT(t, "rsa-1", mustGenerateRSAKey(t), claims), login.Nonce, time.Now()); !errors.Is(err, ErrOIDCInvalidIDToken) {
+		t.Errorf("Expected a token signed by another key to be rejected, got %v", err)
+	}
+}
+
+func TestOIDCExchangeRejectsWrongVerifier(t *testing.T) {
+	issuer := newMockOIDCIssuer(t)
+	provider := issuer.discover(t)
+
+	login, _ := NewOIDCLogin()
+	code := issuer.authorize(provider.AuthCodeURL(login), nil)
+	other, _ := NewOIDCLogin()
+	if _, err := provider.Exchange(context.Background(), code, other.CodeVerifier); err == nil {
+		t.Error("Expected an intercepted code to be useless without the verifier")
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	issuer := newMockOIDCIssuer(t)
+	provider := issuer.discover(t)
+
+	if _, err := issuer.login(t, provider, nil); err != nil {
+		t.Fatalf("VerifyIDToken failed: %v", err)
+	}
+
+	// The provider starts signing with a new key, which is fetched once
+	// the refresh interval elapsed.
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.addKey("ec-2", ecKey)
+	if _, err := issuer.login(t, provider, nil); !errors.Is(err, ErrOIDCInvalidIDToken) {
+		t.Errorf("Expected the keys not to be fetched again right away, got %v", err)
+	}
+
+	provider.keysFetchedAt = provider.keysFetchedAt.Add(-oidcKeysRefreshInterval)
+	if _, err := issuer.login(t, provider, nil); err != nil {
+		t.Errorf("Expected the new key to be fetched, got %v", err)
+	}
+	if issuer.jwksRequests != 2 {
+		t.Errorf("Expected 2 fetches of the keys, got %d", issuer.jwksRequests)
+	}
+}
+
+func TestDiscoverOIDCProviderRejectsInvalidConfigurations(t *testing.T) {
+	issuer := newMockOIDCIssuer(t)
+	client := issuer.server.Client()
+
+	if _, err := DiscoverOIDCProvider(context.Background(), client, OIDCConfig{Issuer: issuer.server.URL + "/other", ClientID: mockOIDCClientID}); err == nil {
+		t.Error("Expected a missing configuration to be rejected")
+	}
+	if _, err := DiscoverOIDCProvider(context.Background(), client, OIDCConfig{Issuer: issuer.server.URL + "/", ClientID: mockOIDCClientID}); err == nil {
+		t.Error("Expected an issuer mismatch to be rejected")
+	}
+	if _, err := DiscoverOIDCProvider(context.Background(), client, OIDCConfig{Issuer: issuer.server.URL}); err == nil {
+		t.Error("Expected a missing client id to be rejected")
+	}
+}
@@ -7,10 +7,15 @@ import (
 	_ "github.com/lib/pq"
 )
 
+// Free-tier limits are counted per account: a free account manages a single
+// copropriete, so its persons, bills and provisions all count towards the same
+// quota. Premium accounts are unlimited, both in buildings and in entries per
+// building.
 const (
-	FreeTierProvisionLimit = 10
-	FreeTierBillLimit      = 5
-	FreeTierPersonLimit    = 5
+	FreeTierProvisionLimit   = 10
+	FreeTierBillLimit        = 5
+	FreeTierPersonLimit      = 5
+	FreeTierCoproprieteLimit = 1
 )
 
 func IsUserPremium(db *sql.DB, userID string) (bool, error) {
@@ -82,3 +87,22 @@ func CanUserCreatePerson(db *sql.DB, userID string) (bool, error) {
 
 	return count < FreeTierPersonLimit, nil
 }
+
+func CanUserCreateCopropriete(db *sql.DB, userID string) (bool, error) {
+	isPremium, err := IsUserPremium(db, userID)
+	if err != nil {
+		return false, fmt.Errorf("error checking premium status: %w", err)
+	}
+	if isPremium {
+		return true, nil
+	}
+
+	var count int
+	query := "SELECT COUNT(*) FROM coproprietes WHERE userId = $1"
+	err = db.QueryRow(query, userID).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking copropriete count: %w", err)
+	}
+
+	return count < FreeTierCoproprieteLimit, nil
+}
@@ -325,6 +325,87 @@ func TestCanUserCreatePerson_FreeUserAtLimit(t *testing.T) {
 	}
 }
 
+func TestCanUserCreateCopropriete_PremiumUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	userID := "premium-user"
+	mock.ExpectQuery("SELECT is_premium FROM users WHERE id = \\$1").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"is_premium"}).AddRow(true))
+
+	canCreate, err := CanUserCreateCopropriete(db, userID)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !canCreate {
+		t.Error("Premium user should always be able to create coproprietes")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCanUserCreateCopropriete_FreeUserUnderLimit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	userID := "free-user"
+	mock.ExpectQuery("SELECT is_premium FROM users WHERE id = \\$1").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"is_premium"}).AddRow(false))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM coproprietes WHERE userId = \\$1").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	canCreate, err := CanUserCreateCopropriete(db, userID)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !canCreate {
+		t.Error("Free user without copropriete should be able to create one")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCanUserCreateCopropriete_FreeUserAtLimit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	userID := "free-user-at-limit"
+	mock.ExpectQuery("SELECT is_premium FROM users WHERE id = \\$1").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"is_premium"}).AddRow(false))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM coproprietes WHERE userId = \\$1").
+		WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(FreeTierCoproprieteLimit))
+
+	canCreate, err := CanUserCreateCopropriete(db, userID)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if canCreate {
+		t.Error("Free user at limit should not be able to create coproprietes")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
 func TestFreeTierLimitConstants(t *testing.T) {
 	if FreeTierPersonLimit != 5 {
 		t.Errorf("Expected FreeTierPersonLimit to be 5, got %d", FreeTierPersonLimit)
@@ -335,4 +416,7 @@ func TestFreeTierLimitConstants(t *testing.T) {
 	if FreeTierProvisionLimit != 10 {
 		t.Errorf("Expected FreeTierProvisionLimit to be 10, got %d", FreeTierProvisionLimit)
 	}
+	if FreeTierCoproprieteLimit != 1 {
+		t.Errorf("Expected FreeTierCoproprieteLimit to be 1, got %d", FreeTierCoproprieteLimit)
+	}
 }
@@ -0,0 +1,101 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const signedTokenNonceLength = 16
+
+var ErrInvalidSignedToken = errors.New("invalid or expired token")
+
+var (
+	tokenSecret     []byte
+	tokenSecretOnce sync.Once
+)
+
+// getTokenSecret returns the key signing the tokens sent by email, read from
+// TOKEN_SECRET. Without it a random key is used, so that tokens stop working
+// when the process restarts.
+func getTokenSecret() []byte {
+	tokenSecretOnce.Do(func() {
+		if secret := os.Getenv("TOKEN_SECRET"); secret != "" {
+			tokenSecret = []byte(secret)
+			return
+		}
+		log.Printf("TOKEN_SECRET is not set, emailed links will not survive a restart")
+		tokenSecret = make([]byte, 32)
+		if _, err := rand.Read(tokenSecret); err != nil {
+			log.Printf("Error generating token secret: %v", err)
+		}
+	})
+	return tokenSecret
+}
+
+// SignToken returns a token for subject, valid until expiresAt for the given
+// purpose only, such as "password-reset". A random nonce makes every token
+// unique, so that it can also be stored hashed and consumed once.
+func SignToken(purpose, subject string, expiresAt time.Time) (string, error) {
+	nonce := make([]byte, signedTokenNonceLength)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(subject)) + "." +
+		strconv.FormatInt(expiresAt.Unix(), 10) + "." +
+		base64.RawURLEncoding.EncodeToString(nonce)
+	return payload + "." + signTokenPayload(purpose, payload), nil
+}
+
+// VerifySignedToken returns the subject of a token made by SignToken for the
+// same purpose, or ErrInvalidSignedToken when it is forged or expired at now.
+func VerifySignedToken(purpose, token string, now time.Time) (string, error) {
+	payload, signature, ok := cutLast(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(signTokenPayload(purpose, payload))) {
+		return "", ErrInvalidSignedToken
+	}
+
+	parts := strings.Split(payload, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalidSignedToken
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || !now.Before(time.Unix(expiresAt, 0)) {
+		return "", ErrInvalidSignedToken
+	}
+	subject, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidSignedToken
+	}
+	return string(subject), nil
+}
+
+// HashToken returns the hash under which a random token is stored, so that a
+// leak of the database does not leak usable tokens.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func signTokenPayload(purpose, payload string) string {
+	mac := hmac.New(sha256.New, getTokenSecret())
+	mac.Write([]byte(purpose + ":" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,71 @@
+package helpers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignToken(t *testing.T) {
+	now := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+
+	token, err := SignToken("password-reset", "42", now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("SignToken failed: %v", err)
+	}
+
+	subject, err := VerifySignedToken("password-reset", token, now)
+	if err != nil || subject != "42" {
+		t.Errorf("Expected subject 42, got %q (%v)", subject, err)
+	}
+
+	other, err := SignToken("password-reset", "42", now.Add(time.Hour))
+	if err != nil || other == token {
+		t.Errorf("Expected two distinct tokens, got %q twice (%v)", token, err)
+	}
+}
+
+func TestVerifySignedTokenRejectsInvalidTokens(t *testing.T) {
+	now := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+	token, err := SignToken("password-reset", "42", now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("SignToken failed: %v", err)
+	}
+
+	parts := strings.Split(token, ".")
+	forged, err := SignToken("password-reset", "43", now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("SignToken failed: %v", err)
+	}
+	forgedParts := strings.Split(forged, ".")
+
+	tests := []struct {
+		name    string
+		purpose string
+		token   string
+		now     time.Time
+	}{
+		{"expired", "password-reset", token, now.Add(time.Hour)},
+		{"other purpose", "email-verification", token, now},
+		{"swapped subject", "password-reset", forgedParts[0] + "." + strings.Join(parts[1:], "."), now},
+		{"extended expiry", "password-reset", parts[0] + ".9999999999." + strings.Join(parts[2:], "."), now},
+		{"truncated", "password-reset", strings.Join(parts[:3], "."), now},
+		{"empty", "password-reset", "", now},
+	}
+
+	for _, tt := range tests {
+		if _, err := VerifySignedToken(tt.purpose, tt.token, tt.now); !errors.Is(err, ErrInvalidSignedToken) {
+			t.Errorf("%s: expected ErrInvalidSignedToken, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	if HashToken("abc") != HashAccessToken("abc") {
+		t.Error("Expected access tokens to be hashed like other tokens")
+	}
+	if HashToken("abc") == HashToken("abd") || len(HashToken("abc")) != 64 {
+		t.Errorf("Unexpected hash %q", HashToken("abc"))
+	}
+}
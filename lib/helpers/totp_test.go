@@ -0,0 +1,99 @@
+package helpers
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the test vectors of RFC 6238.
+var rfc6238Secret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	// The RFC lists 8 digit codes; 6 digit codes are their last digits.
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, expected := range vectors {
+		code, err := TOTPCode(rfc6238Secret, TOTPCounter(time.Unix(unix, 0)))
+		if err != nil || code != expected {
+			t.Errorf("TOTPCode at %d = %q (%v), expected %q", unix, code, err, expected)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret failed: %v", err)
+	}
+	now := time.Date(2026, time.October, 18, 12, 0, 10, 0, time.UTC)
+
+	code, err := TOTPCode(secret, TOTPCounter(now))
+	if err != nil {
+		t.Fatalf("TOTPCode failed: %v", err)
+	}
+	if counter, ok := ValidateTOTP(secret, code, now); !ok || counter != TOTPCounter(now) {
+		t.Errorf("Expected the current code to be valid, got %d, %v", counter, ok)
+	}
+	if _, ok := ValidateTOTP(secret, code[:3]+" "+code[3:], now); !ok {
+		t.Error("Expected spaces in the code to be ignored")
+	}
+
+	// One step of clock drift is tolerated, not two.
+	if counter, ok := ValidateTOTP(secret, code, now.Add(30*time.Second)); !ok || counter != TOTPCounter(now) {
+		t.Errorf("Expected the previous code to be valid, got %d, %v", counter, ok)
+	}
+	if _, ok := ValidateTOTP(secret, code, now.Add(60*time.Second)); ok {
+		t.Error("Expected a code two steps old to be rejected")
+	}
+
+	for _, invalid := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := ValidateTOTP(secret, invalid, now); ok {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri, err := url.Parse(TOTPProvisioningURI("Tanzia", "jean@example.com", "JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatalf("Invalid URI: %v", err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Tanzia:jean@example.com" {
+		t.Errorf("Unexpected URI %q", uri)
+	}
+	query := uri.Query()
+	if query.Get("secret") != "JBSWY3DPEHPK3PXP" || query.Get("issuer") != "Tanzia" || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf("Unexpected parameters %v", query)
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil || len(codes) != 10 {
+		t.Fatalf("Expected 10 codes, got %v (%v)", codes, err)
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || seen[code] {
+			t.Errorf("Unexpected code %q", code)
+		}
+		seen[code] = true
+		if normalized := NormalizeRecoveryCode(" " + strings.ToUpper(strings.ReplaceAll(code, "-", "")) + " "); normalized != code {
+			t.Errorf("NormalizeRecoveryCode gave %q for %q", normalized, code)
+		}
+	}
+
+	if NormalizeRecoveryCode("123456") != "" {
+		t.Error("Expected a TOTP code not to be a recovery code")
+	}
+}
@@ -0,0 +1,366 @@
+package helpers
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+)
+
+const (
+	webAuthnChallengeLength = 32
+	// webAuthnMaxCredentialIDLength is the limit of the WebAuthn spec.
+	webAuthnMaxCredentialIDLength = 1023
+
+	// Flags of the authenticator data.
+	webAuthnFlagUserPresent      = 0x01
+	webAuthnFlagUserVerified     = 0x04
+	webAuthnFlagAttestedCredData = 0x40
+
+	// COSE algorithms supported for credential keys.
+	COSEAlgorithmES256 = -7
+	COSEAlgorithmEdDSA = -8
+	COSEAlgorithmRS256 = -257
+)
+
+var (
+	ErrWebAuthnInvalid = errors.New("invalid webauthn response")
+	// ErrWebAuthnClonedAuthenticator is returned when the signature counter
+	// of a credential goes backwards, a sign that its key was copied.
+	ErrWebAuthnClonedAuthenticator = errors.New("webauthn signature counter went backwards")
+)
+
+// WebAuthnAlgorithms are the credential key algorithms Tanzia accepts, in
+// order of preference.
+var WebAuthnAlgorithms = []int{COSEAlgorithmES256, COSEAlgorithmEdDSA, COSEAlgorithmRS256}
+
+// WebAuthnConfig identifies the relying party credentials are bound to.
+type WebAuthnConfig struct {
+	// RPID is the domain credentials are scoped to, and Origin the origin
+	// the ceremonies must run on.
+	RPID   string
+	RPName string
+	Origin string
+}
+
+// NewWebAuthnConfig returns the relying party of the site served at domain,
+// the base URL found in DOMAIN.
+func NewWebAuthnConfig(domain string) (WebAuthnConfig, error) {
+	u, err := url.Parse(domain)
+	if err != nil || u.Scheme == "" || u.Hostname() == "" {
+		return WebAuthnConfig{}, fmt.Errorf("invalid domain %q", domain)
+	}
+	return WebAuthnConfig{RPID: u.Hostname(), RPName: "Tanzia", Origin: u.Scheme + "://" + u.Host}, nil
+}
+
+// WebAuthnResponse is a PublicKeyCredential serialized by the browser, with
+// its buffers base64url encoded as in PublicKeyCredential.toJSON().
+type WebAuthnResponse struct {
+	ID       string `json:"id"`
+	RawID    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON string `json:"clientDataJSON"`
+		// AttestationObject is set by registrations only.
+		AttestationObject string `json:"attestationObject,omitempty"`
+		// AuthenticatorData, Signature and UserHandle are set by
+		// authentications only.
+		AuthenticatorData string `json:"authenticatorData,omitempty"`
+		Signature         string `json:"signature,omitempty"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// ParseWebAuthnResponse decodes the JSON of a credential sent by the
+// browser.
+func ParseWebAuthnResponse(data string) (WebAuthnResponse, error) {
+	var response WebAuthnResponse
+	if err := json.Unmarshal([]byte(data), &response); err != nil {
+		return response, fmt.Errorf("%w: %v", ErrWebAuthnInvalid, err)
+	}
+	if response.Type != "public-key" || response.RawID == "" {
+		return response, ErrWebAuthnInvalid
+	}
+	return response, nil
+}
+
+// WebAuthnCredential is a public key registered by an authenticator.
+type WebAuthnCredential struct {
+	ID string
+	// PublicKey is the COSE_Key of the credential.
+	PublicKey []byte
+	SignCount uint32
+}
+
+// WebAuthnAssertion is the outcome of a verified authentication.
+type WebAuthnAssertion struct {
+	SignCount uint32
+	// UserVerified tells whether the authenticator checked a PIN or a
+	// biometric, which makes the passkey a second factor on its own.
+	UserVerified bool
+	UserHandle   []byte
+}
+
+// NewWebAuthnChallenge returns a random base64url encoded challenge.
+func NewWebAuthnChallenge() (string, error) {
+	challenge := make([]byte, webAuthnChallengeLength)
+	if _, err := rand.Read(challenge); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(challenge), nil
+}
+
+// VerifyRegistration checks the response of navigator.credentials.create()
+// to challenge and returns the new credential. Attestation statements are
+// not checked: Tanzia asks for none, as it does not restrict which
+// authenticators can be used.
+func (config WebAuthnConfig) VerifyRegistration(challenge string, response WebAuthnResponse) (WebAuthnCredential, error) {
+	clientDataJSON, err := decodeWebAuthnField(response.Response.ClientDataJSON)
+	if err != nil {
+		return WebAuthnCredential{}, err
+	}
+	if err := config.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return WebAuthnCredential{}, err
+	}
+
+	attestationObject, err := decodeWebAuthnField(response.Response.AttestationObject)
+	if err != nil {
+		return WebAuthnCredential{}, err
+	}
+	decoded, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return WebAuthnCredential{}, fmt.Errorf("%w: %v", ErrWebAuthnInvalid, err)
+	}
+	attestation, ok := decoded.(map[any]any)
+	if !ok {
+		return WebAuthnCredential{}, fmt.Errorf("%w: attestation object is not a map", ErrWebAuthnInvalid)
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return WebAuthnCredential{}, fmt.Errorf("%w: missing authenticator data", ErrWebAuthnInvalid)
+	}
+
+	authData, err := config.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return WebAuthnCredential{}, err
+	}
+	if authData.flags&webAuthnFlagAttestedCredData == 0 {
+		return WebAuthnCredential{}, fmt.Errorf("%w: missing credential data", ErrWebAuthnInvalid)
+	}
+
+	credentialID := base64.RawURLEncoding.EncodeToString(authData.credentialID)
+	if credentialID != response.RawID {
+		return WebAuthnCredential{}, fmt.Errorf("%w: credential id mismatch", ErrWebAuthnInvalid)
+	}
+	if _, _, err := parseCOSEKey(authData.publicKey); err != nil {
+		return WebAuthnCredential{}, err
+	}
+
+	return WebAuthnCredential{ID: credentialID, PublicKey: authData.publicKey, SignCount: authData.signCount}, nil
+}
+
+// VerifyAssertion checks the response of navigator.credentials.get() to
+// challenge against the stored credential it was made with.
+func (config WebAuthnConfig) VerifyAssertion(challenge string, credential WebAuthnCredential, response WebAuthnResponse) (WebAuthnAssertion, error) {
+	if response.RawID != credential.ID {
+		return WebAuthnAssertion{}, fmt.Errorf("%w: credential id mismatch", ErrWebAuthnInvalid)
+	}
+
+	clientDataJSON, err := decodeWebAuthnField(response.Response.ClientDataJSON)
+	if err != nil {
+		return WebAuthnAssertion{}, err
+	}
+	if err := config.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return WebAuthnAssertion{}, err
+	}
+
+	rawAuthData, err := decodeWebAuthnField(response.Response.AuthenticatorData)
+	if err != nil {
+		return WebAuthnAssertion{}, err
+	}
+	authData, err := config.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return WebAuthnAssertion{}, err
+	}
+
+	signature, err := decodeWebAuthnField(response.Response.Signature)
+	if err != nil {
+		return WebAuthnAssertion{}, err
+	}
+	key, algorithm, err := parseCOSEKey(credential.PublicKey)
+	if err != nil {
+		return WebAuthnAssertion{}, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	if !verifyCOSESignature(key, algorithm, signed, signature) {
+		return WebAuthnAssertion{}, fmt.Errorf("%w: bad signature", ErrWebAuthnInvalid)
+	}
+
+	// Authenticators without a counter always report zero, passkeys synced
+	// across devices in particular.
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return WebAuthnAssertion{}, ErrWebAuthnClonedAuthenticator
+	}
+
+	assertion := WebAuthnAssertion{SignCount: authData.signCount, UserVerified: authData.flags&webAuthnFlagUserVerified != 0}
+	if response.Response.UserHandle != "" {
+		if assertion.UserHandle, err = decodeWebAuthnField(response.Response.UserHandle); err != nil {
+			return WebAuthnAssertion{}, err
+		}
+	}
+	return assertion, nil
+}
+
+type webAuthnClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+func (config WebAuthnConfig) verifyClientData(clientDataJSON []byte, ceremony, challenge string) error {
+	var clientData webAuthnClientData
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return fmt.Errorf("%w: %v", ErrWebAuthnInvalid, err)
+	}
+	if clientData.Type != ceremony {
+		return fmt.Errorf("%w: unexpected type %q", ErrWebAuthnInvalid, clientData.Type)
+	}
+	if challenge == "" || subtle.ConstantTimeCompare([]byte(clientData.Challenge), []byte(challenge)) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrWebAuthnInvalid)
+	}
+	if clientData.Origin != config.Origin || clientData.CrossOrigin {
+		return fmt.Errorf("%w: unexpected origin %q", ErrWebAuthnInvalid, clientData.Origin)
+	}
+	return nil
+}
+
+type webAuthnAuthenticatorData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData decodes authenticator data, checking it was made
+// for the relying party with the user present.
+func (config WebAuthnConfig) parseAuthenticatorData(data []byte) (webAuthnAuthenticatorData, error) {
+	var authData webAuthnAuthenticatorData
+	if len(data) < 37 {
+		return authData, fmt.Errorf("%w: authenticator data too short", ErrWebAuthnInvalid)
+	}
+
+	rpIDHash := sha256.Sum256([]byte(config.RPID))
+	if !bytes.Equal(data[:32], rpIDHash[:]) {
+		return authData, fmt.Errorf("%w: relying party mismatch", ErrWebAuthnInvalid)
+	}
+	authData.flags = data[32]
+	if authData.flags&webAuthnFlagUserPresent == 0 {
+		return authData, fmt.Errorf("%w: user not present", ErrWebAuthnInvalid)
+	}
+	authData.signCount = binary.BigEndian.Uint32(data[33:37])
+
+	if authData.flags&webAuthnFlagAttestedCredData != 0 {
+		rest := data[37:]
+		// The AAGUID of the authenticator model comes first.
+		if len(rest) < 18 {
+			return authData, fmt.Errorf("%w: credential data too short", ErrWebAuthnInvalid)
+		}
+		length := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if length == 0 || length > webAuthnMaxCredentialIDLength || length > len(rest) {
+			return authData, fmt.Errorf("%w: invalid credential id", ErrWebAuthnInvalid)
+		}
+		authData.credentialID = rest[:length]
+		rest = rest[length:]
+
+		_, extensions, err := decodeCBOR(rest)
+		if err != nil {
+			return authData, fmt.Errorf("%w: %v", ErrWebAuthnInvalid, err)
+		}
+		authData.publicKey = rest[:len(rest)-len(extensions)]
+	}
+	return authData, nil
+}
+
+// parseCOSEKey returns the public key of a COSE_Key (RFC 9053) along with
+// its algorithm, one of WebAuthnAlgorithms.
+func parseCOSEKey(data []byte) (crypto.PublicKey, int, error) {
+	decoded, rest, err := decodeCBOR(data)
+	if err != nil || len(rest) != 0 {
+		return nil, 0, fmt.Errorf("%w: invalid public key", ErrWebAuthnInvalid)
+	}
+	key, ok := decoded.(map[any]any)
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: invalid public key", ErrWebAuthnInvalid)
+	}
+
+	keyType, _ := key[int64(1)].(int64)
+	algorithm, _ := key[int64(3)].(int64)
+	switch {
+	case keyType == 2 && algorithm == COSEAlgorithmES256:
+		curve, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if curve != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, fmt.Errorf("%w: invalid P-256 key", ErrWebAuthnInvalid)
+		}
+		point := append(append([]byte{4}, x...), y...)
+		publicKey, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %v", ErrWebAuthnInvalid, err)
+		}
+		return publicKey, COSEAlgorithmES256, nil
+	case keyType == 1 && algorithm == COSEAlgorithmEdDSA:
+		curve, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		if curve != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, fmt.Errorf("%w: invalid Ed25519 key", ErrWebAuthnInvalid)
+		}
+		return ed25519.PublicKey(x), COSEAlgorithmEdDSA, nil
+	case keyType == 3 && algorithm == COSEAlgorithmRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) < 256 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, 0, fmt.Errorf("%w: invalid RSA key", ErrWebAuthnInvalid)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, COSEAlgorithmRS256, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unsupported key type %d with algorithm %d", ErrWebAuthnInvalid, keyType, algorithm)
+}
+
+func verifyCOSESignature(key crypto.PublicKey, algorithm int, signed, signature []byte) bool {
+	switch algorithm {
+	case COSEAlgorithmES256:
+		hash := sha256.Sum256(signed)
+		return ecdsa.VerifyASN1(key.(*ecdsa.PublicKey), hash[:], signature)
+	case COSEAlgorithmEdDSA:
+		return ed25519.Verify(key.(ed25519.PublicKey), signed, signature)
+	case COSEAlgorithmRS256:
+		hash := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), crypto.SHA256, hash[:], signature) == nil
+	}
+	return false
+}
+
+func decodeWebAuthnField(value string) ([]byte, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(decoded) == 0 {
+		return nil, fmt.Errorf("%w: invalid base64url field", ErrWebAuthnInvalid)
+	}
+	return decoded, nil
+}
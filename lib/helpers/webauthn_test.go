@@ -0,0 +1,342 @@
+package helpers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// softwareAuthenticator plays the part of a security key or a phone, so that
+// the ceremonies can be tested without hardware. It holds one credential.
+type softwareAuthenticator struct {
+	t            *testing.T
+	rpID         string
+	origin       string
+	credentialID []byte
+	signer       crypto.Signer
+	algorithm    int
+	// signCount is incremented by every authentication unless counterless,
+	// like synced passkeys.
+	signCount    uint32
+	counterless  bool
+	userVerified bool
+}
+
+func newSoftwareAuthenticator(t *testing.T, config WebAuthnConfig, algorithm int) *softwareAuthenticator {
+	t.Helper()
+	authenticator := &softwareAuthenticator{t: t, rpID: config.RPID, origin: config.Origin, algorithm: algorithm, userVerified: true}
+
+	var err error
+	switch algorithm {
+	case COSEAlgorithmES256:
+		authenticator.signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case COSEAlgorithmEdDSA:
+		_, authenticator.signer, err = ed25519.GenerateKey(rand.Reader)
+	case COSEAlgorithmRS256:
+		authenticator.signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator.credentialID = make([]byte, 16)
+	if _, err := rand.Read(authenticator.credentialID); err != nil {
+		t.Fatal(err)
+	}
+	return authenticator
+}
+
+func (a *softwareAuthenticator) coseKey() []byte {
+	switch public := a.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		point, err := public.Bytes()
+		if err != nil {
+			a.t.Fatal(err)
+		}
+		return encodeCBOR(map[any]any{1: 2, 3: COSEAlgorithmES256, -1: 1, -2: point[1:33], -3: point[33:]})
+	case ed25519.PublicKey:
+		return encodeCBOR(map[any]any{1: 1, 3: COSEAlgorithmEdDSA, -1: 6, -2: []byte(public)})
+	case *rsa.PublicKey:
+		return encodeCBOR(map[any]any{1: 3, 3: COSEAlgorithmRS256, -1: public.N.Bytes(), -2: big.NewInt(int64(public.E)).Bytes()})
+	}
+	a.t.Fatal("unsupported key")
+	return nil
+}
+
+func (a *softwareAuthenticator) authenticatorData(attestedCredential bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	flags := byte(webAuthnFlagUserPresent)
+	if a.userVerified {
+		flags |= webAuthnFlagUserVerified
+	}
+	if attestedCredential {
+		flags |= webAuthnFlagAttestedCredData
+	}
+
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if attestedCredential {
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+func (a *softwareAuthenticator) clientData(ceremony, challenge string) []byte {
+	clientDataJSON, err := json.Marshal(webAuthnClientData{Type: ceremony, Challenge: challenge, Origin: a.origin})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	return clientDataJSON
+}
+
+// register answers navigator.credentials.create() with a "none"
+// attestation.
+func (a *softwareAuthenticator) register(challenge string) WebAuthnResponse {
+	var response WebAuthnResponse
+	response.ID = base64.RawURLEncoding.EncodeToString(a.credentialID)
+	response.RawID = response.ID
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = base64.RawURLEncoding.EncodeToString(a.clientData("webauthn.create", challenge))
+	response.Response.AttestationObject = base64.RawURLEncoding.EncodeToString(encodeCBOR(map[any]any{
+		"fmt":      "none",
+		"attStmt":  map[any]any{},
+		"authData": a.authenticatorData(true),
+	}))
+	return response
+}
+
+// authenticate answers navigator.credentials.get().
+func (a *softwareAuthenticator) authenticate(challenge string, userHandle []byte) WebAuthnResponse {
+	if !a.counterless {
+		a.signCount++
+	}
+	authData := a.authenticatorData(false)
+	clientDataJSON := a.clientData("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+
+	var signature []byte
+	var err error
+	if a.algorithm == COSEAlgorithmEdDSA {
+		signature, err = a.signer.Sign(rand.Reader, signed, crypto.Hash(0))
+	} else {
+		hash := sha256.Sum256(signed)
+		signature, err = a.signer.Sign(rand.Reader, hash[:], crypto.SHA256)
+	}
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	var response WebAuthnResponse
+	response.ID = base64.RawURLEncoding.EncodeToString(a.credentialID)
+	response.RawID = response.ID
+	response.Type = "public-key"
+	response.Response.ClientDataJSON = base64.RawURLEncoding.EncodeToString(clientDataJSON)
+	response.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(authData)
+	response.Response.Signature = base64.RawURLEncoding.EncodeToString(signature)
+	response.Response.UserHandle = base64.RawURLEncoding.EncodeToString(userHandle)
+	return response
+}
+
+func testWebAuthnConfig(t *testing.T) WebAuthnConfig {
+	t.Helper()
+	config, err := NewWebAuthnConfig("https://tanzia.example:8443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func newTestChallenge(t *testing.T) string {
+	t.Helper()
+	challenge, err := NewWebAuthnChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+func TestNewWebAuthnConfig(t *testing.T) {
+	config := testWebAuthnConfig(t)
+	if config.RPID != "tanzia.example" || config.Origin != "https://tanzia.example:8443" {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	for _, domain := range []string{"", "tanzia.example", "https://"} {
+		if _, err := NewWebAuthnConfig(domain); err == nil {
+			t.Errorf("Expected %q to be rejected", domain)
+		}
+	}
+}
+
+func TestWebAuthnCeremonies(t *testing.T) {
+	config := testWebAuthnConfig(t)
+	for _, algorithm := range WebAuthnAlgorithms {
+		authenticator := newSoftwareAuthenticator(t, config, algorithm)
+
+		// The response goes through JSON, as it does from the browser.
+		challenge := newTestChallenge(t)
+		data, err := json.Marshal(authenticator.register(challenge))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := ParseWebAuthnResponse(string(data))
+		if err != nil {
+			t.Fatalf("ParseWebAuthnResponse failed: %v", err)
+		}
+		credential, err := config.VerifyRegistration(challenge, response)
+		if err != nil {
+			t.Fatalf("Algorithm %d: VerifyRegistration failed: %v", algorithm, err)
+		}
+		if credential.ID != response.RawID || credential.SignCount != 0 {
+			t.Errorf("Algorithm %d: unexpected credential %+v", algorithm, credential)
+		}
+
+		for i := 1; i <= 2; i++ {
+			challenge = newTestChallenge(t)
+			assertion, err := config.VerifyAssertion(challenge, credential, authenticator.authenticate(challenge, []byte("42")))
+			if err != nil {
+				t.Fatalf("Algorithm %d: VerifyAssertion failed: %v", algorithm, err)
+			}
+			if assertion.SignCount != uint32(i) || !assertion.UserVerified || string(assertion.UserHandle) != "42" {
+				t.Errorf("Algorithm %d: unexpected assertion %+v", algorithm, assertion)
+			}
+			credential.SignCount = assertion.SignCount
+		}
+	}
+}
+
+func TestWebAuthnRegistrationRejectsInvalidResponses(t *testing.T) {
+	config := testWebAuthnConfig(t)
+	authenticator := newSoftwareAuthenticator(t, config, COSEAlgorithmES256)
+	challenge := newTestChallenge(t)
+
+	if _, err := config.VerifyRegistration(newTestChallenge(t), authenticator.register(challenge)); !errors.Is(err, ErrWebAuthnInvalid) {
+		t.Errorf("Expected another challenge to be rejected, got %v", err)
+	}
+
+	phishing := *authenticator
+	phishing.origin = "https://tanzia.example.evil"
+	if _, err := config.VerifyRegistration(challenge, phishing.register(challenge)); !errors.Is(err, ErrWebAuthnInvalid) {
+		t.Errorf("Expected another origin to be rejected, got %v", err)
+	}
+
+	otherRP := *authenticator
+	otherRP.rpID = "evil.example"
+	if _, err := config.VerifyRegistration(challenge, otherRP.register(challenge)); !errors.Is(err, ErrWebAuthnInvalid) {
+		t.Errorf("Expected another relying party to be rejected, got %v", err)
+	}
+
+	response := authenticator.register(challenge)
+	response.RawID = base64.RawURLEncoding.EncodeToString([]byte("other"))
+	if _, err := config.VerifyRegistration(challenge, response); !errors.Is(err, ErrWebAuthnInvalid) {
+		t.Errorf("Expected a credential id mismatch to be rejected, got %v", err)
+	}
+
+	// An assertion cannot be replayed as a registration.
+	response = authenticator.authenticate(challenge, nil)
+	response.Response.AttestationObject = authenticator.register(challenge).Response.AttestationObject
+	if _, err := config.VerifyRegistration(challenge, response); !errors.Is(err, ErrWebAuthnInvalid) {
+		t.Errorf("Expected an authentication to be rejected, got %v", err)
+	}
+
+	if _, err := config.VerifyRegistration("", authenticator.register("")); !errors.Is(err, ErrWebAuthnInvalid) {
+		t.Errorf("Expected an empty challenge to be rejected, got %v", err)
+	}
+
+	for _, data := range []string{"", "{}", `{"type":"public-key"}`, `{"type":"other","rawId":"AA"}`} {
+		if _, err := ParseWebAuthnResponse(data); !errors.Is(err, ErrWebAuthnInvalid) {
+			t.Errorf("Expected %q to be rejected, got %v", data, err)
+		}
+	}
+}
+
+func TestWebAuthnAssertionRejectsInvalidResponses(t *testing.T) {
+	config := testWebAuthnConfig(t)
+	authenticator := newSoftwareAuthenticator(t, config, COSEAlgorithmES256)
+	challenge := newTestChallenge(t)
+	credential, err := config.VerifyRegistration(challenge, authenticator.register(challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := config.VerifyAssertion(newTestChallenge(t), credential, authenticator.authenticate(challenge, nil)); !errors.Is(err, ErrWebAuthnInvalid) {
+		t.Errorf("Expected another challenge to be rejected, got %v", err)
+	}
+
+	response := authenticator.authenticate(challenge, nil)
+	signature, _ := base64.RawURLEncoding.DecodeString(response.Response.Signature)
+	signature[len(signature)-1] ^= 1
+	response.Response.Signature = base64.RawURLEncoding.EncodeToString(signature)
+	if _, err := config.VerifyAssertion(challenge, credential, response); !errors.Is(err, ErrWebAuthnInvalid) {
+		t.Errorf("Expected a bad signature to be rejected, got %v", err)
+	}
+
+	other := newSoftwareAuthenticator(t, config, COSEAlgorithmES256)
+	other.credentialID = authenticator.credentialID
+	if _, err := config.VerifyAssertion(challenge, credential, other.authenticate(challenge, nil)); !errors.Is(err, ErrWebAuthnInvalid) {
+		t.Errorf("Expected another key to be rejected, got %v", err)
+	}
+
+	otherCredential := newSoftwareAuthenticator(t, config, COSEAlgorithmES256)
+	if _, err := config.VerifyAssertion(challenge, credential, otherCredential.authenticate(challenge, nil)); !errors.Is(err, ErrWebAuthnInvalid) {
+		t.Errorf("Expected another credential to be rejected, got %v", err)
+	}
+
+	registration := authenticator.register(challenge)
+	registration.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(authenticator.authenticatorData(false))
+	registration.Response.Signature = response.Response.Signature
+	if _, err := config.VerifyAssertion(challenge, credential, registration); !errors.Is(err, ErrWebAuthnInvalid) {
+		t.Errorf("Expected a registration to be rejected, got %v", err)
+	}
+}
+
+func TestWebAuthnSignatureCounter(t *testing.T) {
+	config := testWebAuthnConfig(t)
+	authenticator := newSoftwareAuthenticator(t, config, COSEAlgorithmES256)
+	challenge := newTestChallenge(t)
+	credential, err := config.VerifyRegistration(challenge, authenticator.register(challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator.signCount = 10
+	assertion, err := config.VerifyAssertion(challenge, credential, authenticator.authenticate(challenge, nil))
+	if err != nil || assertion.SignCount != 11 {
+		t.Fatalf("Unexpected assertion %+v (%v)", assertion, err)
+	}
+	credential.SignCount = assertion.SignCount
+
+	// A copy of the key lagging behind the original gives it away.
+	authenticator.signCount = 5
+	if _, err := config.VerifyAssertion(challenge, credential, authenticator.authenticate(challenge, nil)); !errors.Is(err, ErrWebAuthnClonedAuthenticator) {
+		t.Errorf("Expected a counter going backwards to be rejected, got %v", err)
+	}
+
+	counterless := newSoftwareAuthenticator(t, config, COSEAlgorithmES256)
+	counterless.counterless = true
+	counterless.userVerified = false
+	credential, err = config.VerifyRegistration(challenge, counterless.register(challenge))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		assertion, err := config.VerifyAssertion(challenge, credential, counterless.authenticate(challenge, nil))
+		if err != nil || assertion.SignCount != 0 || assertion.UserVerified {
+			t.Errorf("Unexpected assertion %+v (%v)", assertion, err)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/duscraft/tanzia/lib/domains"
 	"github.com/duscraft/tanzia/lib/helpers"
@@ -57,6 +58,28 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Template error", http.StatusInternalServerError)
 		return
 	}
+
+	data := struct {
+		SSOName string
+	}{
+		SSOName: helpers.OIDCProviderName(),
+	}
+
+	if err := t.ExecuteTemplate(w, "base", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	domains.LogUserConnection(w, r, "app")
+}
+
+func loginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := template.ParseFiles("web/templates/login-2fa.html", "web/templates/base-layout.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
 	if err := t.ExecuteTemplate(w, "base", nil); err != nil {
 		log.Printf("Error executing template: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -132,6 +155,85 @@ func resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	domains.LogUserConnection(w, r, "app")
 }
 
+func forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := template.ParseFiles("web/templates/forgot-password.html", "web/templates/base-layout.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	if err := t.ExecuteTemplate(w, "base", nil); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	domains.LogUserConnection(w, r, "app")
+}
+
+func confirmPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := template.ParseFiles("web/templates/reset-password-confirm.html", "web/templates/base-layout.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Token string
+	}{
+		Token: r.URL.Query().Get("token"),
+	}
+
+	// The token is in the URL: keep it out of the Referer of outgoing
+	// requests, such as the CDN scripts of the page.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	if err := t.ExecuteTemplate(w, "base", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	domains.LogUserConnection(w, r, "app")
+}
+
+func loginLinkHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := template.ParseFiles("web/templates/login-link.html", "web/templates/base-layout.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	if err := t.ExecuteTemplate(w, "base", nil); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	domains.LogUserConnection(w, r, "app")
+}
+
+func confirmLoginLinkHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := template.ParseFiles("web/templates/login-link-confirm.html", "web/templates/base-layout.html")
+	if err != nil {
+		log.Printf("Error parsing template: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Token string
+	}{
+		Token: r.URL.Query().Get("token"),
+	}
+
+	// As on the password reset page, the token stays out of the Referer.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	if err := t.ExecuteTemplate(w, "base", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	domains.LogUserConnection(w, r, "app")
+}
+
 func main() {
 	redisURL := os.Getenv("REDIS_URL")
 	if len(redisURL) == 0 {
@@ -159,24 +261,114 @@ func main() {
 		}
 	}()
 
+	go domains.RunRecurringBillScheduler(time.Hour)
+	go domains.RunEmailQueue(10 * time.Second)
+
+	http.HandleFunc("GET /coproprietes", domains.CoproprieteHandler)
+	http.HandleFunc("POST /coproprietes", helpers.CSRFProtect(domains.AddCoproprieteHandler))
+	http.HandleFunc("GET /coproprietes/{id}", domains.EditCoproprieteHandler)
+	http.HandleFunc("POST /coproprietes/{id}", helpers.CSRFProtect(domains.UpdateCoproprieteHandler))
+	http.HandleFunc("POST /coproprietes/switch", helpers.CSRFProtect(domains.SwitchCoproprieteHandler))
 	http.HandleFunc("GET /persons", domains.PersonHandler)
 	http.HandleFunc("POST /persons", helpers.CSRFProtect(domains.AddPersonHandler))
+	http.HandleFunc("GET /persons/{id}", domains.EditPersonHandler)
+	http.HandleFunc("POST /persons/{id}", helpers.CSRFProtect(domains.UpdatePersonHandler))
+	http.HandleFunc("POST /persons/{id}/delete", helpers.CSRFProtect(domains.DeletePersonHandler))
+	http.HandleFunc("GET /lots", domains.LotsHandler)
+	http.HandleFunc("POST /lots", helpers.CSRFProtect(domains.AddLotHandler))
+	http.HandleFunc("GET /lots/{id}", domains.EditLotHandler)
+	http.HandleFunc("POST /lots/{id}", helpers.CSRFProtect(domains.UpdateLotHandler))
+	http.HandleFunc("POST /lots/{id}/delete", helpers.CSRFProtect(domains.DeleteLotHandler))
+	http.HandleFunc("POST /lots/{id}/owners", helpers.CSRFProtect(domains.AddOwnershipHandler))
+	http.HandleFunc("POST /lots/{id}/owners/{ownerId}/delete", helpers.CSRFProtect(domains.DeleteOwnershipHandler))
 	http.HandleFunc("GET /bills", domains.BillsHandler)
 	http.HandleFunc("POST /bills", helpers.CSRFProtect(domains.AddBillHandler))
+	http.HandleFunc("GET /bills/{id}", domains.EditBillHandler)
+	http.HandleFunc("POST /bills/{id}", helpers.CSRFProtect(domains.UpdateBillHandler))
+	http.HandleFunc("POST /bills/{id}/delete", helpers.CSRFProtect(domains.DeleteBillHandler))
+	http.HandleFunc("POST /bills/{id}/attachments", domains.LimitAttachmentUpload(helpers.CSRFProtect(domains.AddBillAttachmentHandler)))
+	http.HandleFunc("GET /recurring-bills", domains.RecurringBillsHandler)
+	http.HandleFunc("POST /recurring-bills", helpers.CSRFProtect(domains.AddRecurringBillHandler))
+	http.HandleFunc("GET /recurring-bills/{id}", domains.EditRecurringBillHandler)
+	http.HandleFunc("POST /recurring-bills/{id}", helpers.CSRFProtect(domains.UpdateRecurringBillHandler))
+	http.HandleFunc("POST /recurring-bills/{id}/delete", helpers.CSRFProtect(domains.DeleteRecurringBillHandler))
 	http.HandleFunc("GET /provisions", domains.ProvisionsHandler)
 	http.HandleFunc("POST /provisions", helpers.CSRFProtect(domains.AddProvisionHandler))
+	http.HandleFunc("GET /provisions/{id}", domains.EditProvisionHandler)
+	http.HandleFunc("POST /provisions/{id}", helpers.CSRFProtect(domains.UpdateProvisionHandler))
+	http.HandleFunc("POST /provisions/{id}/delete", helpers.CSRFProtect(domains.DeleteProvisionHandler))
+	http.HandleFunc("POST /provisions/{id}/attachments", domains.LimitAttachmentUpload(helpers.CSRFProtect(domains.AddProvisionAttachmentHandler)))
+	http.HandleFunc("GET /attachments/{id}", domains.AttachmentHandler)
+	http.HandleFunc("POST /attachments/{id}/delete", helpers.CSRFProtect(domains.DeleteAttachmentHandler))
+	http.HandleFunc("GET /payments", domains.PaymentsHandler)
+	http.HandleFunc("POST /payments", helpers.CSRFProtect(domains.AddPaymentHandler))
+	http.HandleFunc("GET /payments/{id}", domains.EditPaymentHandler)
+	http.HandleFunc("POST /payments/{id}", helpers.CSRFProtect(domains.UpdatePaymentHandler))
+	http.HandleFunc("POST /payments/{id}/delete", helpers.CSRFProtect(domains.DeletePaymentHandler))
+	http.HandleFunc("GET /charge-keys", domains.ChargeKeysHandler)
+	http.HandleFunc("POST /charge-keys", helpers.CSRFProtect(domains.AddChargeKeyHandler))
+	http.HandleFunc("GET /charge-keys/{id}", domains.EditChargeKeyHandler)
+	http.HandleFunc("POST /charge-keys/{id}", helpers.CSRFProtect(domains.UpdateChargeKeyHandler))
+	http.HandleFunc("POST /charge-keys/{id}/delete", helpers.CSRFProtect(domains.DeleteChargeKeyHandler))
+	http.HandleFunc("GET /suppliers", domains.SuppliersHandler)
+	http.HandleFunc("POST /suppliers", helpers.CSRFProtect(domains.AddSupplierHandler))
+	http.HandleFunc("GET /suppliers/{id}", domains.EditSupplierHandler)
+	http.HandleFunc("POST /suppliers/{id}", helpers.CSRFProtect(domains.UpdateSupplierHandler))
+	http.HandleFunc("POST /suppliers/{id}/delete", helpers.CSRFProtect(domains.DeleteSupplierHandler))
+	http.HandleFunc("GET /budget", domains.BudgetHandler)
+	http.HandleFunc("POST /budget", helpers.CSRFProtect(domains.AddBudgetLineHandler))
+	http.HandleFunc("POST /budget/generate", helpers.CSRFProtect(domains.GenerateCallsHandler))
+	http.HandleFunc("GET /budget/{id}", domains.EditBudgetLineHandler)
+	http.HandleFunc("POST /budget/{id}", helpers.CSRFProtect(domains.UpdateBudgetLineHandler))
+	http.HandleFunc("POST /budget/{id}/delete", helpers.CSRFProtect(domains.DeleteBudgetLineHandler))
+	http.HandleFunc("GET /budget/{year}/calls/{quarter}", domains.CallNoticesZipHandler)
+	http.HandleFunc("GET /budget/{year}/calls/{quarter}/persons/{id}", domains.CallNoticeHandler)
+	http.HandleFunc("GET /regularizations", domains.RegularizationsHandler)
+	http.HandleFunc("POST /regularizations", helpers.CSRFProtect(domains.CloseFiscalYearHandler))
+	http.HandleFunc("POST /regularizations/{year}/delete", helpers.CSRFProtect(domains.ReopenFiscalYearHandler))
+	http.HandleFunc("GET /regularizations/{year}/persons/{id}", domains.RegularizationStatementHandler)
+	http.HandleFunc("GET /works-fund", domains.WorksFundHandler)
 	http.HandleFunc("GET /dashboard", domains.DashboardHandler)
+	http.HandleFunc("GET /account", domains.AccountHandler)
+	http.HandleFunc("POST /account/tokens", helpers.CSRFProtect(domains.AddAccessTokenHandler))
+	http.HandleFunc("POST /account/tokens/{id}/delete", helpers.CSRFProtect(domains.RevokeAccessTokenHandler))
+	http.HandleFunc("POST /account/email", helpers.CSRFProtect(domains.ChangeEmailHandler))
+	http.HandleFunc("POST /account/email/verify", helpers.CSRFProtect(domains.ResendEmailVerificationHandler))
+	http.HandleFunc("GET /account/2fa", domains.TwoFactorHandler)
+	http.HandleFunc("POST /account/2fa", helpers.CSRFProtect(domains.EnableTwoFactorHandler))
+	http.HandleFunc("POST /account/2fa/recovery-codes", helpers.CSRFProtect(domains.RegenerateRecoveryCodesHandler))
+	http.HandleFunc("POST /account/2fa/disable", helpers.CSRFProtect(domains.DisableTwoFactorHandler))
+	http.HandleFunc("POST /account/passkeys/options", helpers.CSRFProtect(domains.PasskeyRegistrationOptionsHandler))
+	http.HandleFunc("POST /account/passkeys", helpers.CSRFProtect(domains.AddPasskeyHandler))
+	http.HandleFunc("POST /account/passkeys/{id}", helpers.CSRFProtect(domains.RenamePasskeyHandler))
+	http.HandleFunc("POST /account/passkeys/{id}/delete", helpers.CSRFProtect(domains.RevokePasskeyHandler))
 	http.HandleFunc("GET /login", loginHandler)
 	http.HandleFunc("GET /signup", signupHandler)
 	http.HandleFunc("POST /login", domains.LoginHandler)
+	http.HandleFunc("GET /login/2fa", loginTwoFactorHandler)
+	http.HandleFunc("POST /login/2fa", domains.TwoFactorLoginHandler)
+	http.HandleFunc("POST /login/passkey/options", domains.PasskeyLoginOptionsHandler)
+	http.HandleFunc("POST /login/passkey", domains.PasskeyLoginHandler)
+	http.HandleFunc("GET /login/oidc", domains.OIDCLoginHandler)
+	http.HandleFunc("GET /login/oidc/callback", domains.OIDCCallbackHandler)
+	http.HandleFunc("GET /login/link", loginLinkHandler)
+	http.HandleFunc("POST /login/link", helpers.CSRFProtect(domains.LoginLinkRequestHandler))
+	http.HandleFunc("GET /login/link/confirm", confirmLoginLinkHandler)
+	http.HandleFunc("POST /login/link/confirm", helpers.CSRFProtect(domains.LoginLinkHandler))
 	http.HandleFunc("GET /logout", domains.LogoutHandler)
 	http.HandleFunc("POST /signup", domains.SignupHandler)
 	http.HandleFunc("GET /cgv", cgvHandler)
 	http.HandleFunc("GET /legals", legalsHandler)
 	http.HandleFunc("GET /reset-password", resetPasswordHandler)
 	http.HandleFunc("POST /reset-password", helpers.CSRFProtect(domains.ResetPasswordHandler))
+	http.HandleFunc("GET /forgot-password", forgotPasswordHandler)
+	http.HandleFunc("POST /forgot-password", helpers.CSRFProtect(domains.ForgotPasswordHandler))
+	http.HandleFunc("GET /reset-password/confirm", confirmPasswordResetHandler)
+	http.HandleFunc("POST /reset-password/confirm", helpers.CSRFProtect(domains.ConfirmPasswordResetHandler))
+	http.HandleFunc("GET /verify-email", domains.VerifyEmailHandler)
 	http.HandleFunc("GET /export/pdf", domains.ExportPDFHandler)
 	http.HandleFunc("GET /export/excel", domains.ExportExcelHandler)
+	http.HandleFunc("GET /export/archive", domains.ExportArchiveHandler)
 	http.HandleFunc("POST /subscribe", helpers.CSRFProtect(domains.CreateCheckoutSessionHandler))
 	http.HandleFunc("GET /subscribe", domains.CreateCheckoutSessionHandler)
 	http.HandleFunc("POST /customer-portal", helpers.CSRFProtect(domains.CustomerPortalHandler))